@@ -0,0 +1,512 @@
+// Package asm assembles CHIP-8, SUPER-CHIP and XO-CHIP programs written in
+// the mnemonics of the disasm package into ROM bytes.
+//
+// A line holds an optional "label:", then an instruction or directive, then
+// an optional ; comment. Constants are defined with "NAME EQU expr" or
+// "NAME = expr". Numbers are decimal, hex (#1F, $1F, 0x1F) or binary
+// (0b101), and operands may be expressions over numbers, labels and
+// constants. The directives are:
+//
+//	DB expr|"string", ...  data bytes
+//	DW expr, ...           big-endian data words
+//	SPRITE "..####..", ... sprite rows of 8 or 16 pixels; # X or 1 is set
+//	ORG expr               continue at a later address, padding with zeros
+//	INCLUDE "file"         assemble another file, relative to this one
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/symbols"
+)
+
+// Error is an assembly error at a source position
+type Error struct {
+	File      string
+	Line, Col int
+	Msg       string
+}
+
+// Error returns the error as file:line:col: message
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Col, e.Msg)
+}
+
+// ErrorList is the errors of a failed assembly, in source order
+type ErrorList []*Error
+
+// Error returns the errors, one per line
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, e := range l {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// maxErrors is the number of errors after which assembly gives up
+const maxErrors = 20
+
+// maxIncludeDepth limits nested includes
+const maxIncludeDepth = 16
+
+// Options configure the assembler
+type Options struct {
+	// Mode is the instruction set; instructions of later modes are errors
+	Mode chip8.Mode
+}
+
+// Result is an assembled program
+type Result struct {
+	// ROM is the program, loaded at chip8.ProgramStart
+	ROM []byte
+	// Symbols has the labels and the address of each source line
+	Symbols *symbols.Map
+}
+
+// Assemble assembles src, naming it filename in errors and symbols.
+// Included files are read relative to filename.
+func Assemble(filename string, src []byte, opts Options) (*Result, error) {
+	a := &assembler{
+		mode:   opts.Mode,
+		addr:   chip8.ProgramStart,
+		end:    chip8.ProgramStart,
+		labels: make(map[string]int),
+		consts: make(map[string]*constant),
+		syms:   &symbols.Map{},
+	}
+	a.file(filename, src, 0)
+	if len(a.errs) == 0 {
+		a.emit()
+	}
+	if len(a.errs) > 0 {
+		return nil, a.errs
+	}
+	return &Result{ROM: a.rom, Symbols: a.syms}, nil
+}
+
+// AssembleFile assembles the file at path
+func AssembleFile(path string, opts Options) (*Result, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Assemble(path, src, opts)
+}
+
+// pos is a source line
+type pos struct {
+	file string
+	line int
+}
+
+// operand is the tokens of one comma-separated operand
+type operand struct {
+	tokens []token
+	// col is the column of the operand and end the column after it
+	col, end int
+}
+
+// stmt is an instruction or data directive
+type stmt struct {
+	pos pos
+	// col is the column of the mnemonic
+	col int
+	// name is the upper-case mnemonic or directive
+	name     string
+	operands []operand
+	addr     int
+	size     int
+}
+
+// constant is an EQU constant, evaluated when first used
+type constant struct {
+	pos   pos
+	expr  operand
+	value int
+	// state is 0 before evaluation, 1 during and 2 after
+	state int
+}
+
+// assembler holds the state of an assembly
+type assembler struct {
+	mode chip8.Mode
+	// addr is the address of the next statement; end is the highest
+	// address reached
+	addr, end int
+	stmts     []*stmt
+	labels    map[string]int
+	consts    map[string]*constant
+	// includes are the files being assembled, to detect include cycles
+	includes []string
+	errs     ErrorList
+	rom      []byte
+	syms     *symbols.Map
+}
+
+// errorAt returns an error at a column of a line
+func (a *assembler) errorAt(p pos, col int, format string, args ...any) *Error {
+	return &Error{File: p.file, Line: p.line, Col: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// fail records an error at a column of a line
+func (a *assembler) fail(p pos, col int, format string, args ...any) {
+	a.add(a.errorAt(p, col, format, args...))
+}
+
+// add records an error
+func (a *assembler) add(err *Error) {
+	if len(a.errs) < maxErrors {
+		a.errs = append(a.errs, err)
+	}
+}
+
+// exprErr converts an expression error to an Error on line p
+func (a *assembler) exprErr(p pos, err error) *Error {
+	var ee *exprError
+	if errors.As(err, &ee) {
+		return a.errorAt(p, ee.col, "%s", ee.msg)
+	}
+	return a.errorAt(p, 1, "%v", err)
+}
+
+// file runs the first pass over a source file: it defines labels and
+// constants, lays out statements and follows includes
+func (a *assembler) file(name string, src []byte, depth int) {
+	a.includes = append(a.includes, name)
+	defer func() { a.includes = a.includes[:len(a.includes)-1] }()
+
+	for i, line := range strings.Split(string(src), "\n") {
+		if len(a.errs) >= maxErrors {
+			return
+		}
+		p := pos{file: name, line: i + 1}
+		tokens, lerr := lex(line)
+		if lerr != nil {
+			a.fail(p, lerr.col, "%s", lerr.msg)
+			continue
+		}
+		a.line(p, tokens, len(line)+1, depth)
+	}
+}
+
+// line runs the first pass over one line; end is the column after it
+func (a *assembler) line(p pos, tokens []token, end, depth int) {
+	// Label
+	if len(tokens) >= 2 && tokens[0].kind == tokIdent && tokens[1].is(":") {
+		a.define(p, tokens[0], a.addr)
+		tokens = tokens[2:]
+	}
+	if len(tokens) == 0 {
+		return
+	}
+
+	// Constant
+	if len(tokens) >= 2 && tokens[0].kind == tokIdent && (tokens[1].is("=") || tokens[1].isWord("EQU")) {
+		name := tokens[0]
+		if a.checkName(p, name) {
+			a.consts[name.text] = &constant{pos: p, expr: operand{tokens: tokens[2:], col: tokens[1].col, end: end}}
+		}
+		return
+	}
+
+	if tokens[0].kind != tokIdent {
+		a.fail(p, tokens[0].col, "expected instruction, got %q", tokens[0].text)
+		return
+	}
+	s := &stmt{pos: p, col: tokens[0].col, name: strings.ToUpper(tokens[0].text), addr: a.addr}
+	operands, err := splitOperands(tokens[1:], end)
+	if err != nil {
+		a.add(a.exprErr(p, err))
+		return
+	}
+	s.operands = operands
+
+	switch s.name {
+	case "INCLUDE":
+		a.include(p, s, depth)
+		return
+	case "ORG":
+		if len(operands) != 1 {
+			a.fail(p, s.col, "ORG needs one address")
+			return
+		}
+		v, err := a.eval(operands[0])
+		if err != nil {
+			a.add(a.exprErr(p, err))
+			return
+		}
+		if v < a.addr {
+			a.fail(p, operands[0].col, "ORG %s is before the current address %s", fmtValue(v), fmtValue(a.addr))
+			return
+		}
+		if v > a.addressSpace() {
+			a.fail(p, operands[0].col, "ORG %s is outside the %s address space", fmtValue(v), a.mode)
+			return
+		}
+		a.addr = v
+		a.end = max(a.end, a.addr)
+		return
+	case "DB":
+		for _, op := range operands {
+			if len(op.tokens) == 1 && op.tokens[0].kind == tokString {
+				s.size += len(op.tokens[0].text)
+			} else {
+				s.size++
+			}
+		}
+	case "DW":
+		s.size = 2 * len(operands)
+	case "SPRITE":
+		rows, err := a.sprite(p, operands)
+		if err != nil {
+			a.add(err)
+			return
+		}
+		s.size = len(rows)
+	default:
+		if _, ok := instructions[s.name]; !ok {
+			a.fail(p, s.col, "unknown instruction %q", tokens[0].text)
+			return
+		}
+		s.size = 2
+		for _, op := range operands {
+			if classify(op).kind == argLong {
+				s.size = 4
+			}
+		}
+	}
+
+	if len(operands) == 0 && (s.name == "DB" || s.name == "DW" || s.name == "SPRITE") {
+		a.fail(p, s.col, "%s needs at least one value", s.name)
+		return
+	}
+
+	a.stmts = append(a.stmts, s)
+	a.addr += s.size
+	a.end = max(a.end, a.addr)
+	if limit := a.addressSpace(); a.addr > limit && a.addr-s.size <= limit {
+		a.fail(p, s.col, "program exceeds the %s address space of %d bytes", a.mode, limit)
+	}
+}
+
+// addressSpace returns the size of the mode's address space
+func (a *assembler) addressSpace() int {
+	if a.mode == chip8.ModeXOCHIP {
+		return chip8.ExtendedMemorySize
+	}
+	return chip8.MemorySize
+}
+
+// checkName reports whether a label or constant name is free, recording
+// an error if not
+func (a *assembler) checkName(p pos, name token) bool {
+	if isReserved(name.text) {
+		a.fail(p, name.col, "%q is a reserved name", name.text)
+		return false
+	}
+	_, isLabel := a.labels[name.text]
+	_, isConst := a.consts[name.text]
+	if isLabel || isConst {
+		a.fail(p, name.col, "%q is already defined", name.text)
+		return false
+	}
+	return true
+}
+
+// define defines a label
+func (a *assembler) define(p pos, name token, addr int) {
+	if a.checkName(p, name) {
+		a.labels[name.text] = addr
+		a.syms.Labels = append(a.syms.Labels, symbols.Label{Name: name.text, Addr: uint16(addr)})
+	}
+}
+
+// include assembles an included file
+func (a *assembler) include(p pos, s *stmt, depth int) {
+	if len(s.operands) != 1 || len(s.operands[0].tokens) != 1 || s.operands[0].tokens[0].kind != tokString {
+		a.fail(p, s.col, "INCLUDE needs a quoted file name")
+		return
+	}
+	name := s.operands[0].tokens[0].text
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(p.file), name)
+	}
+	for _, f := range a.includes {
+		if filepath.Clean(f) == filepath.Clean(name) {
+			a.fail(p, s.operands[0].col, "%s includes itself", name)
+			return
+		}
+	}
+	if depth >= maxIncludeDepth {
+		a.fail(p, s.operands[0].col, "includes nested too deeply")
+		return
+	}
+	src, err := os.ReadFile(name)
+	if err != nil {
+		a.fail(p, s.operands[0].col, "%v", err)
+		return
+	}
+	a.file(name, src, depth+1)
+}
+
+// sprite parses SPRITE rows into bytes
+func (a *assembler) sprite(p pos, operands []operand) ([]byte, *Error) {
+	var out []byte
+	width := 0
+	for _, op := range operands {
+		if len(op.tokens) != 1 || op.tokens[0].kind != tokString {
+			return nil, a.errorAt(p, op.col, "sprite rows must be quoted strings")
+		}
+		row := op.tokens[0].text
+		if len(row) != 8 && len(row) != 16 {
+			return nil, a.errorAt(p, op.col, "sprite rows must be 8 or 16 pixels wide, got %d", len(row))
+		}
+		if width != 0 && len(row) != width {
+			return nil, a.errorAt(p, op.col, "sprite rows must all be %d pixels wide", width)
+		}
+		width = len(row)
+
+		bits := 0
+		for i := 0; i < len(row); i++ {
+			bits <<= 1
+			switch row[i] {
+			case '#', 'X', 'x', '1':
+				bits |= 1
+			case '.', ' ', '0':
+			default:
+				return nil, a.errorAt(p, op.col+1+i, "invalid sprite pixel %q", row[i])
+			}
+		}
+		if width == 16 {
+			out = append(out, byte(bits>>8))
+		}
+		out = append(out, byte(bits))
+	}
+	return out, nil
+}
+
+// splitOperands splits tokens at top-level commas
+func splitOperands(tokens []token, end int) ([]operand, error) {
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	var operands []operand
+	start := 0
+	depth := 0
+	for i := 0; i <= len(tokens); i++ {
+		if i < len(tokens) {
+			switch {
+			case tokens[i].is("("), tokens[i].is("["):
+				depth++
+				continue
+			case tokens[i].is(")"), tokens[i].is("]"):
+				depth--
+				continue
+			case !tokens[i].is(",") || depth > 0:
+				continue
+			}
+		}
+
+		opEnd := end
+		if i < len(tokens) {
+			opEnd = tokens[i].col
+		}
+		if i == start {
+			return nil, &exprError{opEnd, "missing operand"}
+		}
+		operands = append(operands, operand{tokens: tokens[start:i], col: tokens[start].col, end: opEnd})
+		start = i + 1
+	}
+	return operands, nil
+}
+
+// eval evaluates an operand expression
+func (a *assembler) eval(op operand) (int, error) {
+	return eval(op.tokens, op.end, a.lookup)
+}
+
+// lookup returns the value of a label or constant
+func (a *assembler) lookup(name string) (int, error) {
+	if addr, ok := a.labels[name]; ok {
+		return addr, nil
+	}
+	c, ok := a.consts[name]
+	if !ok {
+		return 0, fmt.Errorf("undefined symbol %q", name)
+	}
+	switch c.state {
+	case 1:
+		return 0, fmt.Errorf("constant %q refers to itself", name)
+	case 0:
+		c.state = 1
+		v, err := a.eval(c.expr)
+		if err != nil {
+			c.state = 0
+			return 0, fmt.Errorf("in constant %q: %v", name, err)
+		}
+		c.value, c.state = v, 2
+	}
+	return c.value, nil
+}
+
+// emit runs the second pass: it encodes the statements into the ROM and
+// records the address of each source line
+func (a *assembler) emit() {
+	a.rom = make([]byte, a.end-chip8.ProgramStart)
+	for _, s := range a.stmts {
+		if len(a.errs) >= maxErrors {
+			return
+		}
+		var out []byte
+		var err *Error
+		switch s.name {
+		case "DB":
+			out, err = a.data(s, 1)
+		case "DW":
+			out, err = a.data(s, 2)
+		case "SPRITE":
+			out, err = a.sprite(s.pos, s.operands)
+		default:
+			out, err = a.encode(s)
+		}
+		if err != nil {
+			a.add(err)
+			continue
+		}
+		copy(a.rom[s.addr-chip8.ProgramStart:], out)
+		a.syms.Lines = append(a.syms.Lines, symbols.Line{File: s.pos.file, Line: s.pos.line, Addr: uint16(s.addr)})
+	}
+}
+
+// data encodes DB or DW values of size bytes each
+func (a *assembler) data(s *stmt, size int) ([]byte, *Error) {
+	var out []byte
+	for _, op := range s.operands {
+		if size == 1 && len(op.tokens) == 1 && op.tokens[0].kind == tokString {
+			out = append(out, op.tokens[0].text...)
+			continue
+		}
+		v, err := a.eval(op)
+		if err != nil {
+			return nil, a.exprErr(s.pos, err)
+		}
+		lo, hi := -0x80, 0xFF
+		if size == 2 {
+			lo, hi = -0x8000, 0xFFFF
+		}
+		if v < lo || v > hi {
+			return nil, a.errorAt(s.pos, op.col, "value %s out of range %s to %s", fmtValue(v), fmtValue(lo), fmtValue(hi))
+		}
+		if size == 2 {
+			out = append(out, byte(v>>8))
+		}
+		out = append(out, byte(v))
+	}
+	return out, nil
+}
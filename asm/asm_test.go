@@ -0,0 +1,144 @@
+package asm
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/disasm"
+)
+
+func TestAssemble(t *testing.T) {
+	src := `
+; Draw a sprite and loop
+SPEED = 2 * 3
+start:  LD V0, SPEED      ; constant
+        LD I, sprite
+        DRW V0, V1, #5
+        CALL sub
+loop:   JP loop
+sub:    ADD V0, -1
+        SHR V2
+        LD [I], VA
+        RET
+sprite: SPRITE "####....", "#..#....", "#..#....", "#..#....", "####...."
+text:   DB "Hi", 0
+        DW sprite + 1
+`
+	res, err := Assemble("test.asm", []byte(src), Options{})
+	if err != nil {
+		t.Fatalf("Assemble failed: %v", err)
+	}
+	want := []byte{
+		0x60, 0x06,
+		0xA2, 0x12,
+		0xD0, 0x15,
+		0x22, 0x0A,
+		0x12, 0x08,
+		0x70, 0xFF,
+		0x82, 0x26,
+		0xFA, 0x55,
+		0x00, 0xEE,
+		0xF0, 0x90, 0x90, 0x90, 0xF0,
+		'H', 'i', 0x00,
+		0x02, 0x13,
+	}
+	if !bytes.Equal(res.ROM, want) {
+		t.Errorf("ROM = % X\nwant  % X", res.ROM, want)
+	}
+
+	if addr, ok := res.Symbols.Lookup("sprite"); !ok || addr != 0x212 {
+		t.Errorf("Symbol sprite = %03X, %v", addr, ok)
+	}
+	if addrs := res.Symbols.AddrsAt("test.asm", 9); len(addrs) != 1 || addrs[0] != 0x20A {
+		t.Errorf("Line 9 should map to 20A, got %v", addrs)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		mode chip8.Mode
+		want string
+	}{
+		{"  FOO V0", chip8.ModeCHIP8, `x.asm:1:3: unknown instruction "FOO"`},
+		{"\n  JP nowhere", chip8.ModeCHIP8, `x.asm:2:6: undefined symbol "nowhere"`},
+		{"  LD V0, 300", chip8.ModeCHIP8, "x.asm:1:10: value #12C out of range -#80 to #FF"},
+		{"  LD V0, I", chip8.ModeCHIP8, "x.asm:1:3: invalid operands for LD"},
+		{"  HIGH", chip8.ModeCHIP8, "x.asm:1:3: HIGH needs schip mode"},
+		{"  JP V1, #300", chip8.ModeCHIP8, "x.asm:1:6: JP with a register must use V0"},
+		{"a:\na:", chip8.ModeCHIP8, `x.asm:2:1: "a" is already defined`},
+		{"X = Y\nY = X\n  LD V0, X", chip8.ModeCHIP8, `x.asm:3:10: in constant "X": in constant "Y": constant "X" refers to itself`},
+		{`  SPRITE "#.#"`, chip8.ModeCHIP8, "x.asm:1:10: sprite rows must be 8 or 16 pixels wide, got 3"},
+		{"  DB 1,, 2", chip8.ModeCHIP8, "x.asm:1:8: missing operand"},
+		{"  LD V0, @", chip8.ModeCHIP8, `x.asm:1:10: unexpected character '@'`},
+	}
+	for _, tt := range tests {
+		_, err := Assemble("x.asm", []byte(tt.src), Options{Mode: tt.mode})
+		var list ErrorList
+		if !errors.As(err, &list) || list[0].Error() != tt.want {
+			t.Errorf("Assemble(%q) error = %v, want %s", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "font.inc"), []byte("digit: DB #F0, #90\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(dir, "main.asm")
+	if err := os.WriteFile(main, []byte("  LD I, digit\n  INCLUDE \"font.inc\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	res, err := AssembleFile(main, Options{})
+	if err != nil {
+		t.Fatalf("AssembleFile failed: %v", err)
+	}
+	if want := []byte{0xA2, 0x02, 0xF0, 0x90}; !bytes.Equal(res.ROM, want) {
+		t.Errorf("ROM = % X, want % X", res.ROM, want)
+	}
+	if line, ok := res.Symbols.LineAt(0x202); !ok || filepath.Base(line.File) != "font.inc" || line.Line != 1 {
+		t.Errorf("Included lines should be mapped to their file, got %+v", line)
+	}
+}
+
+// TestDisassemblerRoundTrip checks that every opcode disassembled in plain
+// syntax assembles back to the same bytes
+func TestDisassemblerRoundTrip(t *testing.T) {
+	for _, mode := range []chip8.Mode{chip8.ModeCHIP8, chip8.ModeSCHIP, chip8.ModeXOCHIP} {
+		// Opcodes are split into ROMs that fit the CHIP-8 address space
+		for base := 0; base < 0x10000; base += 0x400 {
+			var rom []byte
+			for op := base; op < base+0x400; op++ {
+				rom = append(rom, byte(op>>8), byte(op))
+				if mode == chip8.ModeXOCHIP && op == 0xF000 {
+					rom = append(rom, 0x12, 0x34)
+				}
+			}
+			p, err := disasm.Linear(rom, mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var src strings.Builder
+			if err := p.Write(&src, disasm.SyntaxPlain); err != nil {
+				t.Fatal(err)
+			}
+			res, err := Assemble("rt.asm", []byte(src.String()), Options{Mode: mode})
+			if err != nil {
+				t.Fatalf("%v: assembling the listing of %04X-%04X failed: %v", mode, base, base+0x3FF, err)
+			}
+			if !bytes.Equal(res.ROM, rom) {
+				for i := range rom {
+					if i >= len(res.ROM) || res.ROM[i] != rom[i] {
+						t.Fatalf("%v: mismatch at %03X: got % X, want % X", mode, chip8.ProgramStart+i, res.ROM[i&^1:i&^1+2], rom[i&^1:i&^1+2])
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chip8-emulator/chip8"
+)
+
+// argKind is the kind of an instruction operand
+type argKind int
+
+const (
+	argExpr argKind = iota // a value or address
+	argV                   // V0-VF
+	argI                   // I
+	argDT                  // DT
+	argST                  // ST
+	argK                   // K
+	argF                   // F
+	argHF                  // HF
+	argB                   // B
+	argR                   // R
+	argIndI                // [I]
+	argLong                // LONG expression
+)
+
+// keywords are the operand names that can't be used as symbols
+var keywords = map[string]argKind{
+	"I":  argI,
+	"DT": argDT,
+	"ST": argST,
+	"K":  argK,
+	"F":  argF,
+	"HF": argHF,
+	"B":  argB,
+	"R":  argR,
+}
+
+// arg is a classified operand
+type arg struct {
+	kind argKind
+	// reg is the register number of argV
+	reg int
+	// expr is the expression of argExpr and argLong
+	expr operand
+}
+
+// classify determines the kind of an operand
+func classify(op operand) arg {
+	t := op.tokens
+	if len(t) == 1 && t[0].kind == tokIdent {
+		if r, ok := register(t[0].text); ok {
+			return arg{kind: argV, reg: r}
+		}
+		if kind, ok := keywords[strings.ToUpper(t[0].text)]; ok {
+			return arg{kind: kind}
+		}
+	}
+	if len(t) == 3 && t[0].is("[") && t[1].isWord("I") && t[2].is("]") {
+		return arg{kind: argIndI}
+	}
+	if len(t) > 0 && t[0].isWord("LONG") {
+		return arg{kind: argLong, expr: operand{tokens: t[1:], col: op.col, end: op.end}}
+	}
+	return arg{kind: argExpr, expr: op}
+}
+
+// register parses a V register name
+func register(name string) (int, bool) {
+	if len(name) != 2 || (name[0] != 'V' && name[0] != 'v') {
+		return 0, false
+	}
+	c := name[1] | 0x20
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0'), true
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10, true
+	}
+	return 0, false
+}
+
+// isReserved reports whether name is a register or operand keyword
+func isReserved(name string) bool {
+	if _, ok := register(name); ok {
+		return true
+	}
+	_, ok := keywords[strings.ToUpper(name)]
+	return ok || strings.EqualFold(name, "LONG")
+}
+
+// field is where an operand goes in the encoding
+type field int
+
+const (
+	fNone field = iota // fixed by the opcode
+	fX                 // register in bits 8-11
+	fY                 // register in bits 4-7
+	fXY                // register in both, for the one-operand shifts
+	fV0                // must be V0
+	fN                 // 4-bit value in bits 0-3
+	fXN                // 4-bit value in bits 8-11
+	fNN                // 8-bit value in bits 0-7
+	fNNN               // 12-bit address in bits 0-11
+	fLong              // 16-bit address in the second word
+)
+
+// form is one operand combination of an instruction
+type form struct {
+	args   []argKind
+	fields []field
+	opcode uint16
+	// mode is the first mode that has the instruction
+	mode chip8.Mode
+}
+
+// def builds a form from its opcode, mode and argument kind, field pairs
+func def(opcode uint16, mode chip8.Mode, spec ...any) form {
+	fm := form{opcode: opcode, mode: mode}
+	for i := 0; i < len(spec); i += 2 {
+		fm.args = append(fm.args, spec[i].(argKind))
+		fm.fields = append(fm.fields, spec[i+1].(field))
+	}
+	return fm
+}
+
+const (
+	c8 = chip8.ModeCHIP8
+	sc = chip8.ModeSCHIP
+	xo = chip8.ModeXOCHIP
+)
+
+// instructions are the forms of each mnemonic, in the syntax of the
+// disasm package
+var instructions = map[string][]form{
+	"CLS":  {def(0x00E0, c8)},
+	"RET":  {def(0x00EE, c8)},
+	"SYS":  {def(0x0000, c8, argExpr, fNNN)},
+	"SCD":  {def(0x00C0, sc, argExpr, fN)},
+	"SCU":  {def(0x00D0, xo, argExpr, fN)},
+	"SCR":  {def(0x00FB, sc)},
+	"SCL":  {def(0x00FC, sc)},
+	"EXIT": {def(0x00FD, sc)},
+	"LOW":  {def(0x00FE, sc)},
+	"HIGH": {def(0x00FF, sc)},
+	"JP": {
+		def(0x1000, c8, argExpr, fNNN),
+		def(0xB000, c8, argV, fV0, argExpr, fNNN),
+	},
+	"CALL": {def(0x2000, c8, argExpr, fNNN)},
+	"SE": {
+		def(0x3000, c8, argV, fX, argExpr, fNN),
+		def(0x5000, c8, argV, fX, argV, fY),
+	},
+	"SNE": {
+		def(0x4000, c8, argV, fX, argExpr, fNN),
+		def(0x9000, c8, argV, fX, argV, fY),
+	},
+	"SAVE": {def(0x5002, xo, argV, fX, argV, fY)},
+	"LOAD": {def(0x5003, xo, argV, fX, argV, fY)},
+	"LD": {
+		def(0x6000, c8, argV, fX, argExpr, fNN),
+		def(0x8000, c8, argV, fX, argV, fY),
+		def(0xA000, c8, argI, fNone, argExpr, fNNN),
+		def(0xF000, xo, argI, fNone, argLong, fLong),
+		def(0xF007, c8, argV, fX, argDT, fNone),
+		def(0xF00A, c8, argV, fX, argK, fNone),
+		def(0xF015, c8, argDT, fNone, argV, fX),
+		def(0xF018, c8, argST, fNone, argV, fX),
+		def(0xF029, c8, argF, fNone, argV, fX),
+		def(0xF030, sc, argHF, fNone, argV, fX),
+		def(0xF033, c8, argB, fNone, argV, fX),
+		def(0xF055, c8, argIndI, fNone, argV, fX),
+		def(0xF065, c8, argV, fX, argIndI, fNone),
+		def(0xF075, sc, argR, fNone, argV, fX),
+		def(0xF085, sc, argV, fX, argR, fNone),
+	},
+	"ADD": {
+		def(0x7000, c8, argV, fX, argExpr, fNN),
+		def(0x8004, c8, argV, fX, argV, fY),
+		def(0xF01E, c8, argI, fNone, argV, fX),
+	},
+	"OR":    {def(0x8001, c8, argV, fX, argV, fY)},
+	"AND":   {def(0x8002, c8, argV, fX, argV, fY)},
+	"XOR":   {def(0x8003, c8, argV, fX, argV, fY)},
+	"SUB":   {def(0x8005, c8, argV, fX, argV, fY)},
+	"SHR":   {def(0x8006, c8, argV, fX, argV, fY), def(0x8006, c8, argV, fXY)},
+	"SUBN":  {def(0x8007, c8, argV, fX, argV, fY)},
+	"SHL":   {def(0x800E, c8, argV, fX, argV, fY), def(0x800E, c8, argV, fXY)},
+	"RND":   {def(0xC000, c8, argV, fX, argExpr, fNN)},
+	"DRW":   {def(0xD000, c8, argV, fX, argV, fY, argExpr, fN)},
+	"SKP":   {def(0xE09E, c8, argV, fX)},
+	"SKNP":  {def(0xE0A1, c8, argV, fX)},
+	"AUDIO": {def(0xF002, xo)},
+	"PLANE": {def(0xF001, xo, argExpr, fXN)},
+	"PITCH": {def(0xF03A, xo, argV, fX)},
+}
+
+// encode assembles an instruction statement
+func (a *assembler) encode(s *stmt) ([]byte, *Error) {
+	args := make([]arg, len(s.operands))
+	for i, op := range s.operands {
+		args[i] = classify(op)
+	}
+
+	fm, ok := match(instructions[s.name], args)
+	if !ok {
+		return nil, a.errorAt(s.pos, s.col, "invalid operands for %s", s.name)
+	}
+	if fm.mode > a.mode {
+		return nil, a.errorAt(s.pos, s.col, "%s needs %s mode", s.name, fm.mode)
+	}
+
+	opcode, long := fm.opcode, -1
+	for i, fl := range fm.fields {
+		ar := args[i]
+		switch fl {
+		case fX:
+			opcode |= uint16(ar.reg) << 8
+		case fY:
+			opcode |= uint16(ar.reg) << 4
+		case fXY:
+			opcode |= uint16(ar.reg)<<8 | uint16(ar.reg)<<4
+		case fV0:
+			if ar.reg != 0 {
+				return nil, a.errorAt(s.pos, s.operands[i].col, "%s with a register must use V0", s.name)
+			}
+		case fN, fXN, fNN, fNNN, fLong:
+			v, err := a.value(s.pos, ar.expr, fl)
+			if err != nil {
+				return nil, err
+			}
+			switch fl {
+			case fXN:
+				opcode |= uint16(v) << 8
+			case fLong:
+				long = v
+			default:
+				opcode |= uint16(v)
+			}
+		}
+	}
+
+	out := []byte{byte(opcode >> 8), byte(opcode)}
+	if long >= 0 {
+		out = append(out, byte(long>>8), byte(long))
+	}
+	return out, nil
+}
+
+// match returns the form whose operands match args
+func match(forms []form, args []arg) (form, bool) {
+	for _, fm := range forms {
+		if len(fm.args) != len(args) {
+			continue
+		}
+		ok := true
+		for i, kind := range fm.args {
+			if args[i].kind != kind {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return fm, true
+		}
+	}
+	return form{}, false
+}
+
+// value evaluates an operand and checks it fits the field
+func (a *assembler) value(p pos, op operand, fl field) (int, *Error) {
+	v, err := a.eval(op)
+	if err != nil {
+		return 0, a.exprErr(p, err)
+	}
+
+	var lo, hi int
+	switch fl {
+	case fN, fXN:
+		lo, hi = 0, 0xF
+	case fNN:
+		// Negative bytes are stored as two's complement
+		lo, hi = -0x80, 0xFF
+	case fNNN:
+		lo, hi = 0, 0xFFF
+	case fLong:
+		lo, hi = 0, 0xFFFF
+	}
+	if v < lo || v > hi {
+		return 0, a.errorAt(p, op.col, "value %s out of range %s to %s", fmtValue(v), fmtValue(lo), fmtValue(hi))
+	}
+	return v & hi, nil
+}
+
+// fmtValue formats a value in the assembler's hex syntax
+func fmtValue(v int) string {
+	if v < 0 {
+		return fmt.Sprintf("-#%X", -v)
+	}
+	return fmt.Sprintf("#%X", v)
+}
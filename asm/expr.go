@@ -0,0 +1,160 @@
+package asm
+
+import "fmt"
+
+// exprError is an error at a column of an expression
+type exprError struct {
+	col int
+	msg string
+}
+
+func (e *exprError) Error() string {
+	return e.msg
+}
+
+// binaryOps are the binary operators by precedence, lowest first
+var binaryOps = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// exprParser evaluates an expression: numbers, symbols, parentheses,
+// unary - and ~, and the binary operators in binaryOps
+type exprParser struct {
+	tokens []token
+	pos    int
+	// lookup returns the value of a symbol
+	lookup func(name string) (int, error)
+	// end is the column just past the expression, for errors at its end
+	end int
+}
+
+// eval evaluates tokens as one expression
+func eval(tokens []token, end int, lookup func(string) (int, error)) (int, error) {
+	if len(tokens) == 0 {
+		return 0, &exprError{end, "missing expression"}
+	}
+	p := &exprParser{tokens: tokens, lookup: lookup, end: end}
+	v, err := p.binary(0)
+	if err != nil {
+		return 0, err
+	}
+	if p.pos < len(tokens) {
+		return 0, &exprError{tokens[p.pos].col, fmt.Sprintf("unexpected %q", tokens[p.pos].text)}
+	}
+	return v, nil
+}
+
+// peek returns the next token, or false at the end
+func (p *exprParser) peek() (token, bool) {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos], true
+	}
+	return token{}, false
+}
+
+// binary parses operators of precedence level and higher
+func (p *exprParser) binary(level int) (int, error) {
+	if level == len(binaryOps) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind != tokPunct || !contains(binaryOps[level], t.text) {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return 0, err
+		}
+		switch t.text {
+		case "|":
+			left |= right
+		case "^":
+			left ^= right
+		case "&":
+			left &= right
+		case "<<":
+			left <<= uint(right)
+		case ">>":
+			left >>= uint(right)
+		case "+":
+			left += right
+		case "-":
+			left -= right
+		case "*":
+			left *= right
+		case "/", "%":
+			if right == 0 {
+				return 0, &exprError{t.col, "division by zero"}
+			}
+			if t.text == "/" {
+				left /= right
+			} else {
+				left %= right
+			}
+		}
+	}
+}
+
+// unary parses a unary operator, parenthesised expression or operand
+func (p *exprParser) unary() (int, error) {
+	t, ok := p.peek()
+	if !ok {
+		return 0, &exprError{p.end, "missing operand"}
+	}
+	p.pos++
+	switch {
+	case t.is("-"):
+		v, err := p.unary()
+		return -v, err
+	case t.is("~"):
+		v, err := p.unary()
+		return ^v, err
+	case t.is("("):
+		v, err := p.binary(0)
+		if err != nil {
+			return 0, err
+		}
+		if t, ok := p.peek(); !ok || !t.is(")") {
+			return 0, &exprError{p.colAt(), "missing )"}
+		}
+		p.pos++
+		return v, nil
+	case t.kind == tokNumber:
+		return t.val, nil
+	case t.kind == tokIdent:
+		v, err := p.lookup(t.text)
+		if err != nil {
+			return 0, &exprError{t.col, err.Error()}
+		}
+		return v, nil
+	}
+	return 0, &exprError{t.col, fmt.Sprintf("unexpected %q", t.text)}
+}
+
+// colAt returns the column of the next token or the end
+func (p *exprParser) colAt() int {
+	if t, ok := p.peek(); ok {
+		return t.col
+	}
+	return p.end
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tokenKind is the kind of a token
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokNumber
+	tokString
+	tokPunct
+)
+
+// token is a lexical token of a source line
+type token struct {
+	kind tokenKind
+	// text is the identifier, punctuation or string contents
+	text string
+	// val is the value of a number
+	val int
+	// col is the 1-based column of the token
+	col int
+}
+
+// is reports whether the token is the punctuation p
+func (t token) is(p string) bool {
+	return t.kind == tokPunct && t.text == p
+}
+
+// isWord reports whether the token is the identifier w, ignoring case
+func (t token) isWord(w string) bool {
+	return t.kind == tokIdent && strings.EqualFold(t.text, w)
+}
+
+// lexError is a lexical error at a column
+type lexError struct {
+	col int
+	msg string
+}
+
+// punctuation lists the operators, longest first
+var punctuation = []string{"<<", ">>", ",", ":", "(", ")", "[", "]", "+", "-", "*", "/", "%", "&", "|", "^", "~", "="}
+
+// lex splits a source line into tokens, dropping the ; comment
+func lex(line string) ([]token, *lexError) {
+	var tokens []token
+	for i := 0; i < len(line); {
+		c := line[i]
+		col := i + 1
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == ';':
+			return tokens, nil
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(line) && isIdentChar(line[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: line[i:j], col: col})
+			i = j
+		case c == '#' || c == '$' || isDigit(c):
+			j := i + 1
+			for j < len(line) && isIdentChar(line[j]) {
+				j++
+			}
+			v, err := parseNumber(line[i:j])
+			if err != nil {
+				return nil, &lexError{col, err.Error()}
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: line[i:j], val: v, col: col})
+			i = j
+		case c == '"':
+			s, n, err := parseString(line[i:])
+			if err != nil {
+				return nil, &lexError{col, err.Error()}
+			}
+			tokens = append(tokens, token{kind: tokString, text: s, col: col})
+			i += n
+		default:
+			matched := false
+			for _, p := range punctuation {
+				if strings.HasPrefix(line[i:], p) {
+					tokens = append(tokens, token{kind: tokPunct, text: p, col: col})
+					i += len(p)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, &lexError{col, fmt.Sprintf("unexpected character %q", c)}
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// parseNumber parses a number: #1F, $1F or 0x1F hex, 0b101 binary or
+// decimal
+func parseNumber(s string) (int, error) {
+	digits, base := s, 10
+	lower := strings.ToLower(s)
+	switch {
+	case strings.HasPrefix(s, "#"), strings.HasPrefix(s, "$"):
+		digits, base = s[1:], 16
+	case strings.HasPrefix(lower, "0x"):
+		digits, base = s[2:], 16
+	case strings.HasPrefix(lower, "0b"):
+		digits, base = s[2:], 2
+	}
+	v, err := strconv.ParseUint(digits, base, 32)
+	if err != nil || digits == "" {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return int(v), nil
+}
+
+// parseString parses a double-quoted string with \" \\ \n and \t escapes
+// at the start of s and returns it and its length in s
+func parseString(s string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			i++
+			if i == len(s) {
+				break
+			}
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\':
+				b.WriteByte(s[i])
+			default:
+				return "", 0, fmt.Errorf("invalid escape \\%c", s[i])
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
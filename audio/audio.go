@@ -1,73 +1,35 @@
-// Package audio handles sound output for the CHIP-8 emulator
+// Package audio handles sound output for the CHIP-8 emulator using SDL2
 package audio
 
 import (
-	"math"
 	"sync"
 
+	"github.com/chip8-emulator/platform"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
-const (
-	// Audio configuration
-	SampleRate = 44100
-	Frequency  = 440 // A4 note
-	Amplitude  = 0.3 // Volume (0.0 - 1.0)
-)
+// Maximum queued audio, in bytes, before updates stop adding more
+const maxQueuedBytes = 4 * platform.SamplesPerFrame * 2
 
-// Beeper handles audio playback for the CHIP-8 sound timer
+// Beeper plays the sound timer on an SDL audio device. It implements
+// platform.ToneSink.
 type Beeper struct {
 	deviceID  sdl.AudioDeviceID
 	isPlaying bool
-	phase     float64
+	synth     platform.Synth
+	samples   [platform.SamplesPerFrame]int16
 	mu        sync.Mutex
 }
 
-// audioCallback is called by SDL when it needs more audio data
-func (b *Beeper) audioCallback(data []byte) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-
-	if !b.isPlaying {
-		// Fill with silence
-		for i := range data {
-			data[i] = 0
-		}
-		return
-	}
-
-	// Generate square wave
-	phaseIncrement := 2 * math.Pi * Frequency / SampleRate
-
-	for i := 0; i < len(data); i += 2 {
-		var sample int16
-		if math.Sin(b.phase) >= 0 {
-			sample = int16(Amplitude * 32767)
-		} else {
-			sample = int16(-Amplitude * 32767)
-		}
-
-		// Write 16-bit sample (little-endian)
-		data[i] = byte(sample)
-		data[i+1] = byte(sample >> 8)
-
-		b.phase += phaseIncrement
-		if b.phase >= 2*math.Pi {
-			b.phase -= 2 * math.Pi
-		}
-	}
-}
-
 // New creates a new Beeper instance
 func New() (*Beeper, error) {
 	b := &Beeper{}
 
 	spec := &sdl.AudioSpec{
-		Freq:     SampleRate,
+		Freq:     platform.SampleRate,
 		Format:   sdl.AUDIO_S16LSB,
 		Channels: 1,
 		Samples:  512,
-		Callback: sdl.AudioCallback(b.audioCallbackWrapper),
 	}
 
 	var obtainedSpec sdl.AudioSpec
@@ -78,17 +40,12 @@ func New() (*Beeper, error) {
 
 	b.deviceID = deviceID
 
-	// Start audio (paused initially)
+	// Start audio; samples are queued by Tone while the beeper plays
 	sdl.PauseAudioDevice(b.deviceID, false)
 
 	return b, nil
 }
 
-// audioCallbackWrapper wraps the callback for SDL
-func (b *Beeper) audioCallbackWrapper(userdata interface{}, stream []byte) {
-	b.audioCallback(stream)
-}
-
 // Play starts the beep sound
 func (b *Beeper) Play() {
 	b.mu.Lock()
@@ -118,15 +75,33 @@ func (b *Beeper) Close() {
 	}
 }
 
-// Update updates the beeper state based on the sound timer
-func (b *Beeper) Update(soundTimer uint8) {
-	if soundTimer > 0 {
+// Tone starts or stops the beeper to follow the sound timer and queues the
+// next 1/60 s of audio (should be called at 60Hz)
+func (b *Beeper) Tone(t platform.Tone) {
+	if t.On {
 		if !b.IsPlaying() {
 			b.Play()
 		}
 	} else {
 		if b.IsPlaying() {
 			b.Stop()
+			sdl.ClearQueuedAudio(b.deviceID)
 		}
+		return
+	}
+
+	// Keep latency low by not queueing more than a few updates ahead
+	if sdl.GetQueuedAudioSize(b.deviceID) > maxQueuedBytes {
+		return
+	}
+
+	b.synth.Generate(t, b.samples[:])
+
+	// Queue 16-bit samples (little-endian)
+	data := make([]byte, len(b.samples)*2)
+	for i, s := range b.samples {
+		data[2*i] = byte(s)
+		data[2*i+1] = byte(s >> 8)
 	}
+	sdl.QueueAudio(b.deviceID, data)
 }
@@ -0,0 +1,275 @@
+// Package cfg builds the control-flow graph of a ROM: its basic blocks, the
+// edges between them and the call graph of its subroutines. Jumps whose
+// targets are computed at run time (BNNN) can't be followed statically;
+// targets seen while the ROM runs, recorded by a Recorder, are merged in.
+package cfg
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/disasm"
+)
+
+// EdgeKind is how control passes along an edge
+type EdgeKind int
+
+const (
+	EdgeNext     EdgeKind = iota // falls through to the next instruction
+	EdgeJump                     // jumps
+	EdgeSkip                     // skips the next instruction
+	EdgeIndirect                 // jumps to a target computed at run time
+)
+
+// String returns the edge kind's name
+func (k EdgeKind) String() string {
+	switch k {
+	case EdgeNext:
+		return "next"
+	case EdgeJump:
+		return "jump"
+	case EdgeSkip:
+		return "skip"
+	case EdgeIndirect:
+		return "indirect"
+	}
+	return fmt.Sprintf("EdgeKind(%d)", int(k))
+}
+
+// Edge is a transfer of control to the block at To
+type Edge struct {
+	To   uint16
+	Kind EdgeKind
+}
+
+// Block is a basic block: instructions that run in sequence, entered only
+// at the first and left only after the last. Calls don't end a block.
+type Block struct {
+	// Start is the address of the first instruction and End the address
+	// after the last
+	Start, End   uint16
+	Instructions []disasm.Instruction
+	// Succs are the blocks control can pass to
+	Succs []Edge
+	// Calls are the subroutines called from the block, in order
+	Calls []uint16
+	// Indirect is set if the block ends in a BNNN jump
+	Indirect bool
+}
+
+// Last returns the block's last instruction
+func (b *Block) Last() disasm.Instruction {
+	return b.Instructions[len(b.Instructions)-1]
+}
+
+// Sub is a subroutine, or the main program: the blocks reachable from its
+// entry without following calls
+type Sub struct {
+	Entry uint16
+	// Blocks are the start addresses of its blocks, in order. A block
+	// shared by subroutines, such as a common tail, is in each of them.
+	Blocks []uint16
+	// Calls are the subroutines it calls, in order
+	Calls []uint16
+}
+
+// Jumps maps the address of each BNNN jump to the targets it was seen to
+// jump to
+type Jumps map[uint16][]uint16
+
+// Graph is the control-flow graph of a program
+type Graph struct {
+	Program *disasm.Program
+	// Blocks are the basic blocks by start address
+	Blocks map[uint16]*Block
+	// Subs are the main program and its subroutines by entry address
+	Subs map[uint16]*Sub
+	// Jumps are the computed jump targets merged into the graph
+	Jumps Jumps
+}
+
+// Build builds the graph of rom, following the computed jump targets in
+// jumps as well as the static control flow
+func Build(rom []byte, mode chip8.Mode, jumps Jumps) (*Graph, error) {
+	var entries []uint16
+	for _, targets := range jumps {
+		entries = append(entries, targets...)
+	}
+	p, err := disasm.Analyze(rom, mode, entries...)
+	if err != nil {
+		return nil, err
+	}
+	g := &Graph{Program: p, Blocks: make(map[uint16]*Block), Subs: make(map[uint16]*Sub), Jumps: jumps}
+	g.blocks(g.leaders())
+	g.subs()
+	return g, nil
+}
+
+// leaders finds the addresses that start a block: the entry point, every
+// target, and every instruction after one that passes control elsewhere
+func (g *Graph) leaders() map[uint16]bool {
+	p := g.Program
+	leaders := map[uint16]bool{p.Start: true}
+	for addr, in := range p.Instructions {
+		next := addr + uint16(in.Size)
+		switch f := disasm.FlowOf(in, p.Mode); f {
+		case disasm.FlowJump, disasm.FlowCall:
+			if target, ok := in.Target(); ok {
+				leaders[target] = true
+			}
+			if f == disasm.FlowJump {
+				leaders[next] = true
+			}
+		case disasm.FlowSkip:
+			leaders[next] = true
+			if after, ok := p.Instructions[next]; ok {
+				leaders[next+uint16(after.Size)] = true
+			}
+		case disasm.FlowIndirect, disasm.FlowEnd:
+			leaders[next] = true
+		}
+	}
+	for _, targets := range g.Jumps {
+		for _, target := range targets {
+			leaders[target] = true
+		}
+	}
+	return leaders
+}
+
+// blocks splits the code into blocks at the leaders and links them
+func (g *Graph) blocks(leaders map[uint16]bool) {
+	p := g.Program
+	var b *Block
+	for _, in := range p.Code() {
+		if b == nil || leaders[in.Addr] || in.Addr != b.End {
+			b = &Block{Start: in.Addr}
+			g.Blocks[b.Start] = b
+		}
+		b.Instructions = append(b.Instructions, in)
+		b.End = in.Addr + uint16(in.Size)
+		if target, ok := in.Target(); ok && disasm.FlowOf(in, p.Mode) == disasm.FlowCall {
+			b.Calls = append(b.Calls, target)
+		}
+		if f := disasm.FlowOf(in, p.Mode); f != disasm.FlowNext && f != disasm.FlowCall {
+			b = nil
+		}
+	}
+
+	for _, b := range g.Blocks {
+		last := b.Last()
+		switch disasm.FlowOf(last, p.Mode) {
+		case disasm.FlowNext, disasm.FlowCall:
+			g.link(b, b.End, EdgeNext)
+		case disasm.FlowJump:
+			if target, ok := last.Target(); ok {
+				g.link(b, target, EdgeJump)
+			}
+		case disasm.FlowSkip:
+			g.link(b, b.End, EdgeNext)
+			if after, ok := p.Instructions[b.End]; ok {
+				g.link(b, b.End+uint16(after.Size), EdgeSkip)
+			}
+		case disasm.FlowIndirect:
+			b.Indirect = true
+			for _, target := range g.Jumps[last.Addr] {
+				g.link(b, target, EdgeIndirect)
+			}
+		}
+	}
+}
+
+// link adds an edge from b to the block at to, if there is code there
+func (g *Graph) link(b *Block, to uint16, kind EdgeKind) {
+	if _, ok := g.Blocks[to]; !ok {
+		return
+	}
+	for _, e := range b.Succs {
+		if e.To == to && e.Kind == kind {
+			return
+		}
+	}
+	b.Succs = append(b.Succs, Edge{To: to, Kind: kind})
+}
+
+// subs groups the blocks into the main program and subroutines
+func (g *Graph) subs() {
+	entries := []uint16{g.Program.Start}
+	for _, b := range g.Blocks {
+		entries = append(entries, b.Calls...)
+	}
+	for _, entry := range entries {
+		if _, done := g.Subs[entry]; done {
+			continue
+		}
+		if _, ok := g.Blocks[entry]; !ok {
+			continue
+		}
+		s := &Sub{Entry: entry}
+		seen := map[uint16]bool{entry: true}
+		for work := []uint16{entry}; len(work) > 0; {
+			addr := work[len(work)-1]
+			work = work[:len(work)-1]
+			s.Blocks = append(s.Blocks, addr)
+			for _, e := range g.Blocks[addr].Succs {
+				if !seen[e.To] {
+					seen[e.To] = true
+					work = append(work, e.To)
+				}
+			}
+		}
+		sortAddrs(s.Blocks)
+		for _, addr := range s.Blocks {
+			for _, callee := range g.Blocks[addr].Calls {
+				if _, ok := g.Blocks[callee]; ok && !contains(s.Calls, callee) {
+					s.Calls = append(s.Calls, callee)
+				}
+			}
+		}
+		g.Subs[entry] = s
+	}
+}
+
+// Name returns the label of addr, or a name made from its address
+func (g *Graph) Name(addr uint16) string {
+	if name, ok := g.Program.Label(addr); ok {
+		return name
+	}
+	return fmt.Sprintf("loc_%03X", addr)
+}
+
+// Sorted returns the blocks in address order
+func (g *Graph) Sorted() []*Block {
+	blocks := make([]*Block, 0, len(g.Blocks))
+	for _, b := range g.Blocks {
+		blocks = append(blocks, b)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Start < blocks[j].Start })
+	return blocks
+}
+
+// SortedSubs returns the subroutines in address order
+func (g *Graph) SortedSubs() []*Sub {
+	subs := make([]*Sub, 0, len(g.Subs))
+	for _, s := range g.Subs {
+		subs = append(subs, s)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Entry < subs[j].Entry })
+	return subs
+}
+
+// sortAddrs sorts addresses in place
+func sortAddrs(addrs []uint16) {
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+}
+
+// contains reports whether addrs has addr
+func contains(addrs []uint16, addr uint16) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package cfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chip8-emulator/chip8"
+)
+
+// rom calls a subroutine with a skip, then jumps through BNNN to a loop
+var rom = []byte{
+	0x22, 0x08, // 200: CALL 208
+	0x60, 0x04, // 202: LD V0, 4
+	0xB2, 0x0C, // 204: JP V0, 20C
+	0x00, 0x00, // 206
+	0x31, 0x00, // 208: SE V1, 0
+	0x71, 0x01, // 20A: ADD V1, 1
+	0x00, 0xEE, // 20C: RET
+	0x00, 0x00, // 20E
+	0x12, 0x10, // 210: JP 210
+}
+
+func TestBuild(t *testing.T) {
+	g, err := Build(rom, chip8.ModeCHIP8, nil)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	want := map[uint16][]Edge{
+		0x200: nil,
+		0x208: {{0x20A, EdgeNext}, {0x20C, EdgeSkip}},
+		0x20A: {{0x20C, EdgeNext}},
+		0x20C: nil,
+	}
+	if len(g.Blocks) != len(want) {
+		t.Fatalf("Got %d blocks, want %d", len(g.Blocks), len(want))
+	}
+	for start, succs := range want {
+		b, ok := g.Blocks[start]
+		if !ok {
+			t.Errorf("No block at %03X", start)
+			continue
+		}
+		if !reflect.DeepEqual(b.Succs, succs) {
+			t.Errorf("Block %03X edges = %v, want %v", start, b.Succs, succs)
+		}
+	}
+	if b := g.Blocks[0x200]; !b.Indirect || b.End != 0x206 || !reflect.DeepEqual(b.Calls, []uint16{0x208}) {
+		t.Errorf("Block 200 = %+v, want an indirect block to 206 calling 208", b)
+	}
+
+	main, sub := g.Subs[0x200], g.Subs[0x208]
+	if main == nil || !reflect.DeepEqual(main.Calls, []uint16{0x208}) {
+		t.Errorf("main = %+v, want a call to 208", main)
+	}
+	if sub == nil || !reflect.DeepEqual(sub.Blocks, []uint16{0x208, 0x20A, 0x20C}) {
+		t.Errorf("Subroutine 208 = %+v", sub)
+	}
+}
+
+func TestRecordedJumps(t *testing.T) {
+	vm := chip8.New()
+	if err := vm.LoadROM(rom); err != nil {
+		t.Fatal(err)
+	}
+	rec := NewRecorder(vm)
+	for i := 0; i < 5; i++ {
+		if err := vm.Cycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jumps := rec.Jumps()
+	if !reflect.DeepEqual(jumps, Jumps{0x204: {0x210}}) {
+		t.Fatalf("Recorded jumps = %v", jumps)
+	}
+
+	g, err := Build(rom, chip8.ModeCHIP8, jumps)
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if succs := g.Blocks[0x200].Succs; !reflect.DeepEqual(succs, []Edge{{0x210, EdgeIndirect}}) {
+		t.Errorf("Block 200 edges = %v, want the recorded jump", succs)
+	}
+	if main := g.Subs[0x200]; !reflect.DeepEqual(main.Blocks, []uint16{0x200, 0x210}) {
+		t.Errorf("main blocks = %v, want 200 and 210", main.Blocks)
+	}
+}
+
+func TestExport(t *testing.T) {
+	g, err := Build(rom, chip8.ModeCHIP8, Jumps{0x204: {0x210}})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := g.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	var out jsonGraph
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(out.Blocks) != 5 || out.Blocks[0].Succs[0] != (jsonEdge{To: 0x210, Kind: "indirect"}) {
+		t.Errorf("JSON blocks = %+v", out.Blocks)
+	}
+
+	buf.Reset()
+	if err := g.WriteDOT(&buf); err != nil {
+		t.Fatalf("WriteDOT failed: %v", err)
+	}
+	for _, want := range []string{
+		`subgraph "cluster_sub_208"`,
+		`b208 -> b20C [color="darkgreen", label="skip"];`,
+		`b200 -> b210 [color="red", style="dashed", label="BNNN"];`,
+		`b200 -> b208 [style="dotted", label="call"];`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("DOT output is missing %s:\n%s", want, buf.String())
+		}
+	}
+}
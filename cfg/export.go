@@ -0,0 +1,156 @@
+package cfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// jsonGraph is the JSON form of a graph
+type jsonGraph struct {
+	Mode        string      `json:"mode"`
+	Entry       uint16      `json:"entry"`
+	Blocks      []jsonBlock `json:"blocks"`
+	Subroutines []jsonSub   `json:"subroutines"`
+}
+
+type jsonBlock struct {
+	Start        uint16     `json:"start"`
+	End          uint16     `json:"end"`
+	Label        string     `json:"label"`
+	Instructions []jsonInst `json:"instructions"`
+	Succs        []jsonEdge `json:"succs"`
+	Calls        []uint16   `json:"calls,omitempty"`
+	Indirect     bool       `json:"indirect,omitempty"`
+}
+
+type jsonInst struct {
+	Addr uint16 `json:"addr"`
+	Text string `json:"text"`
+}
+
+type jsonEdge struct {
+	To   uint16 `json:"to"`
+	Kind string `json:"kind"`
+}
+
+type jsonSub struct {
+	Entry  uint16   `json:"entry"`
+	Name   string   `json:"name"`
+	Blocks []uint16 `json:"blocks"`
+	Calls  []uint16 `json:"calls"`
+}
+
+// WriteJSON writes the blocks and subroutines as JSON. Addresses are
+// numbers; edge kinds are next, jump, skip or indirect.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	out := jsonGraph{Mode: g.Program.Mode.String(), Entry: g.Program.Start, Blocks: []jsonBlock{}, Subroutines: []jsonSub{}}
+	for _, b := range g.Sorted() {
+		jb := jsonBlock{Start: b.Start, End: b.End, Label: g.Name(b.Start), Succs: []jsonEdge{}, Calls: b.Calls, Indirect: b.Indirect}
+		for _, in := range b.Instructions {
+			jb.Instructions = append(jb.Instructions, jsonInst{Addr: in.Addr, Text: in.String()})
+		}
+		for _, e := range b.Succs {
+			jb.Succs = append(jb.Succs, jsonEdge{To: e.To, Kind: e.Kind.String()})
+		}
+		out.Blocks = append(out.Blocks, jb)
+	}
+	for _, s := range g.SortedSubs() {
+		calls := s.Calls
+		if calls == nil {
+			calls = []uint16{}
+		}
+		out.Subroutines = append(out.Subroutines, jsonSub{Entry: s.Entry, Name: g.Name(s.Entry), Blocks: s.Blocks, Calls: calls})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// edgeStyles are the DOT attributes of each edge kind
+var edgeStyles = map[EdgeKind]string{
+	EdgeNext:     `color="black"`,
+	EdgeJump:     `color="blue"`,
+	EdgeSkip:     `color="darkgreen", label="skip"`,
+	EdgeIndirect: `color="red", style="dashed", label="BNNN"`,
+}
+
+// WriteDOT writes the graph in Graphviz DOT, with a cluster of blocks for
+// each subroutine and dotted edges for calls. A block shared by several
+// subroutines is drawn in the first.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("digraph cfg {\n")
+	sb.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+
+	drawn := make(map[uint16]bool)
+	for _, s := range g.SortedSubs() {
+		fmt.Fprintf(&sb, "\tsubgraph \"cluster_%s\" {\n", g.Name(s.Entry))
+		fmt.Fprintf(&sb, "\t\tlabel=%q;\n", g.Name(s.Entry))
+		for _, addr := range s.Blocks {
+			if !drawn[addr] {
+				drawn[addr] = true
+				g.dotNode(&sb, "\t\t", g.Blocks[addr])
+			}
+		}
+		sb.WriteString("\t}\n")
+	}
+	// Code only reached by a computed jump that wasn't seen
+	for _, b := range g.Sorted() {
+		if !drawn[b.Start] {
+			g.dotNode(&sb, "\t", b)
+		}
+	}
+
+	for _, b := range g.Sorted() {
+		for _, e := range b.Succs {
+			fmt.Fprintf(&sb, "\tb%03X -> b%03X [%s];\n", b.Start, e.To, edgeStyles[e.Kind])
+		}
+		for _, callee := range b.Calls {
+			if _, ok := g.Blocks[callee]; ok {
+				fmt.Fprintf(&sb, "\tb%03X -> b%03X [style=\"dotted\", label=\"call\"];\n", b.Start, callee)
+			}
+		}
+	}
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// dotNode writes a block as a node listing its instructions
+func (g *Graph) dotNode(sb *strings.Builder, indent string, b *Block) {
+	label := g.Name(b.Start) + ":\\l"
+	for _, in := range b.Instructions {
+		label += fmt.Sprintf("%03X  %s\\l", in.Addr, dotEscape(in.String()))
+	}
+	attrs := ""
+	if b.Indirect {
+		attrs = `, color="red"`
+	}
+	fmt.Fprintf(sb, "%sb%03X [label=\"%s\"%s];\n", indent, b.Start, label, attrs)
+}
+
+// WriteCallDOT writes the call graph in Graphviz DOT: a node for each
+// subroutine and an edge for each call
+func (g *Graph) WriteCallDOT(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("digraph calls {\n")
+	sb.WriteString("\tnode [shape=box, fontname=\"monospace\"];\n")
+	for _, s := range g.SortedSubs() {
+		fmt.Fprintf(&sb, "\ts%03X [label=%q];\n", s.Entry, g.Name(s.Entry))
+	}
+	for _, s := range g.SortedSubs() {
+		for _, callee := range s.Calls {
+			fmt.Fprintf(&sb, "\ts%03X -> s%03X;\n", s.Entry, callee)
+		}
+	}
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// dotEscape escapes text for a DOT string
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
@@ -0,0 +1,55 @@
+package cfg
+
+import (
+	"github.com/chip8-emulator/chip8"
+)
+
+// Recorder is a chip8.Observer that records where BNNN jumps go as the VM
+// runs, to merge into a graph with Build
+type Recorder struct {
+	chip8.NopObserver
+	vm    *chip8.CHIP8
+	jumps Jumps
+}
+
+// NewRecorder creates a recorder and attaches it as the VM's observer,
+// alongside any observer that is already attached
+func NewRecorder(vm *chip8.CHIP8) *Recorder {
+	r := &Recorder{vm: vm, jumps: make(Jumps)}
+	vm.AddObserver(r)
+	return r
+}
+
+// Detach removes the recorder from the VM's observers
+func (r *Recorder) Detach() {
+	r.vm.RemoveObserver(r)
+}
+
+// AfterInstruction records the target of a BNNN jump, which is the PC
+// after it executes
+func (r *Recorder) AfterInstruction(pc, opcode uint16) {
+	if opcode&0xF000 == 0xB000 {
+		r.jumps.Add(pc, r.vm.PC)
+	}
+}
+
+// Jumps returns the targets recorded so far
+func (r *Recorder) Jumps() Jumps {
+	return r.jumps
+}
+
+// Add records a jump from from to to, if it is new
+func (j Jumps) Add(from, to uint16) {
+	if !contains(j[from], to) {
+		j[from] = append(j[from], to)
+	}
+}
+
+// Merge adds the jumps of other
+func (j Jumps) Merge(other Jumps) {
+	for from, targets := range other {
+		for _, to := range targets {
+			j.Add(from, to)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package chip8
+
+import (
+	"fmt"
+	"io"
+)
+
+// Bus carries the memory accesses a program makes: instruction fetches,
+// and the data reads and writes of its instructions. Addresses are within
+// the address space, the memory policy having already been applied. An
+// error stops execution with an *ExecError wrapping it.
+//
+// Memory remains the VM's RAM whatever bus is attached: ROMs and fonts are
+// loaded into it, save states and debuggers use it directly, and observers
+// are given its old contents on writes.
+type Bus interface {
+	Read(addr uint32) (uint8, error)
+	Write(addr uint32, v uint8) error
+}
+
+// RAM is plain memory, indexed by address. A nil CHIP8.Bus behaves like
+// RAM over CHIP8.Memory.
+type RAM []uint8
+
+// RAM returns the VM's memory as a bus device
+func (c *CHIP8) RAM() RAM {
+	return c.Memory[:]
+}
+
+func (r RAM) Read(addr uint32) (uint8, error) {
+	return r[addr], nil
+}
+
+func (r RAM) Write(addr uint32, v uint8) error {
+	r[addr] = v
+	return nil
+}
+
+// ReadOnly makes a device read-only: writes fail with ErrReadOnly
+type ReadOnly struct {
+	Bus
+}
+
+func (ReadOnly) Write(addr uint32, v uint8) error {
+	return ErrReadOnly
+}
+
+// DebugPort is an output port: each byte written to it is written to W, so
+// a program can print by storing characters at its address. Reads return 0.
+type DebugPort struct {
+	W io.Writer
+}
+
+func (DebugPort) Read(addr uint32) (uint8, error) {
+	return 0, nil
+}
+
+func (p DebugPort) Write(addr uint32, v uint8) error {
+	_, err := p.W.Write([]byte{v})
+	return err
+}
+
+// LogBus writes a line to W for each access to the device it wraps,
+// including instruction fetches
+type LogBus struct {
+	Bus
+	W io.Writer
+}
+
+func (l LogBus) Read(addr uint32) (uint8, error) {
+	v, err := l.Bus.Read(addr)
+	if err != nil {
+		fmt.Fprintf(l.W, "read  %04X: %v\n", addr, err)
+	} else {
+		fmt.Fprintf(l.W, "read  %04X = %02X\n", addr, v)
+	}
+	return v, err
+}
+
+func (l LogBus) Write(addr uint32, v uint8) error {
+	err := l.Bus.Write(addr, v)
+	if err != nil {
+		fmt.Fprintf(l.W, "write %04X = %02X: %v\n", addr, v, err)
+	} else {
+		fmt.Fprintf(l.W, "write %04X = %02X\n", addr, v)
+	}
+	return err
+}
+
+// region is a range of addresses mapped to a device
+type region struct {
+	start, end uint32
+	dev        Bus
+}
+
+// MemoryMap is a bus that sends each access to the device mapped at its
+// address, or to RAM where nothing is mapped. Devices are given the full
+// address, not an offset into their region.
+type MemoryMap struct {
+	RAM     Bus
+	regions []region
+}
+
+// NewMemoryMap creates a memory map with nothing mapped over ram
+func NewMemoryMap(ram Bus) *MemoryMap {
+	return &MemoryMap{RAM: ram}
+}
+
+// Map maps the addresses from start up to, but not including, end to dev.
+// A region mapped later takes precedence where it overlaps earlier ones.
+func (m *MemoryMap) Map(start, end uint32, dev Bus) error {
+	if start >= end || end > ExtendedMemorySize {
+		return fmt.Errorf("invalid region 0x%04X-0x%04X", start, end)
+	}
+	m.regions = append(m.regions, region{start: start, end: end, dev: dev})
+	return nil
+}
+
+// device returns the device that handles addr
+func (m *MemoryMap) device(addr uint32) Bus {
+	for i := len(m.regions) - 1; i >= 0; i-- {
+		if r := m.regions[i]; addr >= r.start && addr < r.end {
+			return r.dev
+		}
+	}
+	return m.RAM
+}
+
+func (m *MemoryMap) Read(addr uint32) (uint8, error) {
+	return m.device(addr).Read(addr)
+}
+
+func (m *MemoryMap) Write(addr uint32, v uint8) error {
+	return m.device(addr).Write(addr, v)
+}
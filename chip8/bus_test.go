@@ -0,0 +1,118 @@
+package chip8
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBusReadOnlyFont(t *testing.T) {
+	c := New()
+	m := NewMemoryMap(c.RAM())
+	if err := m.Map(0, FontEnd, ReadOnly{c.RAM()}); err != nil {
+		t.Fatal(err)
+	}
+	c.Bus = m
+	// LD I, #000; LD [I], V0; LD V0, [I]
+	c.LoadROM([]byte{0xA0, 0x00, 0xF0, 0x65, 0xF0, 0x55})
+
+	for i := 0; i < 2; i++ {
+		if err := c.Cycle(); err != nil {
+			t.Fatalf("cycle %d: %v", i, err)
+		}
+	}
+	if c.V[0] != Fontset[0] {
+		t.Errorf("V0 = %#x, want the font byte %#x", c.V[0], Fontset[0])
+	}
+
+	c.V[0] = 0x42
+	err := c.Cycle()
+	var execErr *ExecError
+	if !errors.As(err, &execErr) || !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("write to the font returned %v, want ErrReadOnly", err)
+	}
+	if execErr.Addr != 0 || c.PC != 0x204 {
+		t.Errorf("fault at address %#x, PC %#x; want 0, 0x204", execErr.Addr, c.PC)
+	}
+	if !strings.Contains(err.Error(), "address 0x0000") {
+		t.Errorf("error %q doesn't name the address", err)
+	}
+	if c.Memory[0] != Fontset[0] {
+		t.Errorf("font byte overwritten with %#x", c.Memory[0])
+	}
+}
+
+func TestBusDebugPort(t *testing.T) {
+	c := New()
+	var out strings.Builder
+	m := NewMemoryMap(c.RAM())
+	m.Map(0xFFE, 0xFFF, DebugPort{W: &out})
+	c.Bus = m
+	// LD V0, 'H'; LD V1, 'i'; LD I, #FFE; LD [I], V0; LD V0, V1; LD [I], V0
+	c.LoadROM([]byte{
+		0x60, 'H', 0x61, 'i',
+		0xAF, 0xFE, 0xF0, 0x55,
+		0x80, 0x10, 0xF0, 0x55,
+	})
+	for i := 0; i < 6; i++ {
+		if err := c.Cycle(); err != nil {
+			t.Fatalf("cycle %d: %v", i, err)
+		}
+	}
+	if out.String() != "Hi" {
+		t.Errorf("port output %q, want %q", out.String(), "Hi")
+	}
+	if c.Memory[0xFFE] != 0 {
+		t.Errorf("port write reached RAM: %#x", c.Memory[0xFFE])
+	}
+}
+
+func TestBusLog(t *testing.T) {
+	c := New()
+	var log strings.Builder
+	c.Bus = LogBus{Bus: c.RAM(), W: &log}
+	// LD I, #300; LD [I], V0
+	c.LoadROM([]byte{0xA3, 0x00, 0xF0, 0x55})
+	c.V[0] = 7
+	for i := 0; i < 2; i++ {
+		if err := c.Cycle(); err != nil {
+			t.Fatalf("cycle %d: %v", i, err)
+		}
+	}
+	want := "read  0200 = A3\nread  0201 = 00\nread  0202 = F0\nread  0203 = 55\nwrite 0300 = 07\n"
+	if log.String() != want {
+		t.Errorf("log:\n%s\nwant:\n%s", log.String(), want)
+	}
+	if c.Memory[0x300] != 7 {
+		t.Errorf("Memory[0x300] = %d, want 7", c.Memory[0x300])
+	}
+}
+
+func TestMemoryMapPrecedence(t *testing.T) {
+	ram := make(RAM, MemorySize)
+	m := NewMemoryMap(ram)
+	m.Map(0x100, 0x200, ReadOnly{ram})
+	m.Map(0x180, 0x190, ram)
+
+	for _, tt := range []struct {
+		addr uint32
+		err  error
+	}{
+		{0x0FF, nil},
+		{0x100, ErrReadOnly},
+		{0x185, nil},
+		{0x1FF, ErrReadOnly},
+		{0x200, nil},
+	} {
+		if err := m.Write(tt.addr, 1); err != tt.err {
+			t.Errorf("write %#x: got %v, want %v", tt.addr, err, tt.err)
+		}
+	}
+
+	if err := m.Map(0x200, 0x200, ram); err == nil {
+		t.Error("empty region mapped")
+	}
+	if err := m.Map(0, ExtendedMemorySize+1, ram); err == nil {
+		t.Error("region past the end of memory mapped")
+	}
+}
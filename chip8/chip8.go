@@ -3,12 +3,14 @@ package chip8
 
 import (
 	"fmt"
-	"math/rand"
+	"math/rand/v2"
 )
 
 const (
 	// Memory size (4KB)
 	MemorySize = 4096
+	// XO-CHIP memory size (64KB)
+	ExtendedMemorySize = 0x10000
 	// Number of general purpose registers
 	NumRegisters = 16
 	// Stack size (16 levels)
@@ -17,16 +19,31 @@ const (
 	DisplayWidth = 64
 	// Display height in pixels
 	DisplayHeight = 32
+	// High-resolution (SUPER-CHIP) display width in pixels
+	HiResWidth = 128
+	// High-resolution (SUPER-CHIP) display height in pixels
+	HiResHeight = 64
 	// Number of keys on the keypad
 	NumKeys = 16
 	// Program start address (programs are loaded at 0x200)
 	ProgramStart = 0x200
+	// Address of the large SUPER-CHIP font (follows the small font)
+	BigFontStart = 0x50
+	// Address after the fonts
+	FontEnd = BigFontStart + 160
+	// Number of RPL user flags (SUPER-CHIP uses the first 8)
+	NumFlags = 16
+	// Length of the XO-CHIP audio pattern buffer in bytes
+	AudioPatternSize = 16
+	// Default XO-CHIP audio pitch (4000 Hz playback rate)
+	DefaultPitch = 64
 )
 
 // CHIP8 represents the CHIP-8 virtual machine
 type CHIP8 struct {
-	// Memory (4KB)
-	Memory [MemorySize]uint8
+	// Memory (4KB, or 64KB in XO-CHIP mode; see AddressSpace). Programs
+	// reach it through Bus.
+	Memory [ExtendedMemorySize]uint8
 
 	// General purpose registers V0-VF
 	V [NumRegisters]uint8
@@ -49,8 +66,21 @@ type CHIP8 struct {
 	// Sound timer
 	SoundTimer uint8
 
-	// Display buffer (64x32 monochrome)
-	Display [DisplayWidth * DisplayHeight]uint8
+	// Display buffer (64x32 or 128x64); rows are Width() pixels wide.
+	// Each pixel holds one bit per bitplane, so values are 0-3.
+	Display [HiResWidth * HiResHeight]uint8
+
+	// Bitplanes selected for drawing, clearing and scrolling (XO-CHIP FN01)
+	Plane uint8
+
+	// Audio pattern buffer played while the sound timer is active (XO-CHIP)
+	AudioPattern [AudioPatternSize]uint8
+
+	// Audio pattern playback pitch (XO-CHIP FX3A)
+	Pitch uint8
+
+	// High-resolution (128x64) display mode is active
+	HiRes bool
 
 	// Keypad state (16 keys)
 	Keys [NumKeys]bool
@@ -63,6 +93,51 @@ type CHIP8 struct {
 
 	// Register to store the pressed key
 	KeyRegister uint8
+
+	// RPL user flags (SUPER-CHIP FX75/FX85)
+	RPL [NumFlags]uint8
+
+	// Set when the program has executed 00FD (exit)
+	Halted bool
+
+	// Mode selects the instruction set
+	Mode Mode
+
+	// Quirks selects platform-specific opcode behaviour
+	Quirks Quirks
+
+	// MemoryPolicy selects how out-of-range memory and key accesses are
+	// handled
+	MemoryPolicy MemoryPolicy
+
+	// Timing selects how instructions are scheduled within a frame
+	Timing Timing
+
+	// Bus, if set, carries every memory access the program makes, so
+	// regions can be mapped to devices; when nil the program accesses
+	// Memory directly
+	Bus Bus
+
+	// Observer, if set, is notified as instructions execute
+	Observer Observer
+
+	// Elapsed cycles: instructions executed under TimingNone, machine
+	// cycles under TimingVIP
+	Cycles uint64
+
+	// Machine cycles the last frame overran its budget by (TimingVIP)
+	cycleCarry int
+
+	// Set after a draw when Quirks.DisplayWait is enabled; cleared by the
+	// next timer tick
+	waitingForVBlank bool
+
+	// Random number source for CXNN
+	random RandomSource
+
+	// Address and opcode of the instruction being executed, for errors
+	instrPC uint16
+	opcode  uint16
 }
 
 // Fontset contains the built-in CHIP-8 font sprites (0-F)
@@ -86,13 +161,50 @@ var Fontset = [80]uint8{
 	0xF0, 0x80, 0xF0, 0x80, 0x80, // F
 }
 
-// New creates and initializes a new CHIP-8 virtual machine
+// BigFontset contains the large SUPER-CHIP font sprites (0-F)
+// Each character is 8 pixels wide and 10 bytes tall
+var BigFontset = [160]uint8{
+	0x3C, 0x7E, 0xE7, 0xC3, 0xC3, 0xC3, 0xC3, 0xE7, 0x7E, 0x3C, // 0
+	0x18, 0x38, 0x58, 0x18, 0x18, 0x18, 0x18, 0x18, 0x18, 0x3C, // 1
+	0x3E, 0x7F, 0xC3, 0x06, 0x0C, 0x18, 0x30, 0x60, 0xFF, 0xFF, // 2
+	0x3C, 0x7E, 0xC3, 0x03, 0x0E, 0x0E, 0x03, 0xC3, 0x7E, 0x3C, // 3
+	0x06, 0x0E, 0x1E, 0x36, 0x66, 0xC6, 0xFF, 0xFF, 0x06, 0x06, // 4
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFC, 0xFE, 0x03, 0xC3, 0x7E, 0x3C, // 5
+	0x3E, 0x7C, 0xE0, 0xC0, 0xFC, 0xFE, 0xC3, 0xC3, 0x7E, 0x3C, // 6
+	0xFF, 0xFF, 0x03, 0x06, 0x0C, 0x18, 0x30, 0x60, 0x60, 0x60, // 7
+	0x3C, 0x7E, 0xC3, 0xC3, 0x7E, 0x7E, 0xC3, 0xC3, 0x7E, 0x3C, // 8
+	0x3C, 0x7E, 0xC3, 0xC3, 0x7F, 0x3F, 0x03, 0x03, 0x3E, 0x7C, // 9
+	0x3C, 0x7E, 0xC3, 0xC3, 0xFF, 0xFF, 0xC3, 0xC3, 0xC3, 0xC3, // A
+	0xFC, 0xFE, 0xC3, 0xC3, 0xFE, 0xFE, 0xC3, 0xC3, 0xFE, 0xFC, // B
+	0x3C, 0x7E, 0xC3, 0xC0, 0xC0, 0xC0, 0xC0, 0xC3, 0x7E, 0x3C, // C
+	0xFC, 0xFE, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xC3, 0xFE, 0xFC, // D
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFC, 0xFC, 0xC0, 0xC0, 0xFF, 0xFF, // E
+	0xFF, 0xFF, 0xC0, 0xC0, 0xFC, 0xFC, 0xC0, 0xC0, 0xC0, 0xC0, // F
+}
+
+// DefaultAudioPattern is the XO-CHIP audio pattern used until a program
+// loads its own: a 250 Hz square wave at the default pitch
+var DefaultAudioPattern = [AudioPatternSize]uint8{
+	0xFF, 0x00, 0xFF, 0x00, 0xFF, 0x00, 0xFF, 0x00,
+	0xFF, 0x00, 0xFF, 0x00, 0xFF, 0x00, 0xFF, 0x00,
+}
+
+// New creates and initializes a new CHIP-8 virtual machine. The random
+// source is seeded randomly; use Seed or SetRandomSource for reproducible
+// runs.
 func New() *CHIP8 {
-	c := &CHIP8{}
+	c := &CHIP8{random: NewRandomSource(rand.Uint64())}
 	c.Reset()
 	return c
 }
 
+// NewWithQuirks creates a new CHIP-8 virtual machine using the given quirks
+func NewWithQuirks(quirks Quirks) *CHIP8 {
+	c := New()
+	c.Quirks = quirks
+	return c
+}
+
 // Reset resets the CHIP-8 to its initial state
 func (c *CHIP8) Reset() {
 	// Clear memory
@@ -120,6 +232,11 @@ func (c *CHIP8) Reset() {
 		c.Keys[i] = false
 	}
 
+	// Clear RPL flags
+	for i := range c.RPL {
+		c.RPL[i] = 0
+	}
+
 	// Reset other state
 	c.I = 0
 	c.PC = ProgramStart
@@ -129,17 +246,69 @@ func (c *CHIP8) Reset() {
 	c.DrawFlag = true
 	c.WaitingForKey = false
 	c.KeyRegister = 0
+	c.waitingForVBlank = false
+	c.Cycles = 0
+	c.cycleCarry = 0
+	c.HiRes = false
+	c.Halted = false
+	c.Plane = 1
+	c.AudioPattern = DefaultAudioPattern
+	c.Pitch = DefaultPitch
 
 	// Load fontset into memory (starting at 0x000)
 	for i, b := range Fontset {
 		c.Memory[i] = b
 	}
+
+	// Load the large font right after it
+	for i, b := range BigFontset {
+		c.Memory[BigFontStart+i] = b
+	}
+}
+
+// NewWithMode creates a new virtual machine running the given instruction
+// set, using the quirks that match it
+func NewWithMode(mode Mode) *CHIP8 {
+	c := NewWithQuirks(mode.DefaultQuirks())
+	c.Mode = mode
+	return c
+}
+
+// AddressSpace returns the number of addressable bytes of memory in the
+// current mode
+func (c *CHIP8) AddressSpace() int {
+	if c.Mode == ModeXOCHIP {
+		return ExtendedMemorySize
+	}
+	return MemorySize
+}
+
+// Width returns the width of the active display resolution
+func (c *CHIP8) Width() int {
+	if c.HiRes {
+		return HiResWidth
+	}
+	return DisplayWidth
+}
+
+// Height returns the height of the active display resolution
+func (c *CHIP8) Height() int {
+	if c.HiRes {
+		return HiResHeight
+	}
+	return DisplayHeight
+}
+
+// Pixels returns the active part of the display buffer, Width() * Height()
+// pixels in row-major order
+func (c *CHIP8) Pixels() []uint8 {
+	return c.Display[:c.Width()*c.Height()]
 }
 
 // LoadROM loads a ROM file into memory starting at 0x200
 func (c *CHIP8) LoadROM(data []byte) error {
-	if len(data) > MemorySize-ProgramStart {
-		return fmt.Errorf("ROM too large: %d bytes (max %d)", len(data), MemorySize-ProgramStart)
+	if max := c.AddressSpace() - ProgramStart; len(data) > max {
+		return fmt.Errorf("ROM too large: %d bytes (max %d)", len(data), max)
 	}
 
 	for i, b := range data {
@@ -156,7 +325,7 @@ func (c *CHIP8) SetKey(key uint8, pressed bool) {
 
 		// If we're waiting for a key and a key was pressed
 		if c.WaitingForKey && pressed {
-			c.V[c.KeyRegister] = key
+			c.setV(c.KeyRegister&0xF, key)
 			c.WaitingForKey = false
 		}
 	}
@@ -168,8 +337,17 @@ func (c *CHIP8) UpdateTimers() {
 		c.DelayTimer--
 	}
 	if c.SoundTimer > 0 {
-		c.SoundTimer--
+		c.setSoundTimer(c.SoundTimer - 1)
 	}
+
+	// The timer tick doubles as the vertical blank interrupt
+	c.waitingForVBlank = false
+}
+
+// WaitingForVBlank returns true if execution is paused until the next timer
+// tick because of the display wait quirk or VIP timing
+func (c *CHIP8) WaitingForVBlank() bool {
+	return c.waitingForVBlank
 }
 
 // ShouldBeep returns true if the sound timer is active
@@ -184,39 +362,100 @@ func (c *CHIP8) Cycle() error {
 		return nil
 	}
 
+	// Don't execute until the next vertical blank after a draw
+	if c.waitingForVBlank {
+		return nil
+	}
+
+	// Don't execute once the program has exited
+	if c.Halted {
+		return nil
+	}
+
+	c.instrPC = c.PC
+	c.opcode = 0
+
 	// Fetch opcode (2 bytes, big-endian)
-	opcode := uint16(c.Memory[c.PC])<<8 | uint16(c.Memory[c.PC+1])
+	hi, err := c.fetch(uint32(c.PC))
+	if err != nil {
+		return err
+	}
+	lo, err := c.fetch(uint32(c.PC) + 1)
+	if err != nil {
+		return err
+	}
+	c.opcode = uint16(hi)<<8 | uint16(lo)
+	cost := c.instructionCycles(c.opcode)
+
+	if c.Observer != nil {
+		c.Observer.BeforeInstruction(c.instrPC, c.opcode)
+	}
 
 	// Increment program counter before execution
 	c.PC += 2
 
-	// Execute opcode
-	return c.executeOpcode(opcode)
+	// Execute opcode, leaving PC at the faulting instruction on error
+	if err := c.executeOpcode(c.opcode); err != nil {
+		c.PC = c.instrPC
+		return err
+	}
+
+	// Taken skips cost extra on the VIP
+	if c.Timing == TimingVIP && c.PC-c.instrPC > 2 && isSkip(c.opcode) {
+		cost += vipSkipCycles
+	}
+	c.Cycles += uint64(cost)
+
+	if c.MemoryPolicy == MemoryMask {
+		c.PC &= uint16(c.addressMask())
+		c.I &= uint16(c.addressMask())
+	}
+
+	if c.Observer != nil {
+		c.Observer.AfterInstruction(c.instrPC, c.opcode)
+	}
+	return nil
 }
 
 // executeOpcode decodes and executes a single opcode
 func (c *CHIP8) executeOpcode(opcode uint16) error {
 	// Extract common opcode parts
-	x := uint8((opcode & 0x0F00) >> 8)  // Second nibble
-	y := uint8((opcode & 0x00F0) >> 4)  // Third nibble
-	n := uint8(opcode & 0x000F)         // Fourth nibble
-	nn := uint8(opcode & 0x00FF)        // Second byte
-	nnn := opcode & 0x0FFF              // Last three nibbles
+	x := uint8((opcode & 0x0F00) >> 8) // Second nibble
+	y := uint8((opcode & 0x00F0) >> 4) // Third nibble
+	n := uint8(opcode & 0x000F)        // Fourth nibble
+	nn := uint8(opcode & 0x00FF)       // Second byte
+	nnn := opcode & 0x0FFF             // Last three nibbles
 
 	switch opcode & 0xF000 {
 	case 0x0000:
-		switch opcode {
-		case 0x00E0: // 00E0: Clear screen
-			for i := range c.Display {
-				c.Display[i] = 0
-			}
-			c.DrawFlag = true
-		case 0x00EE: // 00EE: Return from subroutine
+		switch {
+		case opcode == 0x00E0: // 00E0: Clear screen
+			c.clearDisplay()
+		case opcode == 0x00EE: // 00EE: Return from subroutine
 			if c.SP == 0 {
-				return fmt.Errorf("stack underflow")
+				return c.fault(ErrStackUnderflow, 0)
+			}
+			if c.SP > StackSize {
+				return c.fault(ErrStackOverflow, 0)
 			}
 			c.SP--
 			c.PC = c.Stack[c.SP]
+		case c.Mode == ModeCHIP8:
+			// 0NNN: Call machine code routine (ignored on modern interpreters)
+		case opcode&0xFFF0 == 0x00C0: // 00CN: Scroll display down N pixels (SCHIP)
+			c.scroll(0, int(n))
+		case opcode&0xFFF0 == 0x00D0 && c.Mode == ModeXOCHIP: // 00DN: Scroll display up N pixels (XO-CHIP)
+			c.scroll(0, -int(n))
+		case opcode == 0x00FB: // 00FB: Scroll display right 4 pixels (SCHIP)
+			c.scroll(4, 0)
+		case opcode == 0x00FC: // 00FC: Scroll display left 4 pixels (SCHIP)
+			c.scroll(-4, 0)
+		case opcode == 0x00FD: // 00FD: Exit interpreter (SCHIP)
+			c.Halted = true
+		case opcode == 0x00FE: // 00FE: Switch to 64x32 resolution (SCHIP)
+			c.setHiRes(false)
+		case opcode == 0x00FF: // 00FF: Switch to 128x64 resolution (SCHIP)
+			c.setHiRes(true)
 		default:
 			// 0NNN: Call machine code routine (ignored on modern interpreters)
 		}
@@ -226,7 +465,7 @@ func (c *CHIP8) executeOpcode(opcode uint16) error {
 
 	case 0x2000: // 2NNN: Call subroutine at NNN
 		if c.SP >= StackSize {
-			return fmt.Errorf("stack overflow")
+			return c.fault(ErrStackOverflow, 0)
 		}
 		c.Stack[c.SP] = c.PC
 		c.SP++
@@ -234,147 +473,430 @@ func (c *CHIP8) executeOpcode(opcode uint16) error {
 
 	case 0x3000: // 3XNN: Skip next instruction if VX == NN
 		if c.V[x] == nn {
-			c.PC += 2
+			c.skip()
 		}
 
 	case 0x4000: // 4XNN: Skip next instruction if VX != NN
 		if c.V[x] != nn {
-			c.PC += 2
+			c.skip()
 		}
 
-	case 0x5000: // 5XY0: Skip next instruction if VX == VY
-		if c.V[x] == c.V[y] {
-			c.PC += 2
+	case 0x5000:
+		switch {
+		case n == 0x2 && c.Mode == ModeXOCHIP: // 5XY2: Store VX-VY in memory starting at I (XO-CHIP)
+			for i, r := range registerRange(x, y) {
+				if err := c.write(uint32(c.I)+uint32(i), c.V[r]); err != nil {
+					return err
+				}
+			}
+		case n == 0x3 && c.Mode == ModeXOCHIP: // 5XY3: Load VX-VY from memory starting at I (XO-CHIP)
+			for i, r := range registerRange(x, y) {
+				v, err := c.read(uint32(c.I) + uint32(i))
+				if err != nil {
+					return err
+				}
+				c.setV(r, v)
+			}
+		default: // 5XY0: Skip next instruction if VX == VY
+			if c.V[x] == c.V[y] {
+				c.skip()
+			}
 		}
 
 	case 0x6000: // 6XNN: Set VX to NN
-		c.V[x] = nn
+		c.setV(x, nn)
 
 	case 0x7000: // 7XNN: Add NN to VX (no carry flag)
-		c.V[x] += nn
+		c.setV(x, c.V[x]+nn)
 
 	case 0x8000:
 		switch n {
 		case 0x0: // 8XY0: Set VX to VY
-			c.V[x] = c.V[y]
+			c.setV(x, c.V[y])
 		case 0x1: // 8XY1: Set VX to VX OR VY
-			c.V[x] |= c.V[y]
+			c.setV(x, c.V[x]|c.V[y])
+			if c.Quirks.VFReset {
+				c.setV(0xF, 0)
+			}
 		case 0x2: // 8XY2: Set VX to VX AND VY
-			c.V[x] &= c.V[y]
+			c.setV(x, c.V[x]&c.V[y])
+			if c.Quirks.VFReset {
+				c.setV(0xF, 0)
+			}
 		case 0x3: // 8XY3: Set VX to VX XOR VY
-			c.V[x] ^= c.V[y]
+			c.setV(x, c.V[x]^c.V[y])
+			if c.Quirks.VFReset {
+				c.setV(0xF, 0)
+			}
 		case 0x4: // 8XY4: Add VY to VX, VF = carry
 			sum := uint16(c.V[x]) + uint16(c.V[y])
-			c.V[x] = uint8(sum)
+			c.setV(x, uint8(sum))
 			if sum > 255 {
-				c.V[0xF] = 1
+				c.setV(0xF, 1)
 			} else {
-				c.V[0xF] = 0
+				c.setV(0xF, 0)
 			}
 		case 0x5: // 8XY5: Subtract VY from VX, VF = NOT borrow
-			if c.V[x] >= c.V[y] {
-				c.V[0xF] = 1
+			noBorrow := c.V[x] >= c.V[y]
+			c.setV(x, c.V[x]-c.V[y])
+			if noBorrow {
+				c.setV(0xF, 1)
 			} else {
-				c.V[0xF] = 0
+				c.setV(0xF, 0)
 			}
-			c.V[x] -= c.V[y]
-		case 0x6: // 8XY6: Shift VX right, VF = LSB before shift
-			c.V[0xF] = c.V[x] & 0x1
-			c.V[x] >>= 1
+		case 0x6: // 8XY6: Shift VX (or VY) right, VF = LSB before shift
+			src := c.V[x]
+			if c.Quirks.ShiftUsesVY {
+				src = c.V[y]
+			}
+			c.setV(x, src>>1)
+			c.setV(0xF, src&0x1)
 		case 0x7: // 8XY7: Set VX to VY - VX, VF = NOT borrow
-			if c.V[y] >= c.V[x] {
-				c.V[0xF] = 1
+			noBorrow := c.V[y] >= c.V[x]
+			c.setV(x, c.V[y]-c.V[x])
+			if noBorrow {
+				c.setV(0xF, 1)
 			} else {
-				c.V[0xF] = 0
+				c.setV(0xF, 0)
+			}
+		case 0xE: // 8XYE: Shift VX (or VY) left, VF = MSB before shift
+			src := c.V[x]
+			if c.Quirks.ShiftUsesVY {
+				src = c.V[y]
 			}
-			c.V[x] = c.V[y] - c.V[x]
-		case 0xE: // 8XYE: Shift VX left, VF = MSB before shift
-			c.V[0xF] = (c.V[x] & 0x80) >> 7
-			c.V[x] <<= 1
+			c.setV(x, src<<1)
+			c.setV(0xF, (src&0x80)>>7)
 		default:
-			return fmt.Errorf("unknown opcode: 0x%04X", opcode)
+			return c.fault(ErrUnknownOpcode, 0)
 		}
 
 	case 0x9000: // 9XY0: Skip next instruction if VX != VY
 		if c.V[x] != c.V[y] {
-			c.PC += 2
+			c.skip()
 		}
 
 	case 0xA000: // ANNN: Set I to NNN
 		c.I = nnn
 
-	case 0xB000: // BNNN: Jump to NNN + V0
-		c.PC = nnn + uint16(c.V[0])
+	case 0xB000: // BNNN: Jump to NNN + V0 (or BXNN: XNN + VX)
+		if c.Quirks.JumpUsesVX {
+			c.PC = nnn + uint16(c.V[x])
+		} else {
+			c.PC = nnn + uint16(c.V[0])
+		}
 
 	case 0xC000: // CXNN: Set VX to random byte AND NN
-		c.V[x] = uint8(rand.Intn(256)) & nn
+		c.setV(x, uint8(c.RandomSource().Uint64())&nn)
 
 	case 0xD000: // DXYN: Draw sprite at (VX, VY) with N bytes of sprite data starting at I
-		c.V[0xF] = 0
-		for row := uint8(0); row < n; row++ {
-			sprite := c.Memory[c.I+uint16(row)]
-			for col := uint8(0); col < 8; col++ {
-				if (sprite & (0x80 >> col)) != 0 {
-					px := (c.V[x] + col) % DisplayWidth
-					py := (c.V[y] + row) % DisplayHeight
-					idx := int(py)*DisplayWidth + int(px)
-					if c.Display[idx] == 1 {
-						c.V[0xF] = 1
-					}
-					c.Display[idx] ^= 1
-				}
-			}
+		width, height := 8, int(n)
+		if n == 0 && c.Mode != ModeCHIP8 {
+			// DXY0: Draw 16x16 sprite (SCHIP)
+			width, height = 16, 16
+		}
+		if err := c.drawSprite(c.V[x], c.V[y], width, height); err != nil {
+			return err
+		}
+		if c.Quirks.DisplayWait || c.Timing == TimingVIP {
+			c.waitingForVBlank = true
 		}
-		c.DrawFlag = true
 
 	case 0xE000:
 		switch nn {
 		case 0x9E: // EX9E: Skip next instruction if key VX is pressed
-			if c.Keys[c.V[x]] {
-				c.PC += 2
+			pressed, err := c.key(c.V[x])
+			if err != nil {
+				return err
+			}
+			if pressed {
+				c.skip()
 			}
 		case 0xA1: // EXA1: Skip next instruction if key VX is not pressed
-			if !c.Keys[c.V[x]] {
-				c.PC += 2
+			pressed, err := c.key(c.V[x])
+			if err != nil {
+				return err
+			}
+			if !pressed {
+				c.skip()
 			}
 		default:
-			return fmt.Errorf("unknown opcode: 0x%04X", opcode)
+			return c.fault(ErrUnknownOpcode, 0)
 		}
 
 	case 0xF000:
+		switch {
+		case opcode == 0xF000 && c.Mode == ModeXOCHIP: // F000 NNNN: Set I to the 16-bit address NNNN (XO-CHIP)
+			hi, err := c.fetch(uint32(c.PC))
+			if err != nil {
+				return err
+			}
+			lo, err := c.fetch(uint32(c.PC) + 1)
+			if err != nil {
+				return err
+			}
+			c.I = uint16(hi)<<8 | uint16(lo)
+			c.PC += 2
+			return nil
+		case opcode == 0xF002 && c.Mode == ModeXOCHIP: // F002: Load audio pattern buffer from memory at I (XO-CHIP)
+			var pattern [AudioPatternSize]uint8
+			for i := range pattern {
+				v, err := c.read(uint32(c.I) + uint32(i))
+				if err != nil {
+					return err
+				}
+				pattern[i] = v
+			}
+			c.AudioPattern = pattern
+			return nil
+		case nn == 0x01 && c.Mode == ModeXOCHIP: // FN01: Select bitplanes N (XO-CHIP)
+			c.Plane = x & 0x3
+			return nil
+		}
+
 		switch nn {
 		case 0x07: // FX07: Set VX to delay timer
-			c.V[x] = c.DelayTimer
+			c.setV(x, c.DelayTimer)
 		case 0x0A: // FX0A: Wait for key press, store in VX
 			c.WaitingForKey = true
 			c.KeyRegister = x
+			if c.Observer != nil {
+				c.Observer.KeyWait(x)
+			}
 		case 0x15: // FX15: Set delay timer to VX
 			c.DelayTimer = c.V[x]
 		case 0x18: // FX18: Set sound timer to VX
-			c.SoundTimer = c.V[x]
+			c.setSoundTimer(c.V[x])
 		case 0x1E: // FX1E: Add VX to I
 			c.I += uint16(c.V[x])
 		case 0x29: // FX29: Set I to location of font character VX
 			c.I = uint16(c.V[x]) * 5
+		case 0x30: // FX30: Set I to location of large font character VX (SCHIP)
+			if c.Mode == ModeCHIP8 {
+				return c.fault(ErrUnknownOpcode, 0)
+			}
+			c.I = BigFontStart + uint16(c.V[x]&0xF)*10
+		case 0x3A: // FX3A: Set audio pitch to VX (XO-CHIP)
+			if c.Mode != ModeXOCHIP {
+				return c.fault(ErrUnknownOpcode, 0)
+			}
+			c.Pitch = c.V[x]
 		case 0x33: // FX33: Store BCD of VX at I, I+1, I+2
-			c.Memory[c.I] = c.V[x] / 100
-			c.Memory[c.I+1] = (c.V[x] / 10) % 10
-			c.Memory[c.I+2] = c.V[x] % 10
+			digits := [3]uint8{c.V[x] / 100, (c.V[x] / 10) % 10, c.V[x] % 10}
+			for i, d := range digits {
+				if err := c.write(uint32(c.I)+uint32(i), d); err != nil {
+					return err
+				}
+			}
 		case 0x55: // FX55: Store V0-VX in memory starting at I
 			for i := uint8(0); i <= x; i++ {
-				c.Memory[c.I+uint16(i)] = c.V[i]
+				if err := c.write(uint32(c.I)+uint32(i), c.V[i]); err != nil {
+					return err
+				}
+			}
+			if c.Quirks.LoadStoreIncrementI {
+				c.I += uint16(x) + 1
 			}
 		case 0x65: // FX65: Load V0-VX from memory starting at I
 			for i := uint8(0); i <= x; i++ {
-				c.V[i] = c.Memory[c.I+uint16(i)]
+				v, err := c.read(uint32(c.I) + uint32(i))
+				if err != nil {
+					return err
+				}
+				c.setV(i, v)
+			}
+			if c.Quirks.LoadStoreIncrementI {
+				c.I += uint16(x) + 1
+			}
+		case 0x75: // FX75: Store V0-VX in RPL user flags (SCHIP)
+			if c.Mode == ModeCHIP8 || int(x) >= c.numFlags() {
+				return c.fault(ErrUnknownOpcode, 0)
+			}
+			copy(c.RPL[:x+1], c.V[:x+1])
+		case 0x85: // FX85: Load V0-VX from RPL user flags (SCHIP)
+			if c.Mode == ModeCHIP8 || int(x) >= c.numFlags() {
+				return c.fault(ErrUnknownOpcode, 0)
+			}
+			for i := uint8(0); i <= x; i++ {
+				c.setV(i, c.RPL[i])
 			}
 		default:
-			return fmt.Errorf("unknown opcode: 0x%04X", opcode)
+			return c.fault(ErrUnknownOpcode, 0)
 		}
 
 	default:
-		return fmt.Errorf("unknown opcode: 0x%04X", opcode)
+		return c.fault(ErrUnknownOpcode, 0)
 	}
 
 	return nil
 }
+
+// skip skips the next instruction. In XO-CHIP mode the 4-byte F000 NNNN
+// instruction is skipped as a whole.
+func (c *CHIP8) skip() {
+	if c.Mode == ModeXOCHIP && c.peek(uint32(c.PC)) == 0xF0 && c.peek(uint32(c.PC)+1) == 0x00 {
+		c.PC += 2
+	}
+	c.PC += 2
+}
+
+// isSkip returns true for the conditional skip instructions
+func isSkip(opcode uint16) bool {
+	switch opcode & 0xF000 {
+	case 0x3000, 0x4000, 0x5000, 0x9000, 0xE000:
+		return true
+	}
+	return false
+}
+
+// registerRange returns the register indices from x to y inclusive, in
+// descending order when x > y
+func registerRange(x, y uint8) []uint8 {
+	var regs []uint8
+	if x <= y {
+		for r := x; r <= y; r++ {
+			regs = append(regs, r)
+		}
+	} else {
+		for r := int(x); r >= int(y); r-- {
+			regs = append(regs, uint8(r))
+		}
+	}
+	return regs
+}
+
+// numFlags returns how many RPL user flags the current mode provides
+func (c *CHIP8) numFlags() int {
+	if c.Mode == ModeXOCHIP {
+		return NumFlags
+	}
+	return 8
+}
+
+// clearDisplay clears the selected bitplanes of the display
+func (c *CHIP8) clearDisplay() {
+	for i := range c.Display {
+		c.Display[i] &^= c.Plane
+	}
+	c.DrawFlag = true
+}
+
+// setHiRes switches the display resolution. With ResolutionClears every
+// bitplane is cleared; otherwise the picture is rescaled, as SUPER-CHIP 1.1
+// keeps its screen and only changes how it draws to it.
+func (c *CHIP8) setHiRes(hiRes bool) {
+	w, h := c.Width(), c.Height()
+	old := c.Display
+	c.HiRes = hiRes
+	c.DrawFlag = true
+
+	if c.Quirks.ResolutionClears {
+		c.Display = [len(c.Display)]uint8{}
+		return
+	}
+	nw, nh := c.Width(), c.Height()
+	for y := 0; y < nh; y++ {
+		for x := 0; x < nw; x++ {
+			c.Display[y*nw+x] = old[(y*h/nh)*w+x*w/nw]
+		}
+	}
+	for i := nw * nh; i < len(c.Display); i++ {
+		c.Display[i] = 0
+	}
+}
+
+// scroll shifts the selected bitplanes by dx, dy pixels, filling the
+// uncovered area with blank pixels
+func (c *CHIP8) scroll(dx, dy int) {
+	if c.Quirks.HalfScrollLowRes && !c.HiRes {
+		dx, dy = dx/2, dy/2
+	}
+	w, h := c.Width(), c.Height()
+	out := c.Display
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var moved uint8
+			sx, sy := x-dx, y-dy
+			if sx >= 0 && sx < w && sy >= 0 && sy < h {
+				moved = c.Display[sy*w+sx] & c.Plane
+			}
+			out[y*w+x] = c.Display[y*w+x]&^c.Plane | moved
+		}
+	}
+	c.Display = out
+	c.DrawFlag = true
+}
+
+// drawSprite XORs a width x height sprite read from I onto each selected
+// bitplane at (vx, vy) and sets VF if any lit pixel was erased. Sprites 16
+// pixels wide use two bytes per row. When two planes are selected the
+// second plane's sprite data follows the first's in memory.
+func (c *CHIP8) drawSprite(vx, vy uint8, width, height int) error {
+	var collision uint8
+	addr := uint32(c.I)
+	for _, plane := range []uint8{1, 2} {
+		if c.Plane&plane == 0 {
+			continue
+		}
+		collided, err := c.drawPlane(plane, addr, vx, vy, width, height)
+		if err != nil {
+			return err
+		}
+		if collided {
+			collision = 1
+		}
+		addr += uint32(height * width / 8)
+	}
+	c.setV(0xF, collision)
+	c.DrawFlag = true
+	if c.Observer != nil {
+		c.Observer.Draw(vx, vy, width, height, collision != 0)
+	}
+	return nil
+}
+
+// drawPlane draws one bitplane of a sprite whose data starts at addr and
+// reports whether any lit pixel was turned off
+func (c *CHIP8) drawPlane(plane uint8, addr uint32, vx, vy uint8, width, height int) (bool, error) {
+	w, h := c.Width(), c.Height()
+	startX := int(vx) % w
+	startY := int(vy) % h
+	bytesPerRow := width / 8
+
+	var (
+		data     uint8
+		collided bool
+	)
+	for row := 0; row < height; row++ {
+		py := startY + row
+		if py >= h {
+			if c.Quirks.ClipSprites {
+				break
+			}
+			py %= h
+		}
+		for col := 0; col < width; col++ {
+			if col%8 == 0 {
+				var err error
+				if data, err = c.read(addr + uint32(row*bytesPerRow+col/8)); err != nil {
+					return false, err
+				}
+			}
+			if data&(0x80>>(col%8)) == 0 {
+				continue
+			}
+			px := startX + col
+			if px >= w {
+				if c.Quirks.ClipSprites {
+					break
+				}
+				px %= w
+			}
+			idx := py*w + px
+			if c.Display[idx]&plane != 0 {
+				collided = true
+			}
+			c.Display[idx] ^= plane
+		}
+	}
+	return collided, nil
+}
@@ -1,6 +1,11 @@
 package chip8
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"testing"
 )
 
@@ -325,6 +330,55 @@ func TestOpcode8XY5_SubWithBorrow(t *testing.T) {
 	}
 }
 
+func TestOpcode8XY5_FlagOverwritesVF(t *testing.T) {
+	c := New()
+	c.V[0xF] = 0x05
+	c.V[1] = 0x3C
+
+	// Load SUB VF, V1: VF = 5 - 60 borrows, and the flag is written last
+	c.Memory[ProgramStart] = 0x8F
+	c.Memory[ProgramStart+1] = 0x15
+
+	err := c.Cycle()
+	if err != nil {
+		t.Errorf("Cycle failed: %v", err)
+	}
+
+	if c.V[0xF] != 0 {
+		t.Errorf("VF should be 0 (borrow), got %#x", c.V[0xF])
+	}
+
+	// Without a borrow VF ends up 1, not the difference
+	c.V[0xF] = 0x3C
+	c.V[1] = 0x05
+	c.PC = ProgramStart
+	if err := c.Cycle(); err != nil {
+		t.Errorf("Cycle failed: %v", err)
+	}
+	if c.V[0xF] != 1 {
+		t.Errorf("VF should be 1 (no borrow), got %#x", c.V[0xF])
+	}
+}
+
+func TestOpcode8XY7_FlagOverwritesVF(t *testing.T) {
+	c := New()
+	c.V[0xF] = 0x3C
+	c.V[1] = 0x05
+
+	// Load SUBN VF, V1: VF = 5 - 60 borrows, and the flag is written last
+	c.Memory[ProgramStart] = 0x8F
+	c.Memory[ProgramStart+1] = 0x17
+
+	err := c.Cycle()
+	if err != nil {
+		t.Errorf("Cycle failed: %v", err)
+	}
+
+	if c.V[0xF] != 0 {
+		t.Errorf("VF should be 0 (borrow), got %#x", c.V[0xF])
+	}
+}
+
 func TestOpcodeANNN_SetI(t *testing.T) {
 	c := New()
 
@@ -486,3 +540,1055 @@ func TestShouldBeep(t *testing.T) {
 		t.Error("Should beep when SoundTimer > 0")
 	}
 }
+
+func TestQuirkShiftUsesVY(t *testing.T) {
+	c := NewWithQuirks(QuirksVIP)
+	c.V[0] = 0xFF
+	c.V[1] = 0x03
+
+	// Load SHR V0, V1
+	c.Memory[ProgramStart] = 0x80
+	c.Memory[ProgramStart+1] = 0x16
+
+	if err := c.Cycle(); err != nil {
+		t.Errorf("Cycle failed: %v", err)
+	}
+
+	if c.V[0] != 0x01 {
+		t.Errorf("V0 should be VY >> 1 (0x01), got %#x", c.V[0])
+	}
+
+	if c.V[0xF] != 1 {
+		t.Errorf("VF should be LSB of VY (1), got %d", c.V[0xF])
+	}
+}
+
+func TestQuirkLoadStoreIncrementI(t *testing.T) {
+	c := NewWithQuirks(Quirks{LoadStoreIncrementI: true})
+	c.I = 0x300
+
+	// Load LD [I], V2
+	c.Memory[ProgramStart] = 0xF2
+	c.Memory[ProgramStart+1] = 0x55
+
+	if err := c.Cycle(); err != nil {
+		t.Errorf("Cycle failed: %v", err)
+	}
+
+	if c.I != 0x303 {
+		t.Errorf("I should be 0x303 after store, got %#x", c.I)
+	}
+}
+
+func TestQuirkJumpUsesVX(t *testing.T) {
+	c := NewWithQuirks(QuirksSCHIP)
+	c.V[0] = 0x10
+	c.V[3] = 0x04
+
+	// Load JP V0, 0x300 (BXNN on SCHIP: jump to 0x300 + V3)
+	c.Memory[ProgramStart] = 0xB3
+	c.Memory[ProgramStart+1] = 0x00
+
+	if err := c.Cycle(); err != nil {
+		t.Errorf("Cycle failed: %v", err)
+	}
+
+	if c.PC != 0x304 {
+		t.Errorf("PC should be 0x304, got %#x", c.PC)
+	}
+}
+
+func TestQuirkVFReset(t *testing.T) {
+	c := NewWithQuirks(Quirks{VFReset: true})
+	c.V[0xF] = 1
+
+	// Load OR V0, V1
+	c.Memory[ProgramStart] = 0x80
+	c.Memory[ProgramStart+1] = 0x11
+
+	if err := c.Cycle(); err != nil {
+		t.Errorf("Cycle failed: %v", err)
+	}
+
+	if c.V[0xF] != 0 {
+		t.Errorf("VF should be reset to 0, got %d", c.V[0xF])
+	}
+}
+
+func TestQuirkClipSprites(t *testing.T) {
+	for _, clip := range []bool{false, true} {
+		c := NewWithQuirks(Quirks{ClipSprites: clip})
+		c.V[0] = DisplayWidth - 4
+		c.V[1] = 0
+		c.I = 0x300
+		c.Memory[0x300] = 0xFF
+
+		// Load DRW V0, V1, 1
+		c.Memory[ProgramStart] = 0xD0
+		c.Memory[ProgramStart+1] = 0x11
+
+		if err := c.Cycle(); err != nil {
+			t.Errorf("Cycle failed: %v", err)
+		}
+
+		wrapped := c.Display[0] == 1
+		if wrapped == clip {
+			t.Errorf("ClipSprites=%v: pixel wrapped to column 0 = %v", clip, wrapped)
+		}
+	}
+}
+
+func TestQuirkDisplayWait(t *testing.T) {
+	c := NewWithQuirks(Quirks{DisplayWait: true})
+
+	// Load DRW V0, V0, 0; LD V1, 0x01
+	c.Memory[ProgramStart] = 0xD0
+	c.Memory[ProgramStart+1] = 0x00
+	c.Memory[ProgramStart+2] = 0x61
+	c.Memory[ProgramStart+3] = 0x01
+
+	c.Cycle()
+	c.Cycle()
+
+	if c.PC != ProgramStart+2 {
+		t.Errorf("CPU should wait for vblank after draw, PC is %#x", c.PC)
+	}
+
+	c.UpdateTimers()
+	c.Cycle()
+
+	if c.V[1] != 0x01 {
+		t.Errorf("CPU should resume after vblank, V1 is %#x", c.V[1])
+	}
+}
+
+func TestSCHIPHiRes(t *testing.T) {
+	c := NewWithMode(ModeSCHIP)
+
+	// Load HIGH
+	c.Memory[ProgramStart] = 0x00
+	c.Memory[ProgramStart+1] = 0xFF
+
+	if err := c.Cycle(); err != nil {
+		t.Errorf("Cycle failed: %v", err)
+	}
+
+	if !c.HiRes || c.Width() != HiResWidth || c.Height() != HiResHeight {
+		t.Errorf("Display should be %dx%d, got %dx%d", HiResWidth, HiResHeight, c.Width(), c.Height())
+	}
+}
+
+func TestSCHIPIgnoredInCHIP8Mode(t *testing.T) {
+	c := New()
+
+	// Load HIGH (0NNN on plain CHIP-8)
+	c.Memory[ProgramStart] = 0x00
+	c.Memory[ProgramStart+1] = 0xFF
+
+	if err := c.Cycle(); err != nil {
+		t.Errorf("Cycle failed: %v", err)
+	}
+
+	if c.HiRes {
+		t.Error("00FF should be ignored in CHIP-8 mode")
+	}
+}
+
+func TestSCHIPScrollDown(t *testing.T) {
+	c := NewWithMode(ModeSCHIP)
+	c.HiRes = true
+	c.Display[0] = 1
+
+	// Load SCD 3
+	c.Memory[ProgramStart] = 0x00
+	c.Memory[ProgramStart+1] = 0xC3
+
+	if err := c.Cycle(); err != nil {
+		t.Errorf("Cycle failed: %v", err)
+	}
+
+	if c.Display[0] != 0 || c.Display[3*HiResWidth] != 1 {
+		t.Error("Pixel should move down 3 rows")
+	}
+}
+
+func TestSCHIPScrollLowRes(t *testing.T) {
+	// SUPER-CHIP 1.1 scrolls by high-resolution pixels, so low resolution
+	// scrolls move half as far
+	c := NewWithMode(ModeSCHIP)
+	c.Display[0] = 1
+	c.Memory[ProgramStart] = 0x00 // SCD 4
+	c.Memory[ProgramStart+1] = 0xC4
+	c.Cycle()
+	if c.Display[2*DisplayWidth] != 1 {
+		t.Error("SCHIP low resolution SCD 4 should move 2 rows")
+	}
+
+	// XO-CHIP scrolls by whole pixels
+	c = NewWithMode(ModeXOCHIP)
+	c.Display[0] = 1
+	c.Memory[ProgramStart] = 0x00
+	c.Memory[ProgramStart+1] = 0xC4
+	c.Cycle()
+	if c.Display[4*DisplayWidth] != 1 {
+		t.Error("XO-CHIP low resolution SCD 4 should move 4 rows")
+	}
+}
+
+func TestSCHIPScrollLeftRight(t *testing.T) {
+	c := NewWithMode(ModeSCHIP)
+	c.HiRes = true
+	c.Display[4] = 1
+
+	// Load SCL; SCR; SCR
+	copy(c.Memory[ProgramStart:], []byte{0x00, 0xFC, 0x00, 0xFB, 0x00, 0xFB})
+
+	c.Cycle()
+	if c.Display[0] != 1 {
+		t.Error("Pixel should move left 4 columns")
+	}
+
+	c.Cycle()
+	c.Cycle()
+	if c.Display[8] != 1 {
+		t.Error("Pixel should move right 8 columns")
+	}
+}
+
+func TestResolutionSwitch(t *testing.T) {
+	// SUPER-CHIP 1.1 keeps the picture across a switch
+	c := NewWithMode(ModeSCHIP)
+	c.Display[1*DisplayWidth+2] = 1
+	copy(c.Memory[ProgramStart:], []byte{0x00, 0xFF, 0x00, 0xFE}) // HIGH; LOW
+	c.Cycle()
+	for _, i := range []int{2*HiResWidth + 4, 2*HiResWidth + 5, 3*HiResWidth + 4, 3*HiResWidth + 5} {
+		if c.Display[i] != 1 {
+			t.Errorf("Low resolution pixel should cover high resolution pixel %d", i)
+		}
+	}
+	c.Cycle()
+	if c.Display[1*DisplayWidth+2] != 1 || c.Display[2*DisplayWidth+4] != 0 {
+		t.Error("Switching back should restore the low resolution picture")
+	}
+
+	// XO-CHIP clears it
+	c = NewWithMode(ModeXOCHIP)
+	c.Display[0] = 1
+	copy(c.Memory[ProgramStart:], []byte{0x00, 0xFF})
+	c.Cycle()
+	if c.Display[0] != 0 {
+		t.Error("XO-CHIP should clear the display on a resolution switch")
+	}
+}
+
+func TestSCHIPDraw16x16(t *testing.T) {
+	c := NewWithMode(ModeSCHIP)
+	c.I = 0x300
+	for i := 0; i < 32; i++ {
+		c.Memory[0x300+i] = 0xFF
+	}
+
+	// Load DRW V0, V0, 0
+	c.Memory[ProgramStart] = 0xD0
+	c.Memory[ProgramStart+1] = 0x00
+
+	if err := c.Cycle(); err != nil {
+		t.Errorf("Cycle failed: %v", err)
+	}
+
+	lit := 0
+	for _, pixel := range c.Display {
+		lit += int(pixel)
+	}
+
+	if lit != 256 {
+		t.Errorf("16x16 sprite should light 256 pixels, got %d", lit)
+	}
+}
+
+func TestSCHIPBigFont(t *testing.T) {
+	c := NewWithMode(ModeSCHIP)
+	c.V[2] = 3
+
+	// Load LD HF, V2
+	c.Memory[ProgramStart] = 0xF2
+	c.Memory[ProgramStart+1] = 0x30
+
+	if err := c.Cycle(); err != nil {
+		t.Errorf("Cycle failed: %v", err)
+	}
+
+	if c.I != BigFontStart+30 {
+		t.Errorf("I should be %#x, got %#x", BigFontStart+30, c.I)
+	}
+}
+
+func TestSCHIPFlags(t *testing.T) {
+	c := NewWithMode(ModeSCHIP)
+	c.V[0] = 0x11
+	c.V[1] = 0x22
+
+	// Load LD R, V1; LD V0, 0; LD V1, 0; LD V1, R
+	copy(c.Memory[ProgramStart:], []byte{0xF1, 0x75, 0x60, 0x00, 0x61, 0x00, 0xF1, 0x85})
+
+	for i := 0; i < 4; i++ {
+		if err := c.Cycle(); err != nil {
+			t.Errorf("Cycle failed: %v", err)
+		}
+	}
+
+	if c.V[0] != 0x11 || c.V[1] != 0x22 {
+		t.Errorf("Registers should be restored from flags, got %#x %#x", c.V[0], c.V[1])
+	}
+}
+
+func TestSCHIPExit(t *testing.T) {
+	c := NewWithMode(ModeSCHIP)
+
+	// Load EXIT
+	c.Memory[ProgramStart] = 0x00
+	c.Memory[ProgramStart+1] = 0xFD
+
+	c.Cycle()
+	c.Cycle()
+
+	if !c.Halted {
+		t.Error("VM should be halted after EXIT")
+	}
+
+	if c.PC != ProgramStart+2 {
+		t.Errorf("PC should stay at %#x after EXIT, got %#x", ProgramStart+2, c.PC)
+	}
+}
+
+func TestXOCHIPLongLoad(t *testing.T) {
+	c := NewWithMode(ModeXOCHIP)
+
+	// Load LD I, 0x1234 (F000 NNNN)
+	copy(c.Memory[ProgramStart:], []byte{0xF0, 0x00, 0x12, 0x34})
+
+	if err := c.Cycle(); err != nil {
+		t.Errorf("Cycle failed: %v", err)
+	}
+
+	if c.I != 0x1234 {
+		t.Errorf("I should be 0x1234, got %#x", c.I)
+	}
+
+	if c.PC != ProgramStart+4 {
+		t.Errorf("PC should be %#x, got %#x", ProgramStart+4, c.PC)
+	}
+}
+
+func TestXOCHIPSkipLongLoad(t *testing.T) {
+	c := NewWithMode(ModeXOCHIP)
+
+	// Load SE V0, 0x00; LD I, 0x1234
+	copy(c.Memory[ProgramStart:], []byte{0x30, 0x00, 0xF0, 0x00, 0x12, 0x34})
+
+	if err := c.Cycle(); err != nil {
+		t.Errorf("Cycle failed: %v", err)
+	}
+
+	if c.PC != ProgramStart+6 {
+		t.Errorf("Skip should step over the whole 4-byte instruction, PC is %#x", c.PC)
+	}
+}
+
+func TestXOCHIPLoadROMExtendedMemory(t *testing.T) {
+	c := NewWithMode(ModeXOCHIP)
+
+	if err := c.LoadROM(make([]byte, MemorySize)); err != nil {
+		t.Errorf("LoadROM should accept ROMs larger than 4KB: %v", err)
+	}
+}
+
+func TestXOCHIPSaveLoadRange(t *testing.T) {
+	c := NewWithMode(ModeXOCHIP)
+	c.I = 0x300
+	c.V[2] = 0xAA
+	c.V[3] = 0xBB
+
+	// Load SAVE V3 - V2; LOAD V4 - V5
+	copy(c.Memory[ProgramStart:], []byte{0x53, 0x22, 0x54, 0x53})
+
+	c.Cycle()
+	if c.Memory[0x300] != 0xBB || c.Memory[0x301] != 0xAA {
+		t.Errorf("Registers should be stored in reverse order, got %#x %#x", c.Memory[0x300], c.Memory[0x301])
+	}
+
+	c.Cycle()
+	if c.V[4] != 0xBB || c.V[5] != 0xAA {
+		t.Errorf("Registers should be loaded from memory, got %#x %#x", c.V[4], c.V[5])
+	}
+
+	if c.I != 0x300 {
+		t.Errorf("I should not change, got %#x", c.I)
+	}
+}
+
+func TestXOCHIPBitplanes(t *testing.T) {
+	c := NewWithMode(ModeXOCHIP)
+	c.I = 0x300
+	c.Memory[0x300] = 0x80 // plane 1
+	c.Memory[0x301] = 0x80 // plane 2
+
+	// Load PLANE 3; DRW V0, V0, 1; PLANE 2; CLS
+	copy(c.Memory[ProgramStart:], []byte{0xF3, 0x01, 0xD0, 0x01, 0xF2, 0x01, 0x00, 0xE0})
+
+	c.Cycle()
+	c.Cycle()
+	if c.Display[0] != 3 {
+		t.Errorf("Pixel should be set on both planes, got %d", c.Display[0])
+	}
+
+	c.Cycle()
+	c.Cycle()
+	if c.Display[0] != 1 {
+		t.Errorf("CLS should only clear plane 2, got %d", c.Display[0])
+	}
+}
+
+func TestXOCHIPAudio(t *testing.T) {
+	c := NewWithMode(ModeXOCHIP)
+	c.I = 0x300
+	for i := 0; i < AudioPatternSize; i++ {
+		c.Memory[0x300+i] = uint8(i)
+	}
+	c.V[1] = 100
+
+	// Load AUDIO; PITCH := V1
+	copy(c.Memory[ProgramStart:], []byte{0xF0, 0x02, 0xF1, 0x3A})
+
+	c.Cycle()
+	c.Cycle()
+
+	if c.AudioPattern[15] != 15 {
+		t.Errorf("Audio pattern should be loaded from memory, got %v", c.AudioPattern)
+	}
+
+	if c.Pitch != 100 {
+		t.Errorf("Pitch should be 100, got %d", c.Pitch)
+	}
+}
+
+func TestSeedIsReproducible(t *testing.T) {
+	run := func() [NumRegisters]uint8 {
+		c := New()
+		c.Seed(42)
+
+		// Load RND V0..VF, 0xFF
+		for i := 0; i < NumRegisters; i++ {
+			c.Memory[ProgramStart+2*i] = 0xC0 | uint8(i)
+			c.Memory[ProgramStart+2*i+1] = 0xFF
+		}
+
+		for i := 0; i < NumRegisters; i++ {
+			if err := c.Cycle(); err != nil {
+				t.Errorf("Cycle failed: %v", err)
+			}
+		}
+		return c.V
+	}
+
+	if a, b := run(), run(); a != b {
+		t.Errorf("Same seed should produce same values: %v vs %v", a, b)
+	}
+}
+
+func TestRandomStateRoundTrip(t *testing.T) {
+	c := New()
+	c.Seed(7)
+
+	state, err := c.RandomState()
+	if err != nil {
+		t.Fatalf("RandomState failed: %v", err)
+	}
+
+	first := c.RandomSource().Uint64()
+
+	if err := c.SetRandomState(state); err != nil {
+		t.Fatalf("SetRandomState failed: %v", err)
+	}
+
+	if again := c.RandomSource().Uint64(); again != first {
+		t.Errorf("Restored source should repeat %#x, got %#x", first, again)
+	}
+}
+
+func TestRandomWithoutNew(t *testing.T) {
+	// A VM not made by New gets a default source instead of panicking
+	c := &CHIP8{PC: ProgramStart}
+	c.Memory[ProgramStart] = 0xC0 // RND V0, #FF
+	c.Memory[ProgramStart+1] = 0xFF
+	if err := c.Cycle(); err != nil {
+		t.Fatalf("Cycle failed: %v", err)
+	}
+	if c.RandomSource() == nil {
+		t.Error("No random source after CXNN")
+	}
+}
+
+func TestFailedRestoreKeepsRandomSource(t *testing.T) {
+	c := New()
+	c.Seed(3)
+	state, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	rng, err := c.RandomState()
+	if err != nil {
+		t.Fatalf("RandomState failed: %v", err)
+	}
+	want := NewRandomSource(3)
+	want.Uint64()
+	c.RandomSource().Uint64()
+
+	// Corrupt the random state and fix up the checksum
+	at := bytes.Index(state, rng)
+	state[at] ^= 0xFF
+	body := state[:len(state)-4]
+	binary.BigEndian.PutUint32(state[len(body):], crc32.ChecksumIEEE(body))
+	if err := c.UnmarshalBinary(state); !errors.Is(err, ErrInvalidSaveState) {
+		t.Fatalf("Corrupt random state should be refused, got %v", err)
+	}
+
+	if got, w := c.RandomSource().Uint64(), want.Uint64(); got != w {
+		t.Errorf("Failed restore changed the random source: got %#x, want %#x", got, w)
+	}
+}
+
+func TestSaveStateRoundTrip(t *testing.T) {
+	c := NewWithMode(ModeXOCHIP)
+	c.Seed(1)
+	c.LoadROM([]byte{0xC0, 0xFF, 0x12, 0x00}) // RND V0, 0xFF; JP 0x200
+	c.V[3] = 0x33
+	c.I = 0x1234
+	c.Memory[0xFFFF] = 0xEE
+	c.Display[10] = 3
+	c.SetKey(4, true)
+
+	state, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	c.Cycle()
+	want := c.V[0]
+
+	restored := New()
+	if err := restored.UnmarshalBinary(state); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+
+	if restored.Mode != ModeXOCHIP || restored.Quirks != QuirksXOCHIP {
+		t.Errorf("Mode and quirks should be restored, got %v %+v", restored.Mode, restored.Quirks)
+	}
+
+	if restored.V[3] != 0x33 || restored.I != 0x1234 || restored.Memory[0xFFFF] != 0xEE ||
+		restored.Display[10] != 3 || !restored.Keys[4] {
+		t.Error("VM state should be restored")
+	}
+
+	restored.Cycle()
+	if restored.V[0] != want {
+		t.Errorf("Random source should be restored, got %#x want %#x", restored.V[0], want)
+	}
+}
+
+func TestSaveStateRejectsCorruption(t *testing.T) {
+	c := New()
+	state, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	corrupt := append([]byte(nil), state...)
+	corrupt[100] ^= 0xFF
+	if err := c.UnmarshalBinary(corrupt); !errors.Is(err, ErrSaveStateChecksum) {
+		t.Errorf("Corrupted state should fail checksum, got %v", err)
+	}
+
+	if err := c.UnmarshalBinary(state[:20]); !errors.Is(err, ErrInvalidSaveState) {
+		t.Errorf("Truncated state should be invalid, got %v", err)
+	}
+}
+
+func TestSaveStateQuirkBits(t *testing.T) {
+	// The bits are part of the save state format and must never change
+	for _, tt := range []struct {
+		quirks Quirks
+		bits   uint8
+	}{
+		{Quirks{ShiftUsesVY: true}, 0x01},
+		{Quirks{LoadStoreIncrementI: true}, 0x02},
+		{Quirks{JumpUsesVX: true}, 0x04},
+		{Quirks{VFReset: true}, 0x08},
+		{Quirks{ClipSprites: true}, 0x10},
+		{Quirks{DisplayWait: true}, 0x20},
+		{Quirks{ResolutionClears: true}, 0x40},
+		{Quirks{HalfScrollLowRes: true}, 0x80},
+	} {
+		c := New()
+		c.Quirks = tt.quirks
+		if bits := c.quirkBits(); bits != tt.bits {
+			t.Errorf("%+v packed to %#02x, want %#02x", tt.quirks, bits, tt.bits)
+		}
+		c.setQuirkBits(tt.bits)
+		if c.Quirks != tt.quirks {
+			t.Errorf("%#02x unpacked to %+v, want %+v", tt.bits, c.Quirks, tt.quirks)
+		}
+	}
+}
+
+func TestSaveStateRejectsNewerVersion(t *testing.T) {
+	c := New()
+	state, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	// Bump the version and fix up the checksum
+	binary.BigEndian.PutUint16(state[4:], SaveStateVersion+1)
+	body := state[:len(state)-4]
+	binary.BigEndian.PutUint32(state[len(body):], crc32.ChecksumIEEE(body))
+
+	c.V[0] = 0x42
+	if err := c.UnmarshalBinary(state); !errors.Is(err, ErrSaveStateVersion) {
+		t.Errorf("Newer state should be refused, got %v", err)
+	}
+
+	if c.V[0] != 0x42 {
+		t.Error("VM should be unchanged after a failed load")
+	}
+}
+
+func TestRewind(t *testing.T) {
+	c := New()
+	r := NewRewinder(5, 2)
+
+	// Record frames where V0 counts up, with one more frame than fits
+	for frame := 0; frame < 6; frame++ {
+		c.V[0] = uint8(frame)
+		c.Display[frame] = 1
+		if err := r.Push(c); err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+	}
+
+	if r.Len() != 5 {
+		t.Errorf("History should hold 5 frames, got %d", r.Len())
+	}
+
+	// The newest frame is the current state, so the first rewind skips it
+	for want := 4; want >= 1; want-- {
+		ok, err := r.Rewind(c)
+		if !ok || err != nil {
+			t.Fatalf("Rewind to frame %d failed: %v", want, err)
+		}
+
+		if c.V[0] != uint8(want) || c.Display[want] != 1 || c.Display[want+1] != 0 {
+			t.Errorf("Expected frame %d, got V0=%d", want, c.V[0])
+		}
+	}
+
+	if ok, _ := r.Rewind(c); ok {
+		t.Error("Rewind should report an empty history")
+	}
+}
+
+func TestRewindStepsBack(t *testing.T) {
+	c := New()
+	r := NewRewinder(10, 5)
+	for frame := 0; frame < 3; frame++ {
+		c.V[0] = uint8(frame)
+		if err := r.Push(c); err != nil {
+			t.Fatalf("Push failed: %v", err)
+		}
+	}
+
+	// The last push recorded the current state; one rewind goes before it
+	if ok, err := r.Rewind(c); !ok || err != nil {
+		t.Fatalf("Rewind failed: %v", err)
+	}
+	if c.V[0] != 1 {
+		t.Errorf("One rewind should restore frame 1, got V0=%d", c.V[0])
+	}
+}
+
+func TestRewindSizeCountsEvictedKeyframes(t *testing.T) {
+	c := New()
+	r := NewRewinder(3, 10)
+
+	// The keyframe leaves the history, but the deltas still need it
+	for frame := 0; frame < 5; frame++ {
+		c.V[0] = uint8(frame)
+		r.Push(c)
+	}
+
+	state, _ := c.MarshalBinary()
+	if r.Size() < len(state) {
+		t.Errorf("Size %d should include the %d byte keyframe", r.Size(), len(state))
+	}
+}
+
+func TestRewindDeltasAreSmall(t *testing.T) {
+	c := New()
+	r := NewRewinder(10, 10)
+
+	for frame := 0; frame < 10; frame++ {
+		c.V[0] = uint8(frame)
+		r.Push(c)
+	}
+
+	state, _ := c.MarshalBinary()
+	if r.Size() >= 2*len(state) {
+		t.Errorf("10 frames with one keyframe should take under 2 snapshots, got %d bytes", r.Size())
+	}
+}
+
+func TestStackOverflowError(t *testing.T) {
+	c := New()
+	c.SP = StackSize
+
+	// Load CALL 0x400
+	c.Memory[ProgramStart] = 0x24
+	c.Memory[ProgramStart+1] = 0x00
+
+	err := c.Cycle()
+	if !errors.Is(err, ErrStackOverflow) {
+		t.Fatalf("Expected ErrStackOverflow, got %v", err)
+	}
+
+	var execErr *ExecError
+	if !errors.As(err, &execErr) {
+		t.Fatalf("Expected *ExecError, got %T", err)
+	}
+
+	if execErr.PC != ProgramStart || execErr.Opcode != 0x2400 {
+		t.Errorf("Error should carry PC and opcode, got %#x %#x", execErr.PC, execErr.Opcode)
+	}
+
+	if c.PC != ProgramStart {
+		t.Errorf("PC should point at the faulting instruction, got %#x", c.PC)
+	}
+}
+
+func TestUnknownOpcodeError(t *testing.T) {
+	c := New()
+
+	// Load an undefined 8XYF
+	c.Memory[ProgramStart] = 0x80
+	c.Memory[ProgramStart+1] = 0x0F
+
+	if err := c.Cycle(); !errors.Is(err, ErrUnknownOpcode) {
+		t.Errorf("Expected ErrUnknownOpcode, got %v", err)
+	}
+}
+
+func TestMemoryFaultPolicy(t *testing.T) {
+	c := New()
+	c.MemoryPolicy = MemoryFault
+	c.I = MemorySize - 1
+
+	// Load LD [I], V1 (writes I and I+1)
+	c.Memory[ProgramStart] = 0xF1
+	c.Memory[ProgramStart+1] = 0x55
+
+	err := c.Cycle()
+	var execErr *ExecError
+	if !errors.As(err, &execErr) || !errors.Is(err, ErrMemoryFault) {
+		t.Fatalf("Expected memory fault, got %v", err)
+	}
+
+	if execErr.Addr != MemorySize || execErr.I != MemorySize-1 {
+		t.Errorf("Fault should report address %#x and I, got %#x %#x", MemorySize, execErr.Addr, execErr.I)
+	}
+}
+
+func TestMemoryWrapPolicy(t *testing.T) {
+	c := New()
+	c.I = MemorySize - 1
+	c.V[0] = 0xAA
+	c.V[1] = 0xBB
+
+	// Load LD [I], V1
+	c.Memory[ProgramStart] = 0xF1
+	c.Memory[ProgramStart+1] = 0x55
+
+	if err := c.Cycle(); err != nil {
+		t.Fatalf("Cycle failed: %v", err)
+	}
+
+	if c.Memory[MemorySize-1] != 0xAA || c.Memory[0] != 0xBB {
+		t.Error("Store should wrap to the start of memory")
+	}
+}
+
+func TestMemoryMaskPolicy(t *testing.T) {
+	c := New()
+	c.MemoryPolicy = MemoryMask
+	c.I = 0xFFF
+	c.V[0] = 0x02
+
+	// Load ADD I, V0
+	c.Memory[ProgramStart] = 0xF0
+	c.Memory[ProgramStart+1] = 0x1E
+
+	if err := c.Cycle(); err != nil {
+		t.Fatalf("Cycle failed: %v", err)
+	}
+
+	if c.I != 0x001 {
+		t.Errorf("I should be masked to 12 bits, got %#x", c.I)
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	c := New()
+	c.V[0] = 0x15
+	c.Keys[0x5] = true
+
+	// Load SKP V0
+	c.Memory[ProgramStart] = 0xE0
+	c.Memory[ProgramStart+1] = 0x9E
+
+	if err := c.Cycle(); err != nil {
+		t.Fatalf("Cycle failed: %v", err)
+	}
+
+	if c.PC != ProgramStart+4 {
+		t.Errorf("Key 0x15 should wrap to key 5, PC is %#x", c.PC)
+	}
+
+	c.Reset()
+	c.MemoryPolicy = MemoryFault
+	c.V[0] = 0x15
+	c.Memory[ProgramStart] = 0xE0
+	c.Memory[ProgramStart+1] = 0x9E
+
+	if err := c.Cycle(); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey, got %v", err)
+	}
+}
+
+func TestRunFrame(t *testing.T) {
+	c := New()
+	c.DelayTimer = 5
+	c.SoundTimer = 1
+
+	// Load ADD V0, 1; JP 0x200
+	copy(c.Memory[ProgramStart:], []byte{0x70, 0x01, 0x12, 0x00})
+
+	res, err := c.RunFrame(10)
+	if err != nil {
+		t.Fatalf("RunFrame failed: %v", err)
+	}
+
+	if res.Instructions != 10 || c.V[0] != 5 {
+		t.Errorf("Expected 10 instructions and V0=5, got %d and %d", res.Instructions, c.V[0])
+	}
+
+	if !res.Beeping || c.DelayTimer != 4 || c.SoundTimer != 0 {
+		t.Errorf("Timers should tick once after a beeping frame, got DT=%d ST=%d beeping=%v", c.DelayTimer, c.SoundTimer, res.Beeping)
+	}
+}
+
+func TestRunFrameStopsOnKeyWait(t *testing.T) {
+	c := New()
+
+	// Load LD V0, K
+	c.Memory[ProgramStart] = 0xF0
+	c.Memory[ProgramStart+1] = 0x0A
+
+	res, err := c.RunFrame(10)
+	if err != nil {
+		t.Fatalf("RunFrame failed: %v", err)
+	}
+
+	if res.Instructions != 1 {
+		t.Errorf("Frame should stop while waiting for a key, ran %d instructions", res.Instructions)
+	}
+}
+
+func TestVIPTimingFrameBudget(t *testing.T) {
+	c := New()
+	c.Timing = TimingVIP
+
+	// Load ADD V0, 1 (10 cycles); JP 0x200 (12 cycles)
+	copy(c.Memory[ProgramStart:], []byte{0x70, 0x01, 0x12, 0x00})
+
+	total := 0
+	for frame := 0; frame < 10; frame++ {
+		res, err := c.RunFrame(1)
+		if err != nil {
+			t.Fatalf("RunFrame failed: %v", err)
+		}
+		if res.Instructions <= 1 {
+			t.Fatalf("VIP timing should ignore ipf, ran %d instructions", res.Instructions)
+		}
+		total += res.Cycles
+	}
+
+	// Overruns are carried, so the total stays within one instruction
+	if want := 10 * VIPCyclesPerFrame; total < want || total >= want+12 {
+		t.Errorf("Expected about %d cycles over 10 frames, got %d", want, total)
+	}
+
+	if c.Cycles != uint64(total) {
+		t.Errorf("Cycle counter should be %d, got %d", total, c.Cycles)
+	}
+}
+
+func TestVIPTimingCosts(t *testing.T) {
+	c := New()
+	c.Timing = TimingVIP
+
+	// Load SE V0, 0 (taken); DRW V0, V1, 2 at an unaligned position
+	copy(c.Memory[ProgramStart:], []byte{0x30, 0x00, 0x00, 0x00, 0xD0, 0x12})
+	c.Cycle()
+	if c.Cycles != 10+vipSkipCycles {
+		t.Errorf("Taken skip should cost %d cycles, got %d", 10+vipSkipCycles, c.Cycles)
+	}
+
+	c.V[0] = 3
+	c.Cycle()
+	if got := c.Cycles - (10 + vipSkipCycles); got != 26+2*34 {
+		t.Errorf("Unaligned 2-row sprite should cost %d cycles, got %d", 26+2*34, got)
+	}
+
+	// The VIP always waits for the display interrupt after drawing
+	if !c.waitingForVBlank {
+		t.Error("DXYN should wait for vertical blank under VIP timing")
+	}
+}
+
+func TestSaveStateMigratesVersion1(t *testing.T) {
+	c := New()
+	c.V[3] = 0x33
+	state, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	// Strip the version 2 fields and rewrite the header and checksum
+	const v2Fields = 1 + 8 + 4
+	state = state[:len(state)-4-v2Fields]
+	binary.BigEndian.PutUint16(state[4:], 1)
+	binary.BigEndian.PutUint32(state[6:], uint32(len(state)-saveStateHeaderSize))
+	state = binary.BigEndian.AppendUint32(state, crc32.ChecksumIEEE(state))
+
+	restored := New()
+	restored.Timing = TimingVIP
+	restored.Cycles = 1234
+	if err := restored.UnmarshalBinary(state); err != nil {
+		t.Fatalf("Version 1 state should load, got %v", err)
+	}
+
+	if restored.V[3] != 0x33 || restored.Timing != TimingNone || restored.Cycles != 0 {
+		t.Errorf("Version 1 state should migrate with default timing, got V3=%#x timing=%v cycles=%d",
+			restored.V[3], restored.Timing, restored.Cycles)
+	}
+}
+
+// recordingObserver records the callbacks it receives
+type recordingObserver struct {
+	NopObserver
+	events []string
+}
+
+func (r *recordingObserver) BeforeInstruction(pc, opcode uint16) {
+	r.events = append(r.events, fmt.Sprintf("before %03X %04X", pc, opcode))
+}
+
+func (r *recordingObserver) AfterInstruction(pc, opcode uint16) {
+	r.events = append(r.events, fmt.Sprintf("after %03X", pc))
+}
+
+func (r *recordingObserver) MemoryRead(addr uint32, value uint8) {
+	r.events = append(r.events, fmt.Sprintf("read %03X=%02X", addr, value))
+}
+
+func (r *recordingObserver) MemoryWrite(addr uint32, old, value uint8) {
+	r.events = append(r.events, fmt.Sprintf("write %03X %02X->%02X", addr, old, value))
+}
+
+func (r *recordingObserver) RegisterWrite(reg, old, value uint8) {
+	r.events = append(r.events, fmt.Sprintf("V%X %02X->%02X", reg, old, value))
+}
+
+func (r *recordingObserver) Draw(x, y uint8, width, height int, collision bool) {
+	r.events = append(r.events, fmt.Sprintf("draw %d,%d %dx%d %v", x, y, width, height, collision))
+}
+
+func (r *recordingObserver) SoundStart() { r.events = append(r.events, "sound start") }
+func (r *recordingObserver) SoundStop()  { r.events = append(r.events, "sound stop") }
+
+func (r *recordingObserver) KeyWait(reg uint8) {
+	r.events = append(r.events, fmt.Sprintf("key wait V%X", reg))
+}
+
+func TestObserver(t *testing.T) {
+	c := New()
+	obs := &recordingObserver{}
+	c.Observer = obs
+
+	// LD V1, 2; LD ST, V1; LD I, 0x300; LD [I], V0; DRW V0, V0, 1; LD V2, K
+	copy(c.Memory[ProgramStart:], []byte{
+		0x61, 0x02, 0xF1, 0x18, 0xA3, 0x00, 0xF0, 0x55, 0xD0, 0x01, 0xF2, 0x0A,
+	})
+	c.Memory[0x300] = 0x80
+	for i := 0; i < 6; i++ {
+		if err := c.Cycle(); err != nil {
+			t.Fatalf("Cycle failed: %v", err)
+		}
+	}
+	c.UpdateTimers()
+	c.UpdateTimers()
+
+	want := []string{
+		"before 200 6102", "V1 00->02", "after 200",
+		"before 202 F118", "sound start", "after 202",
+		"before 204 A300", "after 204",
+		"before 206 F055", "write 300 80->00", "after 206",
+		"before 208 D001", "read 300=00", "VF 00->00", "draw 0,0 8x1 false", "after 208",
+		"before 20A F20A", "key wait V2", "after 20A",
+		"sound stop",
+	}
+	if fmt.Sprint(obs.events) != fmt.Sprint(want) {
+		t.Errorf("Unexpected observer events:\n got %v\nwant %v", obs.events, want)
+	}
+}
+
+func TestAddRemoveObserver(t *testing.T) {
+	c := New()
+	a, b, d := &recordingObserver{}, &recordingObserver{}, &recordingObserver{}
+	c.AddObserver(a)
+	if c.Observer != a {
+		t.Fatalf("One observer should be attached directly, got %T", c.Observer)
+	}
+	c.AddObserver(b)
+	c.AddObserver(d)
+
+	c.Memory[ProgramStart] = 0x61 // LD V1, 2
+	c.Memory[ProgramStart+1] = 0x02
+	if err := c.Cycle(); err != nil {
+		t.Fatalf("Cycle failed: %v", err)
+	}
+	if len(a.events) != 3 || len(b.events) != 3 || len(d.events) != 3 {
+		t.Errorf("Every observer should see the instruction, got %v %v %v", a.events, b.events, d.events)
+	}
+
+	c.RemoveObserver(b)
+	if m, ok := c.Observer.(MultiObserver); !ok || len(m) != 2 || m[0] != a || m[1] != d {
+		t.Errorf("Removing the middle observer left %v", c.Observer)
+	}
+	c.RemoveObserver(a)
+	if c.Observer != d {
+		t.Errorf("The last observer should be attached directly, got %v", c.Observer)
+	}
+	c.RemoveObserver(d)
+	if c.Observer != nil {
+		t.Errorf("Removing every observer left %v", c.Observer)
+	}
+}
@@ -0,0 +1,79 @@
+package chip8
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors reported by Cycle. They are wrapped in an *ExecError, so use
+// errors.Is to test for a kind and errors.As to get the faulting state.
+var (
+	// ErrStackOverflow is returned when 2NNN is executed with a full stack
+	ErrStackOverflow = errors.New("stack overflow")
+	// ErrStackUnderflow is returned when 00EE is executed with an empty stack
+	ErrStackUnderflow = errors.New("stack underflow")
+	// ErrUnknownOpcode is returned for opcodes the current mode doesn't define
+	ErrUnknownOpcode = errors.New("unknown opcode")
+	// ErrMemoryFault is returned for out-of-range memory accesses under the
+	// MemoryFault policy
+	ErrMemoryFault = errors.New("memory fault")
+	// ErrInvalidKey is returned when EX9E/EXA1 name a key above 0xF under
+	// the MemoryFault policy
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrReadOnly is returned when a program writes to a ReadOnly region
+	ErrReadOnly = errors.New("write to read-only memory")
+)
+
+// ExecError describes an instruction that failed to execute. After Cycle
+// returns an ExecError, PC points at the faulting instruction.
+type ExecError struct {
+	// Err is one of the Err* values above, or an error returned by the Bus
+	Err error
+	// PC is the address of the faulting instruction
+	PC uint16
+	// Opcode is the faulting instruction (0 if it could not be fetched)
+	Opcode uint16
+	// I is the index register when the fault occurred
+	I uint16
+	// Addr is the memory address or key index that caused a memory fault,
+	// bus error or invalid key error
+	Addr uint32
+
+	// bus is set when the error came from the Bus
+	bus bool
+}
+
+// Error implements the error interface
+func (e *ExecError) Error() string {
+	switch {
+	case errors.Is(e.Err, ErrMemoryFault), e.bus:
+		return fmt.Sprintf("%v: address 0x%04X at PC 0x%04X (opcode 0x%04X, I 0x%04X)", e.Err, e.Addr, e.PC, e.Opcode, e.I)
+	case errors.Is(e.Err, ErrInvalidKey):
+		return fmt.Sprintf("%v: key 0x%02X at PC 0x%04X (opcode 0x%04X)", e.Err, e.Addr, e.PC, e.Opcode)
+	default:
+		return fmt.Sprintf("%v: 0x%04X at PC 0x%04X (I 0x%04X)", e.Err, e.Opcode, e.PC, e.I)
+	}
+}
+
+// Unwrap returns the underlying error kind
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
+// busFault returns an ExecError for a Bus error at addr
+func (c *CHIP8) busFault(err error, addr uint32) error {
+	e := c.fault(err, addr).(*ExecError)
+	e.bus = true
+	return e
+}
+
+// fault returns an ExecError for the instruction being executed
+func (c *CHIP8) fault(err error, addr uint32) error {
+	return &ExecError{
+		Err:    err,
+		PC:     c.instrPC,
+		Opcode: c.opcode,
+		I:      c.I,
+		Addr:   addr,
+	}
+}
@@ -0,0 +1,68 @@
+package chip8
+
+// FrameResult reports what happened during one call to RunFrame
+type FrameResult struct {
+	// Instructions is the number of instructions executed
+	Instructions int
+	// Cycles is the number of cycles used (see CHIP8.Cycles)
+	Cycles int
+	// Drew is true if the display needs to be redrawn (DrawFlag is set)
+	Drew bool
+	// Beeping is true if the sound timer was active during the frame
+	Beeping bool
+	// Stopped is true if RunFrameBreak's break function ended the frame
+	Stopped bool
+}
+
+// RunFrame runs one 60 Hz frame: up to ipf instructions followed by one
+// tick of the delay and sound timers. Under TimingVIP ipf is ignored and
+// instructions run until the frame's budget of VIPCyclesPerFrame machine
+// cycles is spent; an overrun is taken from the next frame's budget.
+// Execution stops early, but the timers still tick, if the program waits
+// for a key, waits for vertical blank or exits. On an emulation error the
+// timers are not ticked.
+func (c *CHIP8) RunFrame(ipf int) (FrameResult, error) {
+	return c.RunFrameBreak(ipf, nil)
+}
+
+// RunFrameBreak is like RunFrame, but calls brk, if not nil, before each
+// instruction. If brk returns true the frame ends before that instruction
+// executes: Stopped is set, the timers are not ticked and the rest of the
+// frame's budget is discarded.
+func (c *CHIP8) RunFrameBreak(ipf int, brk func() bool) (FrameResult, error) {
+	var res FrameResult
+
+	start := c.Cycles
+	budget := ipf
+	if c.Timing == TimingVIP {
+		budget = VIPCyclesPerFrame - c.cycleCarry
+	}
+
+	for int(c.Cycles-start) < budget && !c.WaitingForKey && !c.waitingForVBlank && !c.Halted {
+		if brk != nil && brk() {
+			res.Cycles = int(c.Cycles - start)
+			res.Drew = c.DrawFlag
+			res.Stopped = true
+			c.cycleCarry = 0
+			return res, nil
+		}
+		if err := c.Cycle(); err != nil {
+			res.Cycles = int(c.Cycles - start)
+			res.Drew = c.DrawFlag
+			return res, err
+		}
+		res.Instructions++
+	}
+
+	res.Cycles = int(c.Cycles - start)
+	c.cycleCarry = 0
+	if c.Timing == TimingVIP && res.Cycles > budget {
+		c.cycleCarry = res.Cycles - budget
+	}
+
+	res.Drew = c.DrawFlag
+	res.Beeping = c.SoundTimer > 0
+	c.UpdateTimers()
+
+	return res, nil
+}
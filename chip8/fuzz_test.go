@@ -0,0 +1,221 @@
+package chip8
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fuzzCycles is the most instructions run for each fuzz input
+const fuzzCycles = 2000
+
+// addSeedROMs adds the ROMs in the repository as seeds for FuzzCycle
+func addSeedROMs(f *testing.F) {
+	paths, _ := filepath.Glob("../roms/*.ch8")
+	for _, path := range paths {
+		rom, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(rom, uint8(ModeCHIP8), uint8(0), uint8(MemoryWrap), false)
+	}
+}
+
+// accessChecker is an observer that checks memory accesses fall within the
+// address space after the memory policy is applied
+type accessChecker struct {
+	NopObserver
+	t *testing.T
+	c *CHIP8
+}
+
+func (a *accessChecker) MemoryRead(addr uint32, value uint8) {
+	if int(addr) >= a.c.AddressSpace() {
+		a.t.Fatalf("read of %#x outside the %#x byte address space", addr, a.c.AddressSpace())
+	}
+}
+
+func (a *accessChecker) MemoryWrite(addr uint32, old, value uint8) {
+	if int(addr) >= a.c.AddressSpace() {
+		a.t.Fatalf("write of %#x outside the %#x byte address space", addr, a.c.AddressSpace())
+	}
+}
+
+// fuzzRun runs c until it halts, faults or runs fuzzCycles instructions,
+// pressing a key whenever it waits for one and ticking the timers whenever
+// it waits for vertical blank, and checks the VM's invariants after each
+// instruction
+func fuzzRun(t *testing.T, c *CHIP8) {
+	c.Observer = &accessChecker{t: t, c: c}
+	for i := 0; i < fuzzCycles && !c.Halted; i++ {
+		if c.WaitingForKey {
+			key := uint8(i % NumKeys)
+			c.SetKey(key, true)
+			c.SetKey(key, false)
+		}
+		if c.WaitingForVBlank() {
+			c.UpdateTimers()
+		}
+
+		pc := c.PC
+		err := c.Cycle()
+		checkInvariants(t, c)
+		if err != nil {
+			var execErr *ExecError
+			if !errors.As(err, &execErr) {
+				t.Fatalf("Cycle returned %T %v, want *ExecError", err, err)
+			}
+			if c.PC != pc || execErr.PC != pc {
+				t.Fatalf("after fault at %#x PC = %#x and ExecError.PC = %#x", pc, c.PC, execErr.PC)
+			}
+			return
+		}
+	}
+}
+
+// checkInvariants checks the state every instruction must leave the VM in
+func checkInvariants(t *testing.T, c *CHIP8) {
+	t.Helper()
+	if c.SP > StackSize {
+		t.Fatalf("SP = %d, beyond the %d entry stack", c.SP, StackSize)
+	}
+	// Only the mask policy keeps PC and I themselves in range; the others
+	// apply the policy to each access, which accessChecker checks
+	if c.MemoryPolicy == MemoryMask {
+		if int(c.PC) >= c.AddressSpace() {
+			t.Fatalf("PC = %#x, outside the %#x byte address space", c.PC, c.AddressSpace())
+		}
+		if int(c.I) >= c.AddressSpace() {
+			t.Fatalf("I = %#x, outside the %#x byte address space", c.I, c.AddressSpace())
+		}
+	}
+	if c.Plane > 3 {
+		t.Fatalf("Plane = %d, want 0-3", c.Plane)
+	}
+	// One bit per bitplane, and only XO-CHIP has a second plane. The
+	// display is checked only when it has changed, which clears DrawFlag.
+	if c.DrawFlag {
+		max := uint8(1)
+		if c.Mode == ModeXOCHIP {
+			max = 3
+		}
+		for i, p := range c.Display {
+			if p > max {
+				t.Fatalf("Display[%d] = %d, want 0-%d", i, p, max)
+			}
+		}
+		c.DrawFlag = false
+	}
+	if c.WaitingForKey && c.KeyRegister >= NumRegisters {
+		t.Fatalf("waiting for a key into V%d", c.KeyRegister)
+	}
+}
+
+// fuzzVM creates a VM for a fuzz input, choosing its mode, quirks, memory
+// policy and timing from the input's settings bytes. The top bit of quirks
+// puts the fonts behind a read-only memory map.
+func fuzzVM(mode, quirks, policy uint8, vip bool) *CHIP8 {
+	c := NewWithMode(Mode(mode % 3))
+	c.Quirks = Quirks{
+		ShiftUsesVY:         quirks&0x01 != 0,
+		LoadStoreIncrementI: quirks&0x02 != 0,
+		JumpUsesVX:          quirks&0x04 != 0,
+		VFReset:             quirks&0x08 != 0,
+		ClipSprites:         quirks&0x10 != 0,
+		DisplayWait:         quirks&0x20 != 0,
+		ResolutionClears:    quirks&0x40 != 0,
+		HalfScrollLowRes:    quirks&0x40 == 0,
+	}
+	c.MemoryPolicy = MemoryPolicy(policy % 3)
+	if vip {
+		c.Timing = TimingVIP
+	}
+	if quirks&0x80 != 0 {
+		m := NewMemoryMap(c.RAM())
+		m.Map(0, FontEnd, ReadOnly{c.RAM()})
+		c.Bus = m
+	}
+	c.Seed(uint64(mode)<<16 | uint64(quirks)<<8 | uint64(policy))
+	return c
+}
+
+// FuzzCycle runs random ROMs from the initial state
+func FuzzCycle(f *testing.F) {
+	addSeedROMs(f)
+	f.Add([]byte{0x00, 0xEE}, uint8(ModeCHIP8), uint8(0), uint8(MemoryFault), false)
+	f.Add([]byte{0x22, 0x00}, uint8(ModeSCHIP), uint8(0), uint8(MemoryWrap), false)
+	f.Add([]byte{0xBF, 0xFF}, uint8(ModeCHIP8), uint8(0), uint8(MemoryMask), false)
+	f.Add([]byte{0xAF, 0xFF, 0xFF, 0x1E, 0xFF, 0x65}, uint8(ModeCHIP8), uint8(0x02), uint8(MemoryWrap), true)
+	f.Add([]byte{0xA0, 0x10, 0xF3, 0x33}, uint8(ModeCHIP8), uint8(0x80), uint8(MemoryWrap), false)
+	f.Add([]byte{0x00, 0xFF, 0xA2, 0x00, 0xD0, 0x10, 0x00, 0xC5, 0x00, 0xFB}, uint8(ModeSCHIP), uint8(0x10), uint8(MemoryWrap), false)
+	f.Add([]byte{0xF3, 0x01, 0xF0, 0x00, 0xFF, 0xF0, 0xD0, 0x1F, 0x00, 0xD3, 0xF0, 0x02, 0x51, 0xE2}, uint8(ModeXOCHIP), uint8(0), uint8(MemoryFault), false)
+
+	f.Fuzz(func(t *testing.T, rom []byte, mode, quirks, policy uint8, vip bool) {
+		c := fuzzVM(mode, quirks, policy, vip)
+		if err := c.LoadROM(rom); err != nil {
+			t.Skip()
+		}
+		fuzzRun(t, c)
+	})
+}
+
+// fuzzStateSize is the length of the register state read by FuzzState:
+// V0-VF, I, PC, SP, the stack, the timers, the plane, the resolution and
+// the keys
+const fuzzStateSize = NumRegisters + 2 + 2 + 1 + 2*StackSize + 2 + 1 + 1 + 2
+
+// fuzzState builds seed states for FuzzState
+type fuzzState [fuzzStateSize]byte
+
+func (s fuzzState) bytes() []byte {
+	return s[:]
+}
+
+// FuzzState runs from random register and memory states. Memory is filled
+// from offset onwards, wrapping around the address space, so the fonts and
+// the top of memory are covered as well as the program.
+func FuzzState(f *testing.F) {
+	f.Add(make([]byte, fuzzStateSize), []byte{0x12, 0x00}, uint16(ProgramStart), uint8(ModeCHIP8), uint8(MemoryWrap))
+	f.Add(fuzzState{0: 0xFF, 16: 0x0F, 17: 0xFF, 18: 0x0F, 19: 0xFE, 20: StackSize}.bytes(), []byte{0x00, 0xEE, 0xF0, 0x65}, uint16(0xFFE), uint8(ModeCHIP8), uint8(MemoryFault))
+	f.Add(fuzzState{0: 0xFF, 16: 0xFF, 17: 0xFE, 18: 0xFF, 19: 0xFE, 55: 3}.bytes(), []byte{0xF0, 0x00, 0xFF, 0xFF, 0xD0, 0x10}, uint16(0xFFFE), uint8(ModeXOCHIP), uint8(MemoryWrap))
+
+	f.Fuzz(func(t *testing.T, state, memory []byte, offset uint16, mode, policy uint8) {
+		if len(state) < fuzzStateSize {
+			t.Skip()
+		}
+		c := fuzzVM(mode, 0, policy, false)
+		for i, b := range memory {
+			c.Memory[(int(offset)+i)%c.AddressSpace()] = b
+		}
+
+		copy(c.V[:], state)
+		s := state[NumRegisters:]
+		c.I = uint16(s[0])<<8 | uint16(s[1])
+		c.PC = uint16(s[2])<<8 | uint16(s[3])
+		if c.MemoryPolicy == MemoryMask {
+			// PC and I can't leave the address space under this policy
+			c.PC &= uint16(c.addressMask())
+			c.I &= uint16(c.addressMask())
+		}
+		c.SP = s[4] % (StackSize + 1)
+		s = s[5:]
+		for i := range c.Stack {
+			c.Stack[i] = uint16(s[2*i])<<8 | uint16(s[2*i+1])
+		}
+		s = s[2*StackSize:]
+		c.DelayTimer, c.SoundTimer = s[0], s[1]
+		if c.Mode == ModeXOCHIP {
+			c.Plane = s[2] & 3
+		}
+		if c.Mode != ModeCHIP8 {
+			c.HiRes = s[3]&1 != 0
+		}
+		keys := uint16(s[4])<<8 | uint16(s[5])
+		for k := range c.Keys {
+			c.Keys[k] = keys&(1<<k) != 0
+		}
+
+		fuzzRun(t, c)
+	})
+}
@@ -0,0 +1,138 @@
+package chip8
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MemoryPolicy selects how out-of-range memory and key accesses are handled
+type MemoryPolicy uint8
+
+const (
+	// MemoryWrap wraps addresses around the end of the address space and
+	// key indices to 0x0-0xF
+	MemoryWrap MemoryPolicy = iota
+	// MemoryFault stops execution with ErrMemoryFault or ErrInvalidKey
+	MemoryFault
+	// MemoryMask keeps PC and I within the address space by masking them
+	// to 12 bits (16 bits in XO-CHIP mode) after every instruction, the way
+	// the 12-bit address bus of the original hardware did. Accesses that
+	// run past the end wrap as with MemoryWrap.
+	MemoryMask
+)
+
+// String returns the command line name of the policy
+func (p MemoryPolicy) String() string {
+	switch p {
+	case MemoryWrap:
+		return "wrap"
+	case MemoryFault:
+		return "fault"
+	case MemoryMask:
+		return "mask"
+	default:
+		return fmt.Sprintf("MemoryPolicy(%d)", uint8(p))
+	}
+}
+
+// ParseMemoryPolicy returns the memory policy with the given command line name
+func ParseMemoryPolicy(name string) (MemoryPolicy, error) {
+	switch strings.ToLower(name) {
+	case "wrap":
+		return MemoryWrap, nil
+	case "fault":
+		return MemoryFault, nil
+	case "mask":
+		return MemoryMask, nil
+	default:
+		return 0, fmt.Errorf("unknown memory policy %q (valid: wrap, fault, mask)", name)
+	}
+}
+
+// addressMask returns the mask that keeps an address within the address space
+func (c *CHIP8) addressMask() uint32 {
+	return uint32(c.AddressSpace() - 1)
+}
+
+// resolve applies the memory policy to addr
+func (c *CHIP8) resolve(addr uint32) (uint32, error) {
+	if addr > c.addressMask() {
+		if c.MemoryPolicy == MemoryFault {
+			return 0, c.fault(ErrMemoryFault, addr)
+		}
+		addr &= c.addressMask()
+	}
+	return addr, nil
+}
+
+// load reads the byte at addr, which is within the address space, from
+// the bus
+func (c *CHIP8) load(addr uint32) (uint8, error) {
+	if c.Bus == nil {
+		return c.Memory[addr], nil
+	}
+	v, err := c.Bus.Read(addr)
+	if err != nil {
+		return 0, c.busFault(err, addr)
+	}
+	return v, nil
+}
+
+// fetch returns the instruction byte at addr under the memory policy
+func (c *CHIP8) fetch(addr uint32) (uint8, error) {
+	addr, err := c.resolve(addr)
+	if err != nil {
+		return 0, err
+	}
+	return c.load(addr)
+}
+
+// read returns the data byte at addr under the memory policy
+func (c *CHIP8) read(addr uint32) (uint8, error) {
+	addr, err := c.resolve(addr)
+	if err != nil {
+		return 0, err
+	}
+	v, err := c.load(addr)
+	if err != nil {
+		return 0, err
+	}
+	if c.Observer != nil {
+		c.Observer.MemoryRead(addr, v)
+	}
+	return v, nil
+}
+
+// write stores v at addr under the memory policy. The observer is told the
+// old contents of Memory at addr, and is not told of writes the bus fails.
+func (c *CHIP8) write(addr uint32, v uint8) error {
+	addr, err := c.resolve(addr)
+	if err != nil {
+		return err
+	}
+	old := c.Memory[addr]
+	if c.Bus == nil {
+		c.Memory[addr] = v
+	} else if err := c.Bus.Write(addr, v); err != nil {
+		return c.busFault(err, addr)
+	}
+	if c.Observer != nil {
+		c.Observer.MemoryWrite(addr, old, v)
+	}
+	return nil
+}
+
+// peek returns the byte at addr wrapped into the address space, without
+// faulting. It is used to look ahead at the next instruction.
+func (c *CHIP8) peek(addr uint32) uint8 {
+	v, _ := c.load(addr & c.addressMask())
+	return v
+}
+
+// key returns the state of the key named by v under the memory policy
+func (c *CHIP8) key(v uint8) (bool, error) {
+	if v >= NumKeys && c.MemoryPolicy == MemoryFault {
+		return false, c.fault(ErrInvalidKey, uint32(v))
+	}
+	return c.Keys[v&0xF], nil
+}
@@ -0,0 +1,59 @@
+package chip8
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Mode selects the instruction set the virtual machine executes
+type Mode uint8
+
+const (
+	// ModeCHIP8 is the original CHIP-8 instruction set
+	ModeCHIP8 Mode = iota
+	// ModeSCHIP adds the SUPER-CHIP 1.1 instructions and 128x64 display
+	ModeSCHIP
+	// ModeXOCHIP adds the XO-CHIP instructions, 64KB of memory, two
+	// bitplanes and programmable audio on top of SUPER-CHIP
+	ModeXOCHIP
+)
+
+// String returns the command line name of the mode
+func (m Mode) String() string {
+	switch m {
+	case ModeCHIP8:
+		return "chip8"
+	case ModeSCHIP:
+		return "schip"
+	case ModeXOCHIP:
+		return "xochip"
+	default:
+		return fmt.Sprintf("Mode(%d)", uint8(m))
+	}
+}
+
+// DefaultQuirks returns the quirks preset that matches the mode
+func (m Mode) DefaultQuirks() Quirks {
+	switch m {
+	case ModeSCHIP:
+		return QuirksSCHIP
+	case ModeXOCHIP:
+		return QuirksXOCHIP
+	default:
+		return Quirks{}
+	}
+}
+
+// ParseMode returns the mode with the given command line name
+func ParseMode(name string) (Mode, error) {
+	switch strings.ToLower(name) {
+	case "chip8", "chip-8":
+		return ModeCHIP8, nil
+	case "schip", "superchip":
+		return ModeSCHIP, nil
+	case "xochip", "xo-chip":
+		return ModeXOCHIP, nil
+	default:
+		return 0, fmt.Errorf("unknown mode %q (valid: chip8, schip, xochip)", name)
+	}
+}
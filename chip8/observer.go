@@ -0,0 +1,166 @@
+package chip8
+
+// Observer receives callbacks as the VM executes, so tools such as
+// debuggers, tracers and profilers can watch execution without changing
+// the interpreter. Attach one with AddObserver, or set CHIP8.Observer;
+// when it is nil the callbacks cost a single nil check each.
+//
+// Callbacks are made synchronously from Cycle and must not call Cycle
+// themselves. Embed NopObserver to implement only some of them.
+type Observer interface {
+	// BeforeInstruction is called after an instruction has been fetched
+	// and before it executes
+	BeforeInstruction(pc, opcode uint16)
+	// AfterInstruction is called after an instruction has executed
+	// successfully; it is not called if the instruction returned an error
+	AfterInstruction(pc, opcode uint16)
+	// MemoryRead is called for every data byte an instruction reads.
+	// Instruction fetches are not reported.
+	MemoryRead(addr uint32, value uint8)
+	// MemoryWrite is called for every byte an instruction writes
+	MemoryWrite(addr uint32, old, value uint8)
+	// RegisterWrite is called whenever a V register is written, even if
+	// the value doesn't change
+	RegisterWrite(reg, old, value uint8)
+	// Draw is called after a sprite has been drawn at (x, y)
+	Draw(x, y uint8, width, height int, collision bool)
+	// SoundStart is called when the sound timer becomes non-zero
+	SoundStart()
+	// SoundStop is called when the sound timer reaches zero
+	SoundStop()
+	// KeyWait is called when FX0A starts waiting for a key for register reg
+	KeyWait(reg uint8)
+}
+
+// NopObserver implements Observer with callbacks that do nothing. Embed it
+// in observers that only need some of the callbacks.
+type NopObserver struct{}
+
+func (NopObserver) BeforeInstruction(pc, opcode uint16)                {}
+func (NopObserver) AfterInstruction(pc, opcode uint16)                 {}
+func (NopObserver) MemoryRead(addr uint32, value uint8)                {}
+func (NopObserver) MemoryWrite(addr uint32, old, value uint8)          {}
+func (NopObserver) RegisterWrite(reg, old, value uint8)                {}
+func (NopObserver) Draw(x, y uint8, width, height int, collision bool) {}
+func (NopObserver) SoundStart()                                        {}
+func (NopObserver) SoundStop()                                         {}
+func (NopObserver) KeyWait(reg uint8)                                  {}
+
+// MultiObserver forwards every callback to each of its observers in order
+type MultiObserver []Observer
+
+func (m MultiObserver) BeforeInstruction(pc, opcode uint16) {
+	for _, o := range m {
+		o.BeforeInstruction(pc, opcode)
+	}
+}
+
+func (m MultiObserver) AfterInstruction(pc, opcode uint16) {
+	for _, o := range m {
+		o.AfterInstruction(pc, opcode)
+	}
+}
+
+func (m MultiObserver) MemoryRead(addr uint32, value uint8) {
+	for _, o := range m {
+		o.MemoryRead(addr, value)
+	}
+}
+
+func (m MultiObserver) MemoryWrite(addr uint32, old, value uint8) {
+	for _, o := range m {
+		o.MemoryWrite(addr, old, value)
+	}
+}
+
+func (m MultiObserver) RegisterWrite(reg, old, value uint8) {
+	for _, o := range m {
+		o.RegisterWrite(reg, old, value)
+	}
+}
+
+func (m MultiObserver) Draw(x, y uint8, width, height int, collision bool) {
+	for _, o := range m {
+		o.Draw(x, y, width, height, collision)
+	}
+}
+
+func (m MultiObserver) SoundStart() {
+	for _, o := range m {
+		o.SoundStart()
+	}
+}
+
+func (m MultiObserver) SoundStop() {
+	for _, o := range m {
+		o.SoundStop()
+	}
+}
+
+func (m MultiObserver) KeyWait(reg uint8) {
+	for _, o := range m {
+		o.KeyWait(reg)
+	}
+}
+
+// AddObserver attaches o after any observers already attached
+func (c *CHIP8) AddObserver(o Observer) {
+	switch cur := c.Observer.(type) {
+	case nil:
+		c.Observer = o
+	case MultiObserver:
+		c.Observer = append(cur[:len(cur):len(cur)], o)
+	default:
+		c.Observer = MultiObserver{cur, o}
+	}
+}
+
+// RemoveObserver detaches o if it was attached by AddObserver, leaving the
+// other observers in order
+func (c *CHIP8) RemoveObserver(o Observer) {
+	switch cur := c.Observer.(type) {
+	case MultiObserver:
+		var rest MultiObserver
+		for _, x := range cur {
+			if x != o {
+				rest = append(rest, x)
+			}
+		}
+		switch len(rest) {
+		case 0:
+			c.Observer = nil
+		case 1:
+			c.Observer = rest[0]
+		default:
+			c.Observer = rest
+		}
+	default:
+		if cur == o {
+			c.Observer = nil
+		}
+	}
+}
+
+// setV writes register r, notifying the observer
+func (c *CHIP8) setV(r, v uint8) {
+	if c.Observer != nil {
+		c.Observer.RegisterWrite(r, c.V[r], v)
+	}
+	c.V[r] = v
+}
+
+// setSoundTimer sets the sound timer, notifying the observer when the sound
+// starts or stops
+func (c *CHIP8) setSoundTimer(v uint8) {
+	old := c.SoundTimer
+	c.SoundTimer = v
+	if c.Observer == nil {
+		return
+	}
+	switch {
+	case old == 0 && v > 0:
+		c.Observer.SoundStart()
+	case old > 0 && v == 0:
+		c.Observer.SoundStop()
+	}
+}
@@ -0,0 +1,93 @@
+package chip8
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Quirks selects between the behaviours that differ across CHIP-8
+// interpreters for the same opcode. The zero value matches the behaviour
+// of this emulator before quirks were configurable.
+type Quirks struct {
+	// ShiftUsesVY makes 8XY6/8XYE shift VY and store the result in VX
+	// (COSMAC VIP). When false VX is shifted in place (SCHIP).
+	ShiftUsesVY bool
+
+	// LoadStoreIncrementI makes FX55/FX65 leave I pointing past the last
+	// register transferred (I += X + 1). When false I is left untouched.
+	LoadStoreIncrementI bool
+
+	// JumpUsesVX makes BNNN jump to XNN + VX instead of NNN + V0 (SCHIP).
+	JumpUsesVX bool
+
+	// VFReset makes 8XY1, 8XY2 and 8XY3 clear VF (COSMAC VIP).
+	VFReset bool
+
+	// ClipSprites makes DXYN clip pixels that fall off the screen edge
+	// instead of wrapping them to the opposite side. The starting
+	// coordinate always wraps.
+	ClipSprites bool
+
+	// DisplayWait makes DXYN wait for the next vertical blank, so at most
+	// one sprite is drawn per 60 Hz frame (COSMAC VIP).
+	DisplayWait bool
+
+	// ResolutionClears makes 00FE/00FF clear the display (XO-CHIP). When
+	// false the picture is kept and rescaled to the new resolution
+	// (SUPER-CHIP 1.1).
+	ResolutionClears bool
+
+	// HalfScrollLowRes makes scrolls move half as far in low resolution,
+	// since SUPER-CHIP 1.1 scrolls its 128x64 screen by high-resolution
+	// pixels.
+	HalfScrollLowRes bool
+}
+
+// Quirk presets for the common CHIP-8 platforms
+var (
+	// QuirksVIP matches the original COSMAC VIP interpreter
+	QuirksVIP = Quirks{
+		ShiftUsesVY:         true,
+		LoadStoreIncrementI: true,
+		VFReset:             true,
+		ClipSprites:         true,
+		DisplayWait:         true,
+	}
+
+	// QuirksSCHIP matches SUPER-CHIP 1.1 on the HP48
+	QuirksSCHIP = Quirks{
+		JumpUsesVX:       true,
+		ClipSprites:      true,
+		HalfScrollLowRes: true,
+	}
+
+	// QuirksXOCHIP matches Octo's XO-CHIP interpreter
+	QuirksXOCHIP = Quirks{
+		ShiftUsesVY:         true,
+		LoadStoreIncrementI: true,
+		ResolutionClears:    true,
+	}
+)
+
+// QuirksPresets maps preset names, as accepted on the command line, to quirks
+var QuirksPresets = map[string]Quirks{
+	"default": {},
+	"vip":     QuirksVIP,
+	"schip":   QuirksSCHIP,
+	"xochip":  QuirksXOCHIP,
+}
+
+// ParseQuirks returns the quirks preset with the given name
+func ParseQuirks(name string) (Quirks, error) {
+	if q, ok := QuirksPresets[strings.ToLower(name)]; ok {
+		return q, nil
+	}
+
+	names := make([]string, 0, len(QuirksPresets))
+	for n := range QuirksPresets {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return Quirks{}, fmt.Errorf("unknown quirks preset %q (valid: %s)", name, strings.Join(names, ", "))
+}
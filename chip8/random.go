@@ -0,0 +1,76 @@
+package chip8
+
+import (
+	"encoding"
+	"errors"
+	"math/rand/v2"
+	"reflect"
+)
+
+// RandomSource supplies the random numbers used by CXNN. Sources that
+// also implement encoding.BinaryMarshaler and encoding.BinaryUnmarshaler
+// have their state included in VM snapshots.
+type RandomSource interface {
+	Uint64() uint64
+}
+
+// ErrRandomStateUnsupported is returned when the random source cannot save
+// or restore its state
+var ErrRandomStateUnsupported = errors.New("random source does not support saving its state")
+
+// NewRandomSource returns the default random source seeded with seed. The
+// same seed always produces the same sequence.
+func NewRandomSource(seed uint64) RandomSource {
+	return rand.NewPCG(seed, seed)
+}
+
+// Seed replaces the random source with the default source seeded with seed
+func (c *CHIP8) Seed(seed uint64) {
+	c.random = NewRandomSource(seed)
+}
+
+// SetRandomSource makes CXNN draw from src
+func (c *CHIP8) SetRandomSource(src RandomSource) {
+	c.random = src
+}
+
+// RandomSource returns the source CXNN draws from. A VM not created by
+// New gets a randomly seeded default source the first time it needs one.
+func (c *CHIP8) RandomSource() RandomSource {
+	if c.random == nil {
+		c.random = NewRandomSource(rand.Uint64())
+	}
+	return c.random
+}
+
+// cloneRandom returns an independent copy of src to restore state into,
+// so a failed restore leaves src untouched. Sources that aren't pointers
+// can't restore state and are returned as they are.
+func cloneRandom(src RandomSource) RandomSource {
+	v := reflect.ValueOf(src)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return src
+	}
+	clone := reflect.New(v.Elem().Type())
+	clone.Elem().Set(v.Elem())
+	return clone.Interface().(RandomSource)
+}
+
+// RandomState returns the serialized state of the random source
+func (c *CHIP8) RandomState() ([]byte, error) {
+	m, ok := c.random.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, ErrRandomStateUnsupported
+	}
+	return m.MarshalBinary()
+}
+
+// SetRandomState restores the random source from state returned by
+// RandomState
+func (c *CHIP8) SetRandomState(state []byte) error {
+	u, ok := c.random.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return ErrRandomStateUnsupported
+	}
+	return u.UnmarshalBinary(state)
+}
@@ -0,0 +1,217 @@
+package chip8
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Rewinder keeps a bounded history of VM snapshots so execution can be run
+// backwards frame by frame. Every keyframeInterval-th snapshot is stored in
+// full; the rest are stored as run-length encoded XOR deltas against the
+// preceding keyframe, which are small because most memory and display bytes
+// don't change between frames.
+type Rewinder struct {
+	capacity         int
+	keyframeInterval int
+
+	// Ring buffer of frames, oldest at start
+	frames []rewindFrame
+	start  int
+	count  int
+
+	// Most recent keyframe and how many frames have been pushed since
+	keyframe      []byte
+	sinceKeyframe int
+}
+
+// rewindFrame is one snapshot in the history
+type rewindFrame struct {
+	// Full snapshot for keyframes, encoded delta otherwise
+	data []byte
+	// Keyframe the delta applies to; nil for keyframes
+	base []byte
+}
+
+// NewRewinder creates a rewinder holding up to capacity frames, with a full
+// keyframe every keyframeInterval frames
+func NewRewinder(capacity, keyframeInterval int) *Rewinder {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if keyframeInterval < 1 {
+		keyframeInterval = 1
+	}
+	return &Rewinder{
+		capacity:         capacity,
+		keyframeInterval: keyframeInterval,
+		frames:           make([]rewindFrame, capacity),
+	}
+}
+
+// Push records the current state of the VM, discarding the oldest frame
+// when the history is full. It should be called once per frame.
+func (r *Rewinder) Push(c *CHIP8) error {
+	state, err := c.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	var frame rewindFrame
+	if r.keyframe == nil || len(state) != len(r.keyframe) || r.sinceKeyframe >= r.keyframeInterval {
+		frame.data = state
+		r.keyframe = state
+		r.sinceKeyframe = 0
+	} else {
+		frame.data = encodeDelta(state, r.keyframe)
+		frame.base = r.keyframe
+	}
+	r.sinceKeyframe++
+
+	if r.count == r.capacity {
+		// Overwrite the oldest frame
+		r.frames[r.start] = frame
+		r.start = (r.start + 1) % r.capacity
+	} else {
+		r.frames[(r.start+r.count)%r.capacity] = frame
+		r.count++
+	}
+	return nil
+}
+
+// Rewind removes the most recent frame from the history and restores the
+// VM to it. Frames identical to the VM's current state, such as the one
+// pushed at the end of the current frame, are discarded first, so every
+// rewind steps back. It returns false if there is no earlier frame.
+func (r *Rewinder) Rewind(c *CHIP8) (bool, error) {
+	current, err := c.MarshalBinary()
+	if err != nil {
+		return false, err
+	}
+	for r.count > 0 {
+		state, err := r.pop()
+		if err != nil {
+			return false, err
+		}
+		if bytes.Equal(state, current) {
+			continue
+		}
+		if err := c.UnmarshalBinary(state); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// pop removes the most recent frame from the history and returns its state
+func (r *Rewinder) pop() ([]byte, error) {
+	idx := (r.start + r.count - 1) % r.capacity
+	frame := r.frames[idx]
+	r.frames[idx] = rewindFrame{}
+	r.count--
+
+	// Later pushes must delta against a keyframe still in the history
+	r.keyframe = nil
+
+	if frame.base == nil {
+		return frame.data, nil
+	}
+	return applyDelta(frame.data, frame.base)
+}
+
+// Len returns the number of frames in the history
+func (r *Rewinder) Len() int {
+	return r.count
+}
+
+// Size returns the approximate number of bytes used by the history,
+// including keyframes that have left the history but that remaining deltas
+// still apply to
+func (r *Rewinder) Size() int {
+	size := 0
+	keyframes := make(map[*byte]bool)
+	for i := 0; i < r.count; i++ {
+		frame := r.frames[(r.start+i)%r.capacity]
+		size += len(frame.data)
+		if frame.base == nil {
+			keyframes[&frame.data[0]] = true
+		}
+	}
+	for i := 0; i < r.count; i++ {
+		frame := r.frames[(r.start+i)%r.capacity]
+		if frame.base != nil && !keyframes[&frame.base[0]] {
+			keyframes[&frame.base[0]] = true
+			size += len(frame.base)
+		}
+	}
+	return size
+}
+
+// Clear discards the whole history
+func (r *Rewinder) Clear() {
+	for i := range r.frames {
+		r.frames[i] = rewindFrame{}
+	}
+	r.start = 0
+	r.count = 0
+	r.keyframe = nil
+	r.sinceKeyframe = 0
+}
+
+// encodeDelta XORs state against base and run-length encodes the result
+// as (zero run, literal length, literal bytes) triples of uvarints
+func encodeDelta(state, base []byte) []byte {
+	var out []byte
+	for i := 0; i < len(state); {
+		zeros := 0
+		for i < len(state) && state[i] == base[i] {
+			zeros++
+			i++
+		}
+
+		litStart := i
+		for i < len(state) && state[i] != base[i] {
+			i++
+		}
+
+		out = binary.AppendUvarint(out, uint64(zeros))
+		out = binary.AppendUvarint(out, uint64(i-litStart))
+		for j := litStart; j < i; j++ {
+			out = append(out, state[j]^base[j])
+		}
+	}
+	return out
+}
+
+// applyDelta reverses encodeDelta
+func applyDelta(delta, base []byte) ([]byte, error) {
+	state := make([]byte, len(base))
+	copy(state, base)
+
+	pos := 0
+	for len(delta) > 0 {
+		zeros, n := binary.Uvarint(delta)
+		if n <= 0 {
+			return nil, fmt.Errorf("corrupt rewind delta")
+		}
+		delta = delta[n:]
+
+		literal, n := binary.Uvarint(delta)
+		if n <= 0 || uint64(len(delta)-n) < literal {
+			return nil, fmt.Errorf("corrupt rewind delta")
+		}
+		delta = delta[n:]
+
+		pos += int(zeros)
+		if pos > len(state) || uint64(len(state)-pos) < literal {
+			return nil, fmt.Errorf("corrupt rewind delta")
+		}
+		for j := 0; j < int(literal); j++ {
+			state[pos+j] ^= delta[j]
+		}
+		pos += int(literal)
+		delta = delta[literal:]
+	}
+	return state, nil
+}
@@ -0,0 +1,275 @@
+package chip8
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Save state layout:
+//
+//	magic   [4]byte  "C8ST"
+//	version uint16
+//	length  uint32   payload length in bytes
+//	payload [length]byte
+//	crc     uint32   CRC-32 (IEEE) of everything before it
+//
+// All integers are big-endian. The payload layout depends on the version:
+// version 2 appends the timing model and cycle counters to version 1.
+const (
+	// SaveStateVersion is the version written by MarshalBinary
+	SaveStateVersion = 2
+
+	saveStateMagic      = "C8ST"
+	saveStateHeaderSize = 4 + 2 + 4
+	saveStateCRCSize    = 4
+)
+
+// Save state errors
+var (
+	// ErrInvalidSaveState is returned for data that is not a save state or
+	// is truncated or malformed
+	ErrInvalidSaveState = errors.New("invalid save state")
+	// ErrSaveStateChecksum is returned when the save state is corrupted
+	ErrSaveStateChecksum = errors.New("save state checksum mismatch")
+	// ErrSaveStateVersion is returned for save states written by a newer
+	// or unsupported version of the emulator
+	ErrSaveStateVersion = errors.New("unsupported save state version")
+)
+
+// quirkTable gives the bit of each quirk in the save state's quirks field.
+// The bits are part of the format: a new quirk takes the next free bit, and
+// existing entries are never renumbered.
+var quirkTable = []struct {
+	bit   uint8
+	quirk func(q *Quirks) *bool
+}{
+	{0x01, func(q *Quirks) *bool { return &q.ShiftUsesVY }},
+	{0x02, func(q *Quirks) *bool { return &q.LoadStoreIncrementI }},
+	{0x04, func(q *Quirks) *bool { return &q.JumpUsesVX }},
+	{0x08, func(q *Quirks) *bool { return &q.VFReset }},
+	{0x10, func(q *Quirks) *bool { return &q.ClipSprites }},
+	{0x20, func(q *Quirks) *bool { return &q.DisplayWait }},
+	{0x40, func(q *Quirks) *bool { return &q.ResolutionClears }},
+	{0x80, func(q *Quirks) *bool { return &q.HalfScrollLowRes }},
+}
+
+// MarshalBinary serializes the complete VM state, including the mode,
+// quirks and random source state, into a versioned, checksummed snapshot
+func (c *CHIP8) MarshalBinary() ([]byte, error) {
+	var payload bytes.Buffer
+	if err := c.encodeState(&payload); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(saveStateMagic)
+	binary.Write(&out, binary.BigEndian, uint16(SaveStateVersion))
+	binary.Write(&out, binary.BigEndian, uint32(payload.Len()))
+	out.Write(payload.Bytes())
+	binary.Write(&out, binary.BigEndian, crc32.ChecksumIEEE(out.Bytes()))
+
+	return out.Bytes(), nil
+}
+
+// UnmarshalBinary restores the VM from a snapshot created by MarshalBinary.
+// Snapshots from older versions are migrated; snapshots from newer or
+// unknown versions are refused. The VM is left unchanged if an error is
+// returned.
+func (c *CHIP8) UnmarshalBinary(data []byte) error {
+	if len(data) < saveStateHeaderSize+saveStateCRCSize || string(data[:4]) != saveStateMagic {
+		return ErrInvalidSaveState
+	}
+
+	version := binary.BigEndian.Uint16(data[4:6])
+	length := binary.BigEndian.Uint32(data[6:10])
+	if uint64(length) != uint64(len(data)-saveStateHeaderSize-saveStateCRCSize) {
+		return fmt.Errorf("%w: payload length %d does not match data", ErrInvalidSaveState, length)
+	}
+
+	body := data[:len(data)-saveStateCRCSize]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(data[len(body):]) {
+		return ErrSaveStateChecksum
+	}
+
+	if version == 0 || version > SaveStateVersion {
+		return fmt.Errorf("%w: %d (this build supports up to %d)", ErrSaveStateVersion, version, SaveStateVersion)
+	}
+
+	// Decode into a copy so a failure leaves the VM untouched, random
+	// source included
+	restored := *c
+	restored.random = cloneRandom(c.random)
+	if err := restored.decodeState(bytes.NewReader(body[saveStateHeaderSize:]), version); err != nil {
+		return err
+	}
+
+	*c = restored
+	c.DrawFlag = true
+	return nil
+}
+
+// encodeState writes the current version's payload
+func (c *CHIP8) encodeState(w io.Writer) error {
+	rng, err := c.RandomState()
+	if errors.Is(err, ErrRandomStateUnsupported) {
+		rng = nil
+	} else if err != nil {
+		return err
+	}
+
+	fields := []any{
+		uint8(c.Mode),
+		c.quirkBits(),
+		c.HiRes,
+		c.Halted,
+		c.WaitingForKey,
+		c.KeyRegister,
+		c.waitingForVBlank,
+		c.I,
+		c.PC,
+		c.SP,
+		c.DelayTimer,
+		c.SoundTimer,
+		c.V,
+		c.Stack,
+		c.Keys,
+		c.RPL,
+		c.Plane,
+		c.Pitch,
+		c.AudioPattern,
+		c.Display,
+		c.Memory[:c.AddressSpace()],
+		uint16(len(rng)),
+		rng,
+		uint8(c.Timing),
+		c.Cycles,
+		int32(c.cycleCarry),
+	}
+	for _, f := range fields {
+		if err := binary.Write(w, binary.BigEndian, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeState reads a payload of the given version. Fields added after
+// that version keep their defaults.
+func (c *CHIP8) decodeState(r *bytes.Reader, version uint16) error {
+	var (
+		mode, quirks uint8
+		rngLen       uint16
+	)
+
+	fields := []any{
+		&mode,
+		&quirks,
+		&c.HiRes,
+		&c.Halted,
+		&c.WaitingForKey,
+		&c.KeyRegister,
+		&c.waitingForVBlank,
+		&c.I,
+		&c.PC,
+		&c.SP,
+		&c.DelayTimer,
+		&c.SoundTimer,
+		&c.V,
+		&c.Stack,
+		&c.Keys,
+		&c.RPL,
+		&c.Plane,
+		&c.Pitch,
+		&c.AudioPattern,
+		&c.Display,
+	}
+	for _, f := range fields {
+		if err := binary.Read(r, binary.BigEndian, f); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSaveState, err)
+		}
+	}
+
+	if Mode(mode) > ModeXOCHIP {
+		return fmt.Errorf("%w: unknown mode %d", ErrInvalidSaveState, mode)
+	}
+	c.Mode = Mode(mode)
+	c.setQuirkBits(quirks)
+
+	if c.SP > StackSize || c.KeyRegister >= NumRegisters {
+		return fmt.Errorf("%w: register out of range", ErrInvalidSaveState)
+	}
+
+	c.Memory = [ExtendedMemorySize]uint8{}
+	if _, err := io.ReadFull(r, c.Memory[:c.AddressSpace()]); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSaveState, err)
+	}
+
+	if err := binary.Read(r, binary.BigEndian, &rngLen); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSaveState, err)
+	}
+	rng := make([]byte, rngLen)
+	if _, err := io.ReadFull(r, rng); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSaveState, err)
+	}
+
+	c.Timing = TimingNone
+	c.Cycles = 0
+	c.cycleCarry = 0
+	if version >= 2 {
+		var (
+			timing uint8
+			carry  int32
+		)
+		for _, f := range []any{&timing, &c.Cycles, &carry} {
+			if err := binary.Read(r, binary.BigEndian, f); err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidSaveState, err)
+			}
+		}
+		if Timing(timing) > TimingVIP || carry < 0 {
+			return fmt.Errorf("%w: invalid timing state", ErrInvalidSaveState)
+		}
+		c.Timing = Timing(timing)
+		c.cycleCarry = int(carry)
+	}
+
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrInvalidSaveState, r.Len())
+	}
+
+	if len(rng) > 0 {
+		err := c.SetRandomState(rng)
+		if errors.Is(err, ErrRandomStateUnsupported) {
+			// Switch to the default source if the current one can't be restored
+			c.random = NewRandomSource(0)
+			err = c.SetRandomState(rng)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: random state: %v", ErrInvalidSaveState, err)
+		}
+	}
+
+	return nil
+}
+
+// quirkBits packs the quirks into a bit field
+func (c *CHIP8) quirkBits() uint8 {
+	var bits uint8
+	for _, e := range quirkTable {
+		if *e.quirk(&c.Quirks) {
+			bits |= e.bit
+		}
+	}
+	return bits
+}
+
+// setQuirkBits unpacks quirks packed by quirkBits
+func (c *CHIP8) setQuirkBits(bits uint8) {
+	c.Quirks = Quirks{}
+	for _, e := range quirkTable {
+		*e.quirk(&c.Quirks) = bits&e.bit != 0
+	}
+}
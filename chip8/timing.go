@@ -0,0 +1,128 @@
+package chip8
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Timing selects how instructions are scheduled within a frame
+type Timing uint8
+
+const (
+	// TimingNone gives every instruction the same cost; RunFrame runs a
+	// fixed number of instructions per frame
+	TimingNone Timing = iota
+	// TimingVIP charges each instruction the approximate number of 1802
+	// machine cycles it takes in the COSMAC VIP interpreter; RunFrame runs
+	// until the frame's cycle budget is spent
+	TimingVIP
+)
+
+// COSMAC VIP timing constants, in 1802 machine cycles (8 clock cycles,
+// about 4.54 µs at 1.76 MHz)
+const (
+	// VIPFrameCycles is the number of machine cycles in one 60 Hz frame
+	VIPFrameCycles = 3668
+	// VIPInterruptCycles is the part of each frame taken by the display
+	// DMA and the timer interrupt routine
+	VIPInterruptCycles = 1100
+	// VIPCyclesPerFrame is what remains for the interpreter each frame
+	VIPCyclesPerFrame = VIPFrameCycles - VIPInterruptCycles
+)
+
+// String returns the command line name of the timing model
+func (t Timing) String() string {
+	switch t {
+	case TimingNone:
+		return "none"
+	case TimingVIP:
+		return "vip"
+	default:
+		return fmt.Sprintf("Timing(%d)", uint8(t))
+	}
+}
+
+// ParseTiming returns the timing model with the given command line name
+func ParseTiming(name string) (Timing, error) {
+	switch strings.ToLower(name) {
+	case "none":
+		return TimingNone, nil
+	case "vip":
+		return TimingVIP, nil
+	default:
+		return 0, fmt.Errorf("unknown timing model %q (valid: none, vip)", name)
+	}
+}
+
+// instructionCycles returns the cost of executing opcode in the current
+// state. Under TimingVIP this is an approximation of the VIP interpreter's
+// machine cycles, including fetch and decode; otherwise every instruction
+// costs 1.
+func (c *CHIP8) instructionCycles(opcode uint16) int {
+	if c.Timing != TimingVIP {
+		return 1
+	}
+
+	x := (opcode & 0x0F00) >> 8
+	n := int(opcode & 0x000F)
+
+	switch opcode & 0xF000 {
+	case 0x0000:
+		switch opcode {
+		case 0x00E0:
+			return 24
+		case 0x00EE:
+			return 10
+		default:
+			return 12
+		}
+	case 0x1000:
+		return 12
+	case 0x2000:
+		return 26
+	case 0x3000, 0x4000:
+		return 10
+	case 0x5000, 0x9000:
+		return 14
+	case 0x6000:
+		return 6
+	case 0x7000:
+		return 10
+	case 0x8000:
+		return 44
+	case 0xA000:
+		return 12
+	case 0xB000:
+		return 22
+	case 0xC000:
+		return 36
+	case 0xD000:
+		// Each row is shifted into place unless the sprite is byte aligned
+		perRow := 16
+		if c.V[x]%8 != 0 {
+			perRow = 34
+		}
+		return 26 + n*perRow
+	case 0xE000:
+		return 14
+	case 0xF000:
+		switch opcode & 0x00FF {
+		case 0x1E:
+			return 16
+		case 0x29:
+			return 20
+		case 0x33:
+			// The VIP converts by repeated subtraction
+			v := c.V[x]
+			return 80 + 16*int(v/100+(v/10)%10+v%10)
+		case 0x55, 0x65:
+			return 14 + 14*(int(x)+1)
+		default:
+			return 10
+		}
+	}
+	return 12
+}
+
+// vipSkipCycles is the extra cost of a taken skip under TimingVIP
+const vipSkipCycles = 4
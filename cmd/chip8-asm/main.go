@@ -0,0 +1,82 @@
+// Command chip8-asm assembles a CHIP-8 source file, or compiles an Octo
+// source file (.8o), into a ROM, and writes a symbol map next to it for the
+// debuggers.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chip8-emulator/asm"
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/octo"
+	"github.com/chip8-emulator/symbols"
+)
+
+// config holds the command line options
+type config struct {
+	srcPath string
+	mode    string
+	outPath string
+	noSyms  bool
+}
+
+func main() {
+	// Parse command line arguments
+	var cfg config
+	flag.StringVar(&cfg.mode, "mode", "chip8", "Instruction set (chip8, schip, xochip); instructions of later sets are errors")
+	flag.StringVar(&cfg.outPath, "o", "", "Output ROM (default: the source file with a .ch8 extension)")
+	flag.BoolVar(&cfg.noSyms, "nosym", false, "Don't write a symbol map")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Println("Usage: chip8-asm [options] <source-file>")
+		fmt.Println()
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	cfg.srcPath = flag.Arg(0)
+
+	if err := run(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run assembles or compiles the source and writes the ROM and symbol map
+func run(cfg config) error {
+	mode, err := chip8.ParseMode(cfg.mode)
+	if err != nil {
+		return err
+	}
+	var rom []byte
+	var syms *symbols.Map
+	if filepath.Ext(cfg.srcPath) == octo.Ext {
+		res, err := octo.CompileFile(cfg.srcPath, octo.Options{Mode: mode})
+		if err != nil {
+			return err
+		}
+		rom, syms = res.ROM, res.Symbols
+	} else {
+		res, err := asm.AssembleFile(cfg.srcPath, asm.Options{Mode: mode})
+		if err != nil {
+			return err
+		}
+		rom, syms = res.ROM, res.Symbols
+	}
+
+	outPath := cfg.outPath
+	if outPath == "" {
+		outPath = strings.TrimSuffix(cfg.srcPath, filepath.Ext(cfg.srcPath)) + ".ch8"
+	}
+	if err := os.WriteFile(outPath, rom, 0644); err != nil {
+		return err
+	}
+	if cfg.noSyms {
+		return nil
+	}
+	return syms.SaveRelative(symbols.PathFor(outPath))
+}
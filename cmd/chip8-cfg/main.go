@@ -0,0 +1,121 @@
+// Command chip8-cfg extracts the control-flow graph of a CHIP-8 ROM and
+// writes it as Graphviz DOT or JSON. With -frames it first runs the ROM
+// headlessly and merges in the computed jumps it makes.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/chip8-emulator/cfg"
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/headless"
+)
+
+// config holds the command line options
+type config struct {
+	romPath string
+	mode    string
+	format  string
+	frames  int
+	keys    string
+	outPath string
+}
+
+func main() {
+	// Parse command line arguments
+	var c config
+	flag.StringVar(&c.mode, "mode", "chip8", "Instruction set (chip8, schip, xochip)")
+	flag.StringVar(&c.format, "format", "dot", "Output format (dot, calls, json); calls is the call graph in DOT")
+	flag.IntVar(&c.frames, "frames", 0, "Run the ROM headlessly for this many frames first, to find computed jump targets")
+	flag.StringVar(&c.keys, "keys", "", "Scripted key events for -frames, e.g. 30:5:down,40:5:up or 30:5:tap")
+	flag.StringVar(&c.outPath, "o", "", "Output file (default stdout)")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Println("Usage: chip8-cfg [options] <rom-file>")
+		fmt.Println()
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	c.romPath = flag.Arg(0)
+
+	if err := run(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run builds the graph and writes it
+func run(c config) error {
+	mode, err := chip8.ParseMode(c.mode)
+	if err != nil {
+		return err
+	}
+	if c.format != "dot" && c.format != "calls" && c.format != "json" {
+		return fmt.Errorf("unknown output format %q (valid: dot, calls, json)", c.format)
+	}
+	romData, err := os.ReadFile(c.romPath)
+	if err != nil {
+		return err
+	}
+
+	jumps := make(cfg.Jumps)
+	if c.frames > 0 {
+		if jumps, err = record(romData, mode, c); err != nil {
+			return err
+		}
+	}
+	g, err := cfg.Build(romData, mode, jumps)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if c.outPath != "" {
+		f, err := os.Create(c.outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	switch c.format {
+	case "dot":
+		err = g.WriteDOT(out)
+	case "calls":
+		err = g.WriteCallDOT(out)
+	case "json":
+		err = g.WriteJSON(out)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, b := range g.Sorted() {
+		if b.Indirect && len(jumps[b.Last().Addr]) == 0 {
+			fmt.Fprintf(os.Stderr, "Warning: indirect jump at %03X has no known targets; run it with -frames\n", b.Last().Addr)
+		}
+	}
+	return nil
+}
+
+// record runs the ROM headlessly and returns the computed jumps it made
+func record(romData []byte, mode chip8.Mode, c config) (cfg.Jumps, error) {
+	keys, err := headless.ParseKeys(c.keys)
+	if err != nil {
+		return nil, err
+	}
+	vm := chip8.NewWithMode(mode)
+	if err := vm.LoadROM(romData); err != nil {
+		return nil, err
+	}
+	rec := cfg.NewRecorder(vm)
+	if _, err := headless.Run(vm, headless.Options{Frames: c.frames, Keys: keys}); err != nil {
+		// The jumps made before the error are still worth merging
+		fmt.Fprintf(os.Stderr, "Warning: run stopped early: %v\n", err)
+	}
+	return rec.Jumps(), nil
+}
@@ -0,0 +1,119 @@
+// Command chip8-disasm disassembles a CHIP-8 ROM into plain mnemonics or
+// Octo source. With -recursive it follows the program's control flow to
+// separate code from data.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/disasm"
+)
+
+// config holds the command line options
+type config struct {
+	romPath   string
+	mode      string
+	syntax    string
+	recursive bool
+	entries   string
+	outPath   string
+}
+
+func main() {
+	// Parse command line arguments
+	var cfg config
+	flag.StringVar(&cfg.mode, "mode", "chip8", "Instruction set (chip8, schip, xochip)")
+	flag.StringVar(&cfg.syntax, "syntax", "plain", "Output syntax (plain, octo)")
+	flag.BoolVar(&cfg.recursive, "recursive", false, "Follow jumps and calls from the entry point to separate code from data")
+	flag.StringVar(&cfg.entries, "entry", "", "Extra hex entry points for -recursive, e.g. 2A0,2C4")
+	flag.StringVar(&cfg.outPath, "o", "", "Output file (default stdout)")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Println("Usage: chip8-disasm [options] <rom-file>")
+		fmt.Println()
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	cfg.romPath = flag.Arg(0)
+
+	if err := run(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run disassembles the ROM and writes the listing
+func run(cfg config) error {
+	mode, err := chip8.ParseMode(cfg.mode)
+	if err != nil {
+		return err
+	}
+	syntax, err := disasm.ParseSyntax(cfg.syntax)
+	if err != nil {
+		return err
+	}
+	entries, err := parseEntries(cfg.entries)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 && !cfg.recursive {
+		return fmt.Errorf("-entry needs -recursive")
+	}
+
+	romData, err := os.ReadFile(cfg.romPath)
+	if err != nil {
+		return err
+	}
+
+	var p *disasm.Program
+	if cfg.recursive {
+		p, err = disasm.Analyze(romData, mode, entries...)
+	} else {
+		p, err = disasm.Linear(romData, mode)
+	}
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if cfg.outPath != "" {
+		f, err := os.Create(cfg.outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := p.Write(out, syntax); err != nil {
+		return err
+	}
+
+	for _, addr := range p.Indirect {
+		fmt.Fprintf(os.Stderr, "Warning: indirect jump at %03X not followed; add its targets with -entry\n", addr)
+	}
+	return nil
+}
+
+// parseEntries parses a comma separated list of hex addresses
+func parseEntries(s string) ([]uint16, error) {
+	var entries []uint16
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		addr, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(field), "0x"), 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid entry point %q", field)
+		}
+		entries = append(entries, uint16(addr))
+	}
+	return entries, nil
+}
@@ -0,0 +1,147 @@
+// Command chip8-headless runs a CHIP-8 ROM or Octo source file without a
+// window or audio device and writes the final display as a PNG, ASCII art
+// or a hash. It exits non-zero if emulation fails, so it can be used in CI.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/chip8-emulator/headless"
+	"github.com/chip8-emulator/internal/cli"
+	"github.com/chip8-emulator/profile"
+	"github.com/chip8-emulator/trace"
+)
+
+// config holds the command line options
+type config struct {
+	romPath string
+	frames  int
+	cycles  int
+	ipf     int
+	keys    string
+	mode    string
+	quirks  string
+	memory  string
+	timing  string
+	seed    uint64
+	format  string
+	outPath string
+	scale   int
+	trace   string
+	profile string
+}
+
+func main() {
+	// Parse command line arguments
+	var cfg config
+	flag.IntVar(&cfg.frames, "frames", 0, "Number of 60 Hz frames to run (0 for no limit)")
+	flag.IntVar(&cfg.cycles, "cycles", 0, "Number of instructions to run (0 for no limit)")
+	flag.IntVar(&cfg.ipf, "ipf", headless.DefaultInstructionsPerFrame, "Instructions per frame")
+	flag.StringVar(&cfg.keys, "keys", "", "Scripted key events, e.g. 30:5:down,40:5:up or 30:5:tap")
+	flag.StringVar(&cfg.mode, "mode", "chip8", "Instruction set (chip8, schip, xochip)")
+	flag.StringVar(&cfg.quirks, "quirks", "", "Quirks preset (default, vip, schip, xochip); defaults to the mode's preset")
+	flag.StringVar(&cfg.memory, "memory", "wrap", "Out-of-range memory access policy (wrap, fault, mask)")
+	flag.StringVar(&cfg.timing, "timing", "none", "Timing model (none, vip); vip ignores -ipf")
+	flag.Uint64Var(&cfg.seed, "seed", 0, "Random seed for CXNN")
+	flag.StringVar(&cfg.format, "format", "hash", "Output format (png, ascii, hash)")
+	flag.StringVar(&cfg.outPath, "o", "", "Output file (default stdout)")
+	flag.IntVar(&cfg.scale, "scale", 1, "PNG scale factor")
+	flag.StringVar(&cfg.trace, "trace", "", "Write a per-instruction execution trace to this file")
+	flag.StringVar(&cfg.profile, "profile", "", "Write a hot spot report and coverage listing to this file")
+	flag.Parse()
+
+	if flag.NArg() != 1 || (cfg.frames <= 0 && cfg.cycles <= 0) {
+		fmt.Println("Usage: chip8-headless -frames N | -cycles N [options] <rom-file>")
+		fmt.Println()
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	cfg.romPath = flag.Arg(0)
+
+	if err := run(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run loads and runs the ROM and writes the final display
+func run(cfg config) error {
+	keys, err := headless.ParseKeys(cfg.keys)
+	if err != nil {
+		return err
+	}
+	if cfg.format != "png" && cfg.format != "ascii" && cfg.format != "hash" {
+		return fmt.Errorf("unknown output format %q (valid: png, ascii, hash)", cfg.format)
+	}
+
+	vm, romData, syms, err := cli.NewVM(cfg.romPath, cli.VMOptions{
+		Mode:   cfg.mode,
+		Quirks: cfg.quirks,
+		Memory: cfg.memory,
+		Timing: cfg.timing,
+		Seed:   cfg.seed,
+	})
+	if err != nil {
+		return err
+	}
+
+	var tracer *trace.Writer
+	if cfg.trace != "" {
+		f, err := os.Create(cfg.trace)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		tracer = trace.NewWriter(vm, f)
+	}
+
+	var prof *profile.Profiler
+	if cfg.profile != "" {
+		prof = profile.New(vm)
+	}
+
+	res, runErr := headless.Run(vm, headless.Options{
+		Frames:               cfg.frames,
+		Cycles:               cfg.cycles,
+		InstructionsPerFrame: cfg.ipf,
+		Keys:                 keys,
+	})
+	fmt.Fprintf(os.Stderr, "Ran %d frames, %d instructions\n", res.Frames, res.Cycles)
+	if tracer != nil {
+		if err := tracer.Flush(); err != nil {
+			return fmt.Errorf("writing trace: %w", err)
+		}
+	}
+	if prof != nil {
+		if err := cli.WriteProfile(cfg.profile, prof, romData, vm.Mode, syms); err != nil {
+			return fmt.Errorf("writing profile: %w", err)
+		}
+	}
+
+	// Write the final display even if emulation failed, to help debugging
+	var out io.Writer = os.Stdout
+	if cfg.outPath != "" {
+		f, err := os.Create(cfg.outPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	switch cfg.format {
+	case "png":
+		err = headless.WritePNG(out, vm, cfg.scale)
+	case "ascii":
+		_, err = io.WriteString(out, headless.ASCII(vm))
+	case "hash":
+		_, err = fmt.Fprintln(out, headless.Hash(vm))
+	}
+	if runErr != nil {
+		return fmt.Errorf("emulation failed: %w", runErr)
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+// Command chip8-tracediff compares two execution traces written with -trace,
+// or converted from another emulator, and reports where they first diverge.
+// It exits 0 if the traces match and 1 if they diverge.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/chip8-emulator/trace"
+)
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 2 {
+		fmt.Println("Usage: chip8-tracediff <trace-a> <trace-b>")
+		os.Exit(2)
+	}
+
+	diverged, err := run(flag.Arg(0), flag.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if diverged {
+		os.Exit(1)
+	}
+}
+
+// run diffs the traces and prints the result
+func run(pathA, pathB string) (bool, error) {
+	a, err := os.Open(pathA)
+	if err != nil {
+		return false, err
+	}
+	defer a.Close()
+	b, err := os.Open(pathB)
+	if err != nil {
+		return false, err
+	}
+	defer b.Close()
+
+	d, err := trace.Diff(a, b)
+	if err != nil {
+		return false, err
+	}
+	if d == nil {
+		fmt.Println("Traces match")
+		return false, nil
+	}
+	fmt.Print(d.Report(pathA, pathB))
+	return true, nil
+}
@@ -0,0 +1,115 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+// request is a request from the client
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// response answers a request
+type response struct {
+	Seq        int    `json:"seq"`
+	Type       string `json:"type"`
+	RequestSeq int    `json:"request_seq"`
+	Success    bool   `json:"success"`
+	Command    string `json:"command"`
+	Message    string `json:"message,omitempty"`
+	Body       any    `json:"body,omitempty"`
+}
+
+// event is a message sent without a request
+type event struct {
+	Seq   int    `json:"seq"`
+	Type  string `json:"type"`
+	Event string `json:"event"`
+	Body  any    `json:"body,omitempty"`
+}
+
+// conn reads and writes Content-Length framed messages
+type conn struct {
+	r *textproto.Reader
+
+	// Guards w and seq; events are sent from the emulation goroutine
+	mu  sync.Mutex
+	w   io.Writer
+	seq int
+}
+
+// newConn creates a connection over r and w
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{r: textproto.NewReader(bufio.NewReader(r)), w: w}
+}
+
+// read reads the next request
+func (c *conn) read() (*request, error) {
+	header, err := c.r.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r.R, body); err != nil {
+		return nil, err
+	}
+
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("invalid message: %v", err)
+	}
+	return &req, nil
+}
+
+// respond sends a successful response with body
+func (c *conn) respond(req *request, body any) error {
+	return c.send(&response{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+}
+
+// fail sends an error response
+func (c *conn) fail(req *request, err error) error {
+	return c.send(&response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: err.Error()})
+}
+
+// event sends an event
+func (c *conn) event(name string, body any) error {
+	return c.send(&event{Type: "event", Event: name, Body: body})
+}
+
+// send numbers and writes a response or event
+func (c *conn) send(msg any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	switch m := msg.(type) {
+	case *response:
+		m.Seq = c.seq
+	case *event:
+		m.Seq = c.seq
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err = c.w.Write(data)
+	return err
+}
@@ -0,0 +1,548 @@
+// Package dap serves the Debug Adapter Protocol (DAP) for the emulator, so
+// editors that speak DAP can launch a ROM and debug it with breakpoints by
+// address or source line, stepping, variables and memory views.
+//
+// Source line breakpoints and label names come from the ROM's symbol map
+// (see the symbols package). The emulator itself is started by a Launcher
+// supplied by the caller when the launch request arrives.
+package dap
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/debugger"
+	"github.com/chip8-emulator/disasm"
+	"github.com/chip8-emulator/symbols"
+)
+
+// threadID is the ID of the VM's only thread
+const threadID = 1
+
+// Variable references of the scopes
+const (
+	registersRef = iota + 1
+	timersRef
+	stackRef
+)
+
+// LaunchArgs are the arguments of the launch request
+type LaunchArgs struct {
+	// Program is the path of the ROM to run
+	Program string `json:"program"`
+	// Mode, Quirks, Memory and Timing override the emulator's options
+	Mode   string `json:"mode"`
+	Quirks string `json:"quirks"`
+	Memory string `json:"memory"`
+	Timing string `json:"timing"`
+	// Symbols is the symbol map path; by default the ROM's .sym file is
+	// used if it exists
+	Symbols string `json:"symbols"`
+	// StopOnEntry stops before the first instruction
+	StopOnEntry bool `json:"stopOnEntry"`
+}
+
+// Launcher starts the emulator for a launch request and returns the
+// debugger controlling it and the program's symbol map, or nil if it has
+// none. The debugger must be paused; the server resumes it once the client
+// has finished configuring breakpoints. Everything that can fail, loading
+// the symbol map included, must happen before the emulator starts, since a
+// launch can't be undone.
+type Launcher func(args LaunchArgs) (*debugger.Debugger, *symbols.Map, error)
+
+// LoadSymbols loads the symbol map of a launch request: args.Symbols, or
+// else the program's .sym file if it exists. It returns nil if there is
+// none. Relative source paths in the map are resolved against its
+// directory.
+func LoadSymbols(args LaunchArgs) (*symbols.Map, error) {
+	path := args.Symbols
+	if path == "" {
+		path = symbols.PathFor(args.Program)
+		if _, err := os.Stat(path); err != nil {
+			return nil, nil
+		}
+	}
+	syms, err := symbols.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading symbols: %w", err)
+	}
+	for i, l := range syms.Lines {
+		if !filepath.IsAbs(l.File) {
+			syms.Lines[i].File = filepath.Join(filepath.Dir(path), l.File)
+		}
+	}
+	return syms, nil
+}
+
+// Server serves one DAP client
+type Server struct {
+	c      *conn
+	launch Launcher
+
+	d           *debugger.Debugger
+	syms        *symbols.Map
+	stopOnEntry bool
+
+	// Debugger breakpoint IDs by source path, and of instruction
+	// breakpoints. Stops are reported from the emulation goroutine, so
+	// bpMu guards sourceBreakpoints, which reportStop reads.
+	bpMu                   sync.Mutex
+	sourceBreakpoints      map[string][]int
+	instructionBreakpoints []int
+}
+
+// NewServer creates a server that reads requests from r and writes
+// responses and events to w
+func NewServer(r io.Reader, w io.Writer, launch Launcher) *Server {
+	return &Server{
+		c:                 newConn(r, w),
+		launch:            launch,
+		syms:              &symbols.Map{},
+		sourceBreakpoints: make(map[string][]int),
+	}
+}
+
+// Serve handles requests until the client disconnects
+func (s *Server) Serve() error {
+	for {
+		req, err := s.c.read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		done, err := s.handle(req)
+		if err != nil {
+			if err := s.c.fail(req, err); err != nil {
+				return err
+			}
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// handle dispatches one request. It returns true when the session is over.
+func (s *Server) handle(req *request) (bool, error) {
+	if s.d == nil {
+		switch req.Command {
+		case "initialize", "launch", "disconnect", "terminate":
+		default:
+			return false, fmt.Errorf("%s before launch", req.Command)
+		}
+	}
+
+	switch req.Command {
+	case "initialize":
+		return false, s.c.respond(req, map[string]any{
+			"supportsConfigurationDoneRequest": true,
+			"supportsInstructionBreakpoints":   true,
+			"supportsReadMemoryRequest":        true,
+			"supportsDisassembleRequest":       true,
+			"supportsTerminateRequest":         true,
+		})
+	case "launch":
+		return false, s.launchProgram(req)
+	case "setBreakpoints":
+		return false, s.setBreakpoints(req)
+	case "setInstructionBreakpoints":
+		return false, s.setInstructionBreakpoints(req)
+	case "setExceptionBreakpoints":
+		return false, s.c.respond(req, map[string]any{"breakpoints": []any{}})
+	case "configurationDone":
+		if s.stopOnEntry {
+			if err := s.c.respond(req, nil); err != nil {
+				return false, err
+			}
+			return false, s.c.event("stopped", stoppedBody("entry", ""))
+		}
+		s.d.Continue()
+		return false, s.c.respond(req, nil)
+	case "threads":
+		return false, s.c.respond(req, map[string]any{
+			"threads": []any{map[string]any{"id": threadID, "name": "CHIP-8"}},
+		})
+	case "stackTrace":
+		return false, s.stackTrace(req)
+	case "scopes":
+		return false, s.c.respond(req, map[string]any{"scopes": []any{
+			map[string]any{"name": "Registers", "variablesReference": registersRef, "expensive": false},
+			map[string]any{"name": "Timers", "variablesReference": timersRef, "expensive": false},
+			map[string]any{"name": "Stack", "variablesReference": stackRef, "expensive": false},
+		}})
+	case "variables":
+		return false, s.variables(req)
+	case "continue":
+		s.d.Continue()
+		return false, s.c.respond(req, map[string]any{"allThreadsContinued": true})
+	case "next", "stepIn", "stepOut", "pause":
+		// Respond first: stepping stops synchronously and sends its
+		// stopped event right away
+		if err := s.c.respond(req, nil); err != nil {
+			return false, err
+		}
+		switch req.Command {
+		case "next":
+			s.d.StepOver()
+		case "stepIn":
+			s.d.Step()
+		case "stepOut":
+			s.d.StepOut()
+		case "pause":
+			s.d.Pause()
+		}
+		return false, nil
+	case "readMemory":
+		return false, s.readMemory(req)
+	case "disassemble":
+		return false, s.disassemble(req)
+	case "disconnect", "terminate":
+		if err := s.c.respond(req, nil); err != nil {
+			return true, err
+		}
+		if req.Command == "terminate" {
+			s.c.event("terminated", nil)
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("unsupported request %q", req.Command)
+	}
+}
+
+func (s *Server) launchProgram(req *request) error {
+	var args LaunchArgs
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return err
+	}
+	if args.Program == "" {
+		return errors.New("launch: program is required")
+	}
+
+	d, syms, err := s.launch(args)
+	if err != nil {
+		return err
+	}
+	if syms != nil {
+		s.syms = syms
+	}
+	s.d = d
+	s.stopOnEntry = args.StopOnEntry
+	d.SetStopHandler(s.reportStop)
+
+	if err := s.c.respond(req, nil); err != nil {
+		return err
+	}
+	return s.c.event("initialized", nil)
+}
+
+// reportStop sends the events for a debugger stop
+func (s *Server) reportStop(stop debugger.Stop) {
+	switch stop.Reason {
+	case debugger.ReasonHalted:
+		s.c.event("exited", map[string]any{"exitCode": 0})
+		s.c.event("terminated", nil)
+	case debugger.ReasonError:
+		s.c.event("output", map[string]any{"category": "stderr", "output": stop.Err.Error() + "\n"})
+		s.c.event("stopped", stoppedBody("exception", stop.Err.Error()))
+	case debugger.ReasonBreakpoint:
+		reason := "instruction breakpoint"
+		if s.isSourceBreakpoint(stop.Breakpoint) {
+			reason = "breakpoint"
+		}
+		s.c.event("stopped", stoppedBody(reason, stop.String()))
+	case debugger.ReasonWatchpoint:
+		s.c.event("stopped", stoppedBody("data breakpoint", stop.String()))
+	case debugger.ReasonPause:
+		s.c.event("stopped", stoppedBody("pause", ""))
+	default:
+		s.c.event("stopped", stoppedBody("step", ""))
+	}
+}
+
+// stoppedBody returns the body of a stopped event
+func stoppedBody(reason, description string) map[string]any {
+	body := map[string]any{"reason": reason, "threadId": threadID, "allThreadsStopped": true}
+	if description != "" {
+		body["description"] = description
+	}
+	return body
+}
+
+// isSourceBreakpoint returns true if id was set by setBreakpoints
+func (s *Server) isSourceBreakpoint(id int) bool {
+	s.bpMu.Lock()
+	defer s.bpMu.Unlock()
+	for _, ids := range s.sourceBreakpoints {
+		for _, bp := range ids {
+			if bp == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Server) setBreakpoints(req *request) error {
+	var args struct {
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return err
+	}
+
+	// The lock is held while the debugger's breakpoints change, so a stop
+	// at a new breakpoint is reported once it is recorded. The debugger
+	// doesn't hold its own lock when it reports a stop.
+	s.bpMu.Lock()
+	defer s.bpMu.Unlock()
+	path := args.Source.Path
+	for _, id := range s.sourceBreakpoints[path] {
+		s.d.RemoveBreakpoint(id)
+	}
+	s.sourceBreakpoints[path] = nil
+
+	result := make([]any, 0, len(args.Breakpoints))
+	for _, bp := range args.Breakpoints {
+		addrs := s.syms.AddrsAt(path, bp.Line)
+		if len(addrs) == 0 {
+			result = append(result, map[string]any{
+				"verified": false,
+				"line":     bp.Line,
+				"message":  "no code at this line",
+			})
+			continue
+		}
+		for _, addr := range addrs {
+			s.sourceBreakpoints[path] = append(s.sourceBreakpoints[path], s.d.AddBreakpoint(addr))
+		}
+		result = append(result, map[string]any{
+			"verified":             true,
+			"line":                 bp.Line,
+			"instructionReference": formatAddr(addrs[0]),
+		})
+	}
+	return s.c.respond(req, map[string]any{"breakpoints": result})
+}
+
+func (s *Server) setInstructionBreakpoints(req *request) error {
+	var args struct {
+		Breakpoints []struct {
+			InstructionReference string `json:"instructionReference"`
+			Offset               int    `json:"offset"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return err
+	}
+
+	for _, id := range s.instructionBreakpoints {
+		s.d.RemoveBreakpoint(id)
+	}
+	s.instructionBreakpoints = nil
+
+	result := make([]any, 0, len(args.Breakpoints))
+	for _, bp := range args.Breakpoints {
+		addr, err := parseAddr(bp.InstructionReference)
+		if err != nil {
+			result = append(result, map[string]any{"verified": false, "message": err.Error()})
+			continue
+		}
+		addr += uint16(bp.Offset)
+		s.instructionBreakpoints = append(s.instructionBreakpoints, s.d.AddBreakpoint(addr))
+		result = append(result, map[string]any{"verified": true, "instructionReference": formatAddr(addr)})
+	}
+	return s.c.respond(req, map[string]any{"breakpoints": result})
+}
+
+func (s *Server) stackTrace(req *request) error {
+	// Frame 0 is the current instruction; the others are the call sites
+	// of the return addresses on the stack, innermost first
+	var addrs []uint16
+	s.d.Do(func(vm *chip8.CHIP8) {
+		addrs = append(addrs, vm.PC)
+		for i := int(vm.SP) - 1; i >= 0 && i < chip8.StackSize; i-- {
+			addrs = append(addrs, vm.Stack[i]-2)
+		}
+	})
+
+	frames := make([]any, len(addrs))
+	for i, addr := range addrs {
+		frame := map[string]any{
+			"id":                          i,
+			"name":                        s.frameName(addr),
+			"line":                        0,
+			"column":                      0,
+			"instructionPointerReference": formatAddr(addr),
+		}
+		if line, ok := s.syms.LineAt(addr); ok {
+			frame["source"] = map[string]any{"name": filepath.Base(line.File), "path": line.File}
+			frame["line"] = line.Line
+			frame["column"] = 1
+		}
+		frames[i] = frame
+	}
+	return s.c.respond(req, map[string]any{"stackFrames": frames, "totalFrames": len(frames)})
+}
+
+// frameName names the routine containing addr
+func (s *Server) frameName(addr uint16) string {
+	if name, offset, ok := s.syms.Nearest(addr); ok {
+		if offset == 0 {
+			return name
+		}
+		return fmt.Sprintf("%s+%d", name, offset)
+	}
+	return formatAddr(addr)
+}
+
+func (s *Server) variables(req *request) error {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return err
+	}
+
+	variable := func(name, value string) map[string]any {
+		return map[string]any{"name": name, "value": value, "variablesReference": 0}
+	}
+
+	var vars []any
+	s.d.Do(func(vm *chip8.CHIP8) {
+		switch args.VariablesReference {
+		case registersRef:
+			for i, v := range vm.V {
+				vars = append(vars, variable(fmt.Sprintf("V%X", i), fmt.Sprintf("0x%02X (%d)", v, v)))
+			}
+			i := variable("I", formatAddr(vm.I))
+			i["memoryReference"] = formatAddr(vm.I)
+			vars = append(vars, i, variable("PC", formatAddr(vm.PC)), variable("SP", strconv.Itoa(int(vm.SP))))
+		case timersRef:
+			vars = append(vars,
+				variable("DT", strconv.Itoa(int(vm.DelayTimer))),
+				variable("ST", strconv.Itoa(int(vm.SoundTimer))))
+		case stackRef:
+			for i := 0; i < int(vm.SP) && i < chip8.StackSize; i++ {
+				vars = append(vars, variable(strconv.Itoa(i), formatAddr(vm.Stack[i])))
+			}
+		}
+	})
+	if vars == nil {
+		vars = []any{}
+	}
+	return s.c.respond(req, map[string]any{"variables": vars})
+}
+
+func (s *Server) readMemory(req *request) error {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Count           int    `json:"count"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return err
+	}
+	base, err := parseAddr(args.MemoryReference)
+	if err != nil {
+		return err
+	}
+
+	// The count comes from the client, so only the part inside the address
+	// space is read and the rest is reported as unreadable
+	start := int(base) + args.Offset
+	var data []byte
+	unreadable := max(args.Count, 0)
+	s.d.Do(func(vm *chip8.CHIP8) {
+		size := vm.AddressSpace()
+		if start < 0 || start >= size {
+			return
+		}
+		n := min(unreadable, size-start)
+		data = append(data, vm.Memory[start:start+n]...)
+		unreadable -= n
+	})
+	return s.c.respond(req, map[string]any{
+		"address":         formatAddr(uint16(start)),
+		"data":            base64.StdEncoding.EncodeToString(data),
+		"unreadableBytes": unreadable,
+	})
+}
+
+func (s *Server) disassemble(req *request) error {
+	var args struct {
+		MemoryReference   string `json:"memoryReference"`
+		Offset            int    `json:"offset"`
+		InstructionOffset int    `json:"instructionOffset"`
+		InstructionCount  int    `json:"instructionCount"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return err
+	}
+	base, err := parseAddr(args.MemoryReference)
+	if err != nil {
+		return err
+	}
+
+	// Instructions are assumed to be 2 bytes when counting back
+	addr := uint16(int(base) + args.Offset + 2*args.InstructionOffset)
+	var result []any
+	s.d.Do(func(vm *chip8.CHIP8) {
+		mem := vm.Memory[:vm.AddressSpace()]
+		// The count comes from the client; past one pass over the address
+		// space the listing would only repeat
+		count := min(args.InstructionCount, len(mem)/2)
+		for i := 0; i < count; i++ {
+			in := disasm.Decode(mem, addr, vm.Mode)
+			var raw []string
+			for b := 0; b < in.Size; b++ {
+				raw = append(raw, fmt.Sprintf("%02X", mem[(int(addr)+b)&(len(mem)-1)]))
+			}
+			entry := map[string]any{
+				"address":          formatAddr(addr),
+				"instructionBytes": strings.Join(raw, " "),
+				"instruction":      in.String(),
+			}
+			if name, ok := s.syms.Label(addr); ok {
+				entry["symbol"] = name
+			}
+			if line, ok := s.syms.LineAt(addr); ok {
+				entry["location"] = map[string]any{"name": filepath.Base(line.File), "path": line.File}
+				entry["line"] = line.Line
+			}
+			result = append(result, entry)
+			addr += uint16(in.Size)
+		}
+	})
+	return s.c.respond(req, map[string]any{"instructions": result})
+}
+
+// formatAddr formats an address as a DAP memory reference
+func formatAddr(addr uint16) string {
+	return fmt.Sprintf("0x%03X", addr)
+}
+
+// parseAddr parses a memory reference: hex with an optional 0x prefix
+func parseAddr(s string) (uint16, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %q", s)
+	}
+	return uint16(v), nil
+}
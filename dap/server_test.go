@@ -0,0 +1,380 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/debugger"
+	"github.com/chip8-emulator/symbols"
+)
+
+// message is a response or event received by the test client
+type message struct {
+	Type    string          `json:"type"`
+	Command string          `json:"command"`
+	Event   string          `json:"event"`
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Body    json.RawMessage `json:"body"`
+}
+
+// client is a minimal DAP client for tests. Messages are read on their own
+// goroutine, since stopped events are written while the test runs frames.
+type client struct {
+	t    *testing.T
+	w    io.Writer
+	msgs chan message
+	seq  int
+}
+
+// newClient starts reading messages from r
+func newClient(t *testing.T, r io.Reader, w io.Writer) *client {
+	c := &client{t: t, w: w, msgs: make(chan message, 64)}
+	go func() {
+		defer close(c.msgs)
+		tr := textproto.NewReader(bufio.NewReader(r))
+		for {
+			header, err := tr.ReadMIMEHeader()
+			if err != nil {
+				return
+			}
+			length, _ := strconv.Atoi(header.Get("Content-Length"))
+			data := make([]byte, length)
+			if _, err := io.ReadFull(tr.R, data); err != nil {
+				return
+			}
+			var m message
+			if err := json.Unmarshal(data, &m); err != nil {
+				return
+			}
+			c.msgs <- m
+		}
+	}()
+	return c
+}
+
+// send sends a request
+func (c *client) send(command string, args any) {
+	c.t.Helper()
+	c.seq++
+	data, err := json.Marshal(map[string]any{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		c.t.Fatalf("write %s: %v", command, err)
+	}
+}
+
+// recv reads the next message
+func (c *client) recv() message {
+	c.t.Helper()
+	m, ok := <-c.msgs
+	if !ok {
+		c.t.Fatal("connection closed")
+	}
+	return m
+}
+
+// expect reads messages until a response to command or an event named
+// command, and decodes its body into body
+func (c *client) expect(kind, name string, body any) message {
+	c.t.Helper()
+	for {
+		m := c.recv()
+		if m.Type != kind || (m.Command != name && m.Event != name) {
+			continue
+		}
+		if kind == "response" && !m.Success {
+			c.t.Fatalf("%s failed: %s", name, m.Message)
+		}
+		if body != nil {
+			if err := json.Unmarshal(m.Body, body); err != nil {
+				c.t.Fatalf("invalid %s body %s: %v", name, m.Body, err)
+			}
+		}
+		return m
+	}
+}
+
+// call sends a request and waits for its response
+func (c *client) call(command string, args, body any) message {
+	c.t.Helper()
+	c.send(command, args)
+	return c.expect("response", command, body)
+}
+
+// runUntil runs frames until the debugger stops for reason. The server
+// handles requests on its own goroutine, so this gives it time to resume
+// or interrupt execution.
+func runUntil(t *testing.T, d *debugger.Debugger, reason debugger.Reason) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if d.LastStop().Reason == reason {
+			return
+		}
+		d.RunFrame(10)
+		time.Sleep(100 * time.Microsecond)
+	}
+	t.Fatalf("Debugger did not stop with %v, last stop %v", reason, d.LastStop())
+}
+
+// writeROM writes code and its symbol map to game.ch8 and game.sym in dir
+// and returns the ROM's path
+func writeROM(t *testing.T, dir string, code []byte, syms *symbols.Map) string {
+	t.Helper()
+	rom := filepath.Join(dir, "game.ch8")
+	if err := os.WriteFile(rom, code, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syms.Save(symbols.PathFor(rom)); err != nil {
+		t.Fatal(err)
+	}
+	return rom
+}
+
+// launcher returns a Launcher that loads the program into a new VM and
+// stores its debugger in d
+func launcher(d **debugger.Debugger) Launcher {
+	return func(args LaunchArgs) (*debugger.Debugger, *symbols.Map, error) {
+		syms, err := LoadSymbols(args)
+		if err != nil {
+			return nil, nil, err
+		}
+		vm := chip8.New()
+		data, err := os.ReadFile(args.Program)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := vm.LoadROM(data); err != nil {
+			return nil, nil, err
+		}
+		*d = debugger.New(vm)
+		(*d).Pause()
+		return *d, syms, nil
+	}
+}
+
+func TestSession(t *testing.T) {
+	dir := t.TempDir()
+	// 200: LD V0, 1; 202: CALL 208; 204: JP 204; 206: padding; 208: ADD V0, 1; 20A: RET
+	rom := writeROM(t, dir, []byte{0x60, 0x01, 0x22, 0x08, 0x12, 0x04, 0x00, 0x00, 0x70, 0x01, 0x00, 0xEE}, &symbols.Map{
+		Labels: []symbols.Label{{Name: "main", Addr: 0x200}, {Name: "inc", Addr: 0x208}},
+		Lines:  []symbols.Line{{File: "game.8o", Line: 1, Addr: 0x200}, {File: "game.8o", Line: 5, Addr: 0x208}},
+	})
+
+	var d *debugger.Debugger
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	s := NewServer(reqR, respW, launcher(&d))
+	done := make(chan error)
+	go func() { done <- s.Serve() }()
+	c := newClient(t, respR, reqW)
+
+	var caps map[string]bool
+	c.call("initialize", map[string]any{"adapterID": "chip8"}, &caps)
+	if !caps["supportsInstructionBreakpoints"] {
+		t.Errorf("Expected instruction breakpoint support, got %v", caps)
+	}
+
+	c.send("launch", LaunchArgs{Program: rom})
+	c.expect("response", "launch", nil)
+	c.expect("event", "initialized", nil)
+
+	var bps struct {
+		Breakpoints []struct {
+			Verified bool `json:"verified"`
+			Line     int  `json:"line"`
+		} `json:"breakpoints"`
+	}
+	c.call("setBreakpoints", map[string]any{
+		"source":      map[string]any{"path": filepath.Join(dir, "game.8o")},
+		"breakpoints": []any{map[string]any{"line": 5}, map[string]any{"line": 3}},
+	}, &bps)
+	if len(bps.Breakpoints) != 2 || !bps.Breakpoints[0].Verified || bps.Breakpoints[1].Verified {
+		t.Errorf("Expected line 5 verified and line 3 not, got %+v", bps.Breakpoints)
+	}
+
+	c.call("configurationDone", nil, nil)
+	runUntil(t, d, debugger.ReasonBreakpoint)
+	var stopped struct {
+		Reason string `json:"reason"`
+	}
+	c.expect("event", "stopped", &stopped)
+	if stopped.Reason != "breakpoint" {
+		t.Errorf("Expected breakpoint stop, got %q", stopped.Reason)
+	}
+
+	var trace struct {
+		StackFrames []struct {
+			Name   string `json:"name"`
+			Line   int    `json:"line"`
+			Source *struct {
+				Path string `json:"path"`
+			} `json:"source"`
+		} `json:"stackFrames"`
+	}
+	c.call("stackTrace", map[string]any{"threadId": threadID}, &trace)
+	if len(trace.StackFrames) != 2 {
+		t.Fatalf("Expected 2 frames, got %+v", trace.StackFrames)
+	}
+	if f := trace.StackFrames[0]; f.Name != "inc" || f.Line != 5 || f.Source == nil || f.Source.Path != filepath.Join(dir, "game.8o") {
+		t.Errorf("Unexpected top frame %+v", f)
+	}
+	if f := trace.StackFrames[1]; f.Name != "main+2" {
+		t.Errorf("Caller frame should be the call site, got %+v", f)
+	}
+
+	var vars struct {
+		Variables []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"variables"`
+	}
+	c.call("variables", map[string]any{"variablesReference": registersRef}, &vars)
+	if len(vars.Variables) < 1 || vars.Variables[0].Name != "V0" || vars.Variables[0].Value != "0x01 (1)" {
+		t.Errorf("Unexpected registers %+v", vars.Variables)
+	}
+
+	var mem struct {
+		Data string `json:"data"`
+	}
+	c.call("readMemory", map[string]any{"memoryReference": "0x200", "count": 2}, &mem)
+	if mem.Data != "YAE=" {
+		t.Errorf("readMemory = %q, want YAE=", mem.Data)
+	}
+	var tail struct {
+		Data       string `json:"data"`
+		Unreadable int    `json:"unreadableBytes"`
+	}
+	c.call("readMemory", map[string]any{"memoryReference": "0xFFE", "count": 1 << 40}, &tail)
+	if n, _ := base64.StdEncoding.DecodeString(tail.Data); len(n) != 2 || tail.Unreadable != 1<<40-2 {
+		t.Errorf("readMemory past the end read %d bytes, %d unreadable; want 2, %d", len(n), tail.Unreadable, 1<<40-2)
+	}
+
+	var dis struct {
+		Instructions []struct {
+			Instruction string `json:"instruction"`
+			Symbol      string `json:"symbol"`
+		} `json:"instructions"`
+	}
+	c.call("disassemble", map[string]any{"memoryReference": "0x208", "instructionCount": 2}, &dis)
+	if len(dis.Instructions) != 2 || dis.Instructions[0].Instruction != "ADD V0, #01" ||
+		dis.Instructions[0].Symbol != "inc" || dis.Instructions[1].Instruction != "RET" {
+		t.Errorf("Unexpected disassembly %+v", dis.Instructions)
+	}
+	c.call("disassemble", map[string]any{"memoryReference": "0x200", "instructionCount": 1 << 40}, &dis)
+	if len(dis.Instructions) != chip8.MemorySize/2 {
+		t.Errorf("Huge disassemble returned %d instructions, want %d", len(dis.Instructions), chip8.MemorySize/2)
+	}
+
+	c.call("next", map[string]any{"threadId": threadID}, nil)
+	c.expect("event", "stopped", &stopped)
+	if stopped.Reason != "step" || d.LastStop().PC != 0x20A {
+		t.Errorf("Expected step to 20A, got %q at %03X", stopped.Reason, d.LastStop().PC)
+	}
+
+	c.call("disconnect", nil, nil)
+	if err := <-done; err != nil {
+		t.Errorf("Serve failed: %v", err)
+	}
+}
+
+func TestSetBreakpointsWhileRunning(t *testing.T) {
+	dir := t.TempDir()
+	// 200: CALL 204; 202: JP 200; 204: RET
+	rom := writeROM(t, dir, []byte{0x22, 0x04, 0x12, 0x00, 0x00, 0xEE}, &symbols.Map{
+		Lines: []symbols.Line{{File: "game.8o", Line: 3, Addr: 0x204}},
+	})
+
+	var d *debugger.Debugger
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	s := NewServer(reqR, respW, launcher(&d))
+	done := make(chan error)
+	go func() { done <- s.Serve() }()
+	c := newClient(t, respR, reqW)
+
+	c.call("initialize", map[string]any{"adapterID": "chip8"}, nil)
+	c.send("launch", LaunchArgs{Program: rom})
+	c.expect("response", "launch", nil)
+	c.call("configurationDone", nil, nil)
+
+	// Run the VM, resuming from every breakpoint, while the client keeps
+	// replacing the breakpoints
+	stop := make(chan struct{})
+	running := make(chan struct{})
+	go func() {
+		defer close(running)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			d.RunFrame(10)
+			if d.Paused() {
+				d.Continue()
+			}
+		}
+	}()
+	source := map[string]any{"path": filepath.Join(dir, "game.8o")}
+	for i := 0; i < 50; i++ {
+		var lines []any
+		if i%2 == 0 {
+			lines = append(lines, map[string]any{"line": 3})
+		}
+		c.call("setBreakpoints", map[string]any{"source": source, "breakpoints": lines}, nil)
+	}
+	close(stop)
+	<-running
+
+	c.call("disconnect", nil, nil)
+	if err := <-done; err != nil {
+		t.Errorf("Serve failed: %v", err)
+	}
+}
+
+func TestRequestBeforeLaunch(t *testing.T) {
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	s := NewServer(reqR, respW, nil)
+	go s.Serve()
+	c := newClient(t, respR, reqW)
+
+	c.send("threads", nil)
+	if m := c.recv(); m.Success || m.Message == "" {
+		t.Errorf("threads before launch should fail, got %+v", m)
+	}
+	reqW.Close()
+}
+
+func TestLoadSymbols(t *testing.T) {
+	dir := t.TempDir()
+	rom := filepath.Join(dir, "game.ch8")
+
+	syms, err := LoadSymbols(LaunchArgs{Program: rom})
+	if syms != nil || err != nil {
+		t.Errorf("Expected no map without a .sym file, got %v, %v", syms, err)
+	}
+
+	if err := os.WriteFile(symbols.PathFor(rom), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSymbols(LaunchArgs{Program: rom}); err == nil {
+		t.Error("Expected a bad .sym file to fail")
+	}
+	if _, err := LoadSymbols(LaunchArgs{Program: rom, Symbols: filepath.Join(dir, "missing.sym")}); err == nil {
+		t.Error("Expected a missing explicit map to fail")
+	}
+}
@@ -0,0 +1,156 @@
+package debugger
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kind identifies what a breakpoint watches
+type Kind uint8
+
+const (
+	// BreakPC stops before the instruction at Addr executes
+	BreakPC Kind = iota
+	// BreakOpcode stops before any instruction matching Pattern executes
+	BreakOpcode
+	// WatchRead stops after an instruction reads memory in [Addr, Addr+Size)
+	WatchRead
+	// WatchWrite stops after an instruction writes memory in [Addr, Addr+Size)
+	WatchWrite
+	// WatchAccess stops after an instruction reads or writes memory in
+	// [Addr, Addr+Size)
+	WatchAccess
+	// WatchRegister stops after an instruction changes register V[Reg]
+	WatchRegister
+)
+
+// String returns a short name for the kind
+func (k Kind) String() string {
+	switch k {
+	case BreakPC:
+		return "breakpoint"
+	case BreakOpcode:
+		return "opcode breakpoint"
+	case WatchRead:
+		return "read watchpoint"
+	case WatchWrite:
+		return "write watchpoint"
+	case WatchAccess:
+		return "access watchpoint"
+	case WatchRegister:
+		return "register watchpoint"
+	default:
+		return fmt.Sprintf("Kind(%d)", uint8(k))
+	}
+}
+
+// Breakpoint is a breakpoint or watchpoint set on the debugger
+type Breakpoint struct {
+	// ID identifies the breakpoint for removal
+	ID int
+	// Kind selects what is watched
+	Kind Kind
+	// Addr is the PC (BreakPC) or first watched memory address
+	Addr uint32
+	// Size is the number of watched memory bytes
+	Size uint32
+	// Pattern is the opcode pattern (BreakOpcode)
+	Pattern OpcodePattern
+	// Reg is the watched register (WatchRegister)
+	Reg uint8
+	// Enabled breakpoints stop execution; disabled ones are kept but ignored
+	Enabled bool
+	// Hits counts how many times the breakpoint has stopped execution
+	Hits int
+}
+
+// String describes the breakpoint
+func (b Breakpoint) String() string {
+	var what string
+	switch b.Kind {
+	case BreakPC:
+		what = fmt.Sprintf("at %03X", b.Addr)
+	case BreakOpcode:
+		what = "on " + b.Pattern.String()
+	case WatchRegister:
+		what = fmt.Sprintf("on V%X", b.Reg)
+	default:
+		what = fmt.Sprintf("on %03X", b.Addr)
+		if b.Size > 1 {
+			what += fmt.Sprintf("-%03X", b.Addr+b.Size-1)
+		}
+	}
+	s := fmt.Sprintf("#%d %s %s", b.ID, b.Kind, what)
+	if !b.Enabled {
+		s += " (disabled)"
+	}
+	return s
+}
+
+// matchesMemory returns true if the watchpoint covers addr for the access
+func (b *Breakpoint) matchesMemory(addr uint32, write bool) bool {
+	switch b.Kind {
+	case WatchRead:
+		if write {
+			return false
+		}
+	case WatchWrite:
+		if !write {
+			return false
+		}
+	case WatchAccess:
+	default:
+		return false
+	}
+	return addr >= b.Addr && addr-b.Addr < b.Size
+}
+
+// OpcodePattern matches opcodes whose bits under Mask equal Value
+type OpcodePattern struct {
+	Value uint16
+	Mask  uint16
+}
+
+// ParseOpcodePattern parses a four character pattern in which hex digits
+// must match and any other character is a wildcard, e.g. "D???", "8XY6"
+// or "F.55". Note that B, C, D, E and F are hex digits, not wildcards.
+func ParseOpcodePattern(s string) (OpcodePattern, error) {
+	if len(s) != 4 {
+		return OpcodePattern{}, fmt.Errorf("opcode pattern %q must be 4 characters", s)
+	}
+
+	var p OpcodePattern
+	for i, ch := range strings.ToUpper(s) {
+		shift := uint(12 - 4*i)
+		var digit uint16
+		switch {
+		case ch >= '0' && ch <= '9':
+			digit = uint16(ch - '0')
+		case ch >= 'A' && ch <= 'F':
+			digit = uint16(ch-'A') + 10
+		default:
+			continue
+		}
+		p.Value |= digit << shift
+		p.Mask |= 0xF << shift
+	}
+	return p, nil
+}
+
+// Match returns true if opcode matches the pattern
+func (p OpcodePattern) Match(opcode uint16) bool {
+	return opcode&p.Mask == p.Value
+}
+
+// String returns the pattern with wildcards shown as '?'
+func (p OpcodePattern) String() string {
+	var sb strings.Builder
+	for shift := 12; shift >= 0; shift -= 4 {
+		if p.Mask>>shift&0xF == 0 {
+			sb.WriteByte('?')
+		} else {
+			fmt.Fprintf(&sb, "%X", p.Value>>shift&0xF)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,544 @@
+// Package debugger adds breakpoints, watchpoints and stepping on top of a
+// chip8.CHIP8. It is the core shared by the debugger frontends.
+//
+// The frontend keeps driving the VM one frame at a time, calling
+// Debugger.RunFrame instead of CHIP8.RunFrame. While the debugger is paused
+// RunFrame does nothing, so the frontend can keep rendering the stopped
+// frame. Commands such as Continue and StepOver resume execution and return
+// immediately; the next stop is reported to the stop handler and by
+// LastStop. All methods are safe to call from multiple goroutines; other
+// access to the VM, including CHIP8.SetKey, should go through Do.
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/chip8-emulator/chip8"
+)
+
+// Reason says why execution stopped
+type Reason uint8
+
+const (
+	// ReasonNone means execution has not stopped
+	ReasonNone Reason = iota
+	// ReasonPause means Pause was called
+	ReasonPause
+	// ReasonStep means a step, step over or step out completed
+	ReasonStep
+	// ReasonBreakpoint means a PC or opcode breakpoint was reached
+	ReasonBreakpoint
+	// ReasonWatchpoint means a memory or register watchpoint was triggered
+	ReasonWatchpoint
+	// ReasonCursor means RunToCursor reached its address
+	ReasonCursor
+	// ReasonHalted means the program exited
+	ReasonHalted
+	// ReasonError means an instruction failed
+	ReasonError
+)
+
+// String returns a short name for the reason
+func (r Reason) String() string {
+	switch r {
+	case ReasonNone:
+		return "running"
+	case ReasonPause:
+		return "paused"
+	case ReasonStep:
+		return "step"
+	case ReasonBreakpoint:
+		return "breakpoint"
+	case ReasonWatchpoint:
+		return "watchpoint"
+	case ReasonCursor:
+		return "cursor"
+	case ReasonHalted:
+		return "halted"
+	case ReasonError:
+		return "error"
+	default:
+		return fmt.Sprintf("Reason(%d)", uint8(r))
+	}
+}
+
+// Stop describes where and why execution stopped
+type Stop struct {
+	// Reason says why execution stopped
+	Reason Reason
+	// PC is the address of the next instruction to execute
+	PC uint16
+	// Breakpoint is the ID of the breakpoint or watchpoint that was hit
+	Breakpoint int
+	// Kind is the kind of that breakpoint
+	Kind Kind
+	// InstrPC is the address of the instruction that triggered a
+	// watchpoint
+	InstrPC uint16
+	// Addr is the memory address (or register number) that triggered a
+	// watchpoint
+	Addr uint32
+	// Old and New are the values before and after a watched write; both
+	// hold the value read for read watchpoints
+	Old, New uint8
+	// Err is the emulation error for ReasonError
+	Err error
+}
+
+// String describes the stop
+func (s Stop) String() string {
+	switch s.Reason {
+	case ReasonBreakpoint:
+		return fmt.Sprintf("breakpoint #%d at %03X", s.Breakpoint, s.PC)
+	case ReasonWatchpoint:
+		if s.Kind == WatchRegister {
+			return fmt.Sprintf("watchpoint #%d: V%X %02X -> %02X by %03X", s.Breakpoint, s.Addr, s.Old, s.New, s.InstrPC)
+		}
+		if s.Kind == WatchRead || s.Old == s.New {
+			return fmt.Sprintf("watchpoint #%d: %03X = %02X by %03X", s.Breakpoint, s.Addr, s.New, s.InstrPC)
+		}
+		return fmt.Sprintf("watchpoint #%d: %03X %02X -> %02X by %03X", s.Breakpoint, s.Addr, s.Old, s.New, s.InstrPC)
+	case ReasonError:
+		return fmt.Sprintf("error at %03X: %v", s.PC, s.Err)
+	default:
+		return fmt.Sprintf("%s at %03X", s.Reason, s.PC)
+	}
+}
+
+// ErrNoBreakpoint is returned when a breakpoint ID doesn't exist
+var ErrNoBreakpoint = errors.New("no such breakpoint")
+
+// targetKind selects the condition a resumed run stops at
+type targetKind uint8
+
+const (
+	targetNone targetKind = iota
+	// Stop when PC reaches addr with the stack at depth sp (step over)
+	targetReturn
+	// Stop once the stack is shallower than sp (step out)
+	targetOut
+	// Stop when PC reaches addr (run to cursor)
+	targetCursor
+)
+
+// target is the stop condition of a step over, step out or run to cursor
+type target struct {
+	kind targetKind
+	addr uint16
+	sp   uint8
+}
+
+// Debugger controls a VM for a debugger frontend
+type Debugger struct {
+	mu sync.Mutex
+	vm *chip8.CHIP8
+
+	breakpoints []*Breakpoint
+	nextID      int
+
+	paused bool
+	last   Stop
+	target target
+	halted bool
+
+	// Set when execution resumes so a breakpoint at the current PC
+	// doesn't stop it again immediately
+	resumed bool
+
+	// Watchpoint hit by the executing instruction, reported before the
+	// next one
+	pending *Stop
+	instrPC uint16
+
+	onStop func(Stop)
+}
+
+// New creates a debugger for vm and attaches it as the VM's observer,
+// alongside any observer that is already attached. The debugger starts
+// running; call Pause to stop it.
+func New(vm *chip8.CHIP8) *Debugger {
+	d := &Debugger{vm: vm, nextID: 1}
+	obs := &observer{d: d}
+	vm.AddObserver(obs)
+	return d
+}
+
+// SetStopHandler sets a function called whenever execution stops. It is
+// called without the debugger's lock held, from the goroutine that caused
+// the stop.
+func (d *Debugger) SetStopHandler(fn func(Stop)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.onStop = fn
+}
+
+// Do calls fn with the VM while holding the debugger's lock, so frontends
+// can inspect or modify it without racing the emulation loop. fn must not
+// call other debugger methods.
+func (d *Debugger) Do(fn func(vm *chip8.CHIP8)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fn(d.vm)
+}
+
+// Paused returns true if execution is stopped
+func (d *Debugger) Paused() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paused
+}
+
+// LastStop returns the most recent stop, or a stop with ReasonNone if
+// execution has not stopped since it was last resumed
+func (d *Debugger) LastStop() Stop {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.last
+}
+
+// AddBreakpoint adds a breakpoint before the instruction at pc
+func (d *Debugger) AddBreakpoint(pc uint16) int {
+	return d.add(&Breakpoint{Kind: BreakPC, Addr: uint32(pc)})
+}
+
+// AddOpcodeBreakpoint adds a breakpoint before any instruction matching
+// pattern
+func (d *Debugger) AddOpcodeBreakpoint(pattern OpcodePattern) int {
+	return d.add(&Breakpoint{Kind: BreakOpcode, Pattern: pattern})
+}
+
+// AddWatchpoint adds a WatchRead, WatchWrite or WatchAccess watchpoint on
+// size bytes of memory starting at addr
+func (d *Debugger) AddWatchpoint(kind Kind, addr, size uint32) (int, error) {
+	if kind != WatchRead && kind != WatchWrite && kind != WatchAccess {
+		return 0, fmt.Errorf("%s is not a memory watchpoint", kind)
+	}
+	if size == 0 {
+		size = 1
+	}
+	return d.add(&Breakpoint{Kind: kind, Addr: addr, Size: size}), nil
+}
+
+// AddRegisterWatchpoint adds a watchpoint that stops when V[reg] changes
+func (d *Debugger) AddRegisterWatchpoint(reg uint8) (int, error) {
+	if reg >= chip8.NumRegisters {
+		return 0, fmt.Errorf("no register V%X", reg)
+	}
+	return d.add(&Breakpoint{Kind: WatchRegister, Reg: reg}), nil
+}
+
+// add registers a new enabled breakpoint and returns its ID
+func (d *Debugger) add(b *Breakpoint) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b.ID = d.nextID
+	b.Enabled = true
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, b)
+	return b.ID
+}
+
+// RemoveBreakpoint removes the breakpoint or watchpoint with the given ID
+func (d *Debugger) RemoveBreakpoint(id int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, b := range d.breakpoints {
+		if b.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: #%d", ErrNoBreakpoint, id)
+}
+
+// EnableBreakpoint enables or disables the breakpoint with the given ID
+func (d *Debugger) EnableBreakpoint(id int, enabled bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, b := range d.breakpoints {
+		if b.ID == id {
+			b.Enabled = enabled
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: #%d", ErrNoBreakpoint, id)
+}
+
+// ClearBreakpoints removes all breakpoints and watchpoints
+func (d *Debugger) ClearBreakpoints() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints = nil
+}
+
+// Breakpoints returns a copy of the breakpoints and watchpoints, in the
+// order they were added
+func (d *Debugger) Breakpoints() []Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	list := make([]Breakpoint, len(d.breakpoints))
+	for i, b := range d.breakpoints {
+		list[i] = *b
+	}
+	return list
+}
+
+// Pause stops execution at the end of the current frame
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	if d.paused {
+		d.mu.Unlock()
+		return
+	}
+	stop := d.stop(Stop{Reason: ReasonPause})
+	d.mu.Unlock()
+	d.notify(stop)
+}
+
+// Continue resumes execution until a breakpoint, watchpoint or error
+func (d *Debugger) Continue() {
+	d.resume(target{})
+}
+
+// RunToCursor resumes execution until PC reaches addr, or an earlier
+// breakpoint stops it
+func (d *Debugger) RunToCursor(addr uint16) {
+	d.resume(target{kind: targetCursor, addr: addr})
+}
+
+// StepOver steps one instruction, treating a 2NNN call as a single step:
+// execution resumes until the call returns
+func (d *Debugger) StepOver() {
+	d.mu.Lock()
+	pc, sp := d.vm.PC, d.vm.SP
+	isCall := d.opcodeAt(pc)&0xF000 == 0x2000
+	d.mu.Unlock()
+
+	if !isCall {
+		d.Step()
+		return
+	}
+	d.resume(target{kind: targetReturn, addr: pc + 2, sp: sp})
+}
+
+// StepOut resumes execution until the current subroutine returns with
+// 00EE. At the top level it steps one instruction.
+func (d *Debugger) StepOut() {
+	d.mu.Lock()
+	sp := d.vm.SP
+	d.mu.Unlock()
+
+	if sp == 0 {
+		d.Step()
+		return
+	}
+	d.resume(target{kind: targetOut, sp: sp})
+}
+
+// Step executes one instruction and stops. If the VM is waiting for
+// vertical blank the timers are ticked first, ending the frame.
+func (d *Debugger) Step() Stop {
+	d.mu.Lock()
+	d.pending = nil
+	var stop Stop
+	switch {
+	case d.vm.Halted:
+		stop = d.stop(Stop{Reason: ReasonHalted})
+	default:
+		if d.vm.WaitingForVBlank() {
+			d.vm.UpdateTimers()
+		}
+		if err := d.vm.Cycle(); err != nil {
+			stop = d.stop(Stop{Reason: ReasonError, Err: err})
+		} else if d.pending != nil {
+			stop = d.stop(*d.pending)
+		} else {
+			stop = d.stop(Stop{Reason: ReasonStep})
+		}
+	}
+	d.mu.Unlock()
+	d.notify(stop)
+	return stop
+}
+
+// RunFrame runs one frame of the VM like CHIP8.RunFrame, stopping at
+// breakpoints and watchpoints. It does nothing while the debugger is
+// paused. A frame that stops part way through doesn't tick the timers.
+func (d *Debugger) RunFrame(ipf int) (chip8.FrameResult, error) {
+	d.mu.Lock()
+	if d.paused {
+		d.mu.Unlock()
+		return chip8.FrameResult{}, nil
+	}
+
+	res, err := d.vm.RunFrameBreak(ipf, d.check)
+
+	var stop Stop
+	switch {
+	case err != nil:
+		stop = d.stop(Stop{Reason: ReasonError, Err: err})
+	case d.pending != nil:
+		// Watchpoint hit by the frame's last instruction
+		stop = d.stop(*d.pending)
+	case d.paused:
+		stop = d.last
+	case d.vm.Halted && !d.halted:
+		d.halted = true
+		stop = d.stop(Stop{Reason: ReasonHalted})
+	}
+	d.mu.Unlock()
+
+	if stop.Reason != ReasonNone {
+		d.notify(stop)
+	}
+	return res, err
+}
+
+// resume clears the last stop and resumes execution towards t
+func (d *Debugger) resume(t target) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.paused = false
+	d.last = Stop{}
+	d.target = t
+	d.resumed = true
+	d.pending = nil
+	d.halted = d.vm.Halted
+}
+
+// check is called before each instruction while running and returns true
+// if execution should stop before it. The lock is held.
+func (d *Debugger) check() bool {
+	if d.pending != nil {
+		d.stop(*d.pending)
+		return true
+	}
+
+	// Don't stop again at the instruction execution resumed at
+	if d.resumed {
+		d.resumed = false
+		return false
+	}
+
+	pc := d.vm.PC
+	switch t := d.target; {
+	case t.kind == targetCursor && pc == t.addr:
+		d.stop(Stop{Reason: ReasonCursor})
+		return true
+	case t.kind == targetReturn && pc == t.addr && d.vm.SP == t.sp:
+		d.stop(Stop{Reason: ReasonStep})
+		return true
+	case t.kind == targetOut && d.vm.SP < t.sp:
+		d.stop(Stop{Reason: ReasonStep})
+		return true
+	}
+
+	var opcode uint16
+	opcodeRead := false
+	for _, b := range d.breakpoints {
+		if !b.Enabled {
+			continue
+		}
+		hit := false
+		switch b.Kind {
+		case BreakPC:
+			hit = b.Addr == uint32(pc)
+		case BreakOpcode:
+			if !opcodeRead {
+				opcode, opcodeRead = d.opcodeAt(pc), true
+			}
+			hit = b.Pattern.Match(opcode)
+		}
+		if hit {
+			b.Hits++
+			d.stop(Stop{Reason: ReasonBreakpoint, Breakpoint: b.ID, Kind: b.Kind})
+			return true
+		}
+	}
+	return false
+}
+
+// stop pauses execution and records s, filling in the PC. The lock is held.
+func (d *Debugger) stop(s Stop) Stop {
+	s.PC = d.vm.PC
+	d.paused = true
+	d.last = s
+	d.target = target{}
+	d.pending = nil
+	return s
+}
+
+// notify calls the stop handler. The lock must not be held.
+func (d *Debugger) notify(s Stop) {
+	d.mu.Lock()
+	fn := d.onStop
+	d.mu.Unlock()
+	if fn != nil {
+		fn(s)
+	}
+}
+
+// opcodeAt returns the opcode at addr, wrapped into the address space
+func (d *Debugger) opcodeAt(addr uint16) uint16 {
+	mask := uint32(d.vm.AddressSpace() - 1)
+	hi := d.vm.Memory[uint32(addr)&mask]
+	lo := d.vm.Memory[(uint32(addr)+1)&mask]
+	return uint16(hi)<<8 | uint16(lo)
+}
+
+// hitWatch records the first watchpoint hit by the executing instruction
+func (d *Debugger) hitWatch(b *Breakpoint, addr uint32, old, value uint8) {
+	b.Hits++
+	if d.pending != nil {
+		return
+	}
+	d.pending = &Stop{
+		Reason:     ReasonWatchpoint,
+		Breakpoint: b.ID,
+		Kind:       b.Kind,
+		InstrPC:    d.instrPC,
+		Addr:       addr,
+		Old:        old,
+		New:        value,
+	}
+}
+
+// observer receives the VM's callbacks on behalf of the debugger. They are
+// made from within RunFrame, Step or Do, so the lock is held.
+type observer struct {
+	chip8.NopObserver
+	d *Debugger
+}
+
+func (o *observer) BeforeInstruction(pc, opcode uint16) {
+	o.d.instrPC = pc
+}
+
+func (o *observer) MemoryRead(addr uint32, value uint8) {
+	for _, b := range o.d.breakpoints {
+		if b.Enabled && b.matchesMemory(addr, false) {
+			o.d.hitWatch(b, addr, value, value)
+		}
+	}
+}
+
+func (o *observer) MemoryWrite(addr uint32, old, value uint8) {
+	for _, b := range o.d.breakpoints {
+		if b.Enabled && b.matchesMemory(addr, true) {
+			o.d.hitWatch(b, addr, old, value)
+		}
+	}
+}
+
+func (o *observer) RegisterWrite(reg, old, value uint8) {
+	if old == value {
+		return
+	}
+	for _, b := range o.d.breakpoints {
+		if b.Enabled && b.Kind == WatchRegister && b.Reg == reg {
+			o.d.hitWatch(b, uint32(reg), old, value)
+		}
+	}
+}
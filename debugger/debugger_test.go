@@ -0,0 +1,191 @@
+package debugger
+
+import (
+	"testing"
+
+	"github.com/chip8-emulator/chip8"
+)
+
+// newTestDebugger loads program at 0x200 and returns a debugger for it
+func newTestDebugger(program ...byte) (*Debugger, *chip8.CHIP8) {
+	vm := chip8.New()
+	copy(vm.Memory[chip8.ProgramStart:], program)
+	return New(vm), vm
+}
+
+// runUntilStopped runs frames until the debugger stops
+func runUntilStopped(t *testing.T, d *Debugger) Stop {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if _, err := d.RunFrame(10); err != nil {
+			t.Fatalf("RunFrame failed: %v", err)
+		}
+		if d.Paused() {
+			return d.LastStop()
+		}
+	}
+	t.Fatal("Debugger did not stop")
+	return Stop{}
+}
+
+func TestBreakpoint(t *testing.T) {
+	// 200: ADD V0, 1; 202: ADD V1, 1; 204: JP 200
+	d, vm := newTestDebugger(0x70, 0x01, 0x71, 0x01, 0x12, 0x00)
+	id := d.AddBreakpoint(0x202)
+
+	var handled []Stop
+	d.SetStopHandler(func(s Stop) { handled = append(handled, s) })
+
+	stop := runUntilStopped(t, d)
+	if stop.Reason != ReasonBreakpoint || stop.Breakpoint != id || stop.PC != 0x202 {
+		t.Fatalf("Expected breakpoint #%d at 202, got %v", id, stop)
+	}
+	if vm.V[0] != 1 || vm.V[1] != 0 {
+		t.Errorf("Execution should stop before 202, got V0=%d V1=%d", vm.V[0], vm.V[1])
+	}
+	if len(handled) != 1 || handled[0] != stop {
+		t.Errorf("Stop handler should be called once, got %v", handled)
+	}
+
+	// Paused frames don't execute anything
+	if res, _ := d.RunFrame(10); res.Instructions != 0 {
+		t.Errorf("Paused debugger ran %d instructions", res.Instructions)
+	}
+
+	// Continuing runs past the breakpoint and stops at it again
+	d.Continue()
+	stop = runUntilStopped(t, d)
+	if stop.PC != 0x202 || vm.V[0] != 2 || vm.V[1] != 1 {
+		t.Errorf("Expected second stop at 202 with V0=2 V1=1, got %v V0=%d V1=%d", stop, vm.V[0], vm.V[1])
+	}
+	if bps := d.Breakpoints(); len(bps) != 1 || bps[0].Hits != 2 {
+		t.Errorf("Breakpoint should have 2 hits, got %v", bps)
+	}
+
+	if err := d.RemoveBreakpoint(id); err != nil {
+		t.Errorf("RemoveBreakpoint failed: %v", err)
+	}
+	if err := d.RemoveBreakpoint(id); err == nil {
+		t.Error("Removing a missing breakpoint should fail")
+	}
+}
+
+func TestOpcodeBreakpoint(t *testing.T) {
+	// 200: LD V0, 5; 202: DRW V0, V0, 1; 204: JP 204
+	d, _ := newTestDebugger(0x60, 0x05, 0xD0, 0x01, 0x12, 0x04)
+
+	pattern, err := ParseOpcodePattern("D??1")
+	if err != nil {
+		t.Fatalf("ParseOpcodePattern failed: %v", err)
+	}
+	d.AddOpcodeBreakpoint(pattern)
+
+	if stop := runUntilStopped(t, d); stop.Reason != ReasonBreakpoint || stop.PC != 0x202 {
+		t.Errorf("Expected opcode breakpoint at 202, got %v", stop)
+	}
+}
+
+func TestParseOpcodePattern(t *testing.T) {
+	p, err := ParseOpcodePattern("8xy6")
+	if err != nil {
+		t.Fatalf("ParseOpcodePattern failed: %v", err)
+	}
+	if !p.Match(0x8126) || p.Match(0x8127) || p.String() != "8??6" {
+		t.Errorf("Unexpected pattern %v", p)
+	}
+
+	if _, err := ParseOpcodePattern("123"); err == nil {
+		t.Error("Short pattern should be rejected")
+	}
+}
+
+func TestMemoryWatchpoint(t *testing.T) {
+	// 200: LD I, 300; 202: LD V0, 7; 204: LD [I], V0; 206: JP 206
+	d, _ := newTestDebugger(0xA3, 0x00, 0x60, 0x07, 0xF0, 0x55, 0x12, 0x06)
+	id, err := d.AddWatchpoint(WatchWrite, 0x300, 1)
+	if err != nil {
+		t.Fatalf("AddWatchpoint failed: %v", err)
+	}
+
+	stop := runUntilStopped(t, d)
+	if stop.Reason != ReasonWatchpoint || stop.Breakpoint != id || stop.InstrPC != 0x204 ||
+		stop.PC != 0x206 || stop.Addr != 0x300 || stop.New != 7 {
+		t.Errorf("Expected write watchpoint by 204, got %+v", stop)
+	}
+
+	if _, err := d.AddWatchpoint(BreakPC, 0, 1); err == nil {
+		t.Error("AddWatchpoint should reject non-memory kinds")
+	}
+}
+
+func TestRegisterWatchpoint(t *testing.T) {
+	// 200: LD V3, 0; 202: LD V3, 9; 204: JP 204
+	d, _ := newTestDebugger(0x63, 0x00, 0x63, 0x09, 0x12, 0x04)
+	if _, err := d.AddRegisterWatchpoint(3); err != nil {
+		t.Fatalf("AddRegisterWatchpoint failed: %v", err)
+	}
+
+	// Writing the same value doesn't count as a change
+	stop := runUntilStopped(t, d)
+	if stop.Reason != ReasonWatchpoint || stop.InstrPC != 0x202 || stop.Old != 0 || stop.New != 9 {
+		t.Errorf("Expected V3 change by 202, got %+v", stop)
+	}
+}
+
+func TestStepping(t *testing.T) {
+	// 200: CALL 206; 202: ADD V0, 1; 204: JP 204
+	// 206: CALL 20C; 208: ADD V1, 1; 20A: RET
+	// 20C: ADD V2, 1; 20E: RET
+	d, vm := newTestDebugger(
+		0x22, 0x06, 0x70, 0x01, 0x12, 0x04,
+		0x22, 0x0C, 0x71, 0x01, 0x00, 0xEE,
+		0x72, 0x01, 0x00, 0xEE,
+	)
+	d.Pause()
+
+	// Step into the first call
+	if stop := d.Step(); stop.Reason != ReasonStep || stop.PC != 0x206 {
+		t.Fatalf("Step should enter the call, got %v", stop)
+	}
+
+	// Step over the nested call
+	d.StepOver()
+	if stop := runUntilStopped(t, d); stop.Reason != ReasonStep || stop.PC != 0x208 || vm.V[2] != 1 {
+		t.Fatalf("StepOver should stop after the call at 208, got %v V2=%d", stop, vm.V[2])
+	}
+
+	// Step out of the first call
+	d.StepOut()
+	if stop := runUntilStopped(t, d); stop.PC != 0x202 || vm.SP != 0 || vm.V[1] != 1 {
+		t.Fatalf("StepOut should return to 202, got %v SP=%d", stop, vm.SP)
+	}
+
+	// StepOver on a non-call is a plain step
+	d.StepOver()
+	if stop := d.LastStop(); stop.Reason != ReasonStep || stop.PC != 0x204 {
+		t.Fatalf("StepOver should step to 204, got %v", stop)
+	}
+}
+
+func TestRunToCursor(t *testing.T) {
+	// 200: ADD V0, 1; 202: SE V0, 3; 204: JP 200; 206: JP 206
+	d, vm := newTestDebugger(0x70, 0x01, 0x30, 0x03, 0x12, 0x00, 0x12, 0x06)
+	d.Pause()
+	d.RunToCursor(0x206)
+
+	if stop := runUntilStopped(t, d); stop.Reason != ReasonCursor || stop.PC != 0x206 || vm.V[0] != 3 {
+		t.Errorf("Expected to stop at cursor 206 with V0=3, got %v V0=%d", stop, vm.V[0])
+	}
+}
+
+func TestErrorStops(t *testing.T) {
+	// 200: RET with an empty stack
+	d, _ := newTestDebugger(0x00, 0xEE)
+
+	if _, err := d.RunFrame(10); err == nil {
+		t.Fatal("RunFrame should return the emulation error")
+	}
+	if stop := d.LastStop(); stop.Reason != ReasonError || stop.Err == nil || !d.Paused() {
+		t.Errorf("Expected an error stop, got %v", stop)
+	}
+}
@@ -0,0 +1,220 @@
+// Package disasm turns CHIP-8, SUPER-CHIP and XO-CHIP machine code into
+// mnemonics
+package disasm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chip8-emulator/chip8"
+)
+
+// Instruction is one decoded instruction
+type Instruction struct {
+	// Addr is the address of the instruction
+	Addr uint16
+	// Opcode is the first 16-bit word of the instruction
+	Opcode uint16
+	// Long is the second word of a 4-byte instruction
+	Long uint16
+	// Size is the length of the instruction in bytes (4 for XO-CHIP F000)
+	Size int
+	// Mnemonic is the instruction name, or "DW" for words that don't
+	// decode in the mode
+	Mnemonic string
+	// Args are the formatted operands
+	Args []string
+}
+
+// String returns the instruction in assembler syntax
+func (in Instruction) String() string {
+	if len(in.Args) == 0 {
+		return in.Mnemonic
+	}
+	return in.Mnemonic + " " + strings.Join(in.Args, ", ")
+}
+
+// Decode decodes the instruction at addr in mem for the given mode.
+// Addresses wrap around the end of mem, whose length must be a power of two.
+func Decode(mem []byte, addr uint16, mode chip8.Mode) Instruction {
+	mask := len(mem) - 1
+	word := func(a int) uint16 {
+		return uint16(mem[a&mask])<<8 | uint16(mem[(a+1)&mask])
+	}
+
+	opcode := word(int(addr))
+	in := Instruction{Addr: addr, Opcode: opcode, Size: 2}
+
+	x := int(opcode&0x0F00) >> 8
+	y := int(opcode&0x00F0) >> 4
+	n := int(opcode & 0x000F)
+	nn := int(opcode & 0x00FF)
+	nnn := int(opcode & 0x0FFF)
+
+	vx := reg(x)
+	vy := reg(y)
+	set := func(mnemonic string, args ...string) {
+		in.Mnemonic = mnemonic
+		in.Args = args
+	}
+	schip := mode != chip8.ModeCHIP8
+	xo := mode == chip8.ModeXOCHIP
+
+	switch opcode & 0xF000 {
+	case 0x0000:
+		switch {
+		case opcode == 0x00E0:
+			set("CLS")
+		case opcode == 0x00EE:
+			set("RET")
+		case schip && opcode&0xFFF0 == 0x00C0:
+			set("SCD", imm(n, 1))
+		case xo && opcode&0xFFF0 == 0x00D0:
+			set("SCU", imm(n, 1))
+		case schip && opcode == 0x00FB:
+			set("SCR")
+		case schip && opcode == 0x00FC:
+			set("SCL")
+		case schip && opcode == 0x00FD:
+			set("EXIT")
+		case schip && opcode == 0x00FE:
+			set("LOW")
+		case schip && opcode == 0x00FF:
+			set("HIGH")
+		default:
+			set("SYS", addr12(nnn))
+		}
+	case 0x1000:
+		set("JP", addr12(nnn))
+	case 0x2000:
+		set("CALL", addr12(nnn))
+	case 0x3000:
+		set("SE", vx, imm(nn, 2))
+	case 0x4000:
+		set("SNE", vx, imm(nn, 2))
+	case 0x5000:
+		// The VM runs other low nibbles as 5XY0 (and 9XYn as 9XY0), but
+		// they are shown as data so a listing reassembles to the same bytes
+		switch {
+		case n == 0x0:
+			set("SE", vx, vy)
+		case xo && n == 0x2:
+			set("SAVE", vx, vy)
+		case xo && n == 0x3:
+			set("LOAD", vx, vy)
+		}
+	case 0x6000:
+		set("LD", vx, imm(nn, 2))
+	case 0x7000:
+		set("ADD", vx, imm(nn, 2))
+	case 0x8000:
+		if name, ok := aluOps[n]; ok {
+			set(name, vx, vy)
+		}
+	case 0x9000:
+		if n == 0 {
+			set("SNE", vx, vy)
+		}
+	case 0xA000:
+		set("LD", "I", addr12(nnn))
+	case 0xB000:
+		set("JP", "V0", addr12(nnn))
+	case 0xC000:
+		set("RND", vx, imm(nn, 2))
+	case 0xD000:
+		set("DRW", vx, vy, imm(n, 1))
+	case 0xE000:
+		switch nn {
+		case 0x9E:
+			set("SKP", vx)
+		case 0xA1:
+			set("SKNP", vx)
+		}
+	case 0xF000:
+		switch {
+		case xo && opcode == 0xF000:
+			in.Size = 4
+			in.Long = word(int(addr) + 2)
+			set("LD", "I", "LONG "+imm(int(in.Long), 4))
+		case xo && opcode == 0xF002:
+			set("AUDIO")
+		case xo && nn == 0x01:
+			set("PLANE", imm(x, 1))
+		case nn == 0x07:
+			set("LD", vx, "DT")
+		case nn == 0x0A:
+			set("LD", vx, "K")
+		case nn == 0x15:
+			set("LD", "DT", vx)
+		case nn == 0x18:
+			set("LD", "ST", vx)
+		case nn == 0x1E:
+			set("ADD", "I", vx)
+		case nn == 0x29:
+			set("LD", "F", vx)
+		case schip && nn == 0x30:
+			set("LD", "HF", vx)
+		case nn == 0x33:
+			set("LD", "B", vx)
+		case xo && nn == 0x3A:
+			set("PITCH", vx)
+		case nn == 0x55:
+			set("LD", "[I]", vx)
+		case nn == 0x65:
+			set("LD", vx, "[I]")
+		case schip && nn == 0x75:
+			set("LD", "R", vx)
+		case schip && nn == 0x85:
+			set("LD", vx, "R")
+		}
+	}
+
+	if in.Mnemonic == "" {
+		set("DW", imm(int(opcode), 4))
+	}
+	return in
+}
+
+// Target returns the address the instruction jumps to, calls or loads
+// into I. For BNNN it is the base address the jump adds V0 to.
+func (in Instruction) Target() (uint16, bool) {
+	if in.Mnemonic == "DW" {
+		return 0, false
+	}
+	switch in.Opcode & 0xF000 {
+	case 0x1000, 0x2000, 0xA000, 0xB000:
+		return in.Opcode & 0x0FFF, true
+	}
+	if in.Size == 4 {
+		return in.Long, true
+	}
+	return 0, false
+}
+
+// aluOps names the 8XYN instructions
+var aluOps = map[int]string{
+	0x0: "LD",
+	0x1: "OR",
+	0x2: "AND",
+	0x3: "XOR",
+	0x4: "ADD",
+	0x5: "SUB",
+	0x6: "SHR",
+	0x7: "SUBN",
+	0xE: "SHL",
+}
+
+// reg formats register number r
+func reg(r int) string {
+	return fmt.Sprintf("V%X", r)
+}
+
+// imm formats an immediate value as hex with the given number of digits
+func imm(v, digits int) string {
+	return fmt.Sprintf("#%0*X", digits, v)
+}
+
+// addr12 formats a 12-bit address
+func addr12(a int) string {
+	return imm(a, 3)
+}
@@ -0,0 +1,154 @@
+package disasm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chip8-emulator/chip8"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		code []byte
+		mode chip8.Mode
+		want string
+	}{
+		{[]byte{0x00, 0xE0}, chip8.ModeCHIP8, "CLS"},
+		{[]byte{0x12, 0x34}, chip8.ModeCHIP8, "JP #234"},
+		{[]byte{0x6A, 0x05}, chip8.ModeCHIP8, "LD VA, #05"},
+		{[]byte{0x81, 0x2E}, chip8.ModeCHIP8, "SHL V1, V2"},
+		{[]byte{0xD1, 0x25}, chip8.ModeCHIP8, "DRW V1, V2, #5"},
+		{[]byte{0xF3, 0x65}, chip8.ModeCHIP8, "LD V3, [I]"},
+		{[]byte{0x00, 0xFF}, chip8.ModeCHIP8, "SYS #0FF"},
+		{[]byte{0x00, 0xFF}, chip8.ModeSCHIP, "HIGH"},
+		{[]byte{0xF0, 0x00, 0x12, 0x34}, chip8.ModeXOCHIP, "LD I, LONG #1234"},
+		{[]byte{0x81, 0x28}, chip8.ModeCHIP8, "DW #8128"},
+		{[]byte{0x51, 0x22}, chip8.ModeCHIP8, "DW #5122"},
+		{[]byte{0x51, 0x22}, chip8.ModeXOCHIP, "SAVE V1, V2"},
+		{[]byte{0x51, 0x24}, chip8.ModeXOCHIP, "DW #5124"},
+		{[]byte{0x91, 0x21}, chip8.ModeCHIP8, "DW #9121"},
+	}
+
+	for _, tt := range tests {
+		mem := make([]byte, chip8.MemorySize)
+		copy(mem[chip8.ProgramStart:], tt.code)
+		in := Decode(mem, chip8.ProgramStart, tt.mode)
+		if got := in.String(); got != tt.want {
+			t.Errorf("Decode(% X) = %q, want %q", tt.code, got, tt.want)
+		}
+		if want := len(tt.code); in.Size != want {
+			t.Errorf("Decode(% X) size = %d, want %d", tt.code, in.Size, want)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	rom := []byte{
+		0x60, 0x01, // 200: LD V0, #01
+		0x22, 0x08, // 202: CALL 208
+		0xA2, 0x0C, // 204: LD I, 20C
+		0x12, 0x06, // 206: JP 206
+		0x70, 0x01, // 208: ADD V0, #01
+		0x00, 0xEE, // 20A: RET
+		0xF0, 0x90, 0xF0, // 20C: sprite data
+	}
+	p, err := Analyze(rom, chip8.ModeCHIP8)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	if len(p.Instructions) != 6 {
+		t.Errorf("Expected 6 instructions, got %d", len(p.Instructions))
+	}
+	if _, ok := p.Instructions[0x20C]; ok {
+		t.Error("Sprite data should not be decoded as code")
+	}
+	wantLabels := map[uint16]string{0x200: "main", 0x206: "loc_206", 0x208: "sub_208", 0x20C: "data_20C"}
+	for addr, want := range wantLabels {
+		if got := p.Labels[addr]; got != want {
+			t.Errorf("Label at %03X = %q, want %q", addr, got, want)
+		}
+	}
+
+	var plain strings.Builder
+	if err := p.Write(&plain, SyntaxPlain); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"main:\n", "\tCALL sub_208 ", "\tLD I, data_20C ", "\tJP loc_206 ", "\tDB #F0, #90, #F0 "} {
+		if !strings.Contains(plain.String(), want) {
+			t.Errorf("Plain listing missing %q:\n%s", want, plain.String())
+		}
+	}
+
+	var octo strings.Builder
+	if err := p.Write(&octo, SyntaxOcto); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{": main\n", "\tv0 := 0x01 ", "\tsub_208 ", "\ti := data_20C ", "\tjump loc_206 ", "\t0xF0 0x90 0xF0 "} {
+		if !strings.Contains(octo.String(), want) {
+			t.Errorf("Octo listing missing %q:\n%s", want, octo.String())
+		}
+	}
+}
+
+func TestAnalyzeFollowsSkipsAndIndirectJumps(t *testing.T) {
+	rom := []byte{
+		0x30, 0x00, // 200: SE V0, #00
+		0x12, 0x06, // 202: JP 206
+		0xB2, 0x0A, // 204: JP V0, 20A
+		0x12, 0x06, // 206: JP 206
+		0xFF, 0xFF, // 208: data
+		0x00, 0xE0, // 20A: jump table, only reached through BNNN
+	}
+	p, err := Analyze(rom, chip8.ModeCHIP8)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	for _, addr := range []uint16{0x200, 0x202, 0x204, 0x206} {
+		if _, ok := p.Instructions[addr]; !ok {
+			t.Errorf("Expected code at %03X", addr)
+		}
+	}
+	if _, ok := p.Instructions[0x20A]; ok {
+		t.Error("BNNN targets should not be followed")
+	}
+	if len(p.Indirect) != 1 || p.Indirect[0] != 0x204 {
+		t.Errorf("Indirect = %v, want [204]", p.Indirect)
+	}
+
+	// An extra entry point reaches the jump table
+	if p, _ = Analyze(rom, chip8.ModeCHIP8, 0x20A); p.Instructions[0x20A].Mnemonic != "CLS" {
+		t.Error("Extra entry points should be followed")
+	}
+}
+
+func TestAnalyzeFollowsSkipAliases(t *testing.T) {
+	rom := []byte{
+		0x51, 0x21, // 200: runs as SE V1, V2 but shown as data
+		0x12, 0x06, // 202: JP 206
+		0x00, 0xE0, // 204: CLS
+		0x12, 0x06, // 206: JP 206
+	}
+	p, err := Analyze(rom, chip8.ModeCHIP8)
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if in := p.Instructions[0x200]; in.Mnemonic != "DW" {
+		t.Errorf("5121 decoded as %q, want DW", in)
+	}
+	for _, addr := range []uint16{0x202, 0x204, 0x206} {
+		if _, ok := p.Instructions[addr]; !ok {
+			t.Errorf("Expected code at %03X", addr)
+		}
+	}
+}
+
+func TestLinear(t *testing.T) {
+	p, err := Linear([]byte{0x00, 0xE0, 0xF0, 0x90, 0xF0}, chip8.ModeCHIP8)
+	if err != nil {
+		t.Fatalf("Linear failed: %v", err)
+	}
+	if len(p.Instructions) != 2 || p.Instructions[0x202].Mnemonic != "DW" {
+		t.Errorf("Expected every word decoded, got %v", p.Code())
+	}
+}
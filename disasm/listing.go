@@ -0,0 +1,134 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Syntax selects the source syntax of a listing
+type Syntax int
+
+const (
+	// SyntaxPlain is the mnemonic syntax of Instruction.String, which
+	// the assembler accepts
+	SyntaxPlain Syntax = iota
+	// SyntaxOcto is Octo source
+	SyntaxOcto
+)
+
+// String returns the name of the syntax
+func (s Syntax) String() string {
+	switch s {
+	case SyntaxPlain:
+		return "plain"
+	case SyntaxOcto:
+		return "octo"
+	default:
+		return fmt.Sprintf("Syntax(%d)", int(s))
+	}
+}
+
+// ParseSyntax returns the syntax with the given name
+func ParseSyntax(name string) (Syntax, error) {
+	switch strings.ToLower(name) {
+	case "plain":
+		return SyntaxPlain, nil
+	case "octo":
+		return SyntaxOcto, nil
+	default:
+		return 0, fmt.Errorf("unknown syntax %q (valid: plain, octo)", name)
+	}
+}
+
+// bytesPerDataLine is the most data bytes written on one line
+const bytesPerDataLine = 8
+
+// Write writes the program as source in the given syntax: labels on their
+// own lines, instructions with label operands, and data as byte lists.
+// Comments give the address and encoding of each line.
+func (p *Program) Write(w io.Writer, syntax Syntax) error {
+	bw := bufio.NewWriter(w)
+	comment := ";"
+	if syntax == SyntaxOcto {
+		comment = "#"
+	}
+
+	for addr := p.Start; addr < p.End; {
+		if name, ok := p.Labels[addr]; ok {
+			if syntax == SyntaxOcto {
+				fmt.Fprintf(bw, ": %s\n", name)
+			} else {
+				fmt.Fprintf(bw, "%s:\n", name)
+			}
+		}
+
+		if in, ok := p.Instructions[addr]; ok {
+			var text string
+			if syntax == SyntaxOcto {
+				text = p.octo(in)
+			} else {
+				text = p.plain(in)
+			}
+			fmt.Fprintf(bw, "\t%-24s %s %03X  %s\n", text, comment, addr, p.hex(addr, in.Size, ""))
+			addr += uint16(in.Size)
+			continue
+		}
+
+		// Data runs up to the next instruction or label
+		n := 1
+		for n < bytesPerDataLine && addr+uint16(n) < p.End {
+			next := addr + uint16(n)
+			if _, ok := p.Instructions[next]; ok {
+				break
+			}
+			if _, ok := p.Labels[next]; ok {
+				break
+			}
+			n++
+		}
+		var text string
+		if syntax == SyntaxOcto {
+			text = p.hex(addr, n, "0x")
+		} else {
+			text = "DB " + strings.Join(strings.Fields(p.hex(addr, n, "#")), ", ")
+		}
+		fmt.Fprintf(bw, "\t%-24s %s %03X\n", text, comment, addr)
+		addr += uint16(n)
+	}
+	return bw.Flush()
+}
+
+// hex formats size bytes at addr as space separated hex with a prefix
+func (p *Program) hex(addr uint16, size int, prefix string) string {
+	parts := make([]string, size)
+	for i := range parts {
+		parts[i] = fmt.Sprintf("%s%02X", prefix, p.Memory[int(addr)+i])
+	}
+	return strings.Join(parts, " ")
+}
+
+// target returns the label or hex address of an instruction's target
+func (p *Program) target(in Instruction, digits int) string {
+	target, _ := in.Target()
+	if name, ok := p.Labels[target]; ok {
+		return name
+	}
+	return imm(int(target), digits)
+}
+
+// plain formats an instruction in plain syntax, with its target replaced
+// by a label
+func (p *Program) plain(in Instruction) string {
+	if _, ok := in.Target(); !ok {
+		return in.String()
+	}
+	args := append([]string(nil), in.Args...)
+	if in.Size == 4 {
+		args[len(args)-1] = "LONG " + p.target(in, 4)
+	} else {
+		args[len(args)-1] = p.target(in, 3)
+	}
+	return Instruction{Mnemonic: in.Mnemonic, Args: args}.String()
+}
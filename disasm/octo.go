@@ -0,0 +1,139 @@
+package disasm
+
+import "fmt"
+
+// octoALU are the Octo operators of the 8XYN instructions
+var octoALU = map[uint16]string{
+	0x0: ":=",
+	0x1: "|=",
+	0x2: "&=",
+	0x3: "^=",
+	0x4: "+=",
+	0x5: "-=",
+	0x6: ">>=",
+	0x7: "=-",
+	0xE: "<<=",
+}
+
+// octo formats an instruction in Octo syntax. Skips become the inverted
+// if ... then conditional that Octo compiles to them.
+func (p *Program) octo(in Instruction) string {
+	op := in.Opcode
+	vx := fmt.Sprintf("v%x", op>>8&0xF)
+	vy := fmt.Sprintf("v%x", op>>4&0xF)
+	n := op & 0xF
+	nn := fmt.Sprintf("0x%02X", op&0xFF)
+
+	if in.Mnemonic == "DW" || in.Mnemonic == "SYS" {
+		// Octo has no mnemonic for these; emit the bytes
+		return p.hex(in.Addr, in.Size, "0x")
+	}
+
+	switch in.Mnemonic {
+	case "CLS":
+		return "clear"
+	case "RET":
+		return "return"
+	case "SCD":
+		return fmt.Sprintf("scroll-down %d", n)
+	case "SCU":
+		return fmt.Sprintf("scroll-up %d", n)
+	case "SCR":
+		return "scroll-right"
+	case "SCL":
+		return "scroll-left"
+	case "EXIT":
+		return "exit"
+	case "LOW":
+		return "lores"
+	case "HIGH":
+		return "hires"
+	case "AUDIO":
+		return "audio"
+	case "PLANE":
+		return fmt.Sprintf("plane %d", op>>8&0xF)
+	case "SAVE":
+		return fmt.Sprintf("save %s - %s", vx, vy)
+	case "LOAD":
+		return fmt.Sprintf("load %s - %s", vx, vy)
+	case "PITCH":
+		return "pitch := " + vx
+	}
+
+	switch op & 0xF000 {
+	case 0x1000:
+		return "jump " + p.octoTarget(in)
+	case 0x2000:
+		if name, ok := p.Labels[op&0x0FFF]; ok {
+			return name
+		}
+		return ":call " + p.octoTarget(in)
+	case 0x3000:
+		return fmt.Sprintf("if %s != %s then", vx, nn)
+	case 0x4000:
+		return fmt.Sprintf("if %s == %s then", vx, nn)
+	case 0x5000:
+		return fmt.Sprintf("if %s != %s then", vx, vy)
+	case 0x6000:
+		return fmt.Sprintf("%s := %s", vx, nn)
+	case 0x7000:
+		return fmt.Sprintf("%s += %s", vx, nn)
+	case 0x8000:
+		return fmt.Sprintf("%s %s %s", vx, octoALU[n], vy)
+	case 0x9000:
+		return fmt.Sprintf("if %s == %s then", vx, vy)
+	case 0xA000:
+		return "i := " + p.octoTarget(in)
+	case 0xB000:
+		return "jump0 " + p.octoTarget(in)
+	case 0xC000:
+		return fmt.Sprintf("%s := random %s", vx, nn)
+	case 0xD000:
+		return fmt.Sprintf("sprite %s %s %d", vx, vy, n)
+	case 0xE000:
+		if op&0xFF == 0x9E {
+			return fmt.Sprintf("if %s -key then", vx)
+		}
+		return fmt.Sprintf("if %s key then", vx)
+	}
+
+	switch op & 0xFF {
+	case 0x00:
+		return "i := long " + p.octoTarget(in)
+	case 0x07:
+		return vx + " := delay"
+	case 0x0A:
+		return vx + " := key"
+	case 0x15:
+		return "delay := " + vx
+	case 0x18:
+		return "buzzer := " + vx
+	case 0x1E:
+		return "i += " + vx
+	case 0x29:
+		return "i := hex " + vx
+	case 0x30:
+		return "i := bighex " + vx
+	case 0x33:
+		return "bcd " + vx
+	case 0x55:
+		return "save " + vx
+	case 0x65:
+		return "load " + vx
+	case 0x75:
+		return "saveflags " + vx
+	case 0x85:
+		return "loadflags " + vx
+	}
+	return p.hex(in.Addr, in.Size, "0x")
+}
+
+// octoTarget returns the label or hex address of an instruction's target
+// in Octo syntax
+func (p *Program) octoTarget(in Instruction) string {
+	target, _ := in.Target()
+	if name, ok := p.Labels[target]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%03X", target)
+}
@@ -0,0 +1,247 @@
+package disasm
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/chip8-emulator/chip8"
+)
+
+// Program is a ROM split into code and data, with labels for the
+// addresses its code refers to
+type Program struct {
+	// Mode is the instruction set the ROM was decoded for
+	Mode chip8.Mode
+	// Start and End delimit the ROM in the address space
+	Start, End uint16
+	// Memory is the address space with the ROM loaded at Start
+	Memory []byte
+	// Instructions holds the code, by address. Bytes not covered by an
+	// instruction are data.
+	Instructions map[uint16]Instruction
+	// Labels names the targets of jumps, calls and I loads inside the
+	// ROM, and the entry point
+	Labels map[uint16]string
+	// Indirect lists the addresses of BNNN jumps, whose targets depend on
+	// V0 and can't be followed statically
+	Indirect []uint16
+}
+
+// newProgram loads rom into a program with no code yet
+func newProgram(rom []byte, mode chip8.Mode) (*Program, error) {
+	size := chip8.MemorySize
+	if mode == chip8.ModeXOCHIP {
+		size = chip8.ExtendedMemorySize
+	}
+	if len(rom) > size-chip8.ProgramStart {
+		return nil, fmt.Errorf("ROM too large: %d bytes (max %d)", len(rom), size-chip8.ProgramStart)
+	}
+
+	p := &Program{
+		Mode:         mode,
+		Start:        chip8.ProgramStart,
+		End:          uint16(chip8.ProgramStart + len(rom)),
+		Memory:       make([]byte, size),
+		Instructions: make(map[uint16]Instruction),
+		Labels:       make(map[uint16]string),
+	}
+	copy(p.Memory[chip8.ProgramStart:], rom)
+	return p, nil
+}
+
+// Linear decodes every word of rom as an instruction, from the start to
+// the end. A trailing odd byte is data.
+func Linear(rom []byte, mode chip8.Mode) (*Program, error) {
+	p, err := newProgram(rom, mode)
+	if err != nil {
+		return nil, err
+	}
+	for addr := p.Start; p.contains(addr, 2); {
+		in := Decode(p.Memory, addr, mode)
+		if !p.contains(addr, in.Size) {
+			break
+		}
+		p.Instructions[addr] = in
+		addr += uint16(in.Size)
+	}
+	p.label()
+	return p, nil
+}
+
+// Analyze separates code from data by recursive descent: starting at the
+// entry point and any extra entries, it follows jumps, calls and both
+// sides of skips until a return, exit, indirect jump or invalid opcode.
+// Bytes never reached are data.
+func Analyze(rom []byte, mode chip8.Mode, entries ...uint16) (*Program, error) {
+	p, err := newProgram(rom, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	// covered marks the bytes of decoded instructions
+	covered := make(map[uint16]bool)
+	work := append([]uint16{p.Start}, entries...)
+	for len(work) > 0 {
+		addr := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		for {
+			if _, done := p.Instructions[addr]; done || !p.contains(addr, 2) {
+				break
+			}
+			in := Decode(p.Memory, addr, mode)
+			if (in.Mnemonic == "DW" && !isSkipAlias(in)) || !p.contains(addr, in.Size) || overlaps(covered, addr, in.Size) {
+				break
+			}
+			p.Instructions[addr] = in
+			for i := 0; i < in.Size; i++ {
+				covered[addr+uint16(i)] = true
+			}
+
+			next := addr + uint16(in.Size)
+			if flow := FlowOf(in, mode); flow != FlowNext {
+				if target, ok := in.Target(); ok && (flow == FlowJump || flow == FlowCall) {
+					work = append(work, target)
+				}
+				switch flow {
+				case FlowCall:
+					addr = next
+					continue
+				case FlowSkip:
+					// Both the next instruction and the one after it
+					work = append(work, next+uint16(Decode(p.Memory, next, mode).Size))
+					addr = next
+					continue
+				case FlowIndirect:
+					p.Indirect = append(p.Indirect, addr)
+				}
+				break
+			}
+			addr = next
+		}
+	}
+
+	p.label()
+	return p, nil
+}
+
+// isSkipAlias reports whether a word shown as data is a 5XYn or 9XYn the
+// VM runs as 5XY0 or 9XY0, so analysis goes on through it
+func isSkipAlias(in Instruction) bool {
+	return in.Opcode&0xF000 == 0x5000 || in.Opcode&0xF000 == 0x9000
+}
+
+// overlaps reports whether any of size bytes at addr are already code
+func overlaps(covered map[uint16]bool, addr uint16, size int) bool {
+	for i := 0; i < size; i++ {
+		if covered[addr+uint16(i)] {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether size bytes at addr are inside the ROM
+func (p *Program) contains(addr uint16, size int) bool {
+	return addr >= p.Start && int(addr)+size <= int(p.End)
+}
+
+// Flow is how an instruction passes control on
+type Flow int
+
+const (
+	FlowNext     Flow = iota // to the next instruction
+	FlowJump                 // to its target only
+	FlowCall                 // to its target, then back to the next instruction
+	FlowSkip                 // to the next instruction or the one after it
+	FlowIndirect             // to a target computed at run time
+	FlowEnd                  // nowhere: return or exit
+)
+
+// FlowOf classifies an instruction
+func FlowOf(in Instruction, mode chip8.Mode) Flow {
+	op := in.Opcode
+	switch {
+	case op == 0x00EE:
+		return FlowEnd
+	case op == 0x00FD && mode != chip8.ModeCHIP8:
+		return FlowEnd
+	case op&0xF000 == 0x1000:
+		return FlowJump
+	case op&0xF000 == 0x2000:
+		return FlowCall
+	case op&0xF000 == 0xB000:
+		return FlowIndirect
+	case op&0xF000 == 0x5000 && mode == chip8.ModeXOCHIP && (op&0xF == 0x2 || op&0xF == 0x3):
+		return FlowNext
+	case op&0xF000 == 0x3000, op&0xF000 == 0x4000,
+		op&0xF000 == 0x5000, op&0xF000 == 0x9000,
+		op&0xF0FF == 0xE09E, op&0xF0FF == 0xE0A1:
+		// The VM ignores the low nibble of 5XYn and 9XYn, so the ones
+		// shown as data still skip
+		return FlowSkip
+	}
+	return FlowNext
+}
+
+// label names the entry point and the targets of the code
+func (p *Program) label() {
+	kinds := make(map[uint16]string)
+	for _, in := range p.Instructions {
+		target, ok := in.Target()
+		if !ok || !p.contains(target, 1) {
+			continue
+		}
+		kind := "loc"
+		switch {
+		case in.Opcode&0xF000 == 0x2000:
+			kind = "sub"
+		case in.Opcode&0xF000 == 0xA000 || in.Size == 4:
+			if _, code := p.Instructions[target]; !code {
+				kind = "data"
+			}
+		}
+		// Subroutines take precedence over other references
+		if kinds[target] != "sub" {
+			kinds[target] = kind
+		}
+	}
+	for addr, kind := range kinds {
+		p.Labels[addr] = fmt.Sprintf("%s_%03X", kind, addr)
+	}
+	p.Labels[p.Start] = "main"
+
+	// Labels inside an instruction can't be placed in the listing
+	for addr := range p.Labels {
+		if p.inside(addr) {
+			delete(p.Labels, addr)
+		}
+	}
+}
+
+// inside reports whether addr falls within an instruction rather than at
+// its start
+func (p *Program) inside(addr uint16) bool {
+	for back := uint16(1); back < 4 && addr >= p.Start+back; back++ {
+		if in, ok := p.Instructions[addr-back]; ok && int(back) < in.Size {
+			return true
+		}
+	}
+	return false
+}
+
+// Code returns the instructions in address order
+func (p *Program) Code() []Instruction {
+	code := make([]Instruction, 0, len(p.Instructions))
+	for _, in := range p.Instructions {
+		code = append(code, in)
+	}
+	sort.Slice(code, func(i, j int) bool { return code[i].Addr < code[j].Addr })
+	return code
+}
+
+// Label returns the label at addr
+func (p *Program) Label(addr uint16) (string, bool) {
+	name, ok := p.Labels[addr]
+	return name, ok
+}
@@ -11,13 +11,26 @@ const (
 	// CHIP-8 display dimensions
 	Chip8Width  = 64
 	Chip8Height = 32
+	// SUPER-CHIP high-resolution display dimensions
+	HiResWidth  = 128
+	HiResHeight = 64
 )
 
-// Display manages the SDL2 window and rendering
+// Palette holds the colours for each pixel value. Plain CHIP-8 and
+// SUPER-CHIP only use the first two; XO-CHIP's two bitplanes select one
+// of all four.
+var Palette = [4]sdl.Color{
+	{R: 0, G: 0, B: 0, A: 255},       // background
+	{R: 0, G: 255, B: 0, A: 255},     // plane 1 (green phosphor style)
+	{R: 0, G: 120, B: 255, A: 255},   // plane 2
+	{R: 255, G: 255, B: 255, A: 255}, // both planes
+}
+
+// Display manages the SDL2 window and rendering. It implements
+// platform.FrameSink and platform.Titler.
 type Display struct {
 	window   *sdl.Window
 	renderer *sdl.Renderer
-	scale    int32
 }
 
 // New creates a new display with the specified scale factor
@@ -47,7 +60,6 @@ func New(title string, scale int32) (*Display, error) {
 	return &Display{
 		window:   window,
 		renderer: renderer,
-		scale:    scale,
 	}, nil
 }
 
@@ -62,27 +74,39 @@ func (d *Display) Close() {
 	sdl.Quit()
 }
 
-// Clear clears the display with a black background
+// Clear clears the display with the background colour
 func (d *Display) Clear() {
-	d.renderer.SetDrawColor(0, 0, 0, 255)
+	bg := Palette[0]
+	d.renderer.SetDrawColor(bg.R, bg.G, bg.B, bg.A)
 	d.renderer.Clear()
 }
 
-// Render draws the CHIP-8 display buffer to the screen
-func (d *Display) Render(displayBuffer *[Chip8Width * Chip8Height]uint8) {
-	d.Clear()
+// Frame draws a width x height display buffer to the screen. The buffer
+// is stretched to fill the window, so both the 64x32 and 128x64 modes
+// use the whole window.
+func (d *Display) Frame(displayBuffer []uint8, width, height int) {
+	// Size pixels from the window at render time, giving each one the
+	// edges of its share so scales that don't divide evenly leave no gaps
+	winW, winH, err := d.renderer.GetOutputSize()
+	if err != nil {
+		return
+	}
+	w, h := int32(width), int32(height)
 
-	// Set color for active pixels (white/green phosphor style)
-	d.renderer.SetDrawColor(0, 255, 0, 255)
+	d.Clear()
 
-	for y := int32(0); y < Chip8Height; y++ {
-		for x := int32(0); x < Chip8Width; x++ {
-			if displayBuffer[y*Chip8Width+x] != 0 {
+	for y := int32(0); y < h; y++ {
+		top, bottom := y*winH/h, (y+1)*winH/h
+		for x := int32(0); x < w; x++ {
+			if value := displayBuffer[y*w+x]; value != 0 {
+				left, right := x*winW/w, (x+1)*winW/w
+				color := Palette[value&0x3]
+				d.renderer.SetDrawColor(color.R, color.G, color.B, color.A)
 				rect := sdl.Rect{
-					X: x * d.scale,
-					Y: y * d.scale,
-					W: d.scale,
-					H: d.scale,
+					X: left,
+					Y: top,
+					W: right - left,
+					H: bottom - top,
 				}
 				d.renderer.FillRect(&rect)
 			}
@@ -0,0 +1,542 @@
+// Package gdbstub serves the GDB remote serial protocol (RSP) for a VM
+// controlled by the debugger package, so gdb and other RSP clients can
+// debug CHIP-8 programs.
+//
+// The target has registers V0-VF, I, PC, SP, DT and ST, described to the
+// client by a target description XML (qXfer:features:read). The 16-bit I
+// and PC are sent little-endian, the byte order gdb assumes for a target
+// it has no architecture for. Memory reads
+// and writes, software and hardware breakpoints, watchpoints, single step,
+// continue and interrupt are supported. One client is served at a time.
+package gdbstub
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/debugger"
+)
+
+// Server serves RSP clients for a debugger
+type Server struct {
+	d *debugger.Debugger
+
+	// Stops reported by the debugger, consumed by the active session
+	stops chan debugger.Stop
+
+	// Serializes sessions
+	mu sync.Mutex
+}
+
+// NewServer creates a server for d and installs itself as d's stop handler
+func NewServer(d *debugger.Debugger) *Server {
+	s := &Server{d: d, stops: make(chan debugger.Stop, 1)}
+	d.SetStopHandler(func(stop debugger.Stop) {
+		// Keep only the latest stop
+		for {
+			select {
+			case s.stops <- stop:
+				return
+			default:
+			}
+			s.drainStops()
+		}
+	})
+	return s
+}
+
+// ListenAndServe listens on the TCP address addr and serves clients on it
+func ListenAndServe(addr string, d *debugger.Debugger) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	return NewServer(d).Serve(ln)
+}
+
+// Serve accepts clients from ln and serves them one after another until
+// ln fails
+func (s *Server) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves one client until it detaches, kills the program or
+// disconnects, then closes conn. Execution is stopped while the client is
+// attached; when it leaves, its breakpoints are removed and execution
+// continues, except after a kill, which leaves it stopped.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer conn.Close()
+
+	sess := &session{
+		s:           s,
+		w:           conn,
+		breakpoints: make(map[breakpointKey]int),
+	}
+	defer sess.detach()
+
+	s.d.Pause()
+	s.drainStops()
+
+	events := make(chan event)
+	done := make(chan struct{})
+	defer close(done)
+	go readEvents(conn, events, done)
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			done, err := sess.handle(ev)
+			if err != nil || done {
+				return err
+			}
+		case stop := <-s.stops:
+			if sess.running {
+				sess.running = false
+				if err := sess.reply(stopReply(stop)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// drainStops discards any stop that has not been consumed
+func (s *Server) drainStops() {
+	select {
+	case <-s.stops:
+	default:
+	}
+}
+
+// breakpointKey identifies a Z packet breakpoint
+type breakpointKey struct {
+	typ  byte
+	addr uint32
+	size uint32
+}
+
+// session is the state of one client connection
+type session struct {
+	s       *Server
+	w       io.Writer
+	noAck   bool
+	running bool
+	// killed is set by a k packet, which leaves execution stopped
+	killed bool
+
+	// Debugger breakpoint IDs of the client's Z packets
+	breakpoints map[breakpointKey]int
+}
+
+// errDetach ends the session after a D or k packet
+var errDetach = errors.New("detach")
+
+// handle processes one event from the client and reports whether the
+// session is over
+func (sess *session) handle(ev event) (bool, error) {
+	switch {
+	case ev.interrupt:
+		if sess.running {
+			sess.s.d.Pause()
+		}
+		return false, nil
+	case ev.bad:
+		_, err := io.WriteString(sess.w, "-")
+		return false, err
+	}
+
+	if !sess.noAck {
+		if _, err := io.WriteString(sess.w, "+"); err != nil {
+			return true, err
+		}
+	}
+
+	resp, err := sess.dispatch(ev.packet)
+	if errors.Is(err, errDetach) {
+		if resp != "" {
+			return true, sess.reply(resp)
+		}
+		return true, nil
+	}
+	if err != nil {
+		resp = "E01"
+	}
+	if sess.running && resp == "" {
+		// The stop reply is sent when execution stops
+		return false, nil
+	}
+	return false, sess.reply(resp)
+}
+
+// reply sends a packet to the client
+func (sess *session) reply(data string) error {
+	return writePacket(sess.w, data)
+}
+
+// dispatch handles a packet and returns the response. While the target
+// runs after c, s or vCont the response is empty and sent later.
+func (sess *session) dispatch(pkt string) (string, error) {
+	d := sess.s.d
+	switch {
+	case pkt == "?":
+		if stop := d.LastStop(); stop.Reason != debugger.ReasonPause {
+			return stopReply(stop), nil
+		}
+		// Stopped on attach or by an earlier interrupt
+		return fmt.Sprintf("S%02x", sigtrap), nil
+	case strings.HasPrefix(pkt, "qSupported"):
+		return "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+;swbreak+;hwbreak+", nil
+	case pkt == "QStartNoAckMode":
+		sess.noAck = true
+		return "OK", nil
+	case strings.HasPrefix(pkt, "qXfer:features:read:target.xml:"):
+		return readXfer(targetXML, strings.TrimPrefix(pkt, "qXfer:features:read:target.xml:"))
+	case pkt == "qAttached":
+		return "1", nil
+	case pkt == "qC":
+		return "QC1", nil
+	case pkt == "qfThreadInfo":
+		return "m1", nil
+	case pkt == "qsThreadInfo":
+		return "l", nil
+	case strings.HasPrefix(pkt, "H"), strings.HasPrefix(pkt, "T"):
+		return "OK", nil
+	case pkt == "vCont?":
+		return "vCont;c;C;s;S", nil
+	case strings.HasPrefix(pkt, "vCont;"):
+		actions := strings.Split(pkt[len("vCont;"):], ";")
+		if strings.HasPrefix(actions[0], "s") || strings.HasPrefix(actions[0], "S") {
+			return sess.step(), nil
+		}
+		return sess.resume("")
+	case pkt == "g":
+		return sess.readRegisters(), nil
+	case strings.HasPrefix(pkt, "G"):
+		return sess.writeRegisters(pkt[1:])
+	case strings.HasPrefix(pkt, "p"):
+		return sess.readRegister(pkt[1:])
+	case strings.HasPrefix(pkt, "P"):
+		return sess.writeRegister(pkt[1:])
+	case strings.HasPrefix(pkt, "m"):
+		return sess.readMemory(pkt[1:])
+	case strings.HasPrefix(pkt, "M"):
+		return sess.writeMemory(pkt[1:])
+	case strings.HasPrefix(pkt, "Z"), strings.HasPrefix(pkt, "z"):
+		return sess.breakpoint(pkt)
+	case strings.HasPrefix(pkt, "c"):
+		return sess.resume(pkt[1:])
+	case strings.HasPrefix(pkt, "s"):
+		if pkt != "s" {
+			if err := sess.setPC(pkt[1:]); err != nil {
+				return "", err
+			}
+		}
+		return sess.step(), nil
+	case pkt == "D" || strings.HasPrefix(pkt, "D;"):
+		return "OK", errDetach
+	case pkt == "k":
+		sess.killed = true
+		return "", errDetach
+	default:
+		// Unsupported packets get an empty response
+		return "", nil
+	}
+}
+
+// detach removes the client's breakpoints and lets execution continue,
+// unless the client killed the program
+func (sess *session) detach() {
+	for _, id := range sess.breakpoints {
+		sess.s.d.RemoveBreakpoint(id)
+	}
+	if sess.killed {
+		sess.s.d.Pause()
+		return
+	}
+	sess.s.d.Continue()
+}
+
+// step executes one instruction and returns its stop reply
+func (sess *session) step() string {
+	stop := sess.s.d.Step()
+	sess.s.drainStops()
+	return stopReply(stop)
+}
+
+// resume continues execution, optionally from the hex address addr
+func (sess *session) resume(addr string) (string, error) {
+	if addr != "" {
+		if err := sess.setPC(addr); err != nil {
+			return "", err
+		}
+	}
+
+	// A program that has exited can't be resumed
+	halted := false
+	sess.s.d.Do(func(vm *chip8.CHIP8) { halted = vm.Halted })
+	if halted {
+		return "W00", nil
+	}
+
+	sess.s.drainStops()
+	sess.running = true
+	sess.s.d.Continue()
+	return "", nil
+}
+
+// setPC sets PC from a hex address
+func (sess *session) setPC(addr string) error {
+	pc, err := strconv.ParseUint(addr, 16, 16)
+	if err != nil {
+		return err
+	}
+	sess.s.d.Do(func(vm *chip8.CHIP8) { vm.PC = uint16(pc) })
+	return nil
+}
+
+func (sess *session) readRegisters() string {
+	var sb strings.Builder
+	sess.s.d.Do(func(vm *chip8.CHIP8) {
+		for _, r := range registers {
+			sb.WriteString(r.encode(vm))
+		}
+	})
+	return sb.String()
+}
+
+func (sess *session) writeRegisters(data string) (string, error) {
+	var err error
+	sess.s.d.Do(func(vm *chip8.CHIP8) {
+		for _, r := range registers {
+			n := r.bits / 4
+			if len(data) < n {
+				err = errors.New("short register data")
+				return
+			}
+			var v uint16
+			if v, err = r.decode(data[:n]); err != nil {
+				return
+			}
+			r.set(vm, v)
+			data = data[n:]
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+func (sess *session) readRegister(arg string) (string, error) {
+	n, err := strconv.ParseUint(arg, 16, 8)
+	if err != nil || int(n) >= len(registers) {
+		return "E00", nil
+	}
+	var value string
+	sess.s.d.Do(func(vm *chip8.CHIP8) { value = registers[n].encode(vm) })
+	return value, nil
+}
+
+func (sess *session) writeRegister(arg string) (string, error) {
+	num, value, ok := strings.Cut(arg, "=")
+	if !ok {
+		return "", errors.New("malformed P packet")
+	}
+	n, err := strconv.ParseUint(num, 16, 8)
+	if err != nil || int(n) >= len(registers) {
+		return "E00", nil
+	}
+	r := registers[n]
+	v, err := r.decode(value)
+	if err != nil {
+		return "", err
+	}
+	sess.s.d.Do(func(vm *chip8.CHIP8) { r.set(vm, v) })
+	return "OK", nil
+}
+
+// parseRange parses an "addr,length" pair
+func parseRange(arg string) (uint32, uint32, error) {
+	a, l, ok := strings.Cut(arg, ",")
+	if !ok {
+		return 0, 0, errors.New("malformed range")
+	}
+	addr, err := strconv.ParseUint(a, 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	length, err := strconv.ParseUint(l, 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(addr), uint32(length), nil
+}
+
+func (sess *session) readMemory(arg string) (string, error) {
+	addr, length, err := parseRange(arg)
+	if err != nil {
+		return "", err
+	}
+
+	var data []byte
+	sess.s.d.Do(func(vm *chip8.CHIP8) {
+		size := uint32(vm.AddressSpace())
+		if addr >= size {
+			return
+		}
+		end := min(uint64(addr)+uint64(length), uint64(size))
+		data = append(data, vm.Memory[addr:end]...)
+	})
+	if len(data) == 0 && length > 0 {
+		return "E14", nil
+	}
+	return hex.EncodeToString(data), nil
+}
+
+func (sess *session) writeMemory(arg string) (string, error) {
+	rng, payload, ok := strings.Cut(arg, ":")
+	if !ok {
+		return "", errors.New("malformed M packet")
+	}
+	addr, length, err := parseRange(rng)
+	if err != nil {
+		return "", err
+	}
+	data, err := hex.DecodeString(payload)
+	if err != nil || uint32(len(data)) != length {
+		return "", errors.New("malformed M packet data")
+	}
+
+	resp := "OK"
+	sess.s.d.Do(func(vm *chip8.CHIP8) {
+		if uint64(addr)+uint64(length) > uint64(vm.AddressSpace()) {
+			resp = "E14"
+			return
+		}
+		copy(vm.Memory[addr:], data)
+		vm.DrawFlag = true
+	})
+	return resp, nil
+}
+
+// breakpoint handles Z (insert) and z (remove) packets. Types 0 and 1
+// (software and hardware breakpoints) become PC breakpoints; 2, 3 and 4
+// become write, read and access watchpoints.
+func (sess *session) breakpoint(pkt string) (string, error) {
+	fields := strings.Split(pkt[1:], ",")
+	if len(fields) < 3 || len(fields[0]) != 1 {
+		return "", errors.New("malformed breakpoint packet")
+	}
+	typ := fields[0][0]
+	addr, size, err := parseRange(fields[1] + "," + fields[2])
+	if err != nil {
+		return "", err
+	}
+
+	key := breakpointKey{typ: typ, addr: addr, size: size}
+	d := sess.s.d
+
+	if pkt[0] == 'z' {
+		id, ok := sess.breakpoints[key]
+		if !ok {
+			return "E01", nil
+		}
+		delete(sess.breakpoints, key)
+		if err := d.RemoveBreakpoint(id); err != nil {
+			return "", err
+		}
+		return "OK", nil
+	}
+
+	if _, ok := sess.breakpoints[key]; ok {
+		return "OK", nil
+	}
+
+	var id int
+	switch typ {
+	case '0', '1':
+		id = d.AddBreakpoint(uint16(addr))
+	case '2', '3', '4':
+		kind := map[byte]debugger.Kind{'2': debugger.WatchWrite, '3': debugger.WatchRead, '4': debugger.WatchAccess}[typ]
+		if id, err = d.AddWatchpoint(kind, addr, size); err != nil {
+			return "", err
+		}
+	default:
+		return "", nil
+	}
+	sess.breakpoints[key] = id
+	return "OK", nil
+}
+
+// readXfer returns the "offset,length" window of an object for a qXfer
+// read
+func readXfer(object, arg string) (string, error) {
+	offset, length, err := parseRange(arg)
+	if err != nil {
+		return "", err
+	}
+	if int(offset) >= len(object) {
+		return "l", nil
+	}
+	chunk := object[offset:]
+	if uint32(len(chunk)) > length {
+		return "m" + chunk[:length], nil
+	}
+	return "l" + chunk, nil
+}
+
+// Signal numbers used in stop replies
+const (
+	sigint  = 0x02
+	sigill  = 0x04
+	sigtrap = 0x05
+	sigsegv = 0x0B
+)
+
+// stopReply returns the stop reply packet for a debugger stop
+func stopReply(stop debugger.Stop) string {
+	switch stop.Reason {
+	case debugger.ReasonBreakpoint:
+		if stop.Kind == debugger.BreakPC {
+			return fmt.Sprintf("T%02xswbreak:;", sigtrap)
+		}
+	case debugger.ReasonWatchpoint:
+		name := map[debugger.Kind]string{
+			debugger.WatchWrite:  "watch",
+			debugger.WatchRead:   "rwatch",
+			debugger.WatchAccess: "awatch",
+		}[stop.Kind]
+		if name != "" {
+			return fmt.Sprintf("T%02x%s:%x;", sigtrap, name, stop.Addr)
+		}
+	case debugger.ReasonPause:
+		return fmt.Sprintf("S%02x", sigint)
+	case debugger.ReasonHalted:
+		return "W00"
+	case debugger.ReasonError:
+		if errors.Is(stop.Err, chip8.ErrUnknownOpcode) {
+			return fmt.Sprintf("S%02x", sigill)
+		}
+		return fmt.Sprintf("S%02x", sigsegv)
+	}
+	return fmt.Sprintf("S%02x", sigtrap)
+}
@@ -0,0 +1,195 @@
+package gdbstub
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/debugger"
+)
+
+// client is a minimal RSP client for tests
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// send sends a packet and waits for its acknowledgement
+func (c *client) send(pkt string) {
+	c.t.Helper()
+	if err := writePacket(c.conn, pkt); err != nil {
+		c.t.Fatalf("write %q: %v", pkt, err)
+	}
+	if b, err := c.r.ReadByte(); err != nil || b != '+' {
+		c.t.Fatalf("Expected ack for %q, got %q (%v)", pkt, b, err)
+	}
+}
+
+// recv reads and acknowledges a packet
+func (c *client) recv() string {
+	c.t.Helper()
+	if _, err := c.r.ReadString('$'); err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+	var sum [2]byte
+	if _, err := c.r.Read(sum[:1]); err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+	if _, err := c.r.Read(sum[1:]); err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+	c.conn.Write([]byte("+"))
+	return string(unescape([]byte(data[:len(data)-1])))
+}
+
+// exchange sends a packet and returns the response
+func (c *client) exchange(pkt string) string {
+	c.t.Helper()
+	c.send(pkt)
+	return c.recv()
+}
+
+// runUntil runs frames until the debugger stops for reason. The server
+// handles requests on its own goroutine, so this gives it time to resume
+// or interrupt execution.
+func runUntil(t *testing.T, d *debugger.Debugger, reason debugger.Reason) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if d.LastStop().Reason == reason {
+			return
+		}
+		d.RunFrame(10)
+		time.Sleep(100 * time.Microsecond)
+	}
+	t.Fatalf("Debugger did not stop with %v, last stop %v", reason, d.LastStop())
+}
+
+func TestSession(t *testing.T) {
+	vm := chip8.New()
+	// 200: LD V0, 1; 202: ADD V0, 1; 204: ADD V0, 1; 206: JP 206
+	copy(vm.Memory[chip8.ProgramStart:], []byte{0x60, 0x01, 0x70, 0x01, 0x70, 0x01, 0x12, 0x06})
+	d := debugger.New(vm)
+	s := NewServer(d)
+
+	serverConn, clientConn := net.Pipe()
+	done := make(chan error)
+	go func() { done <- s.ServeConn(serverConn) }()
+	c := &client{t: t, conn: clientConn, r: bufio.NewReader(clientConn)}
+
+	if resp := c.exchange("qSupported:swbreak+"); !strings.Contains(resp, "qXfer:features:read+") {
+		t.Errorf("qSupported should offer target descriptions, got %q", resp)
+	}
+	if resp := c.exchange("?"); resp != "S05" {
+		t.Errorf("Target should be stopped on attach, got %q", resp)
+	}
+	if resp := c.exchange("qXfer:features:read:target.xml:0,fff"); !strings.HasPrefix(resp, "l<?xml") ||
+		!strings.Contains(resp, `<reg name="pc" bitsize="16" type="code_ptr" regnum="17"/>`) {
+		t.Errorf("Unexpected target description %q", resp)
+	}
+	if resp := c.exchange("qXfer:features:read:target.xml:0,5"); resp != "m<?xml" {
+		t.Errorf("Partial read should return the first 5 bytes, got %q", resp)
+	}
+
+	// Registers are V0-VF, I, PC, SP, DT, ST
+	want := strings.Repeat("00", 16) + "0000" + "0002" + "000000"
+	if resp := c.exchange("g"); resp != want {
+		t.Errorf("g = %q, want %q", resp, want)
+	}
+	if resp := c.exchange("P10=2301"); resp != "OK" || vm.I != 0x123 {
+		t.Errorf("P should set I, got %q I=%03X", resp, vm.I)
+	}
+	if resp := c.exchange("p10"); resp != "2301" {
+		t.Errorf("p10 = %q, want 2301", resp)
+	}
+
+	// Memory
+	if resp := c.exchange("m200,4"); resp != "60017001" {
+		t.Errorf("m200,4 = %q", resp)
+	}
+	if resp := c.exchange("M300,2:abcd"); resp != "OK" || vm.Memory[0x300] != 0xAB || vm.Memory[0x301] != 0xCD {
+		t.Errorf("M should write memory, got %q", resp)
+	}
+	if resp := c.exchange("m10000,1"); resp != "E14" {
+		t.Errorf("Reading outside memory should fail, got %q", resp)
+	}
+
+	// Breakpoint and continue
+	if resp := c.exchange("Z0,204,2"); resp != "OK" {
+		t.Fatalf("Z0 = %q", resp)
+	}
+	c.send("c")
+	runUntil(t, d, debugger.ReasonBreakpoint)
+	if resp := c.recv(); resp != "T05swbreak:;" || vm.PC != 0x204 || vm.V[0] != 2 {
+		t.Errorf("Expected breakpoint stop at 204, got %q PC=%03X", resp, vm.PC)
+	}
+
+	// Step
+	if resp := c.exchange("s"); resp != "S05" {
+		t.Errorf("s = %q", resp)
+	}
+	if resp := c.exchange("p11"); resp != "0602" {
+		t.Errorf("PC after step = %q, want 0602", resp)
+	}
+
+	// Interrupt a running target
+	if resp := c.exchange("z0,204,2"); resp != "OK" {
+		t.Errorf("z0 = %q", resp)
+	}
+	c.send("vCont;c")
+	c.conn.Write([]byte{interruptByte})
+	runUntil(t, d, debugger.ReasonPause)
+	if resp := c.recv(); resp != "S02" {
+		t.Errorf("Interrupt should stop with SIGINT, got %q", resp)
+	}
+
+	// Detaching lets the program run
+	if resp := c.exchange("D"); resp != "OK" {
+		t.Errorf("D = %q", resp)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("ServeConn failed: %v", err)
+	}
+	if d.Paused() || len(d.Breakpoints()) != 0 {
+		t.Error("Detaching should remove breakpoints and continue")
+	}
+}
+
+func TestKill(t *testing.T) {
+	vm := chip8.New()
+	copy(vm.Memory[chip8.ProgramStart:], []byte{0x12, 0x00}) // 200: JP 200
+	d := debugger.New(vm)
+	s := NewServer(d)
+
+	serverConn, clientConn := net.Pipe()
+	done := make(chan error)
+	go func() { done <- s.ServeConn(serverConn) }()
+	c := &client{t: t, conn: clientConn, r: bufio.NewReader(clientConn)}
+
+	if resp := c.exchange("Z0,200,2"); resp != "OK" {
+		t.Errorf("Z0 = %q", resp)
+	}
+	c.send("k")
+	if err := <-done; err != nil {
+		t.Errorf("ServeConn failed: %v", err)
+	}
+	if !d.Paused() || len(d.Breakpoints()) != 0 {
+		t.Error("Killing should remove breakpoints and leave execution stopped")
+	}
+}
+
+func TestPacketFraming(t *testing.T) {
+	if got := string(escape([]byte("a#b$c}d*"))); got != "a}\x03b}\x04c}]d}\x0a" {
+		t.Errorf("escape = %q", got)
+	}
+	if got := string(unescape(escape([]byte("a#b$c}d*")))); got != "a#b$c}d*" {
+		t.Errorf("unescape(escape) = %q", got)
+	}
+}
@@ -0,0 +1,112 @@
+package gdbstub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// interruptByte is the byte a client sends to stop a running target
+const interruptByte = 0x03
+
+// event is something read from the client: a packet, an interrupt or a
+// packet with a bad checksum that must be NAKed
+type event struct {
+	packet    string
+	interrupt bool
+	bad       bool
+}
+
+// readEvents reads packets from r and sends them to events until r fails
+// or done is closed. Acknowledgements from the client are skipped.
+func readEvents(r io.Reader, events chan<- event, done <-chan struct{}) {
+	defer close(events)
+	send := func(ev event) bool {
+		select {
+		case events <- ev:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case interruptByte:
+			if !send(event{interrupt: true}) {
+				return
+			}
+		case '$':
+			data, err := br.ReadBytes('#')
+			if err != nil {
+				return
+			}
+			data = data[:len(data)-1]
+
+			var sum [2]byte
+			if _, err := io.ReadFull(br, sum[:]); err != nil {
+				return
+			}
+			want, err := strconv.ParseUint(string(sum[:]), 16, 8)
+			ev := event{packet: string(unescape(data))}
+			if err != nil || uint8(want) != checksum(data) {
+				ev = event{bad: true}
+			}
+			if !send(ev) {
+				return
+			}
+		default:
+			// '+' and '-' acknowledgements and stray bytes
+		}
+	}
+}
+
+// writePacket frames and writes a packet
+func writePacket(w io.Writer, data string) error {
+	escaped := escape([]byte(data))
+	_, err := fmt.Fprintf(w, "$%s#%02x", escaped, checksum(escaped))
+	return err
+}
+
+// checksum returns the modulo 256 sum of data
+func checksum(data []byte) uint8 {
+	var sum uint8
+	for _, b := range data {
+		sum += b
+	}
+	return sum
+}
+
+// escape escapes the bytes that can't appear in a packet body
+func escape(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for _, b := range data {
+		switch b {
+		case '#', '$', '}', '*':
+			out = append(out, '}', b^0x20)
+		default:
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// unescape reverses escape
+func unescape(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == '}' && i+1 < len(data) {
+			i++
+			out = append(out, data[i]^0x20)
+		} else {
+			out = append(out, data[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,117 @@
+package gdbstub
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/chip8-emulator/chip8"
+)
+
+// register describes one register exposed to the client. Registers are
+// numbered in the order of the registers table, and multi-byte registers
+// are sent little-endian: the target description can't declare a byte
+// order, and gdb assumes little-endian for an architecture it doesn't know.
+type register struct {
+	name string
+	bits int
+	typ  string
+	get  func(vm *chip8.CHIP8) uint16
+	set  func(vm *chip8.CHIP8, v uint16)
+}
+
+// registers lists V0-VF, I, PC, SP, DT and ST
+var registers = func() []register {
+	var regs []register
+	for i := 0; i < chip8.NumRegisters; i++ {
+		regs = append(regs, register{
+			name: fmt.Sprintf("v%x", i),
+			bits: 8,
+			typ:  "uint8",
+			get:  func(vm *chip8.CHIP8) uint16 { return uint16(vm.V[i]) },
+			set:  func(vm *chip8.CHIP8, v uint16) { vm.V[i] = uint8(v) },
+		})
+	}
+	return append(regs,
+		register{
+			name: "i",
+			bits: 16,
+			typ:  "data_ptr",
+			get:  func(vm *chip8.CHIP8) uint16 { return vm.I },
+			set:  func(vm *chip8.CHIP8, v uint16) { vm.I = v },
+		},
+		register{
+			name: "pc",
+			bits: 16,
+			typ:  "code_ptr",
+			get:  func(vm *chip8.CHIP8) uint16 { return vm.PC },
+			set:  func(vm *chip8.CHIP8, v uint16) { vm.PC = v },
+		},
+		register{
+			name: "sp",
+			bits: 8,
+			typ:  "uint8",
+			get:  func(vm *chip8.CHIP8) uint16 { return uint16(vm.SP) },
+			set: func(vm *chip8.CHIP8, v uint16) {
+				if v <= chip8.StackSize {
+					vm.SP = uint8(v)
+				}
+			},
+		},
+		register{
+			name: "dt",
+			bits: 8,
+			typ:  "uint8",
+			get:  func(vm *chip8.CHIP8) uint16 { return uint16(vm.DelayTimer) },
+			set:  func(vm *chip8.CHIP8, v uint16) { vm.DelayTimer = uint8(v) },
+		},
+		register{
+			name: "st",
+			bits: 8,
+			typ:  "uint8",
+			get:  func(vm *chip8.CHIP8) uint16 { return uint16(vm.SoundTimer) },
+			set:  func(vm *chip8.CHIP8, v uint16) { vm.SoundTimer = uint8(v) },
+		},
+	)
+}()
+
+// encode returns the register's value as little-endian hex bytes
+func (r register) encode(vm *chip8.CHIP8) string {
+	v := r.get(vm)
+	b := make([]byte, r.bits/8)
+	for i := range b {
+		b[i] = byte(v >> (8 * i))
+	}
+	return hex.EncodeToString(b)
+}
+
+// decode parses a value sent as little-endian hex bytes
+func (r register) decode(data string) (uint16, error) {
+	b, err := hex.DecodeString(data)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) != r.bits/8 {
+		return 0, fmt.Errorf("register %s needs %d bytes, got %d", r.name, r.bits/8, len(b))
+	}
+	var v uint16
+	for i, x := range b {
+		v |= uint16(x) << (8 * i)
+	}
+	return v, nil
+}
+
+// targetXML is the target description sent for qXfer:features:read
+var targetXML = func() string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.chip8.core">
+`)
+	for i, r := range registers {
+		fmt.Fprintf(&sb, "    <reg name=%q bitsize=\"%d\" type=%q regnum=\"%d\"/>\n", r.name, r.bits, r.typ, i)
+	}
+	sb.WriteString("  </feature>\n</target>\n")
+	return sb.String()
+}()
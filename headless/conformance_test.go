@@ -0,0 +1,153 @@
+package headless
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chip8-emulator/chip8"
+)
+
+var update = flag.Bool("update", false, "Rewrite conformance golden files and regression snapshots")
+
+const (
+	// conformanceDir holds the community test ROMs, checked against golden
+	// files drawn from the suite's published expected screens
+	conformanceDir = "testdata/conformance"
+	// regressionDir holds ROMs checked against snapshots of this
+	// emulator's own output. They catch unintended changes but say nothing
+	// about correctness.
+	regressionDir = "testdata/regression"
+)
+
+// suiteCase is one entry of a suite's manifest.json
+type suiteCase struct {
+	Name     string   `json:"name"`
+	ROM      string   `json:"rom"`
+	Profiles []string `json:"profiles"`
+	Frames   int      `json:"frames"`
+	Keys     string   `json:"keys"`
+}
+
+// conformanceProfiles maps profile names to the platform they emulate
+var conformanceProfiles = map[string]struct {
+	mode   chip8.Mode
+	quirks chip8.Quirks
+}{
+	"chip8":  {chip8.ModeCHIP8, chip8.QuirksVIP},
+	"schip":  {chip8.ModeSCHIP, chip8.QuirksSCHIP},
+	"xochip": {chip8.ModeXOCHIP, chip8.QuirksXOCHIP},
+}
+
+func TestConformance(t *testing.T) {
+	runSuite(t, conformanceDir, false)
+}
+
+func TestRegression(t *testing.T) {
+	runSuite(t, regressionDir, true)
+}
+
+// runSuite runs every case in dir's manifest. Snapshots may be created by
+// -update, golden files may only be rewritten.
+func runSuite(t *testing.T, dir string, snapshots bool) {
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatalf("Reading manifest: %v", err)
+	}
+
+	var cases []suiteCase
+	if err := json.Unmarshal(data, &cases); err != nil {
+		t.Fatalf("Parsing manifest: %v", err)
+	}
+
+	for _, tc := range cases {
+		for _, profile := range tc.Profiles {
+			t.Run(profile+"/"+tc.Name, func(t *testing.T) {
+				runSuiteCase(t, dir, snapshots, tc, profile)
+			})
+		}
+	}
+}
+
+func runSuiteCase(t *testing.T, dir string, snapshots bool, tc suiteCase, profile string) {
+	platform, ok := conformanceProfiles[profile]
+	if !ok {
+		t.Fatalf("Unknown profile %q", profile)
+	}
+
+	rom, err := os.ReadFile(filepath.Join(dir, "roms", tc.ROM))
+	if os.IsNotExist(err) {
+		t.Skipf("ROM %s not present; see %s/README.md", tc.ROM, dir)
+	} else if err != nil {
+		t.Fatalf("Reading ROM: %v", err)
+	}
+
+	keys, err := ParseKeys(tc.Keys)
+	if err != nil {
+		t.Fatalf("Parsing keys: %v", err)
+	}
+
+	vm := chip8.NewWithMode(platform.mode)
+	vm.Quirks = platform.quirks
+	vm.Seed(0)
+	if err := vm.LoadROM(rom); err != nil {
+		t.Fatalf("LoadROM failed: %v", err)
+	}
+
+	if _, err := Run(vm, Options{Frames: tc.Frames, Keys: keys}); err != nil {
+		t.Fatalf("Emulation failed: %v", err)
+	}
+
+	got := ASCII(vm)
+	goldenPath := filepath.Join(dir, "golden", profile, tc.Name+".txt")
+
+	// Conformance golden files are only ever rewritten, never created: a
+	// new one must be checked against the test's published expected
+	// screen, not taken from whatever the emulator draws now. Regression
+	// snapshots are just that, so -update may create them.
+	want, err := os.ReadFile(goldenPath)
+	if err != nil && !(snapshots && *update && os.IsNotExist(err)) {
+		t.Fatalf("Reading golden file (see %s/README.md): %v", dir, err)
+	}
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(goldenPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(goldenPath, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	if got != string(want) {
+		t.Errorf("Display differs from %s at %s\ngot:\n%s", goldenPath, firstDifference(got, string(want)), got)
+	}
+}
+
+// firstDifference describes the first pixel where two ASCII frames differ
+func firstDifference(got, want string) string {
+	gotLines := strings.Split(got, "\n")
+	wantLines := strings.Split(want, "\n")
+	if len(gotLines) != len(wantLines) {
+		return "resolution"
+	}
+	for y := range gotLines {
+		if gotLines[y] == wantLines[y] {
+			continue
+		}
+		if len(gotLines[y]) != len(wantLines[y]) {
+			return "resolution"
+		}
+		for x := range gotLines[y] {
+			if gotLines[y][x] != wantLines[y][x] {
+				return fmt.Sprintf("row %d, column %d", y, x)
+			}
+		}
+	}
+	return "unknown position"
+}
@@ -0,0 +1,186 @@
+// Package headless runs CHIP-8 programs without a window, audio device or
+// keyboard, for automated testing and CI
+package headless
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/platform"
+)
+
+const (
+	// DefaultInstructionsPerFrame matches the emulator's default speed of
+	// 500 instructions per second at 60 frames per second
+	DefaultInstructionsPerFrame = 500 / 60
+)
+
+// ErrWaitingForKey is returned when a run limited only by cycles reaches a
+// key wait that no scripted key event will satisfy
+var ErrWaitingForKey = errors.New("program is waiting for a key press that the key script never sends")
+
+// KeyEvent presses or releases a key at the start of a frame
+type KeyEvent struct {
+	Frame   int
+	Key     uint8
+	Pressed bool
+}
+
+// Options controls a headless run. Execution stops at whichever limit is
+// reached first; at least one of Frames and Cycles must be set. When the
+// cycle limit is reached part way through a frame, that frame is cut short
+// but its timer tick still happens. Under chip8.TimingVIP frames always
+// run to their cycle budget, so the cycle limit may be passed by up to one
+// frame.
+type Options struct {
+	// Frames is the number of 60 Hz frames to run (0 for no limit)
+	Frames int
+	// Cycles is the number of instructions to run (0 for no limit)
+	Cycles int
+	// InstructionsPerFrame is the number of instructions run between timer
+	// ticks (DefaultInstructionsPerFrame if 0)
+	InstructionsPerFrame int
+	// Keys are the scripted key events, in any order
+	Keys []KeyEvent
+}
+
+// Result reports how a run ended
+type Result struct {
+	// Frames is the number of frames started
+	Frames int
+	// Cycles is the number of instructions executed
+	Cycles int
+
+	// Halted is true if the program exited with 00FD
+	Halted bool
+}
+
+// Run executes the program loaded into vm until a limit in opts is
+// reached, the program exits, or an emulation error occurs. It drives a
+// platform.Loop one frame at a time, with the key script as its input and
+// no display or audio.
+func Run(vm *chip8.CHIP8, opts Options) (Result, error) {
+	var res Result
+
+	if opts.Frames <= 0 && opts.Cycles <= 0 {
+		return res, errors.New("a frame or cycle limit is required")
+	}
+
+	ipf := opts.InstructionsPerFrame
+	if ipf <= 0 {
+		ipf = DefaultInstructionsPerFrame
+	}
+
+	script := &keyScript{keys: append([]KeyEvent(nil), opts.Keys...)}
+	sort.SliceStable(script.keys, func(i, j int) bool { return script.keys[i].Frame < script.keys[j].Frame })
+
+	loop := &platform.Loop{
+		VM:       vm,
+		Platform: platform.Platform{Video: nullVideo{}, Input: script},
+		Log:      io.Discard,
+	}
+
+	for opts.Frames <= 0 || res.Frames < opts.Frames {
+		// The key events scheduled for this frame are polled as it starts
+		script.frame = res.Frames
+		res.Frames++
+
+		n := ipf
+		if opts.Cycles > 0 && opts.Cycles-res.Cycles < n {
+			n = opts.Cycles - res.Cycles
+		}
+		loop.Speed = n * platform.FrameRate
+
+		running, err := loop.Step()
+		res.Cycles += loop.LastFrame().Instructions
+		if err != nil {
+			return res, err
+		}
+
+		switch {
+		case !running:
+			// The loop only stops by itself once the program exits
+			res.Halted = vm.Halted
+			return res, nil
+		case opts.Cycles > 0 && res.Cycles >= opts.Cycles:
+			return res, nil
+		case opts.Frames <= 0 && vm.WaitingForKey && len(script.keys) == 0:
+			// Without a frame limit this would never finish
+			return res, ErrWaitingForKey
+		}
+	}
+
+	return res, nil
+}
+
+// keyScript is the input of a headless run: it reports the scripted key
+// events due by the current frame
+type keyScript struct {
+	keys  []KeyEvent // pending events, sorted by frame
+	frame int
+}
+
+func (s *keyScript) Poll() []platform.Event {
+	var events []platform.Event
+	for len(s.keys) > 0 && s.keys[0].Frame <= s.frame {
+		kind := platform.KeyUp
+		if s.keys[0].Pressed {
+			kind = platform.KeyDown
+		}
+		events = append(events, platform.Event{Kind: kind, Key: s.keys[0].Key})
+		s.keys = s.keys[1:]
+	}
+	return events
+}
+
+// nullVideo discards frames; the display is read from the VM at the end
+type nullVideo struct{}
+
+func (nullVideo) Frame([]uint8, int, int) {}
+
+// ParseKeys parses a key script of comma-separated FRAME:KEY:ACTION
+// entries, where KEY is a hex keypad digit and ACTION is down, up or tap.
+// A tap presses the key for one frame. For example "30:5:down,40:5:up".
+func ParseKeys(script string) ([]KeyEvent, error) {
+	var events []KeyEvent
+	for _, entry := range strings.Split(script, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("key event %q: want FRAME:KEY:ACTION", entry)
+		}
+
+		frame, err := strconv.Atoi(parts[0])
+		if err != nil || frame < 0 {
+			return nil, fmt.Errorf("key event %q: invalid frame", entry)
+		}
+
+		key, err := strconv.ParseUint(parts[1], 16, 8)
+		if err != nil || key >= chip8.NumKeys {
+			return nil, fmt.Errorf("key event %q: invalid key", entry)
+		}
+
+		switch strings.ToLower(parts[2]) {
+		case "down":
+			events = append(events, KeyEvent{Frame: frame, Key: uint8(key), Pressed: true})
+		case "up":
+			events = append(events, KeyEvent{Frame: frame, Key: uint8(key), Pressed: false})
+		case "tap":
+			events = append(events,
+				KeyEvent{Frame: frame, Key: uint8(key), Pressed: true},
+				KeyEvent{Frame: frame + 1, Key: uint8(key), Pressed: false})
+		default:
+			return nil, fmt.Errorf("key event %q: action must be down, up or tap", entry)
+		}
+	}
+	return events, nil
+}
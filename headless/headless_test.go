@@ -0,0 +1,125 @@
+package headless
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/chip8-emulator/chip8"
+)
+
+func TestRunCycles(t *testing.T) {
+	vm := chip8.New()
+	vm.LoadROM([]byte{0x70, 0x01, 0x12, 0x00}) // ADD V0, 1; JP 0x200
+
+	res, err := Run(vm, Options{Cycles: 10})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if res.Cycles != 10 || vm.V[0] != 5 {
+		t.Errorf("Expected 10 cycles and V0=5, got %d cycles and V0=%d", res.Cycles, vm.V[0])
+	}
+}
+
+func TestRunFrames(t *testing.T) {
+	vm := chip8.New()
+	vm.LoadROM([]byte{0x12, 0x00}) // JP 0x200
+	vm.DelayTimer = 10
+
+	res, err := Run(vm, Options{Frames: 4, InstructionsPerFrame: 3})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if res.Frames != 4 || res.Cycles != 12 || vm.DelayTimer != 6 {
+		t.Errorf("Expected 4 frames, 12 cycles, DT=6; got %d, %d, %d", res.Frames, res.Cycles, vm.DelayTimer)
+	}
+}
+
+func TestRunHalted(t *testing.T) {
+	vm := chip8.NewWithMode(chip8.ModeSCHIP)
+	vm.LoadROM([]byte{0x70, 0x01, 0x00, 0xFD}) // ADD V0, 1; EXIT
+
+	res, err := Run(vm, Options{Frames: 10})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !res.Halted || res.Frames != 1 || res.Cycles != 2 {
+		t.Errorf("Expected a halt after 1 frame and 2 cycles, got %+v", res)
+	}
+}
+
+func TestRunError(t *testing.T) {
+	vm := chip8.New()
+	vm.LoadROM([]byte{0x00, 0xEE}) // RET with an empty stack
+
+	if _, err := Run(vm, Options{Frames: 1}); !errors.Is(err, chip8.ErrStackUnderflow) {
+		t.Errorf("Expected stack underflow, got %v", err)
+	}
+}
+
+func TestRunScriptedKeys(t *testing.T) {
+	vm := chip8.New()
+	vm.LoadROM([]byte{0xF3, 0x0A, 0x12, 0x02}) // LD V3, K; JP 0x202
+
+	keys, err := ParseKeys("2:a:tap")
+	if err != nil {
+		t.Fatalf("ParseKeys failed: %v", err)
+	}
+
+	if _, err := Run(vm, Options{Frames: 5, Keys: keys}); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if vm.V[3] != 0xA || vm.Keys[0xA] {
+		t.Errorf("Key A should have been tapped, V3=%#x pressed=%v", vm.V[3], vm.Keys[0xA])
+	}
+}
+
+func TestParseKeysErrors(t *testing.T) {
+	for _, script := range []string{"1:5", "x:5:down", "1:g:down", "1:5:hold"} {
+		if _, err := ParseKeys(script); err == nil {
+			t.Errorf("ParseKeys(%q) should fail", script)
+		}
+	}
+}
+
+func TestOutputs(t *testing.T) {
+	vm := chip8.New()
+	vm.Display[1] = 1
+
+	art := ASCII(vm)
+	if !strings.HasPrefix(art, ".#..") || strings.Count(art, "\n") != chip8.DisplayHeight {
+		t.Errorf("Unexpected ASCII output:\n%s", art)
+	}
+
+	before := Hash(vm)
+	vm.Display[2] = 1
+	if Hash(vm) == before {
+		t.Error("Hash should change with the display")
+	}
+
+	var buf bytes.Buffer
+	if err := WritePNG(&buf, vm, 2); err != nil {
+		t.Fatalf("WritePNG failed: %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("PNG should decode: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 128 || b.Dy() != 64 {
+		t.Errorf("PNG should be 128x64, got %v", b)
+	}
+}
+
+func TestRunWaitingForKey(t *testing.T) {
+	vm := chip8.New()
+	vm.LoadROM([]byte{0xF0, 0x0A}) // LD V0, K
+
+	if _, err := Run(vm, Options{Cycles: 100}); !errors.Is(err, ErrWaitingForKey) {
+		t.Errorf("Expected ErrWaitingForKey, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package headless
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+
+	"github.com/chip8-emulator/chip8"
+)
+
+// Palette holds the PNG colours for each pixel value, matching the SDL
+// display
+var Palette = [4]color.RGBA{
+	{R: 0, G: 0, B: 0, A: 255},
+	{R: 0, G: 255, B: 0, A: 255},
+	{R: 0, G: 120, B: 255, A: 255},
+	{R: 255, G: 255, B: 255, A: 255},
+}
+
+// asciiPixels holds the characters used for each pixel value
+const asciiPixels = ".#+@"
+
+// ASCII renders the active display as text, one line per row
+func ASCII(vm *chip8.CHIP8) string {
+	var sb strings.Builder
+	w, h := vm.Width(), vm.Height()
+	pixels := vm.Pixels()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sb.WriteByte(asciiPixels[pixels[y*w+x]&0x3])
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// Hash returns a hex SHA-256 of the active display resolution and pixels.
+// Two runs produce the same hash only if their final frames are identical.
+func Hash(vm *chip8.CHIP8) string {
+	h := sha256.New()
+	h.Write([]byte{uint8(vm.Width()), uint8(vm.Height())})
+	h.Write(vm.Pixels())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Image returns the active display as an image, scaled by scale
+func Image(vm *chip8.CHIP8, scale int) *image.RGBA {
+	if scale < 1 {
+		scale = 1
+	}
+	w, h := vm.Width(), vm.Height()
+	pixels := vm.Pixels()
+	img := image.NewRGBA(image.Rect(0, 0, w*scale, h*scale))
+	for y := 0; y < h*scale; y++ {
+		for x := 0; x < w*scale; x++ {
+			img.SetRGBA(x, y, Palette[pixels[(y/scale)*w+x/scale]&0x3])
+		}
+	}
+	return img
+}
+
+// WritePNG writes the active display to w as a PNG, scaled by scale
+func WritePNG(w io.Writer, vm *chip8.CHIP8, scale int) error {
+	return png.Encode(w, Image(vm, scale))
+}
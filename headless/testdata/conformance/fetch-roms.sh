@@ -0,0 +1,13 @@
+#!/bin/sh
+# Downloads the ROMs of Timendus' CHIP-8 test suite into roms/. They are
+# not redistributed with this repository.
+set -e
+
+VERSION=${VERSION:-v4.1}
+dir=$(cd "$(dirname "$0")" && pwd)
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+git clone --quiet --depth 1 --branch "$VERSION" https://github.com/Timendus/chip8-test-suite "$tmp"
+cp "$tmp"/bin/*.ch8 "$dir/roms/"
+echo "Copied the $VERSION test suite ROMs into $dir/roms"
@@ -1,7 +1,11 @@
 // Package input handles keyboard input mapping for the CHIP-8 emulator
+// using SDL2
 package input
 
-import "github.com/veandco/go-sdl2/sdl"
+import (
+	"github.com/chip8-emulator/platform"
+	"github.com/veandco/go-sdl2/sdl"
+)
 
 /*
 CHIP-8 Keypad Layout:    Keyboard Mapping:
@@ -14,6 +18,9 @@ CHIP-8 Keypad Layout:    Keyboard Mapping:
 +---+---+---+---+        +---+---+---+---+
 | A | 0 | B | F |        | Z | X | C | V |
 +---+---+---+---+        +---+---+---+---+
+
+Emulator controls: ESC quits, P pauses, R resets, BACKSPACE rewinds while
+held, F1-F8 save state to slot 1-8 and Shift+F1-F8 load it.
 */
 
 // KeyMap maps SDL keycodes to CHIP-8 key indices (0x0-0xF)
@@ -24,7 +31,8 @@ var KeyMap = map[sdl.Keycode]uint8{
 	sdl.K_z: 0xA, sdl.K_x: 0x0, sdl.K_c: 0xB, sdl.K_v: 0xF,
 }
 
-// Keyboard handles keyboard input state
+// Keyboard handles keyboard input state. It implements
+// platform.InputSource.
 type Keyboard struct {
 	// Keys tracks the current state of each CHIP-8 key
 	Keys [16]bool
@@ -72,3 +80,51 @@ func (k *Keyboard) Reset() {
 func (k *Keyboard) GetKeyState() [16]bool {
 	return k.Keys
 }
+
+// Poll handles the pending SDL events and returns them as platform events
+func (k *Keyboard) Poll() []platform.Event {
+	var events []platform.Event
+	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+		switch e := event.(type) {
+		case *sdl.QuitEvent:
+			events = append(events, platform.Event{Kind: platform.Quit})
+
+		case *sdl.KeyboardEvent:
+			if e.Type == sdl.KEYDOWN {
+				events = append(events, k.keyDown(e.Keysym)...)
+			} else if e.Type == sdl.KEYUP {
+				if e.Keysym.Sym == sdl.K_BACKSPACE {
+					events = append(events, platform.Event{Kind: platform.RewindStop})
+				} else if key, ok := k.HandleKeyUp(e.Keysym.Sym); ok {
+					events = append(events, platform.Event{Kind: platform.KeyUp, Key: key})
+				}
+			}
+		}
+	}
+	return events
+}
+
+// keyDown returns the events for a key press
+func (k *Keyboard) keyDown(sym sdl.Keysym) []platform.Event {
+	switch sym.Sym {
+	case sdl.K_ESCAPE:
+		return []platform.Event{{Kind: platform.Quit}}
+	case sdl.K_p:
+		return []platform.Event{{Kind: platform.Pause}}
+	case sdl.K_r:
+		k.Reset()
+		return []platform.Event{{Kind: platform.Reset}}
+	case sdl.K_BACKSPACE:
+		return []platform.Event{{Kind: platform.RewindStart}}
+	case sdl.K_F1, sdl.K_F2, sdl.K_F3, sdl.K_F4, sdl.K_F5, sdl.K_F6, sdl.K_F7, sdl.K_F8:
+		slot := int(sym.Sym-sdl.K_F1) + 1
+		if sym.Mod&sdl.KMOD_SHIFT != 0 {
+			return []platform.Event{{Kind: platform.LoadState, Slot: slot}}
+		}
+		return []platform.Event{{Kind: platform.SaveState, Slot: slot}}
+	}
+	if key, ok := k.HandleKeyDown(sym.Sym); ok {
+		return []platform.Event{{Kind: platform.KeyDown, Key: key}}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Package cli holds the setup shared by the emulator's commands: creating a
+// VM from command line options, reading ROMs and Octo sources, and writing
+// profile reports.
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/octo"
+	"github.com/chip8-emulator/profile"
+	"github.com/chip8-emulator/symbols"
+)
+
+// VMOptions are the command line options that configure a VM
+type VMOptions struct {
+	Mode, Quirks, Memory, Timing string
+	Seed                         uint64
+}
+
+// NewVM creates a VM configured by opts and loads the ROM at romPath into
+// it. It returns the ROM and its symbol map, or nil if it has none.
+func NewVM(romPath string, opts VMOptions) (*chip8.CHIP8, []byte, *symbols.Map, error) {
+	mode, err := chip8.ParseMode(opts.Mode)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	vm := chip8.NewWithMode(mode)
+	if opts.Quirks != "" {
+		if vm.Quirks, err = chip8.ParseQuirks(opts.Quirks); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if vm.MemoryPolicy, err = chip8.ParseMemoryPolicy(opts.Memory); err != nil {
+		return nil, nil, nil, err
+	}
+	if vm.Timing, err = chip8.ParseTiming(opts.Timing); err != nil {
+		return nil, nil, nil, err
+	}
+	vm.Seed(opts.Seed)
+
+	romData, syms, err := ReadROM(romPath, mode)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("loading ROM: %w", err)
+	}
+	if err := vm.LoadROM(romData); err != nil {
+		return nil, nil, nil, fmt.Errorf("loading ROM into memory: %w", err)
+	}
+	return vm, romData, syms, nil
+}
+
+// ReadROM reads a ROM and its symbol map, if it has one. An Octo source
+// file is compiled instead, and its symbol map written next to it for the
+// debuggers.
+func ReadROM(path string, mode chip8.Mode) ([]byte, *symbols.Map, error) {
+	if filepath.Ext(path) == octo.Ext {
+		res, err := octo.CompileFile(path, octo.Options{Mode: mode})
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := res.Symbols.SaveRelative(symbols.PathFor(path)); err != nil {
+			return nil, nil, err
+		}
+		return res.ROM, res.Symbols, nil
+	}
+	rom, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var syms *symbols.Map
+	if _, err := os.Stat(symbols.PathFor(path)); err == nil {
+		if syms, err = symbols.Load(symbols.PathFor(path)); err != nil {
+			return nil, nil, fmt.Errorf("loading symbols: %w", err)
+		}
+	}
+	return rom, syms, nil
+}
+
+// WriteProfile writes the profile report to path, naming addresses from
+// syms if it isn't nil
+func WriteProfile(path string, prof *profile.Profiler, romData []byte, mode chip8.Mode, syms *symbols.Map) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := profile.NewReport(prof, romData, mode, syms).Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/symbols"
+)
+
+func TestNewVM(t *testing.T) {
+	dir := t.TempDir()
+	romPath := filepath.Join(dir, "game.ch8")
+	if err := os.WriteFile(romPath, []byte{0x12, 0x00}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	vm, romData, syms, err := NewVM(romPath, VMOptions{Mode: "schip", Quirks: "vip", Memory: "fault", Timing: "vip", Seed: 1})
+	if err != nil {
+		t.Fatalf("NewVM failed: %v", err)
+	}
+	if vm.Mode != chip8.ModeSCHIP || vm.Quirks != chip8.QuirksVIP || vm.MemoryPolicy != chip8.MemoryFault || vm.Timing != chip8.TimingVIP {
+		t.Errorf("VM not configured from the options: mode %v, quirks %+v", vm.Mode, vm.Quirks)
+	}
+	if len(romData) != 2 || vm.Memory[chip8.ProgramStart] != 0x12 {
+		t.Error("ROM should be loaded")
+	}
+	if syms != nil {
+		t.Error("A ROM without a symbol map should have nil symbols")
+	}
+
+	if _, _, _, err := NewVM(romPath, VMOptions{Mode: "chip8", Memory: "bogus", Timing: "none"}); err == nil {
+		t.Error("An unknown memory policy should fail")
+	}
+}
+
+func TestReadROMCompilesOcto(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "game.8o")
+	if err := os.WriteFile(srcPath, []byte(": main\n  v0 := 1\n  jump main\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rom, syms, err := ReadROM(srcPath, chip8.ModeCHIP8)
+	if err != nil {
+		t.Fatalf("ReadROM failed: %v", err)
+	}
+	if len(rom) != 4 || syms == nil {
+		t.Fatalf("Got %d bytes and symbols %v, want a 4-byte ROM with symbols", len(rom), syms)
+	}
+	if _, err := symbols.Load(symbols.PathFor(srcPath)); err != nil {
+		t.Errorf("Symbol map should be written next to the source: %v", err)
+	}
+}
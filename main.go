@@ -3,34 +3,98 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
 	"os"
-	"time"
 
 	"github.com/chip8-emulator/audio"
 	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/dap"
+	"github.com/chip8-emulator/debugger"
 	"github.com/chip8-emulator/display"
+	"github.com/chip8-emulator/gdbstub"
 	"github.com/chip8-emulator/input"
-	"github.com/veandco/go-sdl2/sdl"
+	"github.com/chip8-emulator/internal/cli"
+	"github.com/chip8-emulator/platform"
+	"github.com/chip8-emulator/profile"
+	"github.com/chip8-emulator/repl"
+	"github.com/chip8-emulator/symbols"
+	"github.com/chip8-emulator/trace"
 )
 
 const (
 	// Default emulation speed (instructions per second)
 	DefaultClockSpeed = 500
-	// Timer frequency (60 Hz as per CHIP-8 spec)
-	TimerFrequency = 60
+	// Frames between full snapshots in the rewind history
+	RewindKeyframeInterval = 60
+	// Window title
+	Title = "CHIP-8 Emulator"
 )
 
 func main() {
 	// Parse command line arguments
-	romPath := flag.String("rom", "", "Path to the CHIP-8 ROM file")
+	romPath := flag.String("rom", "", "Path to the CHIP-8 ROM file, or Octo source (.8o) to compile")
 	scale := flag.Int("scale", 10, "Display scale factor")
 	speed := flag.Int("speed", DefaultClockSpeed, "Emulation speed (instructions per second)")
+	modeName := flag.String("mode", "chip8", "Instruction set (chip8, schip, xochip)")
+	quirksName := flag.String("quirks", "", "Quirks preset (default, vip, schip, xochip); defaults to the mode's preset")
+	seed := flag.Uint64("seed", 0, "Random seed for CXNN (0 picks one at random)")
+	memoryName := flag.String("memory", "wrap", "Out-of-range memory access policy (wrap, fault, mask)")
+	timingName := flag.String("timing", "none", "Timing model (none, vip); vip ignores -speed")
+	rewindSeconds := flag.Int("rewind", 180, "Seconds of rewind history to keep (0 disables rewind)")
+	debug := flag.Bool("debug", false, "Start stopped, with a debugger prompt on stdin")
+	gdbAddr := flag.String("gdb", "", "Start stopped and serve the GDB remote protocol on this address, e.g. localhost:2159")
+	dapAddr := flag.String("dap", "", "Serve the Debug Adapter Protocol on stdio or this address; the ROM comes from the launch request")
+	tracePath := flag.String("trace", "", "Write a per-instruction execution trace to this file")
+	profilePath := flag.String("profile", "", "Write a hot spot report and coverage listing to this file at exit")
 	flag.Parse()
 
-	// Check for ROM path
-	if *romPath == "" {
+	frontends := 0
+	for _, on := range []bool{*debug, *gdbAddr != "", *dapAddr != ""} {
+		if on {
+			frontends++
+		}
+	}
+	if frontends > 1 {
+		fmt.Fprintln(os.Stderr, "Error: -debug, -gdb and -dap can't be used together")
+		os.Exit(1)
+	}
+
+	// Seed the random source so a run can be reproduced with -seed
+	if *seed == 0 {
+		*seed = rand.Uint64()
+	}
+	opts := cli.VMOptions{Mode: *modeName, Quirks: *quirksName, Memory: *memoryName, Timing: *timingName, Seed: *seed}
+
+	// The debugger frontends run on their own goroutines, so once the
+	// emulation starts the VM is only touched through loop.Do. debuggerDone
+	// is closed when the frontend ends the session.
+	var vm *chip8.CHIP8
+	var romData []byte
+	var syms *symbols.Map
+	var dbg *debugger.Debugger
+	debuggerDone := make(chan struct{})
+
+	if *dapAddr != "" {
+		// Wait for the client to launch a program
+		launched := make(chan launch)
+		go func() {
+			defer close(debuggerDone)
+			if err := serveDAP(*dapAddr, opts, launched); err != nil {
+				fmt.Fprintf(os.Stderr, "DAP server error: %v\n", err)
+			}
+		}()
+		select {
+		case l := <-launched:
+			*romPath, vm, romData, syms, dbg = l.romPath, l.vm, l.romData, l.syms, l.dbg
+		case <-debuggerDone:
+			os.Exit(1)
+		}
+	} else if *romPath == "" {
 		// Check if ROM path is provided as positional argument
 		if flag.NArg() > 0 {
 			*romPath = flag.Arg(0)
@@ -43,130 +107,192 @@ func main() {
 		}
 	}
 
-	// Load ROM file
-	romData, err := os.ReadFile(*romPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading ROM: %v\n", err)
-		os.Exit(1)
+	if vm == nil {
+		var err error
+		vm, romData, syms, err = cli.NewVM(*romPath, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
-	// Initialize CHIP-8
-	vm := chip8.New()
-	if err := vm.LoadROM(romData); err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading ROM into memory: %v\n", err)
-		os.Exit(1)
+	var traceFile *os.File
+	if *tracePath != "" {
+		var err error
+		if traceFile, err = os.Create(*tracePath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer traceFile.Close()
 	}
-
-	// Initialize display
-	disp, err := display.New("CHIP-8 Emulator", int32(*scale))
+	// Initialize the SDL frontend
+	disp, err := display.New(Title, int32(*scale))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error initializing display: %v\n", err)
 		os.Exit(1)
 	}
 	defer disp.Close()
+	loop := &platform.Loop{
+		VM:       vm,
+		ROM:      romData,
+		Platform: platform.Platform{Video: disp, Input: input.New()},
+		Speed:    *speed,
+		Title:    Title,
+		Done:     debuggerDone,
+		StatePath: func(slot int) string {
+			return stateSlotPath(*romPath, slot)
+		},
+	}
 
-	// Initialize audio
-	beeper, err := audio.New()
-	if err != nil {
+	// Continue without audio if it can't be initialized
+	if beeper, err := audio.New(); err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: Could not initialize audio: %v\n", err)
-		// Continue without audio
 	} else {
 		defer beeper.Close()
+		loop.Audio = beeper
 	}
 
-	// Initialize keyboard
-	keyboard := input.New()
+	// Initialize rewind history (one snapshot per 60 Hz frame)
+	if *rewindSeconds > 0 {
+		loop.Rewinder = chip8.NewRewinder(*rewindSeconds*platform.FrameRate, RewindKeyframeInterval)
+	}
 
-	// Calculate timing intervals
-	cycleInterval := time.Second / time.Duration(*speed)
-	timerInterval := time.Second / TimerFrequency
+	// Start the debugger prompt or GDB server
+	if *debug || *gdbAddr != "" {
+		dbg = debugger.New(vm)
+		dbg.Pause()
+	}
+	loop.Debugger = dbg
 
-	// Main emulation loop
-	running := true
-	lastCycleTime := time.Now()
-	lastTimerTime := time.Now()
+	// With -dap the debugger's goroutine already owns the VM, so the
+	// tracer and profiler attach under its lock. Nothing runs until the
+	// loop starts, so they still see every instruction.
+	var tracer *trace.Writer
+	if traceFile != nil {
+		loop.Do(func() { tracer = trace.NewWriter(vm, traceFile) })
+	}
+	var prof *profile.Profiler
+	if *profilePath != "" {
+		loop.Do(func() { prof = profile.New(vm) })
+	}
+	if *debug {
+		go func() {
+			defer close(debuggerDone)
+			if err := repl.New(dbg, os.Stdin, os.Stdout).Run(); err != nil {
+				fmt.Fprintf(os.Stderr, "Debugger error: %v\n", err)
+			}
+		}()
+	}
+	if *gdbAddr != "" {
+		go func() {
+			if err := gdbstub.ListenAndServe(*gdbAddr, dbg); err != nil {
+				fmt.Fprintf(os.Stderr, "GDB server error: %v\n", err)
+			}
+		}()
+		fmt.Printf("Waiting for GDB on %s\n", *gdbAddr)
+	}
 
-	fmt.Printf("Running %s at %d Hz\n", *romPath, *speed)
+	fmt.Printf("Running %s at %d Hz (seed %d)\n", *romPath, *speed, *seed)
 	fmt.Println("Keys: 1234 QWER ASDF ZXCV (mapped to CHIP-8 keypad)")
 	fmt.Println("Press ESC to quit, P to pause/resume, R to reset")
+	fmt.Println("F1-F8 save state to slot 1-8, Shift+F1-F8 load it")
+	if loop.Rewinder != nil {
+		fmt.Println("Hold BACKSPACE to rewind")
+	}
+	if *debug {
+		fmt.Println("Debugger started; type help for commands, continue to run")
+	}
 
-	paused := false
-
-	for running {
-		// Handle SDL events
-		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
-			switch e := event.(type) {
-			case *sdl.QuitEvent:
-				running = false
-
-			case *sdl.KeyboardEvent:
-				if e.Type == sdl.KEYDOWN {
-					switch e.Keysym.Sym {
-					case sdl.K_ESCAPE:
-						running = false
-					case sdl.K_p:
-						paused = !paused
-						if paused {
-							disp.SetTitle("CHIP-8 Emulator (PAUSED)")
-						} else {
-							disp.SetTitle("CHIP-8 Emulator")
-						}
-					case sdl.K_r:
-						vm.Reset()
-						if err := vm.LoadROM(romData); err != nil {
-							fmt.Fprintf(os.Stderr, "Error reloading ROM: %v\n", err)
-						}
-						keyboard.Reset()
-					default:
-						if key, ok := keyboard.HandleKeyDown(e.Keysym.Sym); ok {
-							vm.SetKey(key, true)
-						}
-					}
-				} else if e.Type == sdl.KEYUP {
-					if key, ok := keyboard.HandleKeyUp(e.Keysym.Sym); ok {
-						vm.SetKey(key, false)
-					}
-				}
-			}
-		}
+	if err := loop.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Emulation error: %v\n", err)
+	}
 
-		if paused {
-			time.Sleep(10 * time.Millisecond)
-			continue
-		}
+	if tracer != nil {
+		loop.Do(func() {
+			if err := tracer.Flush(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing trace: %v\n", err)
+			}
+		})
+	}
+	if prof != nil {
+		loop.Do(func() {
+			if err := cli.WriteProfile(*profilePath, prof, romData, vm.Mode, syms); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing profile: %v\n", err)
+			}
+		})
+	}
+	fmt.Println("Emulator stopped.")
+}
 
-		now := time.Now()
+// launch is a program launched by a DAP client
+type launch struct {
+	romPath string
+	vm      *chip8.CHIP8
+	romData []byte
+	syms    *symbols.Map
+	dbg     *debugger.Debugger
+}
 
-		// Execute CPU cycles
-		if now.Sub(lastCycleTime) >= cycleInterval {
-			if err := vm.Cycle(); err != nil {
-				fmt.Fprintf(os.Stderr, "Emulation error: %v\n", err)
-				running = false
-			}
-			lastCycleTime = now
+// serveDAP serves one DAP client on stdio or a TCP address. The first
+// launch request creates a paused VM from opts, overridden by the launch
+// arguments, and sends it on launched.
+func serveDAP(addr string, opts cli.VMOptions, launched chan<- launch) error {
+	var r io.Reader
+	var w io.Writer
+	if addr == "stdio" {
+		// The protocol owns stdout, so the emulator's messages go to stderr
+		r, w = os.Stdin, os.Stdout
+		os.Stdout = os.Stderr
+	} else {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
 		}
+		fmt.Fprintf(os.Stderr, "Waiting for a DAP client on %s\n", l.Addr())
+		conn, err := l.Accept()
+		l.Close()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		r, w = conn, conn
+	}
 
-		// Update timers at 60Hz
-		if now.Sub(lastTimerTime) >= timerInterval {
-			vm.UpdateTimers()
-
-			// Update beeper
-			if beeper != nil {
-				beeper.Update(vm.SoundTimer)
+	started := false
+	start := func(args dap.LaunchArgs) (*debugger.Debugger, *symbols.Map, error) {
+		if started {
+			return nil, nil, errors.New("a program is already running")
+		}
+		o := opts
+		override := func(dst *string, src string) {
+			if src != "" {
+				*dst = src
 			}
-
-			lastTimerTime = now
 		}
-
-		// Update display if needed
-		if vm.DrawFlag {
-			disp.Render(&vm.Display)
-			vm.DrawFlag = false
+		override(&o.Mode, args.Mode)
+		override(&o.Quirks, args.Quirks)
+		override(&o.Memory, args.Memory)
+		override(&o.Timing, args.Timing)
+		vm, romData, _, err := cli.NewVM(args.Program, o)
+		if err != nil {
+			return nil, nil, err
 		}
-
-		// Small sleep to prevent CPU spinning
-		time.Sleep(time.Microsecond * 100)
+		// Octo sources write their symbol map as they compile, so it is
+		// loaded after the VM is created but before it is handed over
+		syms, err := dap.LoadSymbols(args)
+		if err != nil {
+			return nil, nil, err
+		}
+		dbg := debugger.New(vm)
+		dbg.Pause()
+		started = true
+		launched <- launch{romPath: args.Program, vm: vm, romData: romData, syms: syms, dbg: dbg}
+		return dbg, syms, nil
 	}
+	return dap.NewServer(r, w, start).Serve()
+}
 
-	fmt.Println("Emulator stopped.")
+// stateSlotPath returns the save state file for a slot, stored next to the ROM
+func stateSlotPath(romPath string, slot int) string {
+	return fmt.Sprintf("%s.state%d", romPath, slot)
 }
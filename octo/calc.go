@@ -0,0 +1,157 @@
+package octo
+
+import (
+	"math"
+)
+
+// calcUnary are the prefix operators of :calc expressions
+var calcUnary = map[string]func(float64) float64{
+	"-":     func(x float64) float64 { return -x },
+	"~":     func(x float64) float64 { return float64(^int(x)) },
+	"!":     func(x float64) float64 { return b2f(x == 0) },
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"tan":   math.Tan,
+	"exp":   math.Exp,
+	"log":   math.Log,
+	"abs":   math.Abs,
+	"sqrt":  math.Sqrt,
+	"sign":  func(x float64) float64 { return b2f(x > 0) - b2f(x < 0) },
+	"ceil":  math.Ceil,
+	"floor": math.Floor,
+}
+
+// calcBinary are the infix operators of :calc expressions
+var calcBinary = map[string]func(x, y float64) float64{
+	"+":   func(x, y float64) float64 { return x + y },
+	"-":   func(x, y float64) float64 { return x - y },
+	"*":   func(x, y float64) float64 { return x * y },
+	"/":   func(x, y float64) float64 { return x / y },
+	"%":   func(x, y float64) float64 { return float64(int(x) % nonZero(int(y))) },
+	"&":   func(x, y float64) float64 { return float64(int(x) & int(y)) },
+	"|":   func(x, y float64) float64 { return float64(int(x) | int(y)) },
+	"^":   func(x, y float64) float64 { return float64(int(x) ^ int(y)) },
+	"<<":  func(x, y float64) float64 { return float64(int(x) << uint(y)) },
+	">>":  func(x, y float64) float64 { return float64(int(x) >> uint(y)) },
+	"pow": math.Pow,
+	"min": math.Min,
+	"max": math.Max,
+	"<":   func(x, y float64) float64 { return b2f(x < y) },
+	">":   func(x, y float64) float64 { return b2f(x > y) },
+	"<=":  func(x, y float64) float64 { return b2f(x <= y) },
+	">=":  func(x, y float64) float64 { return b2f(x >= y) },
+	"==":  func(x, y float64) float64 { return b2f(x == y) },
+	"!=":  func(x, y float64) float64 { return b2f(x != y) },
+}
+
+// b2f converts a boolean to 1 or 0
+func b2f(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// nonZero avoids a division by zero panic in %; the result is then 0
+func nonZero(y int) int {
+	if y == 0 {
+		return 1
+	}
+	return y
+}
+
+// calc evaluates the tokens of a :calc expression. As in Octo, operators
+// have no precedence and are applied right to left, so "2 * 3 + 1" is 8;
+// parentheses group.
+func (c *compiler) calc(tokens []token) (float64, error) {
+	p := &calcParser{c: c, tokens: tokens}
+	v, err := p.expr()
+	if err != nil {
+		return 0, err
+	}
+	if p.pos < len(tokens) {
+		return 0, c.errorAt(tokens[p.pos], "unexpected %v in expression", tokens[p.pos])
+	}
+	return v, nil
+}
+
+// calcParser parses a :calc expression
+type calcParser struct {
+	c      *compiler
+	tokens []token
+	pos    int
+}
+
+// expr parses term [operator expr]
+func (p *calcParser) expr() (float64, error) {
+	x, err := p.term()
+	if err != nil {
+		return 0, err
+	}
+	if p.pos == len(p.tokens) || p.tokens[p.pos].text == ")" {
+		return x, nil
+	}
+	op := p.tokens[p.pos]
+	f, ok := calcBinary[op.text]
+	if !ok {
+		return 0, p.c.errorAt(op, "unknown operator %v", op)
+	}
+	p.pos++
+	y, err := p.expr()
+	if err != nil {
+		return 0, err
+	}
+	return f(x, y), nil
+}
+
+// term parses a unary operator, parenthesised expression or value
+func (p *calcParser) term() (float64, error) {
+	if p.pos == len(p.tokens) {
+		return 0, p.c.errorAt(p.c.last, "missing value in expression")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+
+	if f, ok := calcUnary[t.text]; ok {
+		x, err := p.term()
+		if err != nil {
+			return 0, err
+		}
+		return f(x), nil
+	}
+	switch t.text {
+	case "(":
+		x, err := p.expr()
+		if err != nil {
+			return 0, err
+		}
+		if p.pos == len(p.tokens) || p.tokens[p.pos].text != ")" {
+			return 0, p.c.errorAt(t, "missing )")
+		}
+		p.pos++
+		return x, nil
+	case "@":
+		// A byte already compiled into the ROM
+		x, err := p.term()
+		if err != nil {
+			return 0, err
+		}
+		return float64(p.c.rom[int(x)&(len(p.c.rom)-1)]), nil
+	case "HERE":
+		return float64(p.c.here), nil
+	case "PI":
+		return math.Pi, nil
+	case "E":
+		return math.E, nil
+	}
+	if v, ok := parseNumber(t.text); ok {
+		return float64(v), nil
+	}
+	if v, ok := p.c.consts[t.text]; ok {
+		return v, nil
+	}
+	if v, ok := p.c.labels[t.text]; ok {
+		return float64(v), nil
+	}
+	return 0, p.c.errorAt(t, "undefined name %v in expression", t)
+}
@@ -0,0 +1,421 @@
+package octo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/symbols"
+)
+
+// maxExpansions limits macro expansions, to stop runaway recursion
+const maxExpansions = 10000
+
+// macro is a :macro definition
+type macro struct {
+	params []string
+	body   []token
+}
+
+// fixupKind is how a forward reference is patched
+type fixupKind int
+
+const (
+	fixAddr fixupKind = iota // 12-bit address in the low bits of an instruction
+	fixLong                  // 16-bit address
+	fixHigh                  // nibble and high 4 address bits of :unpack
+	fixLow                   // low address byte of :unpack
+)
+
+// fixup is a reference to a label not defined yet
+type fixup struct {
+	kind fixupKind
+	// at is the address to patch
+	at   int
+	name token
+	// nibble is the high nibble of a fixHigh byte
+	nibble int
+}
+
+// block is an open loop or if ... begin
+type block struct {
+	tok  token
+	loop bool
+	// start is the address a loop jumps back to
+	start int
+	// jumps are the jumps to patch to the end of the block
+	jumps   []int
+	hasElse bool
+}
+
+// compiler holds the state of a compilation
+type compiler struct {
+	mode   chip8.Mode
+	tokens []token
+	pos    int
+	// last is the last token read, for errors at the end of the input
+	last token
+
+	// here is the address of the next byte; end is the highest address
+	// written
+	here, end int
+	rom       []byte
+	written   []bool
+
+	labels  map[string]int
+	consts  map[string]float64
+	aliases map[string]int
+	macros  map[string]*macro
+	fixups  []fixup
+	blocks  []block
+
+	// started is set once the jump to main has been reserved, or main
+	// found first
+	started    bool
+	expansions int
+
+	syms *symbols.Map
+	// stmt is the first token of the current statement, and recorded is
+	// set once its line has been added to the symbol map
+	stmt     token
+	recorded bool
+}
+
+// errorAt returns an error at a token
+func (c *compiler) errorAt(t token, format string, args ...any) error {
+	return &Error{File: t.file, Line: t.line, Col: t.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// next returns the next token
+func (c *compiler) next() (token, error) {
+	if c.pos == len(c.tokens) {
+		return token{}, c.errorAt(c.last, "unexpected end of input")
+	}
+	t := c.tokens[c.pos]
+	c.pos++
+	c.last = t
+	return t, nil
+}
+
+// peek returns the next token's text, or "" at the end
+func (c *compiler) peek() string {
+	if c.pos == len(c.tokens) {
+		return ""
+	}
+	return c.tokens[c.pos].text
+}
+
+// expect reads a token with the given text
+func (c *compiler) expect(text string) error {
+	t, err := c.next()
+	if err != nil {
+		return err
+	}
+	if t.text != text {
+		return c.errorAt(t, "expected %q, got %v", text, t)
+	}
+	return nil
+}
+
+// compile compiles all statements and resolves forward references
+func (c *compiler) compile() error {
+	for c.pos < len(c.tokens) {
+		t, _ := c.next()
+		c.stmt, c.recorded = t, false
+		if err := c.statement(t); err != nil {
+			return err
+		}
+	}
+
+	if len(c.blocks) > 0 {
+		b := c.blocks[len(c.blocks)-1]
+		if b.loop {
+			return c.errorAt(b.tok, "loop without again")
+		}
+		return c.errorAt(b.tok, "if without end")
+	}
+
+	for _, f := range c.fixups {
+		addr, ok := c.labels[f.name.text]
+		if !ok {
+			if f.name.text == "main" && f.at == chip8.ProgramStart {
+				return c.errorAt(f.name, "program has no main label")
+			}
+			return c.errorAt(f.name, "undefined label %v", f.name)
+		}
+		switch f.kind {
+		case fixAddr:
+			if addr > 0xFFF {
+				return c.errorAt(f.name, "label %v at %04X is beyond the 12-bit address range", f.name, addr)
+			}
+			c.rom[f.at] |= byte(addr >> 8)
+			c.rom[f.at+1] = byte(addr)
+		case fixLong:
+			c.rom[f.at] = byte(addr >> 8)
+			c.rom[f.at+1] = byte(addr)
+		case fixHigh:
+			c.rom[f.at] = byte(f.nibble<<4 | addr>>8&0xF)
+		case fixLow:
+			c.rom[f.at] = byte(addr)
+		}
+	}
+	return nil
+}
+
+// prologue reserves the jump to main at the start of the program, before
+// the first code, data or label, unless that label is main itself
+func (c *compiler) prologue(label string) error {
+	if c.started {
+		return nil
+	}
+	c.started = true
+	if label == "main" {
+		return nil
+	}
+	c.fixups = append(c.fixups, fixup{kind: fixAddr, at: c.here, name: token{text: "main", file: c.stmt.file, line: 1, col: 1}})
+	return c.emit(0x10, 0x00)
+}
+
+// emit writes bytes at here
+func (c *compiler) emit(data ...byte) error {
+	if err := c.prologue(""); err != nil {
+		return err
+	}
+	if !c.recorded {
+		c.recorded = true
+		c.recordLine()
+	}
+	for _, b := range data {
+		if c.here >= len(c.rom) {
+			return c.errorAt(c.stmt, "program exceeds the %s address space of %d bytes", c.mode, len(c.rom))
+		}
+		if c.written[c.here] {
+			return c.errorAt(c.stmt, "overlaps code or data already at %03X", c.here)
+		}
+		c.rom[c.here] = b
+		c.written[c.here] = true
+		c.here++
+	}
+	c.end = max(c.end, c.here)
+	return nil
+}
+
+// recordLine maps the current statement's line to here, once per line
+func (c *compiler) recordLine() {
+	if n := len(c.syms.Lines); n > 0 {
+		if l := c.syms.Lines[n-1]; l.File == c.stmt.file && l.Line == c.stmt.line {
+			return
+		}
+	}
+	c.syms.Lines = append(c.syms.Lines, symbols.Line{File: c.stmt.file, Line: c.stmt.line, Addr: uint16(c.here)})
+}
+
+// op emits an instruction
+func (c *compiler) op(opcode uint16) error {
+	return c.emit(byte(opcode>>8), byte(opcode))
+}
+
+// need checks the mode has an instruction
+func (c *compiler) need(t token, mode chip8.Mode) error {
+	if c.mode < mode {
+		return c.errorAt(t, "%s needs %s mode", t.text, mode)
+	}
+	return nil
+}
+
+// define defines a label at addr
+func (c *compiler) define(name token, addr int) error {
+	if err := c.checkName(name); err != nil {
+		return err
+	}
+	c.labels[name.text] = addr
+	c.syms.Labels = append(c.syms.Labels, symbols.Label{Name: name.text, Addr: uint16(addr)})
+	return nil
+}
+
+// checkName checks a new name is valid and unused
+func (c *compiler) checkName(name token) error {
+	if _, ok := parseNumber(name.text); ok || strings.HasPrefix(name.text, ":") {
+		return c.errorAt(name, "invalid name %v", name)
+	}
+	if _, ok := register(name.text); ok {
+		return c.errorAt(name, "%v is a register", name)
+	}
+	_, isLabel := c.labels[name.text]
+	_, isConst := c.consts[name.text]
+	_, isAlias := c.aliases[name.text]
+	_, isMacro := c.macros[name.text]
+	if isLabel || isConst || isAlias || isMacro {
+		return c.errorAt(name, "%v is already defined", name)
+	}
+	return nil
+}
+
+// reg reads a register or register alias
+func (c *compiler) reg() (int, error) {
+	t, err := c.next()
+	if err != nil {
+		return 0, err
+	}
+	if r, ok := register(t.text); ok {
+		return r, nil
+	}
+	if r, ok := c.aliases[t.text]; ok {
+		return r, nil
+	}
+	return 0, c.errorAt(t, "expected a register, got %v", t)
+}
+
+// isReg reports whether s names a register or alias
+func (c *compiler) isReg(s string) bool {
+	if _, ok := register(s); ok {
+		return true
+	}
+	_, ok := c.aliases[s]
+	return ok
+}
+
+// lookup returns the value of a number, constant or defined label
+func (c *compiler) lookup(t token) (int, bool) {
+	if v, ok := parseNumber(t.text); ok {
+		return v, true
+	}
+	if v, ok := c.consts[t.text]; ok {
+		return int(v), true
+	}
+	if v, ok := c.labels[t.text]; ok {
+		return v, true
+	}
+	return 0, false
+}
+
+// value reads a number or name whose value is in lo..hi
+func (c *compiler) value(lo, hi int) (int, error) {
+	t, err := c.next()
+	if err != nil {
+		return 0, err
+	}
+	v, ok := c.lookup(t)
+	if !ok {
+		return 0, c.errorAt(t, "undefined name %v", t)
+	}
+	if v < lo || v > hi {
+		return 0, c.errorAt(t, "value %d out of range %d to %d", v, lo, hi)
+	}
+	return v, nil
+}
+
+// byteValue reads a byte; negative values are two's complement
+func (c *compiler) byteValue() (int, error) {
+	v, err := c.value(-128, 255)
+	return v & 0xFF, err
+}
+
+// addr reads an address to patch in at at
+func (c *compiler) addr(kind fixupKind, at int) (int, error) {
+	t, err := c.next()
+	if err != nil {
+		return 0, err
+	}
+	return c.refer(t, kind, at)
+}
+
+// refer returns the address named by t, recording a fixup to patch in at
+// at if it is a label defined later
+func (c *compiler) refer(t token, kind fixupKind, at int) (int, error) {
+	hi := 0xFFF
+	if kind == fixLong {
+		hi = 0xFFFF
+	}
+	if v, ok := c.lookup(t); ok {
+		if v < 0 || v > hi {
+			return 0, c.errorAt(t, "address %d out of range 0 to %d", v, hi)
+		}
+		return v, nil
+	}
+	if err := c.checkName(t); err != nil {
+		return 0, err
+	}
+	c.fixups = append(c.fixups, fixup{kind: kind, at: at, name: t})
+	return 0, nil
+}
+
+// block returns the innermost open block
+func (c *compiler) block() *block {
+	if len(c.blocks) == 0 {
+		return nil
+	}
+	return &c.blocks[len(c.blocks)-1]
+}
+
+// loopBlock returns the innermost open loop
+func (c *compiler) loopBlock() *block {
+	for i := len(c.blocks) - 1; i >= 0; i-- {
+		if c.blocks[i].loop {
+			return &c.blocks[i]
+		}
+	}
+	return nil
+}
+
+// patchJump points the jump at at to target
+func (c *compiler) patchJump(at int, t token, target int) error {
+	if target > 0xFFF {
+		return c.errorAt(t, "jump target %04X is beyond the 12-bit address range", target)
+	}
+	c.rom[at] = 0x10 | byte(target>>8)
+	c.rom[at+1] = byte(target)
+	return nil
+}
+
+// braced reads the tokens between { and the matching }
+func (c *compiler) braced() ([]token, error) {
+	if err := c.expect("{"); err != nil {
+		return nil, err
+	}
+	var body []token
+	depth := 1
+	for {
+		t, err := c.next()
+		if err != nil {
+			return nil, err
+		}
+		switch t.text {
+		case "{":
+			depth++
+		case "}":
+			depth--
+			if depth == 0 {
+				return body, nil
+			}
+		}
+		body = append(body, t)
+	}
+}
+
+// expand inserts the body of a macro invocation into the input
+func (c *compiler) expand(t token, m *macro) error {
+	c.expansions++
+	if c.expansions > maxExpansions {
+		return c.errorAt(t, "too many macro expansions; is %v recursive?", t)
+	}
+	args := make(map[string]token)
+	for _, p := range m.params {
+		a, err := c.next()
+		if err != nil {
+			return err
+		}
+		args[p] = a
+	}
+
+	body := make([]token, 0, len(m.body)+len(c.tokens)-c.pos)
+	for _, bt := range m.body {
+		if a, ok := args[bt.text]; ok {
+			bt.text = a.text
+		}
+		body = append(body, bt)
+	}
+	c.tokens = append(body, c.tokens[c.pos:]...)
+	c.pos = 0
+	return nil
+}
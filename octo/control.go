@@ -0,0 +1,133 @@
+package octo
+
+// condition is the test of an if or while
+type condition struct {
+	tok token
+	x   int
+	op  string
+	// y is a register if isReg, else a byte
+	y     int
+	isReg bool
+}
+
+// condition parses vx op vy, vx op n, vx key or vx -key
+func (c *compiler) condition() (condition, error) {
+	x, err := c.reg()
+	if err != nil {
+		return condition{}, err
+	}
+	op, err := c.next()
+	if err != nil {
+		return condition{}, err
+	}
+	cond := condition{tok: op, x: x, op: op.text}
+	switch op.text {
+	case "key", "-key":
+		return cond, nil
+	case "==", "!=", "<", ">", "<=", ">=":
+	default:
+		return condition{}, c.errorAt(op, "expected a comparison, got %v", op)
+	}
+	if c.isReg(c.peek()) {
+		cond.y, _ = c.reg()
+		cond.isReg = true
+		return cond, nil
+	}
+	cond.y, err = c.byteValue()
+	return cond, err
+}
+
+// test emits the instructions that skip the next one unless the
+// condition holds, or if it holds when negate is set. The comparisons
+// <, >, <= and >= subtract into vf, whose borrow flag is the result.
+func (c *compiler) test(cond condition, negate bool) error {
+	x, y := uint16(cond.x), uint16(cond.y)
+	switch cond.op {
+	case "==", "!=":
+		eq := (cond.op == "==") != negate
+		switch {
+		case cond.isReg && eq:
+			return c.op(0x9000 | x<<8 | y<<4)
+		case cond.isReg:
+			return c.op(0x5000 | x<<8 | y<<4)
+		case eq:
+			return c.op(0x4000 | x<<8 | y)
+		default:
+			return c.op(0x3000 | x<<8 | y)
+		}
+	case "key", "-key":
+		if (cond.op == "key") != negate {
+			return c.op(0xE0A1 | x<<8)
+		}
+		return c.op(0xE09E | x<<8)
+	}
+
+	// vf gets the flag of x >= y for < and >=, or y >= x for > and <=
+	swap := cond.op == ">" || cond.op == "<="
+	var setup [2]uint16
+	switch {
+	case cond.isReg && !swap:
+		setup = [2]uint16{0x8F00 | x<<4, 0x8F05 | y<<4}
+	case cond.isReg:
+		setup = [2]uint16{0x8F00 | y<<4, 0x8F05 | x<<4}
+	case !swap:
+		setup = [2]uint16{0x6F00 | y, 0x8F07 | x<<4}
+	default:
+		setup = [2]uint16{0x6F00 | y, 0x8F05 | x<<4}
+	}
+	for _, op := range setup {
+		if err := c.op(op); err != nil {
+			return err
+		}
+	}
+	// < and > hold when the flag is clear
+	if (cond.op == "<" || cond.op == ">") != negate {
+		return c.op(0x4F00)
+	}
+	return c.op(0x3F00)
+}
+
+// ifStatement compiles if ... then statement and if ... begin
+func (c *compiler) ifStatement(t token) error {
+	cond, err := c.condition()
+	if err != nil {
+		return err
+	}
+	kw, err := c.next()
+	if err != nil {
+		return err
+	}
+	switch kw.text {
+	case "then":
+		// As in Octo, the skip passes over whatever instruction comes next
+		return c.test(cond, false)
+	case "begin":
+		if err := c.test(cond, true); err != nil {
+			return err
+		}
+		if err := c.op(0x1000); err != nil {
+			return err
+		}
+		c.blocks = append(c.blocks, block{tok: t, jumps: []int{c.here - 2}})
+		return nil
+	}
+	return c.errorAt(kw, "expected then or begin, got %v", kw)
+}
+
+// elseStatement compiles the else of if ... begin, jumping from the end of
+// the if part to the end and pointing the if's jump here
+func (c *compiler) elseStatement(t token) error {
+	b := c.block()
+	if b == nil || b.loop || b.hasElse {
+		return c.errorAt(t, "else without if ... begin")
+	}
+	if err := c.op(0x1000); err != nil {
+		return err
+	}
+	if err := c.patchJump(b.jumps[0], t, c.here); err != nil {
+		return err
+	}
+	b.jumps = []int{c.here - 2}
+	b.hasElse = true
+	return nil
+}
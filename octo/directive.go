@@ -0,0 +1,122 @@
+package octo
+
+// alias compiles :alias name register; an alias may be redefined
+func (c *compiler) alias() error {
+	name, err := c.next()
+	if err != nil {
+		return err
+	}
+	if _, ok := c.aliases[name.text]; !ok {
+		if err := c.checkName(name); err != nil {
+			return err
+		}
+	}
+	r, err := c.reg()
+	if err != nil {
+		return err
+	}
+	c.aliases[name.text] = r
+	return nil
+}
+
+// constant compiles :const name value
+func (c *compiler) constant() error {
+	name, err := c.next()
+	if err != nil {
+		return err
+	}
+	if err := c.checkName(name); err != nil {
+		return err
+	}
+	t, err := c.next()
+	if err != nil {
+		return err
+	}
+	v, ok := c.lookup(t)
+	if !ok {
+		return c.errorAt(t, "undefined name %v", t)
+	}
+	c.consts[name.text] = float64(v)
+	return nil
+}
+
+// calcStatement compiles :calc name { expression }; unlike :const, a
+// :calc constant may be recalculated
+func (c *compiler) calcStatement() error {
+	name, err := c.next()
+	if err != nil {
+		return err
+	}
+	if _, ok := c.consts[name.text]; !ok {
+		if err := c.checkName(name); err != nil {
+			return err
+		}
+	}
+	body, err := c.braced()
+	if err != nil {
+		return err
+	}
+	v, err := c.calc(body)
+	if err != nil {
+		return err
+	}
+	c.consts[name.text] = v
+	return nil
+}
+
+// macro compiles :macro name params... { body }
+func (c *compiler) macro() error {
+	name, err := c.next()
+	if err != nil {
+		return err
+	}
+	if err := c.checkName(name); err != nil {
+		return err
+	}
+	m := &macro{}
+	for c.peek() != "{" {
+		p, err := c.next()
+		if err != nil {
+			return err
+		}
+		m.params = append(m.params, p.text)
+	}
+	if m.body, err = c.braced(); err != nil {
+		return err
+	}
+	c.macros[name.text] = m
+	return nil
+}
+
+// unpack compiles :unpack nibble label, which loads v0 with the nibble and
+// the high 4 bits of the label's address and v1 with the low byte
+func (c *compiler) unpack() error {
+	nibble, err := c.value(0, 15)
+	if err != nil {
+		return err
+	}
+	if err := c.op(0x6000); err != nil {
+		return err
+	}
+	if err := c.op(0x6100); err != nil {
+		return err
+	}
+	hi, lo := c.here-3, c.here-1
+
+	t, err := c.next()
+	if err != nil {
+		return err
+	}
+	if a, ok := c.lookup(t); ok {
+		c.rom[hi] = byte(nibble<<4 | a>>8&0xF)
+		c.rom[lo] = byte(a)
+		return nil
+	}
+	if err := c.checkName(t); err != nil {
+		return err
+	}
+	c.fixups = append(c.fixups,
+		fixup{kind: fixHigh, at: hi, name: t, nibble: nibble},
+		fixup{kind: fixLow, at: lo, name: t})
+	return nil
+}
@@ -0,0 +1,77 @@
+package octo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// token is a whitespace-separated word of Octo source
+type token struct {
+	text string
+	file string
+	line int
+	col  int
+}
+
+// lex splits source into tokens, dropping # comments
+func lex(file, src string) []token {
+	var tokens []token
+	for i, line := range strings.Split(src, "\n") {
+		for col := 0; col < len(line); {
+			c := line[col]
+			switch {
+			case c == ' ' || c == '\t' || c == '\r':
+				col++
+				continue
+			case c == '#':
+				col = len(line)
+				continue
+			}
+			start := col
+			for col < len(line) && !strings.ContainsRune(" \t\r", rune(line[col])) {
+				col++
+			}
+			tokens = append(tokens, token{text: line[start:col], file: file, line: i + 1, col: start + 1})
+		}
+	}
+	return tokens
+}
+
+// parseNumber parses a decimal, 0x hex or 0b binary number with an
+// optional minus sign
+func parseNumber(s string) (int, bool) {
+	neg := strings.HasPrefix(s, "-")
+	digits, base := strings.TrimPrefix(s, "-"), 10
+	switch {
+	case strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X"):
+		digits, base = digits[2:], 16
+	case strings.HasPrefix(digits, "0b") || strings.HasPrefix(digits, "0B"):
+		digits, base = digits[2:], 2
+	}
+	v, err := strconv.ParseInt(digits, base, 32)
+	if err != nil || digits == "" {
+		return 0, false
+	}
+	if neg {
+		v = -v
+	}
+	return int(v), true
+}
+
+// register parses a register name v0-vf
+func register(s string) (int, bool) {
+	if len(s) != 2 || (s[0] != 'v' && s[0] != 'V') {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 4)
+	if err != nil {
+		return 0, false
+	}
+	return int(v), true
+}
+
+// String describes the token for error messages
+func (t token) String() string {
+	return fmt.Sprintf("%q", t.text)
+}
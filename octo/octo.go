@@ -0,0 +1,80 @@
+// Package octo compiles Octo, the high-level CHIP-8 assembly language, into
+// ROM bytes and a symbol map.
+//
+// It supports the instructions and pseudo-instructions of Octo, labels,
+// :alias, :const, :calc, :macro, :next, :org, :unpack, :byte and :call,
+// loop/again/while and if/then and if/begin/else/end. As in Octo, code
+// starts with a jump to main unless main is the first thing in the program.
+package octo
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/symbols"
+)
+
+// Ext is the file extension of Octo source
+const Ext = ".8o"
+
+// Error is a compile error at a source position
+type Error struct {
+	File      string
+	Line, Col int
+	Msg       string
+}
+
+// Error returns the error as file:line:col: message
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Col, e.Msg)
+}
+
+// Options configure the compiler
+type Options struct {
+	// Mode is the instruction set; instructions of later modes are errors
+	Mode chip8.Mode
+}
+
+// Result is a compiled program
+type Result struct {
+	// ROM is the program, loaded at chip8.ProgramStart
+	ROM []byte
+	// Symbols has the labels and the address of each source line
+	Symbols *symbols.Map
+}
+
+// Compile compiles src, naming it filename in errors and symbols
+func Compile(filename string, src []byte, opts Options) (*Result, error) {
+	size := chip8.MemorySize
+	if opts.Mode == chip8.ModeXOCHIP {
+		size = chip8.ExtendedMemorySize
+	}
+	c := &compiler{
+		mode:    opts.Mode,
+		tokens:  lex(filename, string(src)),
+		here:    chip8.ProgramStart,
+		end:     chip8.ProgramStart,
+		rom:     make([]byte, size),
+		written: make([]bool, size),
+		labels:  make(map[string]int),
+		consts:  make(map[string]float64),
+		aliases: make(map[string]int),
+		macros:  make(map[string]*macro),
+		syms:    &symbols.Map{},
+		last:    token{file: filename, line: 1, col: 1},
+	}
+	if err := c.compile(); err != nil {
+		return nil, err
+	}
+	return &Result{ROM: c.rom[chip8.ProgramStart:c.end], Symbols: c.syms}, nil
+}
+
+// CompileFile compiles the file at path
+func CompileFile(path string, opts Options) (*Result, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Compile(path, src, opts)
+}
@@ -0,0 +1,141 @@
+package octo
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/disasm"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		mode chip8.Mode
+		want []byte
+	}{
+		{"jump to main", ": sub ; : main sub", chip8.ModeCHIP8,
+			[]byte{0x12, 0x04, 0x00, 0xEE, 0x22, 0x02}},
+		{"main first", ": main v0 := 5 v0 += v1 v0 -= 1 v2 =- v3 i := main", chip8.ModeCHIP8,
+			[]byte{0x60, 0x05, 0x80, 0x14, 0x70, 0xFF, 0x82, 0x37, 0xA2, 0x00}},
+		{"if then", ": main if v1 == 3 then v2 := key if v1 != v2 then jump main", chip8.ModeCHIP8,
+			[]byte{0x41, 0x03, 0xF2, 0x0A, 0x51, 0x20, 0x12, 0x00}},
+		{"comparison", ": main if v1 < 3 then clear if v1 >= v2 then clear", chip8.ModeCHIP8,
+			[]byte{0x6F, 0x03, 0x8F, 0x17, 0x4F, 0x00, 0x00, 0xE0, 0x8F, 0x10, 0x8F, 0x25, 0x3F, 0x00, 0x00, 0xE0}},
+		{"if else", ": main if v0 key begin v1 := 1 else v1 := 2 end", chip8.ModeCHIP8,
+			[]byte{0xE0, 0x9E, 0x12, 0x08, 0x61, 0x01, 0x12, 0x0A, 0x61, 0x02}},
+		{"loop", ": main loop v0 += 1 while v0 != 10 again", chip8.ModeCHIP8,
+			[]byte{0x70, 0x01, 0x40, 0x0A, 0x12, 0x08, 0x12, 0x00}},
+		{"alias const calc", ":alias x v3 :const N 7 :calc M { N * 2 + 1 } : main x := M x += N", chip8.ModeCHIP8,
+			[]byte{0x63, 0x15, 0x73, 0x07}},
+		{"macro", ":macro twice r { r += r r += r } : main twice v4", chip8.ModeCHIP8,
+			[]byte{0x84, 0x44, 0x84, 0x44}},
+		{"next", ": main :next n v0 := 0 n", chip8.ModeCHIP8,
+			[]byte{0x60, 0x00, 0x22, 0x01}},
+		{"org and data", ": main jump data :org 0x210 : data 1 2 :byte { 3 + 4 } :byte -1", chip8.ModeCHIP8,
+			append(append([]byte{0x12, 0x10}, make([]byte, 14)...), 1, 2, 7, 0xFF)},
+		{"unpack", ": main :unpack 0xA data : data 0xFF", chip8.ModeCHIP8,
+			[]byte{0x60, 0xA2, 0x61, 0x04, 0xFF}},
+		{"schip", ": main hires scroll-down 3 i := bighex v1 saveflags v7", chip8.ModeSCHIP,
+			[]byte{0x00, 0xFF, 0x00, 0xC3, 0xF1, 0x30, 0xF7, 0x75}},
+		{"xochip", ": main plane 3 save v1 - v4 if v0 == 0 then i := long data : data", chip8.ModeXOCHIP,
+			[]byte{0xF3, 0x01, 0x51, 0x42, 0x40, 0x00, 0xF0, 0x00, 0x02, 0x0A}},
+	}
+	for _, tt := range tests {
+		res, err := Compile("test.8o", []byte(tt.src), Options{Mode: tt.mode})
+		if err != nil {
+			t.Errorf("%s: Compile failed: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(res.ROM, tt.want) {
+			t.Errorf("%s: ROM = % X\nwant  % X", tt.name, res.ROM, tt.want)
+		}
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	src := ": main\n  v0 := 1\n  draw\n\n: draw\n  sprite v0 v0 5 ;\n"
+	res, err := Compile("game.8o", []byte(src), Options{})
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if addr, ok := res.Symbols.Lookup("draw"); !ok || addr != 0x204 {
+		t.Errorf("Symbol draw = %03X, %v", addr, ok)
+	}
+	if addrs := res.Symbols.AddrsAt("game.8o", 6); len(addrs) != 1 || addrs[0] != 0x204 {
+		t.Errorf("Line 6 should map to 204, got %v", addrs)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		mode chip8.Mode
+		want string
+	}{
+		{"v0 := 1", chip8.ModeCHIP8, "x.8o:1:1: program has no main label"},
+		{": main\n  jump nowhere", chip8.ModeCHIP8, `x.8o:2:8: undefined label "nowhere"`},
+		{": main v0 := 300", chip8.ModeCHIP8, "x.8o:1:14: value 300 out of range -128 to 255"},
+		{": main hires", chip8.ModeCHIP8, "x.8o:1:8: hires needs schip mode"},
+		{": main : main", chip8.ModeCHIP8, `x.8o:1:10: "main" is already defined`},
+		{": main loop v0 += 1", chip8.ModeCHIP8, "x.8o:1:8: loop without again"},
+		{": main v0 >>= 1", chip8.ModeCHIP8, `x.8o:1:15: >>= needs a register, got "1"`},
+		{": main :calc X { 1 + }", chip8.ModeCHIP8, "x.8o:1:22: missing value in expression"},
+		{":macro m { m } : main m", chip8.ModeCHIP8, `x.8o:1:12: too many macro expansions; is "m" recursive?`},
+		{": main clear :org 0x200 clear", chip8.ModeCHIP8, "x.8o:1:25: overlaps code or data already at 200"},
+	}
+	for _, tt := range tests {
+		_, err := Compile("x.8o", []byte(tt.src), Options{Mode: tt.mode})
+		var e *Error
+		if !errors.As(err, &e) || e.Error() != tt.want {
+			t.Errorf("Compile(%q) error = %v, want %s", tt.src, err, tt.want)
+		}
+	}
+}
+
+// TestDisassemblerRoundTrip checks that ROMs disassembled in Octo syntax
+// compile back to the same bytes
+func TestDisassemblerRoundTrip(t *testing.T) {
+	maze, err := os.ReadFile("../roms/maze.ch8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roms := [][]byte{maze}
+	// Every opcode, split into ROMs that fit the CHIP-8 address space
+	for base := 0; base < 0x10000; base += 0x400 {
+		var rom []byte
+		for op := base; op < base+0x400; op++ {
+			rom = append(rom, byte(op>>8), byte(op))
+			if op == 0xF000 {
+				rom = append(rom, 0x12, 0x34)
+			}
+		}
+		roms = append(roms, rom)
+	}
+
+	for _, rom := range roms {
+		p, err := disasm.Linear(rom, chip8.ModeXOCHIP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var src strings.Builder
+		if err := p.Write(&src, disasm.SyntaxOcto); err != nil {
+			t.Fatal(err)
+		}
+		res, err := Compile("rt.8o", []byte(src.String()), Options{Mode: chip8.ModeXOCHIP})
+		if err != nil {
+			t.Fatalf("Compiling the listing of % X failed: %v", rom[:4], err)
+		}
+		if !bytes.Equal(res.ROM, rom) {
+			for i := range rom {
+				if i >= len(res.ROM) || res.ROM[i] != rom[i] {
+					t.Fatalf("Mismatch at %03X in the listing of % X", chip8.ProgramStart+i, rom[:4])
+				}
+			}
+		}
+	}
+}
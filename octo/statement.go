@@ -0,0 +1,436 @@
+package octo
+
+import (
+	"github.com/chip8-emulator/chip8"
+)
+
+// simple are the instructions without operands
+var simple = map[string]struct {
+	opcode uint16
+	mode   chip8.Mode
+}{
+	"clear":        {0x00E0, chip8.ModeCHIP8},
+	"return":       {0x00EE, chip8.ModeCHIP8},
+	";":            {0x00EE, chip8.ModeCHIP8},
+	"scroll-right": {0x00FB, chip8.ModeSCHIP},
+	"scroll-left":  {0x00FC, chip8.ModeSCHIP},
+	"exit":         {0x00FD, chip8.ModeSCHIP},
+	"lores":        {0x00FE, chip8.ModeSCHIP},
+	"hires":        {0x00FF, chip8.ModeSCHIP},
+	"audio":        {0xF002, chip8.ModeXOCHIP},
+}
+
+// regOps are the instructions taking one register as FX00 | opcode
+var regOps = map[string]struct {
+	opcode uint16
+	mode   chip8.Mode
+}{
+	"bcd":       {0xF033, chip8.ModeCHIP8},
+	"saveflags": {0xF075, chip8.ModeSCHIP},
+	"loadflags": {0xF085, chip8.ModeSCHIP},
+}
+
+// aluOps are the register to register assignments 8XYN
+var aluOps = map[string]uint16{
+	":=":  0x0,
+	"|=":  0x1,
+	"&=":  0x2,
+	"^=":  0x3,
+	"+=":  0x4,
+	"-=":  0x5,
+	">>=": 0x6,
+	"=-":  0x7,
+	"<<=": 0xE,
+}
+
+// statement compiles the statement starting with t
+func (c *compiler) statement(t token) error {
+	if s, ok := simple[t.text]; ok {
+		if err := c.need(t, s.mode); err != nil {
+			return err
+		}
+		return c.op(s.opcode)
+	}
+	if r, ok := regOps[t.text]; ok {
+		if err := c.need(t, r.mode); err != nil {
+			return err
+		}
+		x, err := c.reg()
+		if err != nil {
+			return err
+		}
+		return c.op(r.opcode | uint16(x)<<8)
+	}
+
+	switch t.text {
+	case ":":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		if err := c.prologue(name.text); err != nil {
+			return err
+		}
+		return c.define(name, c.here)
+	case ":next":
+		name, err := c.next()
+		if err != nil {
+			return err
+		}
+		if err := c.prologue(""); err != nil {
+			return err
+		}
+		return c.define(name, c.here+1)
+	case ":alias":
+		return c.alias()
+	case ":const":
+		return c.constant()
+	case ":calc":
+		return c.calcStatement()
+	case ":macro":
+		return c.macro()
+	case ":org":
+		v, err := c.value(0, len(c.rom)-1)
+		if err != nil {
+			return err
+		}
+		if err := c.prologue(""); err != nil {
+			return err
+		}
+		c.here = v
+		return nil
+	case ":byte":
+		if c.peek() == "{" {
+			body, err := c.braced()
+			if err != nil {
+				return err
+			}
+			v, err := c.calc(body)
+			if err != nil {
+				return err
+			}
+			return c.emit(byte(int(v)))
+		}
+		v, err := c.byteValue()
+		if err != nil {
+			return err
+		}
+		return c.emit(byte(v))
+	case ":pointer":
+		if err := c.need(t, chip8.ModeXOCHIP); err != nil {
+			return err
+		}
+		return c.addrOp(0x0000, fixLong)
+	case ":call":
+		return c.addrOp(0x2000, fixAddr)
+	case ":unpack":
+		return c.unpack()
+	case ":proto", ":breakpoint":
+		// Debugger hints with one operand; the symbol map has the labels
+		_, err := c.next()
+		return err
+	case ":monitor":
+		if _, err := c.next(); err != nil {
+			return err
+		}
+		_, err := c.next()
+		return err
+	case "jump":
+		return c.addrOp(0x1000, fixAddr)
+	case "jump0":
+		return c.addrOp(0xB000, fixAddr)
+	case "native":
+		return c.addrOp(0x0000, fixAddr)
+	case "scroll-down", "scroll-up":
+		mode, opcode := chip8.ModeSCHIP, uint16(0x00C0)
+		if t.text == "scroll-up" {
+			mode, opcode = chip8.ModeXOCHIP, 0x00D0
+		}
+		if err := c.need(t, mode); err != nil {
+			return err
+		}
+		n, err := c.value(0, 15)
+		if err != nil {
+			return err
+		}
+		return c.op(opcode | uint16(n))
+	case "plane":
+		if err := c.need(t, chip8.ModeXOCHIP); err != nil {
+			return err
+		}
+		n, err := c.value(0, 15)
+		if err != nil {
+			return err
+		}
+		return c.op(0xF001 | uint16(n)<<8)
+	case "save", "load":
+		return c.saveLoad(t)
+	case "sprite":
+		x, err := c.reg()
+		if err != nil {
+			return err
+		}
+		y, err := c.reg()
+		if err != nil {
+			return err
+		}
+		n, err := c.value(0, 15)
+		if err != nil {
+			return err
+		}
+		return c.op(0xD000 | uint16(x)<<8 | uint16(y)<<4 | uint16(n))
+	case "i":
+		return c.assignI()
+	case "delay", "buzzer", "pitch":
+		if t.text == "pitch" {
+			if err := c.need(t, chip8.ModeXOCHIP); err != nil {
+				return err
+			}
+		}
+		if err := c.expect(":="); err != nil {
+			return err
+		}
+		x, err := c.reg()
+		if err != nil {
+			return err
+		}
+		opcode := map[string]uint16{"delay": 0xF015, "buzzer": 0xF018, "pitch": 0xF03A}[t.text]
+		return c.op(opcode | uint16(x)<<8)
+	case "if":
+		return c.ifStatement(t)
+	case "else":
+		return c.elseStatement(t)
+	case "end":
+		b := c.block()
+		if b == nil || b.loop {
+			return c.errorAt(t, "end without if ... begin")
+		}
+		for _, at := range b.jumps {
+			if err := c.patchJump(at, t, c.here); err != nil {
+				return err
+			}
+		}
+		c.blocks = c.blocks[:len(c.blocks)-1]
+		return nil
+	case "loop":
+		if err := c.prologue(""); err != nil {
+			return err
+		}
+		c.blocks = append(c.blocks, block{tok: t, loop: true, start: c.here})
+		return nil
+	case "while":
+		b := c.loopBlock()
+		if b == nil {
+			return c.errorAt(t, "while outside a loop")
+		}
+		cond, err := c.condition()
+		if err != nil {
+			return err
+		}
+		// Skip the jump out of the loop while the condition holds
+		if err := c.test(cond, true); err != nil {
+			return err
+		}
+		if err := c.op(0x1000); err != nil {
+			return err
+		}
+		b.jumps = append(b.jumps, c.here-2)
+		return nil
+	case "again":
+		b := c.block()
+		if b == nil || !b.loop {
+			return c.errorAt(t, "again without loop")
+		}
+		if err := c.op(0x1000 | uint16(b.start)); err != nil {
+			return err
+		}
+		for _, at := range b.jumps {
+			if err := c.patchJump(at, t, c.here); err != nil {
+				return err
+			}
+		}
+		c.blocks = c.blocks[:len(c.blocks)-1]
+		return nil
+	}
+
+	if m, ok := c.macros[t.text]; ok {
+		return c.expand(t, m)
+	}
+	if x, ok := register(t.text); ok {
+		return c.assign(x)
+	}
+	if x, ok := c.aliases[t.text]; ok {
+		return c.assign(x)
+	}
+	if v, ok := parseNumber(t.text); ok {
+		return c.emitByte(t, v)
+	}
+	if v, ok := c.consts[t.text]; ok {
+		return c.emitByte(t, int(v))
+	}
+
+	// Any other name calls a subroutine, which may be defined later
+	c.pos--
+	return c.addrOp(0x2000, fixAddr)
+}
+
+// emitByte emits a data byte
+func (c *compiler) emitByte(t token, v int) error {
+	if v < -128 || v > 255 {
+		return c.errorAt(t, "byte %d out of range -128 to 255", v)
+	}
+	return c.emit(byte(v))
+}
+
+// addrOp emits an instruction with an address operand. A fixLong address
+// is a word of its own, after the opcode unless that is 0.
+func (c *compiler) addrOp(opcode uint16, kind fixupKind) error {
+	if kind == fixLong && opcode != 0 {
+		if err := c.op(opcode); err != nil {
+			return err
+		}
+		opcode = 0
+	}
+	if err := c.op(opcode); err != nil {
+		return err
+	}
+	at := c.here - 2
+	a, err := c.addr(kind, at)
+	if err != nil {
+		return err
+	}
+	c.rom[at] |= byte(a >> 8)
+	c.rom[at+1] = byte(a)
+	return nil
+}
+
+// assign compiles an assignment to register vx
+func (c *compiler) assign(x int) error {
+	op, err := c.next()
+	if err != nil {
+		return err
+	}
+	alu, ok := aluOps[op.text]
+	if !ok {
+		return c.errorAt(op, "expected an assignment operator, got %v", op)
+	}
+	vx := uint16(x) << 8
+
+	if op.text == ":=" {
+		switch c.peek() {
+		case "random":
+			c.next()
+			n, err := c.byteValue()
+			if err != nil {
+				return err
+			}
+			return c.op(0xC000 | vx | uint16(n))
+		case "key":
+			c.next()
+			return c.op(0xF00A | vx)
+		case "delay":
+			c.next()
+			return c.op(0xF007 | vx)
+		}
+	}
+	if c.isReg(c.peek()) {
+		y, err := c.reg()
+		if err != nil {
+			return err
+		}
+		return c.op(0x8000 | vx | uint16(y)<<4 | alu)
+	}
+
+	switch op.text {
+	case ":=", "+=", "-=":
+		n, err := c.byteValue()
+		if err != nil {
+			return err
+		}
+		switch op.text {
+		case ":=":
+			return c.op(0x6000 | vx | uint16(n))
+		case "+=":
+			return c.op(0x7000 | vx | uint16(n))
+		default:
+			return c.op(0x7000 | vx | uint16(-n&0xFF))
+		}
+	}
+	t, err := c.next()
+	if err != nil {
+		return err
+	}
+	return c.errorAt(t, "%s needs a register, got %v", op.text, t)
+}
+
+// assignI compiles an assignment to i
+func (c *compiler) assignI() error {
+	op, err := c.next()
+	if err != nil {
+		return err
+	}
+	switch op.text {
+	case "+=":
+		x, err := c.reg()
+		if err != nil {
+			return err
+		}
+		return c.op(0xF01E | uint16(x)<<8)
+	case ":=":
+	default:
+		return c.errorAt(op, "expected := or += after i, got %v", op)
+	}
+
+	switch c.peek() {
+	case "hex", "bighex":
+		t, _ := c.next()
+		opcode := uint16(0xF029)
+		if t.text == "bighex" {
+			if err := c.need(t, chip8.ModeSCHIP); err != nil {
+				return err
+			}
+			opcode = 0xF030
+		}
+		x, err := c.reg()
+		if err != nil {
+			return err
+		}
+		return c.op(opcode | uint16(x)<<8)
+	case "long":
+		t, _ := c.next()
+		if err := c.need(t, chip8.ModeXOCHIP); err != nil {
+			return err
+		}
+		return c.addrOp(0xF000, fixLong)
+	}
+	return c.addrOp(0xA000, fixAddr)
+}
+
+// saveLoad compiles save and load of v0-vx, or of vx-vy in XO-CHIP
+func (c *compiler) saveLoad(t token) error {
+	x, err := c.reg()
+	if err != nil {
+		return err
+	}
+	if c.peek() != "-" {
+		opcode := uint16(0xF055)
+		if t.text == "load" {
+			opcode = 0xF065
+		}
+		return c.op(opcode | uint16(x)<<8)
+	}
+
+	c.next()
+	if err := c.need(t, chip8.ModeXOCHIP); err != nil {
+		return err
+	}
+	y, err := c.reg()
+	if err != nil {
+		return err
+	}
+	opcode := uint16(0x5002)
+	if t.text == "load" {
+		opcode = 0x5003
+	}
+	return c.op(opcode | uint16(x)<<8 | uint16(y)<<4)
+}
@@ -0,0 +1,312 @@
+package platform
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/debugger"
+)
+
+const (
+	// FrameRate is the rate frames run at, that of the 60 Hz timers
+	FrameRate = 60
+	// MaxFrameLag is the frames Run may fall behind before skipping ahead
+	MaxFrameLag = 5
+)
+
+// Loop runs a VM against a platform: it handles input events, runs a frame
+// of instructions at a time, plays the sound timer and shows the display
+// when it changes. Run paces the frames in real time; frontends with their
+// own clock, such as a browser's animation frames or a test, call Step
+// instead.
+type Loop struct {
+	VM *chip8.CHIP8
+	// ROM is reloaded into the VM on reset
+	ROM []byte
+	Platform
+	// Speed is the instructions run per second
+	Speed int
+	// Title is shown by a Titler, with the state appended while paused
+	Title string
+
+	// Debugger, if set, owns the VM: frames run through it so they stop at
+	// breakpoints, errors stop execution instead of the loop, and pausing
+	// drops into the debugger
+	Debugger *debugger.Debugger
+	// Rewinder, if set, records every frame, and rewinding steps back
+	// through it
+	Rewinder *chip8.Rewinder
+	// StatePath, if set, returns the file of a save state slot
+	StatePath func(slot int) string
+	// Done, if set, ends the loop when closed, such as when a debugger
+	// session ends
+	Done <-chan struct{}
+	// Log receives status and error messages; nil means os.Stderr
+	Log io.Writer
+
+	paused    bool
+	rewinding bool
+	// last is the result of the last frame run
+	last chip8.FrameResult
+	// budget carries instructions over from frame to frame so the
+	// average speed matches Speed exactly
+	budget int
+}
+
+// Do runs fn with the VM, through the debugger if there is one. The VM
+// must only be touched through Do while a debugger frontend is running.
+func (l *Loop) Do(fn func()) {
+	if l.Debugger == nil {
+		fn()
+		return
+	}
+	l.Debugger.Do(func(*chip8.CHIP8) { fn() })
+}
+
+// Paused reports whether the loop is paused
+func (l *Loop) Paused() bool {
+	return l.paused
+}
+
+// LastFrame returns the result of the last frame run, which is zero after
+// a rewind step
+func (l *Loop) LastFrame() chip8.FrameResult {
+	return l.last
+}
+
+// logf writes a message to the log
+func (l *Loop) logf(format string, args ...any) {
+	w := l.Log
+	if w == nil {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, format+"\n", args...)
+}
+
+// Run runs frames in real time until the input quits, Done is closed, the
+// program exits or emulation fails. If the host falls behind by more than
+// MaxFrameLag frames the backlog is dropped instead of run all at once.
+func (l *Loop) Run() error {
+	interval := time.Second / FrameRate
+	next := time.Now()
+	for {
+		if !l.input() {
+			return nil
+		}
+		if l.paused {
+			time.Sleep(10 * time.Millisecond)
+			next = time.Now()
+			continue
+		}
+
+		if time.Since(next) > MaxFrameLag*interval {
+			next = time.Now()
+		}
+		for !time.Now().Before(next) {
+			next = next.Add(interval)
+			if err := l.frame(); err != nil {
+				return err
+			}
+		}
+
+		if !l.present() {
+			return nil
+		}
+		time.Sleep(time.Until(next))
+	}
+}
+
+// Step handles the pending input, runs one frame unless paused, and shows
+// the display. It returns false once the loop should stop: the input quit,
+// Done was closed or the program exited.
+func (l *Loop) Step() (bool, error) {
+	if !l.input() {
+		return false, nil
+	}
+	if !l.paused {
+		if err := l.frame(); err != nil {
+			return false, err
+		}
+	}
+	return l.present(), nil
+}
+
+// input handles the pending input events and reports whether to go on
+func (l *Loop) input() bool {
+	for _, e := range l.Input.Poll() {
+		switch e.Kind {
+		case Quit:
+			return false
+		case KeyDown, KeyUp:
+			l.Do(func() { l.VM.SetKey(e.Key, e.Kind == KeyDown) })
+		case Pause:
+			l.togglePause()
+		case Reset:
+			l.Do(func() {
+				l.VM.Reset()
+				if err := l.VM.LoadROM(l.ROM); err != nil {
+					l.logf("Error reloading ROM: %v", err)
+				}
+			})
+			if l.Rewinder != nil {
+				l.Rewinder.Clear()
+			}
+		case RewindStart:
+			l.rewinding = l.Rewinder != nil
+		case RewindStop:
+			l.rewinding = false
+		case SaveState:
+			l.saveState(e.Slot)
+		case LoadState:
+			l.loadState(e.Slot)
+		}
+	}
+
+	select {
+	case <-l.Done:
+		return false
+	default:
+	}
+	return true
+}
+
+// togglePause pauses or resumes the loop, or the debugger if there is one
+func (l *Loop) togglePause() {
+	if l.Debugger != nil {
+		// Pausing drops into the debugger prompt
+		if l.Debugger.Paused() {
+			l.Debugger.Continue()
+		} else {
+			l.Debugger.Pause()
+		}
+		return
+	}
+	l.paused = !l.paused
+	if t, ok := l.Video.(Titler); ok {
+		if l.paused {
+			t.SetTitle(l.Title + " (PAUSED)")
+		} else {
+			t.SetTitle(l.Title)
+		}
+	}
+}
+
+// saveState writes a snapshot of the VM to a slot
+func (l *Loop) saveState(slot int) {
+	if l.StatePath == nil {
+		return
+	}
+	var data []byte
+	var err error
+	l.Do(func() { data, err = l.VM.MarshalBinary() })
+	if err == nil {
+		err = os.WriteFile(l.StatePath(slot), data, 0644)
+	}
+	if err != nil {
+		l.logf("Error saving state to slot %d: %v", slot, err)
+		return
+	}
+	l.logf("Saved state to slot %d", slot)
+}
+
+// loadState restores the VM from a slot. Keys held when the state was
+// saved are released so they don't stick.
+func (l *Loop) loadState(slot int) {
+	if l.StatePath == nil {
+		return
+	}
+	data, err := os.ReadFile(l.StatePath(slot))
+	if err == nil {
+		l.Do(func() {
+			if err = l.VM.UnmarshalBinary(data); err != nil {
+				return
+			}
+			for key := uint8(0); key < chip8.NumKeys; key++ {
+				l.VM.SetKey(key, false)
+			}
+		})
+	}
+	if err != nil {
+		l.logf("Error loading state from slot %d: %v", slot, err)
+		return
+	}
+	l.logf("Loaded state from slot %d", slot)
+}
+
+// frame runs one frame, or steps back one while rewinding
+func (l *Loop) frame() error {
+	l.last = chip8.FrameResult{}
+	if l.rewinding {
+		var err error
+		l.Do(func() { _, err = l.Rewinder.Rewind(l.VM) })
+		if err != nil {
+			l.logf("Rewind error: %v", err)
+		}
+		l.tone(Silence)
+		return nil
+	}
+
+	l.budget += l.Speed
+	ipf := l.budget / FrameRate
+	l.budget -= ipf * FrameRate
+
+	// Under the debugger errors and exits stop execution and are reported
+	// at the prompt instead of ending the loop
+	var res chip8.FrameResult
+	if l.Debugger != nil {
+		res, _ = l.Debugger.RunFrame(ipf)
+		l.last = res
+		if l.Debugger.Paused() {
+			l.tone(Silence)
+			return nil
+		}
+	} else {
+		var err error
+		res, err = l.VM.RunFrame(ipf)
+		l.last = res
+		if err != nil {
+			return err
+		}
+	}
+
+	l.Do(func() {
+		if l.Rewinder != nil {
+			if err := l.Rewinder.Push(l.VM); err != nil {
+				l.logf("Rewind error: %v", err)
+			}
+		}
+		l.tone(ToneOf(l.VM, res.Beeping))
+	})
+	return nil
+}
+
+// tone plays t if there is audio
+func (l *Loop) tone(t Tone) {
+	if l.Audio != nil {
+		l.Audio.Tone(t)
+	}
+}
+
+// present shows the display if it changed and reports whether to go on,
+// which it doesn't once the program has exited. While the debugger has
+// execution stopped the current frame stays on show.
+func (l *Loop) present() bool {
+	running := true
+	l.Do(func() {
+		vm := l.VM
+		if vm.DrawFlag {
+			l.Video.Frame(vm.Pixels(), vm.Width(), vm.Height())
+			vm.DrawFlag = false
+		}
+
+		// Stop once the program has exited (SCHIP 00FD)
+		if vm.Halted && l.Debugger == nil {
+			l.logf("Program exited.")
+			running = false
+		}
+	})
+	return running
+}
@@ -0,0 +1,276 @@
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/chip8-emulator/chip8"
+)
+
+// frontend is a test backend: it plays a script of input, one batch of
+// events per poll, and records what it is sent
+type frontend struct {
+	script [][]Event
+	frames int
+	pixels []uint8
+	tones  []Tone
+	title  string
+}
+
+func (f *frontend) Frame(pixels []uint8, width, height int) {
+	f.frames++
+	f.pixels = append(f.pixels[:0], pixels...)
+}
+
+func (f *frontend) SetTitle(title string) { f.title = title }
+
+func (f *frontend) Tone(t Tone) { f.tones = append(f.tones, t) }
+
+func (f *frontend) Poll() []Event {
+	if len(f.script) == 0 {
+		return nil
+	}
+	events := f.script[0]
+	f.script = f.script[1:]
+	return events
+}
+
+// newLoop creates a loop running rom at 4 instructions per frame on a test
+// frontend
+func newLoop(t *testing.T, rom []byte, mode chip8.Mode) (*Loop, *frontend) {
+	t.Helper()
+	vm := chip8.NewWithMode(mode)
+	if err := vm.LoadROM(rom); err != nil {
+		t.Fatal(err)
+	}
+	f := &frontend{}
+	return &Loop{
+		VM:       vm,
+		ROM:      rom,
+		Platform: Platform{Video: f, Audio: f, Input: f},
+		Speed:    4 * FrameRate,
+		Title:    "test",
+		Log:      io.Discard,
+	}, f
+}
+
+func step(t *testing.T, l *Loop) bool {
+	t.Helper()
+	running, err := l.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return running
+}
+
+// spin sets the sound timer, draws the 0 glyph and loops
+var spin = []byte{
+	0x60, 0x05, // LD V0, #05
+	0xF0, 0x18, // LD ST, V0
+	0xD1, 0x15, // DRW V1, V1, #5
+	0x12, 0x06, // JP #206
+}
+
+func TestLoopStep(t *testing.T) {
+	l, f := newLoop(t, spin, chip8.ModeCHIP8)
+	if !step(t, l) {
+		t.Fatal("loop stopped")
+	}
+	if l.VM.PC != 0x206 || l.VM.Cycles != 4 {
+		t.Errorf("after a frame PC = %#x, cycles = %d; want 0x206, 4", l.VM.PC, l.VM.Cycles)
+	}
+	if f.frames != 1 || f.pixels[0] != 1 {
+		t.Errorf("got %d frames, first pixel %d; want the drawn frame", f.frames, f.pixels[0])
+	}
+	if len(f.tones) != 1 || !f.tones[0].On {
+		t.Errorf("tones %v, want one playing", f.tones)
+	}
+
+	// Nothing is drawn again, so no frame is sent
+	step(t, l)
+	if f.frames != 1 {
+		t.Errorf("got %d frames after an unchanged frame, want 1", f.frames)
+	}
+}
+
+func TestLoopShortBeep(t *testing.T) {
+	// A sound timer of 1 runs out at the end of its frame but still sounds
+	l, f := newLoop(t, []byte{
+		0x60, 0x01, // LD V0, #01
+		0xF0, 0x18, // LD ST, V0
+		0x12, 0x04, // JP #204
+	}, chip8.ModeCHIP8)
+	step(t, l)
+	if l.VM.SoundTimer != 0 || len(f.tones) != 1 || !f.tones[0].On {
+		t.Errorf("sound timer %d, tones %v; want 0 and one playing", l.VM.SoundTimer, f.tones)
+	}
+	step(t, l)
+	if f.tones[1].On {
+		t.Error("tone still playing after the timer ran out")
+	}
+}
+
+func TestLoopInput(t *testing.T) {
+	l, f := newLoop(t, spin, chip8.ModeCHIP8)
+	f.script = [][]Event{
+		{{Kind: KeyDown, Key: 0xA}},
+		{{Kind: KeyUp, Key: 0xA}, {Kind: Pause}},
+		nil,
+		{{Kind: Pause}},
+		{{Kind: Quit}},
+	}
+
+	step(t, l)
+	if !l.VM.Keys[0xA] {
+		t.Error("key A not pressed")
+	}
+
+	step(t, l)
+	if l.VM.Keys[0xA] {
+		t.Error("key A not released")
+	}
+	if !l.Paused() || f.title != "test (PAUSED)" {
+		t.Errorf("paused %v with title %q", l.Paused(), f.title)
+	}
+	cycles := l.VM.Cycles
+	step(t, l)
+	if l.VM.Cycles != cycles {
+		t.Error("instructions ran while paused")
+	}
+
+	step(t, l)
+	if l.Paused() || f.title != "test" || l.VM.Cycles == cycles {
+		t.Errorf("not resumed: paused %v, title %q", l.Paused(), f.title)
+	}
+
+	if step(t, l) {
+		t.Error("loop didn't stop on quit")
+	}
+}
+
+func TestLoopReset(t *testing.T) {
+	l, f := newLoop(t, spin, chip8.ModeCHIP8)
+	step(t, l)
+	f.script = [][]Event{{{Kind: Reset}}}
+	l.Speed = 0
+	step(t, l)
+	if l.VM.PC != chip8.ProgramStart || l.VM.Memory[chip8.ProgramStart] != spin[0] {
+		t.Errorf("after reset PC = %#x, ROM byte %#x", l.VM.PC, l.VM.Memory[chip8.ProgramStart])
+	}
+}
+
+func TestLoopStates(t *testing.T) {
+	l, f := newLoop(t, spin, chip8.ModeCHIP8)
+	dir := t.TempDir()
+	l.StatePath = func(slot int) string {
+		return filepath.Join(dir, fmt.Sprintf("state%d", slot))
+	}
+
+	step(t, l)
+	pc, cycles := l.VM.PC, l.VM.Cycles
+
+	// At speed 0 no instructions run, so only the events change the VM
+	f.script = [][]Event{{{Kind: SaveState, Slot: 1}}, {{Kind: KeyDown, Key: 3}, {Kind: LoadState, Slot: 1}}}
+	l.Speed = 0
+	step(t, l)
+	l.VM.V[0] = 0x42
+	step(t, l)
+	if l.VM.PC != pc || l.VM.Cycles != cycles || l.VM.V[0] != 5 {
+		t.Errorf("loaded PC %#x, cycles %d, V0 %#x; want %#x, %d, 5", l.VM.PC, l.VM.Cycles, l.VM.V[0], pc, cycles)
+	}
+	if l.VM.Keys[3] {
+		t.Error("key held across a state load")
+	}
+}
+
+func TestLoopRewind(t *testing.T) {
+	l, f := newLoop(t, spin, chip8.ModeCHIP8)
+	l.Rewinder = chip8.NewRewinder(10, 5)
+	for i := 0; i < 3; i++ {
+		step(t, l)
+	}
+
+	f.script = [][]Event{{{Kind: RewindStart}}, nil, {{Kind: RewindStop}}}
+	step(t, l)
+	if l.VM.Cycles != 8 {
+		t.Errorf("after rewinding a frame cycles = %d, want 8", l.VM.Cycles)
+	}
+	if n := l.LastFrame().Instructions; n != 0 {
+		t.Errorf("rewind step ran %d instructions", n)
+	}
+	step(t, l)
+	if l.VM.Cycles != 4 {
+		t.Errorf("after rewinding two frames cycles = %d, want 4", l.VM.Cycles)
+	}
+	if last := f.tones[len(f.tones)-1]; last.On {
+		t.Error("sound played while rewinding")
+	}
+	step(t, l)
+	if l.VM.Cycles != 8 {
+		t.Errorf("after rewinding stopped cycles = %d, want 8", l.VM.Cycles)
+	}
+	if n := l.LastFrame().Instructions; n != 4 {
+		t.Errorf("frame after rewinding ran %d instructions, want 4", n)
+	}
+}
+
+func TestLoopStops(t *testing.T) {
+	// SCHIP 00FD exits the program
+	l, _ := newLoop(t, []byte{0x00, 0xFD}, chip8.ModeSCHIP)
+	if step(t, l) {
+		t.Error("loop didn't stop when the program exited")
+	}
+
+	// Errors end the loop
+	l, _ = newLoop(t, []byte{0xFF, 0xFF}, chip8.ModeCHIP8)
+	if _, err := l.Step(); !errors.Is(err, chip8.ErrUnknownOpcode) {
+		t.Errorf("got %v, want ErrUnknownOpcode", err)
+	}
+
+	// As does closing Done
+	l, _ = newLoop(t, spin, chip8.ModeCHIP8)
+	done := make(chan struct{})
+	l.Done = done
+	close(done)
+	if step(t, l) {
+		t.Error("loop didn't stop when Done closed")
+	}
+}
+
+// samples records the samples it is sent
+type samples []int16
+
+func (s *samples) Samples(b []int16) { *s = append(*s, b...) }
+
+func TestSampler(t *testing.T) {
+	var out samples
+	s := &Sampler{Sink: &out}
+	s.Tone(Silence)
+	if len(out) != SamplesPerFrame {
+		t.Fatalf("got %d samples, want %d", len(out), SamplesPerFrame)
+	}
+	for i, v := range out {
+		if v != 0 {
+			t.Fatalf("sample %d of silence is %d", i, v)
+		}
+	}
+
+	out = out[:0]
+	s.Tone(Tone{On: true})
+	// A 440 Hz square wave changes sign every 50 samples or so
+	if out[0] != level || out[60] != -level {
+		t.Errorf("square wave samples %d, %d; want %d, %d", out[0], out[60], level, -level)
+	}
+
+	out = out[:0]
+	pattern := Tone{On: true, UsePattern: true, Pitch: chip8.DefaultPitch}
+	pattern.Pattern[0] = 0x80
+	s.Tone(pattern)
+	// At 4000 bits per second the first bit lasts 12 samples
+	if out[10] != level || out[12] != -level {
+		t.Errorf("pattern samples %d, %d; want %d, %d", out[10], out[12], level, -level)
+	}
+}
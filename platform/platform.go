@@ -0,0 +1,91 @@
+// Package platform connects the emulator to a frontend. A frontend shows
+// frames on a FrameSink, plays the sound timer on a ToneSink and reports
+// the keypad and emulator controls from an InputSource; Loop drives a VM
+// against any frontend that implements them. The interfaces don't depend
+// on cgo, so SDL is just one implementation alongside headless, terminal,
+// web and test backends.
+package platform
+
+import "github.com/chip8-emulator/chip8"
+
+// FrameSink shows the display
+type FrameSink interface {
+	// Frame shows a width x height display buffer in row-major order. Each
+	// pixel holds one bit per bitplane, so values are 0-3.
+	Frame(pixels []uint8, width, height int)
+}
+
+// Titler is implemented by frame sinks with a title, such as a window,
+// to show the emulator's state
+type Titler interface {
+	SetTitle(title string)
+}
+
+// ToneSink plays the sound timer
+type ToneSink interface {
+	// Tone is called once per frame with what to play for that frame
+	Tone(t Tone)
+}
+
+// InputSource reports input
+type InputSource interface {
+	// Poll returns the events since the last poll, in order
+	Poll() []Event
+}
+
+// Platform is a frontend. Audio may be nil for a silent one.
+type Platform struct {
+	Video FrameSink
+	Audio ToneSink
+	Input InputSource
+}
+
+// Tone is what to play for one frame
+type Tone struct {
+	// On is set while the sound timer is running
+	On bool
+	// UsePattern selects the XO-CHIP audio pattern played at Pitch
+	// instead of the default square wave
+	UsePattern bool
+	Pattern    [chip8.AudioPatternSize]uint8
+	Pitch      uint8
+}
+
+// Silence is the tone of a stopped sound timer
+var Silence = Tone{}
+
+// ToneOf returns the tone the VM plays for a frame. on is whether the
+// sound timer ran during the frame, FrameResult.Beeping: by the end of the
+// frame the timer has already ticked, so a timer of 1 would be missed.
+func ToneOf(vm *chip8.CHIP8, on bool) Tone {
+	return Tone{
+		On:         on,
+		UsePattern: vm.Mode == chip8.ModeXOCHIP,
+		Pattern:    vm.AudioPattern,
+		Pitch:      vm.Pitch,
+	}
+}
+
+// EventKind is the kind of an input event
+type EventKind int
+
+const (
+	KeyDown     EventKind = iota // keypad key Key pressed
+	KeyUp                        // keypad key Key released
+	Quit                         // stop the emulator
+	Pause                        // pause or resume
+	Reset                        // reset the VM and reload the ROM
+	RewindStart                  // start stepping back through history
+	RewindStop                   // stop rewinding
+	SaveState                    // save the VM to state slot Slot
+	LoadState                    // restore the VM from state slot Slot
+)
+
+// Event is an input event
+type Event struct {
+	Kind EventKind
+	// Key is the keypad key (0x0-0xF) of KeyDown and KeyUp
+	Key uint8
+	// Slot is the state slot of SaveState and LoadState
+	Slot int
+}
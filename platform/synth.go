@@ -0,0 +1,92 @@
+package platform
+
+import "math"
+
+const (
+	// Audio configuration
+	SampleRate = 44100
+	Frequency  = 440 // A4 note
+	Amplitude  = 0.3 // Volume (0.0 - 1.0)
+
+	// SamplesPerFrame is the audio for one 60 Hz frame
+	SamplesPerFrame = SampleRate / 60
+)
+
+// level is the 16-bit sample value for the configured amplitude
+var level = int16(math.Round(Amplitude * math.MaxInt16))
+
+// Synth turns tones into 16-bit mono samples at SampleRate, keeping the
+// waveform continuous from one call to the next
+type Synth struct {
+	phase  float64
+	bitPos float64
+}
+
+// Generate fills samples with the tone, or with silence if it is off
+func (s *Synth) Generate(t Tone, samples []int16) {
+	switch {
+	case !t.On:
+		clear(samples)
+	case t.UsePattern:
+		s.generatePattern(t, samples)
+	default:
+		s.generateSquare(samples)
+	}
+}
+
+// generateSquare generates the default square wave
+func (s *Synth) generateSquare(samples []int16) {
+	phaseIncrement := 2 * math.Pi * Frequency / SampleRate
+
+	for i := range samples {
+		samples[i] = -level
+		if math.Sin(s.phase) >= 0 {
+			samples[i] = level
+		}
+
+		s.phase += phaseIncrement
+		if s.phase >= 2*math.Pi {
+			s.phase -= 2 * math.Pi
+		}
+	}
+}
+
+// generatePattern plays the 128-bit XO-CHIP pattern buffer, one bit per
+// sample period at a rate of 4000 * 2^((pitch-64)/48) bits per second
+func (s *Synth) generatePattern(t Tone, samples []int16) {
+	rate := 4000 * math.Pow(2, (float64(t.Pitch)-64)/48)
+	bitIncrement := rate / SampleRate
+
+	for i := range samples {
+		bit := int(s.bitPos)
+		samples[i] = -level
+		if t.Pattern[bit/8]&(0x80>>(bit%8)) != 0 {
+			samples[i] = level
+		}
+
+		s.bitPos += bitIncrement
+		if s.bitPos >= 128 {
+			s.bitPos -= 128
+		}
+	}
+}
+
+// SampleSink plays audio as 16-bit mono samples at SampleRate, for
+// frontends that stream samples rather than follow the sound timer
+type SampleSink interface {
+	Samples(samples []int16)
+}
+
+// Sampler is a ToneSink that renders each frame's tone, or silence, as
+// SamplesPerFrame samples for a SampleSink
+type Sampler struct {
+	Sink  SampleSink
+	synth Synth
+	buf   [SamplesPerFrame]int16
+}
+
+// Tone renders one frame of the tone
+func (s *Sampler) Tone(t Tone) {
+	s.synth.Generate(t, s.buf[:])
+	s.Sink.Samples(s.buf[:])
+}
@@ -0,0 +1,260 @@
+// Package profile profiles a running ROM: how often each address and
+// opcode class executes and how many cycles it takes, the cycles spent in
+// each subroutine, and which bytes were executed, read as data or
+// written. Under the VIP timing model cycles are machine cycles; otherwise
+// each instruction counts as one.
+package profile
+
+import (
+	"fmt"
+
+	"github.com/chip8-emulator/chip8"
+)
+
+// Access is a bit set of the ways a byte was used
+type Access uint8
+
+const (
+	Executed Access = 1 << iota
+	Read
+	Written
+)
+
+// String returns the access as XRW, with a dot for each missing use
+func (a Access) String() string {
+	s := []byte("...")
+	for i, c := range "XRW" {
+		if a&(1<<i) != 0 {
+			s[i] = byte(c)
+		}
+	}
+	return string(s)
+}
+
+// Counter counts executions and the cycles they took
+type Counter struct {
+	Count, Cycles uint64
+}
+
+// Sub is the time spent in a subroutine, from the instruction after a
+// 2NNN call to its 00EE return. The main program is the subroutine at
+// chip8.ProgramStart.
+type Sub struct {
+	Entry uint16
+	Calls uint64
+	// Total is the cycles spent in it and the subroutines it calls, and
+	// Self the cycles spent in it alone
+	Total, Self uint64
+}
+
+// frame is a call in progress
+type frame struct {
+	entry uint16
+	// start is the cycle count at the call, and children the cycles spent
+	// in the subroutines it called
+	start, children uint64
+}
+
+// Profiler is a chip8.Observer that profiles execution
+type Profiler struct {
+	chip8.NopObserver
+	vm *chip8.CHIP8
+	// before is the cycle count before the executing instruction
+	before uint64
+
+	total    Counter
+	addrs    []Counter
+	access   []Access
+	classes  map[string]*Counter
+	subs     map[uint16]*Sub
+	stack    []frame
+	maxStack int
+}
+
+// New creates a profiler and attaches it as the VM's observer, alongside
+// any observer that is already attached
+func New(vm *chip8.CHIP8) *Profiler {
+	p := &Profiler{
+		vm:       vm,
+		addrs:    make([]Counter, chip8.ExtendedMemorySize),
+		access:   make([]Access, chip8.ExtendedMemorySize),
+		classes:  make(map[string]*Counter),
+		subs:     map[uint16]*Sub{chip8.ProgramStart: {Entry: chip8.ProgramStart, Calls: 1}},
+		stack:    []frame{{entry: chip8.ProgramStart, start: vm.Cycles}},
+		maxStack: chip8.StackSize,
+	}
+	vm.AddObserver(p)
+	return p
+}
+
+// Detach removes the profiler from the VM's observers
+func (p *Profiler) Detach() {
+	p.vm.RemoveObserver(p)
+}
+
+// BeforeInstruction notes the cycle count before the instruction
+func (p *Profiler) BeforeInstruction(pc, opcode uint16) {
+	p.before = p.vm.Cycles
+}
+
+// AfterInstruction counts the instruction and follows calls and returns
+func (p *Profiler) AfterInstruction(pc, opcode uint16) {
+	cycles := p.vm.Cycles - p.before
+	p.total.Count++
+	p.total.Cycles += cycles
+	p.addrs[pc].Count++
+	p.addrs[pc].Cycles += cycles
+
+	class := Class(opcode)
+	c := p.classes[class]
+	if c == nil {
+		c = &Counter{}
+		p.classes[class] = c
+	}
+	c.Count++
+	c.Cycles += cycles
+
+	size := uint16(2)
+	if opcode == 0xF000 && p.vm.Mode == chip8.ModeXOCHIP {
+		size = 4
+	}
+	for i := uint16(0); i < size; i++ {
+		p.access[pc+i] |= Executed
+	}
+
+	switch {
+	case opcode&0xF000 == 0x2000:
+		// The call stack is bounded like the VM's, so a ROM that never
+		// returns can't grow it without limit
+		if len(p.stack) <= p.maxStack {
+			entry := p.vm.PC
+			p.stack = append(p.stack, frame{entry: entry, start: p.vm.Cycles})
+			s := p.subs[entry]
+			if s == nil {
+				s = &Sub{Entry: entry}
+				p.subs[entry] = s
+			}
+			s.Calls++
+		}
+	case opcode == 0x00EE:
+		// A return with no call seen, e.g. after a stack reset, is ignored
+		if len(p.stack) > 1 {
+			p.pop(p.vm.Cycles)
+		}
+	}
+}
+
+// pop ends the innermost call at cycle now
+func (p *Profiler) pop(now uint64) {
+	f := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+	total := now - f.start
+	s := p.subs[f.entry]
+	s.Total += total
+	s.Self += total - f.children
+	p.stack[len(p.stack)-1].children += total
+}
+
+// MemoryRead marks a byte read as data
+func (p *Profiler) MemoryRead(addr uint32, value uint8) {
+	p.access[addr&0xFFFF] |= Read
+}
+
+// MemoryWrite marks a byte written
+func (p *Profiler) MemoryWrite(addr uint32, old, value uint8) {
+	p.access[addr&0xFFFF] |= Written
+}
+
+// Total returns the instructions executed and the cycles they took
+func (p *Profiler) Total() Counter {
+	return p.total
+}
+
+// At returns the executions of the instruction at addr
+func (p *Profiler) At(addr uint16) Counter {
+	return p.addrs[addr]
+}
+
+// Access returns how the byte at addr was used
+func (p *Profiler) Access(addr uint16) Access {
+	return p.access[addr]
+}
+
+// Classes returns the executions of each opcode class, such as 8XY4
+func (p *Profiler) Classes() map[string]Counter {
+	classes := make(map[string]Counter, len(p.classes))
+	for name, c := range p.classes {
+		classes[name] = *c
+	}
+	return classes
+}
+
+// Subs returns the time spent in each subroutine by entry address. Calls
+// still in progress, including the main program, count up to now.
+func (p *Profiler) Subs() map[uint16]Sub {
+	subs := make(map[uint16]Sub, len(p.subs))
+	for entry, s := range p.subs {
+		subs[entry] = *s
+	}
+	// Close the open calls on a copy of the stack
+	children := uint64(0)
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		f := p.stack[i]
+		total := p.vm.Cycles - f.start
+		s := subs[f.entry]
+		s.Total += total
+		s.Self += total - f.children - children
+		subs[f.entry] = s
+		children = total
+	}
+	return subs
+}
+
+// Class returns the opcode class of an instruction, in the usual
+// notation such as 00E0, 8XY4 or FX33
+func Class(op uint16) string {
+	switch op & 0xF000 {
+	case 0x0000:
+		switch {
+		case op == 0x00E0, op == 0x00EE, op >= 0x00FB && op <= 0x00FF:
+			return fmt.Sprintf("%04X", op)
+		case op&0xFFF0 == 0x00C0:
+			return "00CN"
+		case op&0xFFF0 == 0x00D0:
+			return "00DN"
+		}
+		return "0NNN"
+	case 0x1000:
+		return "1NNN"
+	case 0x2000:
+		return "2NNN"
+	case 0x3000:
+		return "3XNN"
+	case 0x4000:
+		return "4XNN"
+	case 0x5000:
+		return fmt.Sprintf("5XY%X", op&0xF)
+	case 0x6000:
+		return "6XNN"
+	case 0x7000:
+		return "7XNN"
+	case 0x8000:
+		return fmt.Sprintf("8XY%X", op&0xF)
+	case 0x9000:
+		return "9XY0"
+	case 0xA000:
+		return "ANNN"
+	case 0xB000:
+		return "BNNN"
+	case 0xC000:
+		return "CXNN"
+	case 0xD000:
+		return "DXYN"
+	case 0xE000:
+		return fmt.Sprintf("EX%02X", op&0xFF)
+	}
+	if op == 0xF000 {
+		return "F000"
+	}
+	return fmt.Sprintf("FX%02X", op&0xFF)
+}
@@ -0,0 +1,116 @@
+package profile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/symbols"
+)
+
+var rom = []byte{
+	0xA2, 0x10, // 200: LD I, 210
+	0x22, 0x0A, // 202: CALL 20A
+	0xF0, 0x55, // 204: LD [I], V0
+	0x12, 0x06, // 206: JP 206
+	0x00, 0x00, // 208
+	0xD0, 0x01, // 20A: DRW V0, V0, 1
+	0x00, 0xEE, // 20C: RET
+	0x00, 0x00, // 20E
+	0x80, 0x00, // 210: sprite
+}
+
+// run profiles n instructions of rom
+func run(t *testing.T, n int) (*chip8.CHIP8, *Profiler) {
+	t.Helper()
+	vm := chip8.New()
+	if err := vm.LoadROM(rom); err != nil {
+		t.Fatal(err)
+	}
+	p := New(vm)
+	for i := 0; i < n; i++ {
+		if err := vm.Cycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return vm, p
+}
+
+func TestProfile(t *testing.T) {
+	_, p := run(t, 10)
+
+	if total := p.Total(); total != (Counter{10, 10}) {
+		t.Errorf("Total = %+v, want 10 instructions", total)
+	}
+	if c := p.At(0x206); c.Count != 5 {
+		t.Errorf("206 ran %d times, want 5", c.Count)
+	}
+	if c := p.Classes()["1NNN"]; c.Count != 5 {
+		t.Errorf("1NNN ran %d times, want 5", c.Count)
+	}
+
+	subs := p.Subs()
+	if s := subs[0x20A]; s.Calls != 1 || s.Total != 2 || s.Self != 2 {
+		t.Errorf("Subroutine 20A = %+v, want 1 call of 2 cycles", s)
+	}
+	if s := subs[chip8.ProgramStart]; s.Total != 10 || s.Self != 8 {
+		t.Errorf("main = %+v, want 10 cycles, 8 of them its own", s)
+	}
+
+	for addr, want := range map[uint16]string{0x200: "X..", 0x20B: "X..", 0x208: "...", 0x210: ".RW", 0x211: "..."} {
+		if got := p.Access(addr).String(); got != want {
+			t.Errorf("Access(%03X) = %s, want %s", addr, got, want)
+		}
+	}
+}
+
+func TestOpenCalls(t *testing.T) {
+	// Stopped inside the subroutine, its time so far is counted
+	_, p := run(t, 3)
+	if s := p.Subs()[0x20A]; s.Total != 1 || s.Self != 1 {
+		t.Errorf("Subroutine 20A = %+v, want 1 cycle so far", s)
+	}
+}
+
+func TestReport(t *testing.T) {
+	_, p := run(t, 10)
+	syms := &symbols.Map{Labels: []symbols.Label{{Name: "main", Addr: 0x200}, {Name: "draw", Addr: 0x20A}}}
+	r := NewReport(p, rom, chip8.ModeCHIP8, syms)
+
+	var sb strings.Builder
+	if err := r.WriteHotspots(&sb); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Executed 10 instructions in 10 cycles",
+		"         5          5   50.0%  0206  JP #206              main+6",
+		"         1          2   20.0%          2   20.0%  020A  draw",
+		"Coverage of 18 ROM bytes: 12 executed (66.7%), 1 read as data, 1 written, 5 unused",
+	} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("Report is missing %q:\n%s", want, sb.String())
+		}
+	}
+
+	sb.Reset()
+	if err := r.WriteListing(&sb); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"draw:\n  020A  X..          1          1  D0 01        DRW V0, V0, #1\n",
+		"  0210  .RW          -          -  80\n",
+		"  0211  ...          -          -  00\n",
+	} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("Listing is missing %q:\n%s", want, sb.String())
+		}
+	}
+}
+
+func TestClass(t *testing.T) {
+	for op, want := range map[uint16]string{0x00E0: "00E0", 0x00C4: "00CN", 0x0123: "0NNN", 0x8AB4: "8XY4", 0xE29E: "EX9E", 0xF233: "FX33", 0xF000: "F000", 0x5122: "5XY2"} {
+		if got := Class(op); got != want {
+			t.Errorf("Class(%04X) = %s, want %s", op, got, want)
+		}
+	}
+}
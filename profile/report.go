@@ -0,0 +1,205 @@
+package profile
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/disasm"
+	"github.com/chip8-emulator/symbols"
+)
+
+// Hotspots is the number of addresses listed in a report
+const Hotspots = 20
+
+// Report writes the profile of a ROM
+type Report struct {
+	p    *Profiler
+	rom  []byte
+	mode chip8.Mode
+	mem  []byte
+	syms *symbols.Map
+}
+
+// NewReport prepares a report of the profile of rom. Instructions are
+// disassembled from the ROM as loaded, not as the program may have
+// modified it. syms may be nil.
+func NewReport(p *Profiler, rom []byte, mode chip8.Mode, syms *symbols.Map) *Report {
+	mem := make([]byte, chip8.ExtendedMemorySize)
+	copy(mem[chip8.ProgramStart:], rom)
+	return &Report{p: p, rom: rom, mode: mode, mem: mem, syms: syms}
+}
+
+// name names an address by the nearest label before it, if any
+func (r *Report) name(addr uint16) string {
+	if r.syms != nil {
+		if label, off, ok := r.syms.Nearest(addr); ok {
+			if off == 0 {
+				return label
+			}
+			return fmt.Sprintf("%s+%d", label, off)
+		}
+	}
+	return ""
+}
+
+// percent formats part as a percentage of whole
+func percent(part, whole uint64) string {
+	if whole == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(part)/float64(whole))
+}
+
+// WriteHotspots writes the totals, the busiest addresses, the opcode
+// classes and the subroutines, each sorted by cycles
+func (r *Report) WriteHotspots(w io.Writer) error {
+	var sb strings.Builder
+	total := r.p.Total()
+	fmt.Fprintf(&sb, "Executed %d instructions in %d cycles\n", total.Count, total.Cycles)
+
+	var addrs []uint16
+	for a := range r.p.addrs {
+		if r.p.addrs[a].Count > 0 {
+			addrs = append(addrs, uint16(a))
+		}
+	}
+	sort.SliceStable(addrs, func(i, j int) bool { return r.p.addrs[addrs[i]].Cycles > r.p.addrs[addrs[j]].Cycles })
+	if len(addrs) > Hotspots {
+		addrs = addrs[:Hotspots]
+	}
+	fmt.Fprintf(&sb, "\nHot spots:\n%10s %10s %7s  %-4s  %-20s %s\n", "count", "cycles", "time", "addr", "instruction", "label")
+	for _, a := range addrs {
+		c := r.p.addrs[a]
+		in := disasm.Decode(r.mem, a, r.mode)
+		fmt.Fprintf(&sb, "%10d %10d %7s  %04X  %-20s %s\n", c.Count, c.Cycles, percent(c.Cycles, total.Cycles), a, in, r.name(a))
+	}
+
+	classes := r.p.Classes()
+	names := make([]string, 0, len(classes))
+	for name := range classes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ci, cj := classes[names[i]], classes[names[j]]
+		if ci.Cycles != cj.Cycles {
+			return ci.Cycles > cj.Cycles
+		}
+		return names[i] < names[j]
+	})
+	fmt.Fprintf(&sb, "\nOpcode classes:\n%10s %10s %7s  %s\n", "count", "cycles", "time", "class")
+	for _, name := range names {
+		c := classes[name]
+		fmt.Fprintf(&sb, "%10d %10d %7s  %s\n", c.Count, c.Cycles, percent(c.Cycles, total.Cycles), name)
+	}
+
+	subs := r.p.Subs()
+	entries := make([]uint16, 0, len(subs))
+	for entry := range subs {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		si, sj := subs[entries[i]], subs[entries[j]]
+		if si.Total != sj.Total {
+			return si.Total > sj.Total
+		}
+		return entries[i] < entries[j]
+	})
+	fmt.Fprintf(&sb, "\nSubroutines:\n%10s %10s %7s %10s %7s  %-4s  %s\n", "calls", "total", "time", "self", "time", "addr", "label")
+	for _, entry := range entries {
+		s := subs[entry]
+		fmt.Fprintf(&sb, "%10d %10d %7s %10d %7s  %04X  %s\n", s.Calls, s.Total, percent(s.Total, total.Cycles),
+			s.Self, percent(s.Self, total.Cycles), entry, r.name(entry))
+	}
+
+	var executed, read, written, unused int
+	for i := range r.rom {
+		a := r.p.access[chip8.ProgramStart+i]
+		if a&Executed != 0 {
+			executed++
+		}
+		if a&Read != 0 {
+			read++
+		}
+		if a&Written != 0 {
+			written++
+		}
+		if a == 0 {
+			unused++
+		}
+	}
+	fmt.Fprintf(&sb, "\nCoverage of %d ROM bytes: %d executed (%s), %d read as data, %d written, %d unused\n",
+		len(r.rom), executed, percent(uint64(executed), uint64(len(r.rom))), read, written, unused)
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// listingData is the most data bytes on a listing line
+const listingData = 8
+
+// WriteListing writes the ROM annotated with coverage: each executed
+// instruction with its count and cycles, and the other bytes as data, all
+// marked with how they were used (see Access.String)
+func (r *Report) WriteListing(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("; use: X executed, R read as data, W written\n")
+	fmt.Fprintf(&sb, "; %-4s  %-3s %10s %10s  %-11s  %s\n", "addr", "use", "count", "cycles", "bytes", "code")
+
+	start, end := chip8.ProgramStart, chip8.ProgramStart+len(r.rom)
+	for addr := start; addr < end; {
+		a := uint16(addr)
+		if r.syms != nil {
+			if label, ok := r.syms.Label(a); ok {
+				fmt.Fprintf(&sb, "%s:\n", label)
+			}
+		}
+
+		if c := r.p.addrs[a]; c.Count > 0 {
+			in := disasm.Decode(r.mem, a, r.mode)
+			fmt.Fprintf(&sb, "  %04X  %s %10d %10d  %-11s  %s\n", a, r.p.access[a], c.Count, c.Cycles, hexBytes(r.mem[addr:min(addr+in.Size, len(r.mem))]), in)
+			addr += in.Size
+			continue
+		}
+
+		// A run of data bytes used the same way, up to the next
+		// instruction or label
+		use := r.p.access[a]
+		n := 1
+		for n < listingData && addr+n < end {
+			next := uint16(addr + n)
+			if r.p.access[next] != use || r.p.addrs[next].Count > 0 {
+				break
+			}
+			if r.syms != nil {
+				if _, ok := r.syms.Label(next); ok {
+					break
+				}
+			}
+			n++
+		}
+		fmt.Fprintf(&sb, "  %04X  %s %10s %10s  %s\n", a, use, "-", "-", hexBytes(r.mem[addr:addr+n]))
+		addr += n
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// hexBytes formats bytes as space separated hex
+func hexBytes(b []byte) string {
+	return fmt.Sprintf("% X", b)
+}
+
+// Write writes the hot spots followed by the coverage listing
+func (r *Report) Write(w io.Writer) error {
+	if err := r.WriteHotspots(w); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return err
+	}
+	return r.WriteListing(w)
+}
@@ -0,0 +1,520 @@
+// Package repl implements an interactive command-line debugger on top of
+// the debugger package. Commands are read from a reader (normally stdin)
+// while the emulator keeps running in its own loop.
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/debugger"
+	"github.com/chip8-emulator/disasm"
+)
+
+// Prompt is printed before each command
+const Prompt = "(chip8) "
+
+// errQuit is returned by Exec for the quit command
+var errQuit = errors.New("quit")
+
+// REPL reads debugger commands and prints their results
+type REPL struct {
+	d   *debugger.Debugger
+	in  io.Reader
+	out io.Writer
+
+	// Serializes output from the command loop and the stop handler
+	mu sync.Mutex
+}
+
+// New creates a REPL controlling d. Stops are reported to out as they
+// happen.
+func New(d *debugger.Debugger, in io.Reader, out io.Writer) *REPL {
+	r := &REPL{d: d, in: in, out: out}
+	d.SetStopHandler(r.reportStop)
+	return r
+}
+
+// Run reads and executes commands until the quit command or the end of
+// input
+func (r *REPL) Run() error {
+	scanner := bufio.NewScanner(r.in)
+	r.print(Prompt)
+	for scanner.Scan() {
+		err := r.Exec(scanner.Text())
+		if errors.Is(err, errQuit) {
+			return nil
+		}
+		if err != nil {
+			r.printf("Error: %v\n", err)
+		}
+		r.print(Prompt)
+	}
+	return scanner.Err()
+}
+
+// command is one REPL command
+type command struct {
+	names []string
+	usage string
+	help  string
+	run   func(r *REPL, args []string) error
+}
+
+// commands lists the REPL commands in the order help shows them
+var commands []command
+
+func init() {
+	commands = []command{
+		{[]string{"help", "h", "?"}, "", "Show this help", (*REPL).help},
+		{[]string{"regs", "r"}, "", "Show registers", (*REPL).regs},
+		{[]string{"stack", "bt"}, "", "Show the call stack", (*REPL).stack},
+		{[]string{"timers", "t"}, "", "Show the delay and sound timers", (*REPL).timers},
+		{[]string{"keys", "k"}, "", "Show pressed keys", (*REPL).keys},
+		{[]string{"mem", "x"}, "ADDR [LEN]", "Dump memory", (*REPL).mem},
+		{[]string{"dis", "l"}, "[ADDR] [COUNT]", "Disassemble (default around PC)", (*REPL).dis},
+		{[]string{"break", "b"}, "ADDR", "Set a breakpoint", (*REPL).breakAt},
+		{[]string{"opbreak", "ob"}, "PATTERN", "Break on opcodes matching PATTERN, e.g. D???", (*REPL).opBreak},
+		{[]string{"watch", "w"}, "ADDR [LEN] | VX", "Stop when memory is written or a register changes", (*REPL).watch},
+		{[]string{"rwatch", "rw"}, "ADDR [LEN]", "Stop when memory is read", (*REPL).rwatch},
+		{[]string{"awatch", "aw"}, "ADDR [LEN]", "Stop when memory is read or written", (*REPL).awatch},
+		{[]string{"info", "i"}, "", "List breakpoints and watchpoints", (*REPL).info},
+		{[]string{"delete", "d"}, "[ID]", "Delete a breakpoint (all if no ID)", (*REPL).deleteBreak},
+		{[]string{"poke", "p"}, "ADDR BYTE...", "Write bytes to memory", (*REPL).poke},
+		{[]string{"set"}, "REG VALUE", "Set V0-VF, I, PC, SP, DT or ST", (*REPL).set},
+		{[]string{"continue", "c"}, "", "Continue execution", (*REPL).cont},
+		{[]string{"step", "s"}, "", "Execute one instruction", (*REPL).step},
+		{[]string{"next", "n"}, "", "Step over subroutine calls", (*REPL).next},
+		{[]string{"finish", "f"}, "", "Run until the current subroutine returns", (*REPL).finish},
+		{[]string{"until", "u"}, "ADDR", "Run until PC reaches ADDR", (*REPL).until},
+		{[]string{"pause"}, "", "Stop execution", (*REPL).pause},
+		{[]string{"quit", "q"}, "", "Quit the emulator", (*REPL).quit},
+	}
+}
+
+// Exec executes one command line
+func (r *REPL) Exec(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	name := strings.ToLower(fields[0])
+	for _, cmd := range commands {
+		for _, n := range cmd.names {
+			if n == name {
+				return cmd.run(r, fields[1:])
+			}
+		}
+	}
+	return fmt.Errorf("unknown command %q (try help)", fields[0])
+}
+
+// print writes s to the output
+func (r *REPL) print(s string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	io.WriteString(r.out, s)
+}
+
+// printf formats to the output
+func (r *REPL) printf(format string, args ...any) {
+	r.print(fmt.Sprintf(format, args...))
+}
+
+// reportStop prints a stop and the instruction execution stopped at
+func (r *REPL) reportStop(s debugger.Stop) {
+	var line string
+	r.d.Do(func(vm *chip8.CHIP8) {
+		line = disasm.Decode(vm.Memory[:vm.AddressSpace()], vm.PC, vm.Mode).String()
+	})
+	r.printf("\nStopped: %v\n%03X  %s\n", s, s.PC, line)
+}
+
+func (r *REPL) help(args []string) error {
+	var sb strings.Builder
+	for _, cmd := range commands {
+		usage := strings.Join(cmd.names, ", ")
+		if cmd.usage != "" {
+			usage += " " + cmd.usage
+		}
+		fmt.Fprintf(&sb, "  %-32s %s\n", usage, cmd.help)
+	}
+	sb.WriteString("Addresses and values are hex; a 0x or # prefix is optional.\n")
+	r.print(sb.String())
+	return nil
+}
+
+func (r *REPL) regs(args []string) error {
+	var sb strings.Builder
+	r.d.Do(func(vm *chip8.CHIP8) {
+		for i, v := range vm.V {
+			fmt.Fprintf(&sb, "V%X=%02X", i, v)
+			if i%8 == 7 {
+				sb.WriteByte('\n')
+			} else {
+				sb.WriteByte(' ')
+			}
+		}
+		fmt.Fprintf(&sb, "I=%03X PC=%03X SP=%X DT=%02X ST=%02X\n", vm.I, vm.PC, vm.SP, vm.DelayTimer, vm.SoundTimer)
+	})
+	r.print(sb.String())
+	return nil
+}
+
+func (r *REPL) stack(args []string) error {
+	var sb strings.Builder
+	r.d.Do(func(vm *chip8.CHIP8) {
+		fmt.Fprintf(&sb, "#0 %03X\n", vm.PC)
+		for i := int(vm.SP) - 1; i >= 0 && i < chip8.StackSize; i-- {
+			fmt.Fprintf(&sb, "#%d %03X\n", int(vm.SP)-i, vm.Stack[i])
+		}
+	})
+	r.print(sb.String())
+	return nil
+}
+
+func (r *REPL) timers(args []string) error {
+	var dt, st uint8
+	r.d.Do(func(vm *chip8.CHIP8) {
+		dt, st = vm.DelayTimer, vm.SoundTimer
+	})
+	r.printf("DT=%02X (%d) ST=%02X (%d)\n", dt, dt, st, st)
+	return nil
+}
+
+func (r *REPL) keys(args []string) error {
+	var pressed []string
+	var waiting string
+	r.d.Do(func(vm *chip8.CHIP8) {
+		for k, down := range vm.Keys {
+			if down {
+				pressed = append(pressed, fmt.Sprintf("%X", k))
+			}
+		}
+		if vm.WaitingForKey {
+			waiting = fmt.Sprintf(" (waiting for a key for V%X)", vm.KeyRegister)
+		}
+	})
+	if len(pressed) == 0 {
+		pressed = []string{"none"}
+	}
+	r.printf("Pressed: %s%s\n", strings.Join(pressed, " "), waiting)
+	return nil
+}
+
+func (r *REPL) mem(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: mem ADDR [LEN]")
+	}
+	addr, err := parseHex(args[0], 16)
+	if err != nil {
+		return err
+	}
+	length := uint64(0x40)
+	if len(args) == 2 {
+		if length, err = parseHex(args[1], 16); err != nil {
+			return err
+		}
+	}
+
+	var sb strings.Builder
+	r.d.Do(func(vm *chip8.CHIP8) {
+		size := uint64(vm.AddressSpace())
+		for row := addr; row < addr+length && row < size; row += 16 {
+			fmt.Fprintf(&sb, "%04X ", row)
+			for a := row; a < row+16 && a < addr+length && a < size; a++ {
+				fmt.Fprintf(&sb, " %02X", vm.Memory[a])
+			}
+			sb.WriteByte('\n')
+		}
+	})
+	r.print(sb.String())
+	return nil
+}
+
+func (r *REPL) dis(args []string) error {
+	if len(args) > 2 {
+		return errors.New("usage: dis [ADDR] [COUNT]")
+	}
+	count := uint64(10)
+	if len(args) == 2 {
+		var err error
+		if count, err = parseHex(args[1], 8); err != nil {
+			return err
+		}
+	}
+
+	var sb strings.Builder
+	var err error
+	r.d.Do(func(vm *chip8.CHIP8) {
+		addr := uint64(vm.PC)
+		if len(args) > 0 {
+			addr, err = parseHex(args[0], 16)
+		} else if addr >= 4 {
+			// Show a couple of instructions before PC
+			addr -= 4
+		}
+
+		mem := vm.Memory[:vm.AddressSpace()]
+		for i := uint64(0); i < count; i++ {
+			in := disasm.Decode(mem, uint16(addr), vm.Mode)
+			marker := "  "
+			if in.Addr == vm.PC {
+				marker = "=>"
+			}
+			fmt.Fprintf(&sb, "%s %03X  %04X  %s\n", marker, in.Addr, in.Opcode, in)
+			addr += uint64(in.Size)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	r.print(sb.String())
+	return nil
+}
+
+func (r *REPL) breakAt(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: break ADDR")
+	}
+	addr, err := parseHex(args[0], 16)
+	if err != nil {
+		return err
+	}
+	id := r.d.AddBreakpoint(uint16(addr))
+	r.printf("Breakpoint #%d at %03X\n", id, addr)
+	return nil
+}
+
+func (r *REPL) opBreak(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: opbreak PATTERN")
+	}
+	pattern, err := debugger.ParseOpcodePattern(args[0])
+	if err != nil {
+		return err
+	}
+	id := r.d.AddOpcodeBreakpoint(pattern)
+	r.printf("Breakpoint #%d on %v\n", id, pattern)
+	return nil
+}
+
+func (r *REPL) watch(args []string) error {
+	if len(args) == 1 {
+		if reg, ok := parseRegister(args[0]); ok {
+			id, err := r.d.AddRegisterWatchpoint(reg)
+			if err != nil {
+				return err
+			}
+			r.printf("Watchpoint #%d on V%X\n", id, reg)
+			return nil
+		}
+	}
+	return r.addWatch(debugger.WatchWrite, "watch", args)
+}
+
+func (r *REPL) rwatch(args []string) error {
+	return r.addWatch(debugger.WatchRead, "rwatch", args)
+}
+
+func (r *REPL) awatch(args []string) error {
+	return r.addWatch(debugger.WatchAccess, "awatch", args)
+}
+
+// addWatch adds a memory watchpoint from ADDR [LEN] arguments
+func (r *REPL) addWatch(kind debugger.Kind, name string, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: %s ADDR [LEN]", name)
+	}
+	addr, err := parseHex(args[0], 16)
+	if err != nil {
+		return err
+	}
+	size := uint64(1)
+	if len(args) == 2 {
+		if size, err = parseHex(args[1], 16); err != nil {
+			return err
+		}
+	}
+	id, err := r.d.AddWatchpoint(kind, uint32(addr), uint32(size))
+	if err != nil {
+		return err
+	}
+	r.printf("Watchpoint #%d on %03X (%d bytes)\n", id, addr, size)
+	return nil
+}
+
+func (r *REPL) info(args []string) error {
+	bps := r.d.Breakpoints()
+	if len(bps) == 0 {
+		r.print("No breakpoints or watchpoints\n")
+		return nil
+	}
+	var sb strings.Builder
+	for _, b := range bps {
+		fmt.Fprintf(&sb, "%v, %d hits\n", b, b.Hits)
+	}
+	r.print(sb.String())
+	return nil
+}
+
+func (r *REPL) deleteBreak(args []string) error {
+	switch len(args) {
+	case 0:
+		r.d.ClearBreakpoints()
+		r.print("Deleted all breakpoints and watchpoints\n")
+		return nil
+	case 1:
+		id, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+		if err != nil {
+			return fmt.Errorf("invalid breakpoint ID %q", args[0])
+		}
+		return r.d.RemoveBreakpoint(id)
+	default:
+		return errors.New("usage: delete [ID]")
+	}
+}
+
+func (r *REPL) poke(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: poke ADDR BYTE...")
+	}
+	addr, err := parseHex(args[0], 16)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, len(args)-1)
+	for i, arg := range args[1:] {
+		v, err := parseHex(arg, 8)
+		if err != nil {
+			return err
+		}
+		data[i] = byte(v)
+	}
+
+	r.d.Do(func(vm *chip8.CHIP8) {
+		if int(addr)+len(data) > vm.AddressSpace() {
+			err = fmt.Errorf("address %04X out of range", addr+uint64(len(data))-1)
+			return
+		}
+		copy(vm.Memory[addr:], data)
+		vm.DrawFlag = true
+	})
+	return err
+}
+
+func (r *REPL) set(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: set REG VALUE")
+	}
+	name := strings.ToUpper(args[0])
+	reg, isV := parseRegister(name)
+
+	bits := 8
+	if name == "I" || name == "PC" {
+		bits = 16
+	}
+	value, err := parseHex(args[1], bits)
+	if err != nil {
+		return err
+	}
+
+	r.d.Do(func(vm *chip8.CHIP8) {
+		switch {
+		case isV:
+			vm.V[reg] = uint8(value)
+		case name == "I":
+			vm.I = uint16(value)
+		case name == "PC":
+			vm.PC = uint16(value)
+		case name == "SP":
+			if value > chip8.StackSize {
+				err = fmt.Errorf("SP must be at most %X", chip8.StackSize)
+				return
+			}
+			vm.SP = uint8(value)
+		case name == "DT":
+			vm.DelayTimer = uint8(value)
+		case name == "ST":
+			vm.SoundTimer = uint8(value)
+		default:
+			err = fmt.Errorf("unknown register %q", args[0])
+		}
+	})
+	return err
+}
+
+func (r *REPL) cont(args []string) error {
+	r.d.Continue()
+	return nil
+}
+
+func (r *REPL) step(args []string) error {
+	// The stop handler reports where the step ended
+	r.d.Step()
+	return nil
+}
+
+func (r *REPL) next(args []string) error {
+	r.d.StepOver()
+	return nil
+}
+
+func (r *REPL) finish(args []string) error {
+	r.d.StepOut()
+	return nil
+}
+
+func (r *REPL) until(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: until ADDR")
+	}
+	addr, err := parseHex(args[0], 16)
+	if err != nil {
+		return err
+	}
+	r.d.RunToCursor(uint16(addr))
+	return nil
+}
+
+func (r *REPL) pause(args []string) error {
+	if r.d.Paused() {
+		return errors.New("already stopped")
+	}
+	r.d.Pause()
+	return nil
+}
+
+func (r *REPL) quit(args []string) error {
+	return errQuit
+}
+
+// parseHex parses a hex number with an optional 0x or # prefix that must
+// fit in bits bits
+func parseHex(s string, bits int) (uint64, error) {
+	digits := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "0x"), "#")
+	v, err := strconv.ParseUint(digits, 16, bits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hex value %q", s)
+	}
+	return v, nil
+}
+
+// parseRegister parses a V register name such as V3 or vA
+func parseRegister(s string) (uint8, bool) {
+	if len(s) != 2 || (s[0] != 'V' && s[0] != 'v') {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 4)
+	if err != nil {
+		return 0, false
+	}
+	return uint8(v), true
+}
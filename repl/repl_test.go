@@ -0,0 +1,84 @@
+package repl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/chip8-emulator/chip8"
+	"github.com/chip8-emulator/debugger"
+)
+
+// runScript runs commands against a paused VM with program loaded at 0x200
+// and returns the output
+func runScript(t *testing.T, vm *chip8.CHIP8, script string, program ...byte) string {
+	t.Helper()
+	copy(vm.Memory[chip8.ProgramStart:], program)
+	d := debugger.New(vm)
+	d.Pause()
+
+	var out bytes.Buffer
+	r := New(d, strings.NewReader(script), &out)
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	return out.String()
+}
+
+func TestInspectAndStep(t *testing.T) {
+	vm := chip8.New()
+	// 200: LD V0, 5; 202: ADD V0, 1
+	out := runScript(t, vm, "step\nregs\ndis 200 2\nbogus\n", 0x60, 0x05, 0x70, 0x01)
+
+	for _, want := range []string{
+		"Stopped: step at 202\n202  ADD V0, #01",
+		"V0=05 V1=00",
+		"I=000 PC=202 SP=0",
+		"   200  6005  LD V0, #05\n=> 202  7001  ADD V0, #01",
+		`Error: unknown command "bogus"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Output should contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestPokeAndSet(t *testing.T) {
+	vm := chip8.New()
+	out := runScript(t, vm, "poke 0x300 AB CD\nset VA #7F\nset pc 300\nset SP 11\nmem 300 2\nq\nregs\n")
+
+	if vm.Memory[0x300] != 0xAB || vm.Memory[0x301] != 0xCD {
+		t.Errorf("poke should write memory, got %02X %02X", vm.Memory[0x300], vm.Memory[0x301])
+	}
+	if vm.V[0xA] != 0x7F || vm.PC != 0x300 {
+		t.Errorf("set should write registers, got VA=%02X PC=%03X", vm.V[0xA], vm.PC)
+	}
+	if !strings.Contains(out, "Error: SP must be at most 10") {
+		t.Errorf("Out of range SP should be rejected, got:\n%s", out)
+	}
+	if !strings.Contains(out, "0300  AB CD\n") {
+		t.Errorf("mem should dump the poked bytes, got:\n%s", out)
+	}
+	if strings.Contains(out, "V0=") {
+		t.Error("Commands after quit should not run")
+	}
+}
+
+func TestBreakpointCommands(t *testing.T) {
+	vm := chip8.New()
+	out := runScript(t, vm, "break 204\nopbreak D???\nwatch VA\nrwatch 300 4\ninfo\ndelete 2\ninfo\ndelete\ninfo\n")
+
+	for _, want := range []string{
+		"Breakpoint #1 at 204",
+		"Breakpoint #2 on D???",
+		"Watchpoint #3 on VA",
+		"Watchpoint #4 on 300 (4 bytes)",
+		"#2 opcode breakpoint on D???, 0 hits\n#3",
+		"#1 breakpoint at 204, 0 hits\n#3 register watchpoint on VA, 0 hits\n#4 read watchpoint on 300-303, 0 hits\n",
+		"No breakpoints or watchpoints",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Output should contain %q, got:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Package symbols reads and writes symbol maps: the label addresses and
+// source line numbers of an assembled ROM, stored as JSON next to it, so
+// debuggers can show labels and set breakpoints by source line.
+package symbols
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Ext is the file extension of symbol maps
+const Ext = ".sym"
+
+// Label is a named address
+type Label struct {
+	Name string `json:"name"`
+	Addr uint16 `json:"addr"`
+}
+
+// Line maps a source line to the address of the first byte it assembled to
+type Line struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Addr uint16 `json:"addr"`
+}
+
+// Map is the symbol map of a ROM
+type Map struct {
+	Labels []Label `json:"labels"`
+	Lines  []Line  `json:"lines"`
+}
+
+// PathFor returns the symbol map path for a ROM: the ROM path with its
+// extension replaced by Ext
+func PathFor(romPath string) string {
+	return strings.TrimSuffix(romPath, filepath.Ext(romPath)) + Ext
+}
+
+// Read decodes a symbol map
+func Read(r io.Reader) (*Map, error) {
+	var m Map
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	m.sort()
+	return &m, nil
+}
+
+// Load reads the symbol map at path
+func Load(path string) (*Map, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Write encodes the symbol map
+func (m *Map) Write(w io.Writer) error {
+	m.sort()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// Save writes the symbol map to path
+func (m *Map) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := m.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// SaveRelative writes the symbol map to path with source paths made
+// relative to it, so the ROM, map and sources can be moved together
+func (m *Map) SaveRelative(path string) error {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	for i, l := range m.Lines {
+		abs, err := filepath.Abs(l.File)
+		if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(dir, abs); err == nil {
+			m.Lines[i].File = filepath.ToSlash(rel)
+		}
+	}
+	return m.Save(path)
+}
+
+// sort orders labels and lines by address, keeping the original order of
+// entries at the same address
+func (m *Map) sort() {
+	sort.SliceStable(m.Labels, func(i, j int) bool { return m.Labels[i].Addr < m.Labels[j].Addr })
+	sort.SliceStable(m.Lines, func(i, j int) bool { return m.Lines[i].Addr < m.Lines[j].Addr })
+}
+
+// Lookup returns the address of the named label
+func (m *Map) Lookup(name string) (uint16, bool) {
+	for _, l := range m.Labels {
+		if l.Name == name {
+			return l.Addr, true
+		}
+	}
+	return 0, false
+}
+
+// Label returns the first label at addr
+func (m *Map) Label(addr uint16) (string, bool) {
+	for _, l := range m.Labels {
+		if l.Addr == addr {
+			return l.Name, true
+		}
+	}
+	return "", false
+}
+
+// Nearest returns the closest label at or before addr and addr's offset
+// from it, e.g. to name the routine containing an address
+func (m *Map) Nearest(addr uint16) (string, uint16, bool) {
+	i := sort.Search(len(m.Labels), func(i int) bool { return m.Labels[i].Addr > addr })
+	if i == 0 {
+		return "", 0, false
+	}
+	// Prefer the first label at that address
+	l := m.Labels[i-1]
+	for i > 1 && m.Labels[i-2].Addr == l.Addr {
+		i--
+		l = m.Labels[i-1]
+	}
+	return l.Name, addr - l.Addr, true
+}
+
+// LineAt returns the source line that assembled to addr
+func (m *Map) LineAt(addr uint16) (Line, bool) {
+	for _, l := range m.Lines {
+		if l.Addr == addr {
+			return l, true
+		}
+	}
+	return Line{}, false
+}
+
+// AddrsAt returns the addresses of a source line. File names match if
+// they are the same path or, failing that, have the same base name.
+func (m *Map) AddrsAt(file string, line int) []uint16 {
+	var addrs []uint16
+	for _, l := range m.Lines {
+		if l.Line == line && sameFile(l.File, file) {
+			addrs = append(addrs, l.Addr)
+		}
+	}
+	return addrs
+}
+
+// sameFile reports whether two source file names refer to the same file
+func sameFile(a, b string) bool {
+	if filepath.Clean(a) == filepath.Clean(b) {
+		return true
+	}
+	return filepath.Base(a) == filepath.Base(b)
+}
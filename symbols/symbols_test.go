@@ -0,0 +1,47 @@
+package symbols
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	m := &Map{
+		Labels: []Label{{"loop", 0x206}, {"main", 0x200}, {"start", 0x200}},
+		Lines:  []Line{{"game.8o", 3, 0x202}, {"game.8o", 2, 0x200}},
+	}
+
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	if addr, ok := got.Lookup("loop"); !ok || addr != 0x206 {
+		t.Errorf("Lookup(loop) = %03X, %v", addr, ok)
+	}
+	if name, ok := got.Label(0x200); !ok || name != "main" {
+		t.Errorf("Label(200) = %q, %v", name, ok)
+	}
+	if name, off, ok := got.Nearest(0x204); !ok || name != "main" || off != 4 {
+		t.Errorf("Nearest(204) = %q+%d, %v", name, off, ok)
+	}
+	if _, _, ok := got.Nearest(0x100); ok {
+		t.Error("Nearest should fail before the first label")
+	}
+	if line, ok := got.LineAt(0x202); !ok || line.Line != 3 {
+		t.Errorf("LineAt(202) = %v, %v", line, ok)
+	}
+	if addrs := got.AddrsAt("/home/user/src/game.8o", 2); len(addrs) != 1 || addrs[0] != 0x200 {
+		t.Errorf("AddrsAt should match by base name, got %v", addrs)
+	}
+}
+
+func TestPathFor(t *testing.T) {
+	if got := PathFor("roms/game.ch8"); got != "roms/game.sym" {
+		t.Errorf("PathFor = %q", got)
+	}
+}
@@ -0,0 +1,100 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Divergence is where two traces first differ
+type Divergence struct {
+	// Index is the number of entries that matched before it
+	Index int
+	// A and B are the differing entries, and LineA and LineB their line
+	// numbers. If a trace ended first, its Ended flag is set instead.
+	A, B           Entry
+	LineA, LineB   int
+	EndedA, EndedB bool
+	// Prev is the last matching entry of the first trace, if any
+	Prev *Entry
+	// Fields names the fields that differ, such as pc, v3 or i
+	Fields []string
+}
+
+// Diff compares two traces entry by entry, on the fields both have, and
+// returns the first divergence, or nil if they match. The instruction
+// count is not compared, so traces may number from 0 or 1.
+func Diff(a, b io.Reader) (*Divergence, error) {
+	ra, rb := NewReader(a), NewReader(b)
+	var prev *Entry
+	for index := 0; ; index++ {
+		ea, la, errA := ra.Next()
+		if errA != nil && errA != io.EOF {
+			return nil, fmt.Errorf("first trace: %w", errA)
+		}
+		eb, lb, errB := rb.Next()
+		if errB != nil && errB != io.EOF {
+			return nil, fmt.Errorf("second trace: %w", errB)
+		}
+		if errA == io.EOF && errB == io.EOF {
+			return nil, nil
+		}
+
+		d := &Divergence{Index: index, A: ea, B: eb, LineA: la, LineB: lb, Prev: prev,
+			EndedA: errA == io.EOF, EndedB: errB == io.EOF}
+		if d.EndedA || d.EndedB {
+			return d, nil
+		}
+		if d.Fields = compare(ea, eb); len(d.Fields) > 0 {
+			return d, nil
+		}
+		prev = &ea
+	}
+}
+
+// compare returns the names of the fields present in both entries that
+// differ
+func compare(a, b Entry) []string {
+	both := a.Fields & b.Fields
+	var diff []string
+	check := func(f Field, name string, differ bool) {
+		if both&f != 0 && differ {
+			diff = append(diff, name)
+		}
+	}
+	check(FieldPC, "pc", a.PC != b.PC)
+	check(FieldOpcode, "op", a.Opcode != b.Opcode)
+	for r := range a.V {
+		check(FieldV, fmt.Sprintf("v%x", r), a.V[r] != b.V[r])
+	}
+	check(FieldI, "i", a.I != b.I)
+	check(FieldSP, "sp", a.SP != b.SP)
+	check(FieldDT, "dt", a.DT != b.DT)
+	check(FieldST, "st", a.ST != b.ST)
+	return diff
+}
+
+// Report describes the divergence, naming the traces a and b
+func (d *Divergence) Report(a, b string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Traces diverge after %d matching instructions\n", d.Index)
+	if d.Prev != nil {
+		fmt.Fprintf(&sb, "  last match: %v\n", *d.Prev)
+	}
+	for _, t := range []struct {
+		name  string
+		e     Entry
+		line  int
+		ended bool
+	}{{a, d.A, d.LineA, d.EndedA}, {b, d.B, d.LineB, d.EndedB}} {
+		if t.ended {
+			fmt.Fprintf(&sb, "  %s: ended\n", t.name)
+		} else {
+			fmt.Fprintf(&sb, "  %s:%d: %v\n", t.name, t.line, t.e)
+		}
+	}
+	if len(d.Fields) > 0 {
+		fmt.Fprintf(&sb, "  differs in: %s\n", strings.Join(d.Fields, ", "))
+	}
+	return sb.String()
+}
@@ -0,0 +1,231 @@
+// Package trace writes and reads per-instruction execution traces, and
+// finds where two traces diverge.
+//
+// A trace is text with one line per instruction, recording the state just
+// before it executes:
+//
+//	c=0 pc=0200 op=6A02 v=00000000000000000000000000000000 i=0000 sp=00 dt=00 st=00
+//
+// c is the instruction count in decimal; the other fields are hex, with v
+// holding V0-VF. Lines starting with # are comments. Fields may be in any
+// order and unknown fields are ignored, so traces converted from other
+// emulators only need the fields they can provide.
+package trace
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/chip8-emulator/chip8"
+)
+
+// Header is the comment line a Writer starts a trace with
+const Header = "# chip8 trace: c=count pc op v=V0-VF i sp dt st"
+
+// Field is a bit set of the fields present in an entry
+type Field uint8
+
+const (
+	FieldCycle Field = 1 << iota
+	FieldPC
+	FieldOpcode
+	FieldV
+	FieldI
+	FieldSP
+	FieldDT
+	FieldST
+
+	// FieldAll are all the fields, as written by a Writer
+	FieldAll = FieldCycle | FieldPC | FieldOpcode | FieldV | FieldI | FieldSP | FieldDT | FieldST
+)
+
+// fieldNames are the keys of the fields in a trace line
+var fieldNames = []struct {
+	field Field
+	name  string
+}{
+	{FieldCycle, "c"},
+	{FieldPC, "pc"},
+	{FieldOpcode, "op"},
+	{FieldV, "v"},
+	{FieldI, "i"},
+	{FieldSP, "sp"},
+	{FieldDT, "dt"},
+	{FieldST, "st"},
+}
+
+// Entry is the state before one instruction
+type Entry struct {
+	Cycle      uint64
+	PC, Opcode uint16
+	V          [chip8.NumRegisters]uint8
+	I          uint16
+	SP, DT, ST uint8
+	// Fields are the fields present when the entry was parsed
+	Fields Field
+}
+
+// String formats the fields of the entry as a trace line
+func (e Entry) String() string {
+	var fields []string
+	for _, f := range fieldNames {
+		if e.Fields&f.field == 0 {
+			continue
+		}
+		var value string
+		switch f.field {
+		case FieldCycle:
+			value = strconv.FormatUint(e.Cycle, 10)
+		case FieldPC:
+			value = fmt.Sprintf("%04X", e.PC)
+		case FieldOpcode:
+			value = fmt.Sprintf("%04X", e.Opcode)
+		case FieldV:
+			value = fmt.Sprintf("%X", e.V[:])
+		case FieldI:
+			value = fmt.Sprintf("%04X", e.I)
+		case FieldSP:
+			value = fmt.Sprintf("%02X", e.SP)
+		case FieldDT:
+			value = fmt.Sprintf("%02X", e.DT)
+		case FieldST:
+			value = fmt.Sprintf("%02X", e.ST)
+		}
+		fields = append(fields, f.name+"="+value)
+	}
+	return strings.Join(fields, " ")
+}
+
+// Parse parses a trace line
+func Parse(line string) (Entry, error) {
+	var e Entry
+	for _, kv := range strings.Fields(line) {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return e, fmt.Errorf("field %q is not key=value", kv)
+		}
+		var err error
+		switch key {
+		case "c":
+			e.Cycle, err = strconv.ParseUint(value, 10, 64)
+			e.Fields |= FieldCycle
+		case "pc":
+			e.PC, err = parseHex16(value)
+			e.Fields |= FieldPC
+		case "op":
+			e.Opcode, err = parseHex16(value)
+			e.Fields |= FieldOpcode
+		case "v":
+			var v []byte
+			if v, err = hex.DecodeString(value); err == nil && len(v) != len(e.V) {
+				err = fmt.Errorf("want %d registers, got %d", len(e.V), len(v))
+			}
+			copy(e.V[:], v)
+			e.Fields |= FieldV
+		case "i":
+			e.I, err = parseHex16(value)
+			e.Fields |= FieldI
+		case "sp":
+			e.SP, err = parseHex8(value)
+			e.Fields |= FieldSP
+		case "dt":
+			e.DT, err = parseHex8(value)
+			e.Fields |= FieldDT
+		case "st":
+			e.ST, err = parseHex8(value)
+			e.Fields |= FieldST
+		}
+		if err != nil {
+			return e, fmt.Errorf("field %s: %w", key, err)
+		}
+	}
+	return e, nil
+}
+
+func parseHex16(s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 16, 16)
+	return uint16(v), err
+}
+
+func parseHex8(s string) (uint8, error) {
+	v, err := strconv.ParseUint(s, 16, 8)
+	return uint8(v), err
+}
+
+// Writer is a chip8.Observer that writes a trace line before each
+// instruction executes
+type Writer struct {
+	chip8.NopObserver
+	vm    *chip8.CHIP8
+	w     *bufio.Writer
+	cycle uint64
+	err   error
+}
+
+// NewWriter creates a trace writer and attaches it as the VM's observer,
+// alongside any observer that is already attached. Call Flush when done.
+func NewWriter(vm *chip8.CHIP8, w io.Writer) *Writer {
+	t := &Writer{vm: vm, w: bufio.NewWriter(w)}
+	_, t.err = fmt.Fprintln(t.w, Header)
+	vm.AddObserver(t)
+	return t
+}
+
+// Detach removes the writer from the VM's observers
+func (t *Writer) Detach() {
+	t.vm.RemoveObserver(t)
+}
+
+// BeforeInstruction writes the state before the instruction
+func (t *Writer) BeforeInstruction(pc, opcode uint16) {
+	if t.err != nil {
+		return
+	}
+	vm := t.vm
+	e := Entry{Cycle: t.cycle, PC: pc, Opcode: opcode, V: vm.V, I: vm.I, SP: vm.SP, DT: vm.DelayTimer, ST: vm.SoundTimer, Fields: FieldAll}
+	_, t.err = fmt.Fprintln(t.w, e)
+	t.cycle++
+}
+
+// Flush writes any buffered lines and returns the first write error
+func (t *Writer) Flush() error {
+	if t.err != nil {
+		return t.err
+	}
+	return t.w.Flush()
+}
+
+// Reader reads the entries of a trace
+type Reader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+// NewReader creates a reader of the trace in r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{s: bufio.NewScanner(r)}
+}
+
+// Next returns the next entry and its line number, or io.EOF at the end
+func (r *Reader) Next() (Entry, int, error) {
+	for r.s.Scan() {
+		r.line++
+		text := strings.TrimSpace(r.s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		e, err := Parse(text)
+		if err != nil {
+			return e, r.line, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		return e, r.line, nil
+	}
+	if err := r.s.Err(); err != nil {
+		return Entry{}, r.line, err
+	}
+	return Entry{}, r.line, io.EOF
+}
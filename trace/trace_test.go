@@ -0,0 +1,89 @@
+package trace
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chip8-emulator/chip8"
+)
+
+// record traces the first n instructions of rom
+func record(t *testing.T, rom []byte, n int) string {
+	t.Helper()
+	vm := chip8.New()
+	if err := vm.LoadROM(rom); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewWriter(vm, &buf)
+	for i := 0; i < n; i++ {
+		if err := vm.Cycle(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestWriteAndParse(t *testing.T) {
+	out := record(t, []byte{0x6A, 0x02, 0xA2, 0x34, 0x7A, 0x01}, 3)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 4 || lines[0] != Header {
+		t.Fatalf("Trace = %q, want a header and 3 lines", out)
+	}
+	if want := "c=2 pc=0204 op=7A01 v=00000000000000000000020000000000 i=0234 sp=00 dt=00 st=00"; lines[3] != want {
+		t.Errorf("Line = %q\nwant   %q", lines[3], want)
+	}
+
+	e, err := Parse(lines[3])
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if e.Cycle != 2 || e.PC != 0x204 || e.V[0xA] != 2 || e.I != 0x234 || e.Fields != FieldAll {
+		t.Errorf("Parsed %+v", e)
+	}
+	if e.String() != lines[3] {
+		t.Errorf("String() = %q, want the line back", e.String())
+	}
+
+	if _, err := Parse("pc=02G0"); err == nil {
+		t.Error("Parse should reject a bad hex value")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	rom := []byte{0x6A, 0x02, 0xA2, 0x34, 0x7A, 0x01, 0x12, 0x00}
+	a := record(t, rom, 8)
+
+	if d, err := Diff(strings.NewReader(a), strings.NewReader(a)); err != nil || d != nil {
+		t.Errorf("Identical traces: %+v, %v", d, err)
+	}
+
+	// Another emulator's trace with fewer fields, numbered from 1, that
+	// adds 2 instead of 1 to VA
+	other := "c=1 pc=0200 op=6A02\nc=2 pc=0202 op=A234\nc=3 pc=0204 op=7A02\n"
+	d, err := Diff(strings.NewReader(a), strings.NewReader(other))
+	if err != nil || d == nil {
+		t.Fatalf("Diff = %+v, %v; want a divergence", d, err)
+	}
+	if d.Index != 2 || !reflect.DeepEqual(d.Fields, []string{"op"}) || d.LineA != 4 || d.LineB != 3 {
+		t.Errorf("Divergence = %+v", d)
+	}
+	if d.Prev == nil || d.Prev.PC != 0x202 {
+		t.Errorf("Last match = %v, want the instruction at 202", d.Prev)
+	}
+	if report := d.Report("a", "b"); !strings.Contains(report, "differs in: op") {
+		t.Errorf("Report = %q", report)
+	}
+
+	// A trace that stops early
+	short := strings.Join(strings.Split(a, "\n")[:4], "\n")
+	d, err = Diff(strings.NewReader(a), strings.NewReader(short))
+	if err != nil || d == nil || d.Index != 3 || !d.EndedB || d.EndedA {
+		t.Errorf("Short trace: %+v, %v", d, err)
+	}
+}